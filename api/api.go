@@ -4,8 +4,10 @@ package api
 // WARNING 2: useless to be removed
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/toorop/tmail/core"
 )
@@ -37,6 +39,11 @@ func UserChangePassword(login, password string) error {
 	return core.UserChangePassword(login, password)
 }
 
+// UserSetLmtpEndpoint sets LMTP endpoint for local deliveries to user
+func UserSetLmtpEndpoint(login, endpoint string) error {
+	return core.UserSetLmtpEndpoint(login, endpoint)
+}
+
 // ALIAS
 
 // AliasAdd add an alias
@@ -44,6 +51,11 @@ func AliasAdd(alias, deliverTo, pipe string, isMinilist bool) error {
 	return core.AliasAdd(alias, deliverTo, pipe, isMinilist)
 }
 
+// AliasSetPipeOptions sets user, working directory and timeout of alias pipe
+func AliasSetPipeOptions(alias, runAs, dir string, timeout int) error {
+	return core.AliasSetPipeOptions(alias, runAs, dir, timeout)
+}
+
 // AliasDel  delete an alias
 func AliasDel(alias string) error {
 	return core.AliasDel(alias)
@@ -74,9 +86,9 @@ func MailboxList() (mailboxes []core.Mailbox, err error) {
 */
 
 // RELAY IP
-// RelayIpAdd add an IP authozed to relay through tmail
-func RelayIpAdd(ip string) error {
-	return core.RelayIpAdd(ip)
+// RelayIpAdd add an IP or network authozed to relay through tmail
+func RelayIpAdd(ip, description string, expiresAt *time.Time, maxSize int64, senderDomains []string, skipScan bool) error {
+	return core.RelayIpAdd(ip, description, expiresAt, maxSize, senderDomains, skipScan)
 }
 
 // RelayIpDel remove an ip from authorized IP
@@ -84,6 +96,11 @@ func RelayIpDel(ip string) error {
 	return core.RelayIpDel(ip)
 }
 
+// RelayIpDelById remove entry id from authorized IP
+func RelayIpDelById(id int64) error {
+	return core.RelayIpDelById(id)
+}
+
 // RelayIpGetAll returns all IPs which are authorized to relay through tmail
 func RelayIpGetAll() (ips []core.RelayIpOk, err error) {
 	return core.RelayIpGetAll()
@@ -163,6 +180,21 @@ func RcpthostAdd(host string, isLocal, isAlias bool) error {
 	return core.RcpthostAdd(host, isLocal, isAlias)
 }
 
+// RcpthostSetLmtpEndpoint sets LMTP endpoint for local deliveries to host
+func RcpthostSetLmtpEndpoint(host, endpoint string) error {
+	return core.RcpthostSetLmtpEndpoint(host, endpoint)
+}
+
+// RcpthostSetRcptDelimiter sets recipient delimiter(s) of host
+func RcpthostSetRcptDelimiter(host, delimiter string) error {
+	return core.RcpthostSetRcptDelimiter(host, delimiter)
+}
+
+// RcpthostSetRcptVerify sets recipient verification of relay host
+func RcpthostSetRcptVerify(host, method, endpoint string, failOpen bool) error {
+	return core.RcpthostSetRcptVerify(host, method, endpoint, failOpen)
+}
+
 // RcpthostDel delete a rcpthost
 func RcpthostDel(host string) error {
 	return core.RcpthostDel(host)
@@ -191,3 +223,300 @@ func DkimDisable(domain string) error {
 func DkimGetConfig(domain string) (dkimConfig *core.DkimConfig, err error) {
 	return core.DkimGetConfig(domain)
 }
+
+// RATE LIMITS
+
+// RateLimitAdd adds or replaces a smtpd rate limit override
+// limits lower than 0 are not overridden
+func RateLimitAdd(scope, target string, maxSessions, connPerMinute, msgPerHour, rcptPerHour int) error {
+	return core.RateLimitAdd(scope, target, maxSessions, connPerMinute, msgPerHour, rcptPerHour)
+}
+
+// RateLimitDel deletes a rate limit override
+func RateLimitDel(id int64) error {
+	return core.RateLimitDel(id)
+}
+
+// RateLimitGetAll returns all rate limit overrides
+func RateLimitGetAll() ([]core.RateLimit, error) {
+	return core.RateLimitGetAll()
+}
+
+// RateLimitGetCounters returns current rate limit counters
+func RateLimitGetCounters() []core.RateLimitCounter {
+	return core.RateLimitGetCounters()
+}
+
+// AUTH BANS
+
+// AuthBanGetAll returns active bans (IP) and lockouts (user)
+func AuthBanGetAll() ([]core.AuthBan, error) {
+	return core.AuthBanGetAll()
+}
+
+// AuthBanDel lifts a ban or a lockout
+func AuthBanDel(id int64) error {
+	return core.AuthBanDel(id)
+}
+
+// TLS
+
+// TLSGetCertificates returns smtpd certificates with their expiration
+func TLSGetCertificates() ([]core.TLSCertificate, error) {
+	return core.TLSGetCertificates()
+}
+
+// RestTLSGetCertificate returns REST server certificate
+func RestTLSGetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return core.RestTLSGetCertificate(hello)
+}
+
+// ACME
+
+// AcmeHTTP01Response returns key authorization for HTTP-01 token
+func AcmeHTTP01Response(token string) (string, bool) {
+	return core.AcmeHTTP01Response(token)
+}
+
+// AcmeCertificateGetAll returns all ACME certificates
+func AcmeCertificateGetAll() ([]core.AcmeCertificate, error) {
+	return core.AcmeCertificateGetAll()
+}
+
+// AcmeRenew obtains or renews ACME certificates (all of them if force is true)
+func AcmeRenew(force bool) error {
+	return core.AcmeRenew(force)
+}
+
+// SIEVE
+
+// SieveCheck checks script syntax
+func SieveCheck(script string) error {
+	return core.SieveCheck(script)
+}
+
+// SieveScriptPut adds or replaces script name of user login
+func SieveScriptPut(login, name, script string, activate bool) error {
+	return core.SieveScriptPut(login, name, script, activate)
+}
+
+// SieveScriptGet returns script name of user login
+func SieveScriptGet(login, name string) (*core.SieveScript, error) {
+	return core.SieveScriptGet(login, name)
+}
+
+// SieveScriptList returns scripts of user login
+func SieveScriptList(login string) ([]core.SieveScript, error) {
+	return core.SieveScriptList(login)
+}
+
+// SieveScriptDel deletes script name of user login
+func SieveScriptDel(login, name string) error {
+	return core.SieveScriptDel(login, name)
+}
+
+// SieveScriptActivate activates script name of user login ("" deactivates all)
+func SieveScriptActivate(login, name string) error {
+	return core.SieveScriptActivate(login, name)
+}
+
+// VACATION
+
+// VacationSet adds or replaces vacation settings of user login
+func VacationSet(login string, enabled bool, subject, body string, startAt, endAt *time.Time, excludedSenders string, days int) error {
+	return core.VacationSet(login, enabled, subject, body, startAt, endAt, excludedSenders, days)
+}
+
+// VacationGet returns vacation settings of user login
+func VacationGet(login string) (*core.Vacation, error) {
+	return core.VacationGet(login)
+}
+
+// VacationDel deletes vacation settings of user login
+func VacationDel(login string) error {
+	return core.VacationDel(login)
+}
+
+// MAILING LISTS
+
+// MailingListAdd creates a mailing list
+func MailingListAdd(address, description, policy, moderators string) error {
+	return core.MailingListAdd(address, description, policy, moderators)
+}
+
+// MailingListUpdate updates description, policy and moderators of a list
+func MailingListUpdate(address, description, policy, moderators string) error {
+	return core.MailingListUpdate(address, description, policy, moderators)
+}
+
+// MailingListGet returns a mailing list
+func MailingListGet(address string) (*core.MailingList, error) {
+	return core.MailingListGet(address)
+}
+
+// MailingListGetAll returns all mailing lists
+func MailingListGetAll() ([]core.MailingList, error) {
+	return core.MailingListGetAll()
+}
+
+// MailingListDel deletes a mailing list
+func MailingListDel(address string) error {
+	return core.MailingListDel(address)
+}
+
+// MailingListMemberAdd adds member to list
+func MailingListMemberAdd(address, member string) error {
+	return core.MailingListMemberAdd(address, member)
+}
+
+// MailingListMemberDel removes member from list
+func MailingListMemberDel(address, member string) error {
+	return core.MailingListMemberDel(address, member)
+}
+
+// MailingListMemberGetAll returns members of list
+func MailingListMemberGetAll(address string) ([]core.MailingListMember, error) {
+	return core.MailingListMemberGetAll(address)
+}
+
+// MailingListPendingGetAll returns pending actions of list
+func MailingListPendingGetAll(address string) ([]core.MailingListPending, error) {
+	return core.MailingListPendingGetAll(address)
+}
+
+// MailingListModerate approves or rejects a held post
+func MailingListModerate(token string, approve bool) error {
+	return core.MailingListModerate(token, approve)
+}
+
+// MailingListUnsubscribe removes member having unsubscribe token
+func MailingListUnsubscribe(token string) error {
+	return core.MailingListUnsubscribe(token)
+}
+
+// REWRITE
+
+// RewriteRuleAdd adds an address rewriting rule
+func RewriteRuleAdd(scope, kind, match, pattern, replacement, authUser string, priority int) error {
+	return core.RewriteRuleAdd(scope, kind, match, pattern, replacement, authUser, priority)
+}
+
+// RewriteRuleDel deletes an address rewriting rule
+func RewriteRuleDel(id int64) error {
+	return core.RewriteRuleDel(id)
+}
+
+// RewriteRuleGetAll returns all address rewriting rules
+func RewriteRuleGetAll() ([]core.RewriteRule, error) {
+	return core.RewriteRuleGetAll()
+}
+
+// CONTENT RULES
+
+// ContentRuleAdd adds a content rule
+func ContentRuleAdd(name string, conditions, actions []string, priority int, comment string) error {
+	return core.ContentRuleAdd(name, conditions, actions, priority, comment)
+}
+
+// ContentRuleUpdate adds a new version of a content rule
+func ContentRuleUpdate(name string, conditions, actions []string, priority int, comment string, disabled bool) error {
+	return core.ContentRuleUpdate(name, conditions, actions, priority, comment, disabled)
+}
+
+// ContentRuleRollback adds a new version of a content rule copied from version
+func ContentRuleRollback(name string, version int) error {
+	return core.ContentRuleRollback(name, version)
+}
+
+// ContentRuleDel deletes a content rule (all its versions)
+func ContentRuleDel(name string) error {
+	return core.ContentRuleDel(name)
+}
+
+// ContentRuleGet returns the latest version of a content rule
+func ContentRuleGet(name string) (*core.ContentRule, error) {
+	return core.ContentRuleGet(name)
+}
+
+// ContentRuleGetAll returns the latest version of all content rules
+func ContentRuleGetAll() ([]core.ContentRule, error) {
+	return core.ContentRuleGetAll()
+}
+
+// ContentRuleGetHistory returns all versions of a content rule
+func ContentRuleGetHistory(name string) ([]core.ContentRule, error) {
+	return core.ContentRuleGetHistory(name)
+}
+
+// ContentRulesTest evaluates content rules against a message (dry run)
+func ContentRulesTest(raw []byte, mailFrom string, rcptTo []string, clientIp, authUser string) (*core.ContentRuleResult, error) {
+	return core.ContentRulesTest(raw, mailFrom, rcptTo, clientIp, authUser)
+}
+
+// QUARANTINE
+
+// QuarantineGetAll returns quarantined messages
+func QuarantineGetAll() ([]core.QuarantinedMessage, error) {
+	return core.QuarantineGetAll()
+}
+
+// QuarantineGet returns a quarantined message
+func QuarantineGet(id int64) (*core.QuarantinedMessage, error) {
+	return core.QuarantineGet(id)
+}
+
+// QuarantineGetHeaders returns headers of a quarantined message
+func QuarantineGetHeaders(id int64) (string, error) {
+	return core.QuarantineGetHeaders(id)
+}
+
+// QuarantineRelease requeues a quarantined message for rcpt ("" for all
+// recipients)
+func QuarantineRelease(id int64, rcpt string) error {
+	return core.QuarantineRelease(id, rcpt)
+}
+
+// QuarantineReleaseSigned releases a quarantined message for rcpt from a
+// digest link
+func QuarantineReleaseSigned(id int64, rcpt string, expires int64, signature string) error {
+	return core.QuarantineReleaseSigned(id, rcpt, expires, signature)
+}
+
+// QuarantineDel deletes a quarantined message
+func QuarantineDel(id int64) error {
+	return core.QuarantineDel(id)
+}
+
+// JOURNAL
+
+// JournalRuleAdd adds a journaling rule
+func JournalRuleAdd(direction, format, destination, domain, user string, retention int) error {
+	return core.JournalRuleAdd(direction, format, destination, domain, user, retention)
+}
+
+// JournalRuleDel deletes a journaling rule
+func JournalRuleDel(id int64) error {
+	return core.JournalRuleDel(id)
+}
+
+// JournalRuleGetAll returns all journaling rules
+func JournalRuleGetAll() ([]core.JournalRule, error) {
+	return core.JournalRuleGetAll()
+}
+
+// DISCLAIMER
+
+// DisclaimerSet sets the disclaimer of a domain or of an user
+func DisclaimerSet(domain, user, text, html string) error {
+	return core.DisclaimerSet(domain, user, text, html)
+}
+
+// DisclaimerDel deletes a disclaimer
+func DisclaimerDel(id int64) error {
+	return core.DisclaimerDel(id)
+}
+
+// DisclaimerGetAll returns all disclaimers
+func DisclaimerGetAll() ([]core.Disclaimer, error) {
+	return core.DisclaimerGetAll()
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Acme represents commands for dealing with ACME certificates
+var Acme = cgCli.Command{
+	Name:  "acme",
+	Usage: "commands to manage certificates obtained via ACME",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "list",
+			Usage:       "List ACME certificates",
+			Description: "tmail acme list",
+			Action: func(c *cgCli.Context) {
+				certs, err := api.AcmeCertificateGetAll()
+				cliHandleErr(err)
+				if len(certs) == 0 {
+					println("There is no ACME certificate.")
+				} else {
+					for _, cert := range certs {
+						fmt.Println(fmt.Sprintf("%s - valid until %s (%d days left)", cert.Domain, cert.NotAfter.Format(time.RFC3339), int(time.Until(cert.NotAfter).Hours()/24)))
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "renew",
+			Usage:       "Obtain missing certificates and renew expiring ones (dns-01 only, with http-01 use the REST API)",
+			Description: "tmail acme renew [--force]",
+			Flags: []cgCli.Flag{
+				cgCli.BoolFlag{
+					Name:  "force, f",
+					Usage: "Renew all certificates",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				cliHandleErr(api.AcmeRenew(c.Bool("force")))
+				cliDieOk()
+			},
+		},
+	},
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/toorop/tmail/api"
@@ -14,8 +15,8 @@ var alias = cgCli.Command{
 		// users
 		{
 			Name:        "add",
-			Usage:       "Add an alias",
-			Description: "tmail alias add [--pipe COMMAND] [--deliver-to REAL_LOCAL_USER] ALIAS ",
+			Usage:       "Add an alias (local part can be a pattern: support-*@domain)",
+			Description: "tmail alias add [--pipe COMMAND [--pipe-user USER] [--pipe-dir DIR] [--pipe-timeout SECONDS]] [--deliver-to REAL_LOCAL_USER] ALIAS ",
 			Flags: []cgCli.Flag{
 				cgCli.StringFlag{
 					Name:  "pipe, p",
@@ -29,6 +30,18 @@ var alias = cgCli.Command{
 					Name:  "minilist, m",
 					Usage: "if set, enveloppe mail from is rewritted to alias@domain",
 				},
+				cgCli.StringFlag{
+					Name:  "pipe-user",
+					Usage: "run pipe command as this user (default TMAIL_DELIVERD_PIPE_USER)",
+				},
+				cgCli.StringFlag{
+					Name:  "pipe-dir",
+					Usage: "working directory of pipe command (default TMAIL_DELIVERD_PIPE_DIR)",
+				},
+				cgCli.IntFlag{
+					Name:  "pipe-timeout",
+					Usage: "pipe command timeout in seconds (default TMAIL_DELIVERD_PIPE_TIMEOUT)",
+				},
 			},
 			Action: func(c *cgCli.Context) {
 				if len(c.Args()) != 1 {
@@ -36,6 +49,9 @@ var alias = cgCli.Command{
 				}
 				err := api.AliasAdd(c.Args()[0], c.String("d"), c.String("p"), c.Bool("m"))
 				cliHandleErr(err)
+				if c.String("pipe-user") != "" || c.String("pipe-dir") != "" || c.Int("pipe-timeout") != 0 {
+					cliHandleErr(api.AliasSetPipeOptions(c.Args()[0], c.String("pipe-user"), c.String("pipe-dir"), c.Int("pipe-timeout")))
+				}
 				cliDieOk()
 			},
 		}, {
@@ -61,9 +77,22 @@ var alias = cgCli.Command{
 					println("there is no alias defined")
 				} else {
 					for _, alias := range aliases {
-						println(alias.Alias)
+						if alias.IsPattern {
+							println(alias.Alias + " (pattern)")
+						} else {
+							println(alias.Alias)
+						}
 						if alias.Pipe != "" {
 							println("\tPipe: " + alias.Pipe)
+							if alias.PipeUser != "" {
+								println("\t\tuser: " + alias.PipeUser)
+							}
+							if alias.PipeDir != "" {
+								println("\t\tdir: " + alias.PipeDir)
+							}
+							if alias.PipeTimeout != 0 {
+								println("\t\ttimeout: " + strconv.Itoa(alias.PipeTimeout) + "s")
+							}
 						}
 						for _, d := range strings.Split(alias.DeliverTo, ";") {
 							println("\t-> " + d)
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// AuthBan represents commands for dealing with IP bans and user lockouts
+// triggered by SMTP AUTH failures
+var AuthBan = cgCli.Command{
+	Name:  "authban",
+	Usage: "commands to manage IP bans and user lockouts after SMTP AUTH failures",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "list",
+			Usage:       "List active bans and lockouts",
+			Description: "tmail authban list",
+			Action: func(c *cgCli.Context) {
+				bans, err := api.AuthBanGetAll()
+				cliHandleErr(err)
+				if len(bans) == 0 {
+					println("There is no active ban.")
+				} else {
+					for _, b := range bans {
+						fmt.Println(fmt.Sprintf("%d %s %s - %d failures - until %s", b.Id, b.Scope, b.Target, b.Failures, b.ExpiresAt.Format(time.RFC3339)))
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Lift a ban or a lockout",
+			Description: "tmail authban del ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.AuthBanDel(id))
+				cliDieOk()
+			},
+		},
+	},
+}
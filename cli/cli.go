@@ -14,6 +14,17 @@ var CliCommands = []cgCli.Command{
 	RelayIP,
 	//Mailbox,
 	Dkim,
+	RateLimit,
+	AuthBan,
+	Acme,
+	Sieve,
+	Vacation,
+	MailingList,
+	Rewrite,
+	ContentRule,
+	Quarantine,
+	Journal,
+	Disclaimer,
 }
 
 var cliCommandHelpTemplate = `NAME:
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// contentRuleFlags are flags of add and update commands
+var contentRuleFlags = []cgCli.Flag{
+	cgCli.StringSliceFlag{
+		Name:  "if",
+		Usage: "condition FIELD OPERATOR [VALUE], all conditions must match (eg --if 'attachment.filename matches *.exe')",
+	},
+	cgCli.StringSliceFlag{
+		Name:  "then",
+		Usage: "action ACTION [ARGUMENT] (eg --then 'reject executables are not allowed')",
+	},
+	cgCli.IntFlag{
+		Name:  "priority, p",
+		Value: 0,
+		Usage: "Rules are evaluated by ascending priority",
+	},
+	cgCli.StringFlag{
+		Name:  "comment, c",
+		Usage: "Comment",
+	},
+}
+
+// ContentRule represents commands for dealing with content rules
+var ContentRule = cgCli.Command{
+	Name:  "contentrule",
+	Usage: "commands to manage content policy rules",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "add",
+			Usage:       "Add a content rule",
+			Description: "tmail contentrule add NAME [--if CONDITION...] --then ACTION [--then ACTION...] [-p PRIORITY] [-c COMMENT]",
+			Flags:       contentRuleFlags,
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.ContentRuleAdd(c.Args()[0], c.StringSlice("if"), c.StringSlice("then"), c.Int("p"), c.String("c")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "update",
+			Usage:       "Update a content rule (a new version is created, unset options are kept)",
+			Description: "tmail contentrule update NAME [--if CONDITION...] [--then ACTION...] [--no-condition] [-p PRIORITY] [-c COMMENT] [--disable|--enable]",
+			Flags: append([]cgCli.Flag{
+				cgCli.BoolFlag{
+					Name:  "no-condition",
+					Usage: "Remove all conditions (the rule matches all messages)",
+				},
+				cgCli.BoolFlag{
+					Name:  "disable",
+					Usage: "Disable the rule",
+				},
+				cgCli.BoolFlag{
+					Name:  "enable",
+					Usage: "Enable the rule",
+				},
+			}, contentRuleFlags...),
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				rule, err := api.ContentRuleGet(c.Args()[0])
+				cliHandleErr(err)
+				conditions := strings.Split(rule.Conditions, "\n")
+				if c.IsSet("if") || c.Bool("no-condition") {
+					conditions = c.StringSlice("if")
+				}
+				actions := strings.Split(rule.Actions, "\n")
+				if c.IsSet("then") {
+					actions = c.StringSlice("then")
+				}
+				priority := rule.Priority
+				if c.IsSet("priority") {
+					priority = c.Int("p")
+				}
+				comment := rule.Comment
+				if c.IsSet("comment") {
+					comment = c.String("c")
+				}
+				disabled := (rule.Disabled || c.Bool("disable")) && !c.Bool("enable")
+				cliHandleErr(api.ContentRuleUpdate(rule.Name, conditions, actions, priority, comment, disabled))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "rollback",
+			Usage:       "Restore a previous version of a content rule (as a new version)",
+			Description: "tmail contentrule rollback NAME VERSION",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				version, err := strconv.Atoi(c.Args()[1])
+				cliHandleErr(err)
+				cliHandleErr(api.ContentRuleRollback(c.Args()[0], version))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a content rule and its history",
+			Description: "tmail contentrule del NAME",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.ContentRuleDel(c.Args()[0]))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "List content rules",
+			Description: "tmail contentrule list",
+			Action: func(c *cgCli.Context) {
+				rules, err := api.ContentRuleGetAll()
+				cliHandleErr(err)
+				if len(rules) == 0 {
+					println("There is no content rule.")
+				} else {
+					for _, r := range rules {
+						line := fmt.Sprintf("%s v%d - priority: %d", r.Name, r.Version, r.Priority)
+						if r.Disabled {
+							line += " - disabled"
+						}
+						fmt.Println(line)
+						if r.Comment != "" {
+							fmt.Println("\t# " + r.Comment)
+						}
+						for _, condition := range strings.Split(r.Conditions, "\n") {
+							if condition != "" {
+								fmt.Println("\tif " + condition)
+							}
+						}
+						for _, action := range strings.Split(r.Actions, "\n") {
+							fmt.Println("\tthen " + action)
+						}
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "history",
+			Usage:       "List versions of a content rule",
+			Description: "tmail contentrule history NAME",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				rules, err := api.ContentRuleGetHistory(c.Args()[0])
+				cliHandleErr(err)
+				for _, r := range rules {
+					line := fmt.Sprintf("v%d %s - priority: %d", r.Version, r.CreatedAt.Format("2006-01-02 15:04:05"), r.Priority)
+					if r.Latest {
+						line += " - in use"
+					}
+					if r.Disabled {
+						line += " - disabled"
+					}
+					fmt.Println(line)
+					for _, condition := range strings.Split(r.Conditions, "\n") {
+						if condition != "" {
+							fmt.Println("\tif " + condition)
+						}
+					}
+					for _, action := range strings.Split(r.Actions, "\n") {
+						fmt.Println("\tthen " + action)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "test",
+			Usage:       "Evaluate content rules against a message (dry run)",
+			Description: "tmail contentrule test [-f MAIL_FROM] [-r RCPT_TO...] [--ip CLIENT_IP] [-u AUTH_USER] FILE.eml",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "from, f",
+					Usage: "Envelope sender",
+				},
+				cgCli.StringSliceFlag{
+					Name:  "rcpt, r",
+					Usage: "Envelope recipient",
+				},
+				cgCli.StringFlag{
+					Name:  "ip",
+					Value: "127.0.0.1",
+					Usage: "Client IP",
+				},
+				cgCli.StringFlag{
+					Name:  "user, u",
+					Usage: "Authenticated user",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				raw, err := ioutil.ReadFile(c.Args()[0])
+				cliHandleErr(err)
+				result, err := api.ContentRulesTest(raw, c.String("f"), c.StringSlice("r"), c.String("ip"), c.String("u"))
+				cliHandleErr(err)
+				if len(result.Matched) == 0 {
+					println("No rule matched.")
+				} else {
+					fmt.Println("Matched rules: " + strings.Join(result.Matched, ", "))
+				}
+				verdict := "Verdict: " + result.Verdict
+				if result.Message != "" {
+					verdict += " (" + result.Message + ")"
+				}
+				fmt.Println(verdict)
+				for _, header := range result.DelHeaders {
+					fmt.Println("Remove header: " + header)
+				}
+				for _, header := range result.AddHeaders {
+					fmt.Println("Add header: " + header)
+				}
+				if len(result.Redirect) != 0 {
+					fmt.Println("Redirect to: " + strings.Join(result.Redirect, ", "))
+				}
+				if len(result.Bcc) != 0 {
+					fmt.Println("Bcc: " + strings.Join(result.Bcc, ", "))
+				}
+				cliDieOk()
+			},
+		},
+	},
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Disclaimer represents commands for dealing with disclaimers
+var Disclaimer = cgCli.Command{
+	Name:  "disclaimer",
+	Usage: "commands to manage disclaimers added to outgoing messages",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "set",
+			Usage:       "Set the disclaimer of a domain or of an user",
+			Description: "tmail disclaimer set -d DOMAIN|-u USER TEXT_FILE [HTML_FILE]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "domain, d",
+					Usage: "Disclaimer of messages from this domain",
+				},
+				cgCli.StringFlag{
+					Name:  "user, u",
+					Usage: "Disclaimer of messages from this user",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 && len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				text, err := ioutil.ReadFile(c.Args()[0])
+				cliHandleErr(err)
+				html := []byte{}
+				if len(c.Args()) == 2 {
+					html, err = ioutil.ReadFile(c.Args()[1])
+					cliHandleErr(err)
+				}
+				cliHandleErr(api.DisclaimerSet(c.String("d"), c.String("u"), string(text), string(html)))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "List disclaimers",
+			Description: "tmail disclaimer list",
+			Action: func(c *cgCli.Context) {
+				disclaimers, err := api.DisclaimerGetAll()
+				cliHandleErr(err)
+				if len(disclaimers) == 0 {
+					println("There is no disclaimer.")
+				} else {
+					for _, d := range disclaimers {
+						line := fmt.Sprintf("%d domain: %s", d.Id, d.Domain)
+						if d.User != "" {
+							line = fmt.Sprintf("%d user: %s", d.Id, d.User)
+						}
+						if d.Html != "" {
+							line += " - text and HTML"
+						}
+						fmt.Println(line)
+						for _, l := range strings.Split(strings.TrimRight(d.Text, "\r\n"), "\n") {
+							fmt.Println("\t" + strings.TrimRight(l, "\r"))
+						}
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a disclaimer",
+			Description: "tmail disclaimer del ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.DisclaimerDel(id))
+				cliDieOk()
+			},
+		},
+	},
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Journal represents commands for dealing with journaling rules
+var Journal = cgCli.Command{
+	Name:  "journal",
+	Usage: "commands to manage journaling (archiving) rules",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "add",
+			Usage:       "Add a journaling rule",
+			Description: "tmail journal add inbound|outbound|both raw|envelope ADDRESS|store [-d DOMAIN|-u USER] [-r RETENTION_DAYS]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "domain, d",
+					Usage: "Only journal messages of this domain",
+				},
+				cgCli.StringFlag{
+					Name:  "user, u",
+					Usage: "Only journal messages of this user",
+				},
+				cgCli.IntFlag{
+					Name:  "retention, r",
+					Value: 0,
+					Usage: "Retention in days of copies in the archive store (default TMAIL_JOURNAL_RETENTION)",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 3 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.JournalRuleAdd(c.Args()[0], c.Args()[1], c.Args()[2], c.String("d"), c.String("u"), c.Int("r")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "List journaling rules",
+			Description: "tmail journal list",
+			Action: func(c *cgCli.Context) {
+				rules, err := api.JournalRuleGetAll()
+				cliHandleErr(err)
+				if len(rules) == 0 {
+					println("There is no journaling rule.")
+				} else {
+					for _, r := range rules {
+						line := fmt.Sprintf("%d %s %s -> %s", r.Id, r.Direction, r.Format, r.Destination)
+						switch {
+						case r.User != "":
+							line += " - user: " + r.User
+						case r.Domain != "":
+							line += " - domain: " + r.Domain
+						default:
+							line += " - all messages"
+						}
+						if r.Retention != 0 {
+							line += fmt.Sprintf(" - retention: %d days", r.Retention)
+						}
+						fmt.Println(line)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a journaling rule",
+			Description: "tmail journal del ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.JournalRuleDel(id))
+				cliDieOk()
+			},
+		},
+	},
+}
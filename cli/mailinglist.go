@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// MailingList represents commands for dealing with mailing lists
+var MailingList = cgCli.Command{
+	Name:  "mailinglist",
+	Usage: "commands to manage mailing lists",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "add",
+			Usage:       "Add a mailing list (a minilist alias with the same address is converted)",
+			Description: "tmail mailinglist add LIST [-d DESCRIPTION] [-p open|members|moderated] [-m MODERATOR1,MODERATOR2]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "description, d",
+					Usage: "List description (used in List-Id header)",
+				},
+				cgCli.StringFlag{
+					Name:  "policy, p",
+					Value: "members",
+					Usage: "Posting policy: open, members (only members can post) or moderated (posts are held for moderation)",
+				},
+				cgCli.StringFlag{
+					Name:  "moderators, m",
+					Usage: "Moderators addresses, comma separated",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.MailingListAdd(c.Args()[0], c.String("d"), c.String("p"), c.String("m")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "update",
+			Usage:       "Update a mailing list",
+			Description: "tmail mailinglist update LIST [-d DESCRIPTION] [-p open|members|moderated] [-m MODERATOR1,MODERATOR2]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "description, d",
+					Usage: "List description (used in List-Id header)",
+				},
+				cgCli.StringFlag{
+					Name:  "policy, p",
+					Usage: "Posting policy: open, members or moderated",
+				},
+				cgCli.StringFlag{
+					Name:  "moderators, m",
+					Usage: "Moderators addresses, comma separated",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				list, err := api.MailingListGet(c.Args()[0])
+				cliHandleErr(err)
+				description, policy, moderators := list.Description, list.Policy, list.Moderators
+				if c.IsSet("d") {
+					description = c.String("d")
+				}
+				if c.IsSet("p") {
+					policy = c.String("p")
+				}
+				if c.IsSet("m") {
+					moderators = c.String("m")
+				}
+				cliHandleErr(api.MailingListUpdate(c.Args()[0], description, policy, moderators))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a mailing list (and its members)",
+			Description: "tmail mailinglist del LIST",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.MailingListDel(c.Args()[0]))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "List mailing lists",
+			Description: "tmail mailinglist list",
+			Action: func(c *cgCli.Context) {
+				lists, err := api.MailingListGetAll()
+				cliHandleErr(err)
+				if len(lists) == 0 {
+					println("There is no mailing list.")
+				} else {
+					for _, list := range lists {
+						line := list.Address + " - policy: " + list.Policy
+						if list.Moderators != "" {
+							line += " - moderators: " + list.Moderators
+						}
+						if list.Description != "" {
+							line += " - " + list.Description
+						}
+						fmt.Println(line)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "members",
+			Usage:       "List members of a mailing list",
+			Description: "tmail mailinglist members LIST",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				members, err := api.MailingListMemberGetAll(c.Args()[0])
+				cliHandleErr(err)
+				if len(members) == 0 {
+					println("There is no member.")
+				} else {
+					for _, member := range members {
+						line := member.Address
+						if member.Bounces != 0 {
+							line += fmt.Sprintf(" - bounces: %d", member.Bounces)
+						}
+						fmt.Println(line)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "subscribe",
+			Usage:       "Add a member to a mailing list (without confirmation)",
+			Description: "tmail mailinglist subscribe LIST ADDRESS",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.MailingListMemberAdd(c.Args()[0], c.Args()[1]))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "unsubscribe",
+			Usage:       "Remove a member from a mailing list",
+			Description: "tmail mailinglist unsubscribe LIST ADDRESS",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.MailingListMemberDel(c.Args()[0], c.Args()[1]))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "pending",
+			Usage:       "List pending subscriptions and held posts of a mailing list",
+			Description: "tmail mailinglist pending LIST",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				pending, err := api.MailingListPendingGetAll(c.Args()[0])
+				cliHandleErr(err)
+				if len(pending) == 0 {
+					println("Nothing is pending.")
+				} else {
+					for _, p := range pending {
+						fmt.Println(fmt.Sprintf("%s - %s - %s - %s", p.Token, p.Kind, p.Address, p.CreatedAt.Format(time.RFC3339)))
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "approve",
+			Usage:       "Approve a held post",
+			Description: "tmail mailinglist approve TOKEN",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.MailingListModerate(c.Args()[0], true))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "reject",
+			Usage:       "Reject a held post",
+			Description: "tmail mailinglist reject TOKEN",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.MailingListModerate(c.Args()[0], false))
+				cliDieOk()
+			},
+		},
+	},
+}
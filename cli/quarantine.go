@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Quarantine represents commands for dealing with quarantined messages
+var Quarantine = cgCli.Command{
+	Name:  "quarantine",
+	Usage: "commands to manage quarantined messages",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "list",
+			Usage:       "List quarantined messages",
+			Description: "tmail quarantine list",
+			Action: func(c *cgCli.Context) {
+				messages, err := api.QuarantineGetAll()
+				cliHandleErr(err)
+				if len(messages) == 0 {
+					println("There is no quarantined message.")
+				} else {
+					fmt.Printf("%d quarantined messages.\n", len(messages))
+					for _, m := range messages {
+						fmt.Printf("%d - From: %s - To: %s - Subject: %s - Reason: %s - Added: %v - Expire: %v\n", m.Id, m.MailFrom, strings.Replace(m.RcptTo, ";", ", ", -1), m.Subject, m.Reason, m.CreatedAt, m.ExpireAt)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "show",
+			Usage:       "Show envelope, verdict and headers of a quarantined message",
+			Description: "tmail quarantine show MESSAGE_ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				m, err := api.QuarantineGet(id)
+				cliHandleErr(err)
+				headers, err := api.QuarantineGetHeaders(id)
+				cliHandleErr(err)
+				fmt.Printf("Mail from: %s\nRcpt to: %s\n", m.MailFrom, strings.Replace(m.RcptTo, ";", ", ", -1))
+				if m.AuthUser != "" {
+					fmt.Printf("Authenticated user: %s\n", m.AuthUser)
+				}
+				fmt.Printf("Size: %d\nReason: %s\nVerdict: %s\nAdded: %v\nExpire: %v\n\n%s", m.Size, m.Reason, m.Verdict, m.CreatedAt, m.ExpireAt, headers)
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "release",
+			Usage:       "Release (requeue) a quarantined message",
+			Description: "tmail quarantine release [-r RCPT] MESSAGE_ID",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "rcpt, r",
+					Usage: "Only release message for this recipient",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.QuarantineRelease(id, c.String("r")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a quarantined message",
+			Description: "tmail quarantine del MESSAGE_ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.QuarantineDel(id))
+				cliDieOk()
+			},
+		},
+	},
+}
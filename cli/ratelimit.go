@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// RateLimit represents commands for dealing with smtpd rate limits overrides
+var RateLimit = cgCli.Command{
+	Name:  "ratelimit",
+	Usage: "commands to manage smtpd rate limits per IP/network or per user",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "add",
+			Usage:       "Add (or replace) a rate limit override",
+			Description: "tmail ratelimit add ip|user CIDR|LOGIN [-s MAX_SESSIONS] [-c CONN_PER_MINUTE] [-m MSG_PER_HOUR] [-r RCPT_PER_HOUR]",
+			Flags: []cgCli.Flag{
+				cgCli.IntFlag{
+					Name:  "sessions, s",
+					Value: -1,
+					Usage: "Max concurrent sessions. 0 for unlimited, default from config if not set",
+				},
+				cgCli.IntFlag{
+					Name:  "conn, c",
+					Value: -1,
+					Usage: "Max connections per minute. 0 for unlimited, default from config if not set",
+				},
+				cgCli.IntFlag{
+					Name:  "msg, m",
+					Value: -1,
+					Usage: "Max messages per hour. 0 for unlimited, default from config if not set",
+				},
+				cgCli.IntFlag{
+					Name:  "rcpt, r",
+					Value: -1,
+					Usage: "Max recipients per hour. 0 for unlimited, default from config if not set",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.RateLimitAdd(c.Args()[0], c.Args()[1], c.Int("s"), c.Int("c"), c.Int("m"), c.Int("r")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "List rate limit overrides",
+			Description: "tmail ratelimit list",
+			Action: func(c *cgCli.Context) {
+				limits, err := api.RateLimitGetAll()
+				cliHandleErr(err)
+				if len(limits) == 0 {
+					println("There is no rate limit override, defaults from config are used.")
+				} else {
+					for _, l := range limits {
+						fmt.Println(fmt.Sprintf("%d %s %s - sessions: %s - conn/min: %s - msg/hour: %s - rcpt/hour: %s", l.Id, l.Scope, l.Target,
+							rateLimitValueToString(l.MaxSessions.Valid, l.MaxSessions.Int64),
+							rateLimitValueToString(l.ConnPerMinute.Valid, l.ConnPerMinute.Int64),
+							rateLimitValueToString(l.MsgPerHour.Valid, l.MsgPerHour.Int64),
+							rateLimitValueToString(l.RcptPerHour.Valid, l.RcptPerHour.Int64)))
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a rate limit override",
+			Description: "tmail ratelimit del ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.RateLimitDel(id))
+				cliDieOk()
+			},
+		},
+	},
+}
+
+// rateLimitValueToString returns a printable value of an override
+func rateLimitValueToString(valid bool, value int64) string {
+	if !valid {
+		return "default"
+	}
+	if value == 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(value, 10)
+}
@@ -34,6 +34,58 @@ var Rcpthost = cgCli.Command{
 				cliHandleErr(err)
 			},
 		},
+		// Update rcpthost
+		{
+			Name:        "update",
+			Usage:       "Change proprieties of a rcpthost",
+			Description: "tmail rcpthost update HOSTNAME [--lmtp ENDPOINT|none] [--delimiter CHARS|none] [--verify smtp|lmtp|ldap|none [--verify-endpoint ENDPOINT] [--verify-fail-open]]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "lmtp",
+					Usage: "LMTP endpoint for local deliveries (unix:/path or host:port), none to remove it",
+				},
+				cgCli.StringFlag{
+					Name:  "delimiter",
+					Usage: "recipient delimiter(s) for user+detail addresses (eg +), none to disable them",
+				},
+				cgCli.StringFlag{
+					Name:  "verify",
+					Usage: "verify recipients of relay host before accepting them (smtp, lmtp or ldap), none to disable it",
+				},
+				cgCli.StringFlag{
+					Name:  "verify-endpoint",
+					Usage: "backend to verify recipients on: host[:port] for smtp (default: routes of host), LMTP endpoint for lmtp",
+				},
+				cgCli.BoolFlag{
+					Name:  "verify-fail-open",
+					Usage: "accept recipients if backend can't be reached (default: temporary reject)",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 || (c.String("lmtp") == "" && c.String("delimiter") == "" && c.String("verify") == "") {
+					cliDieBadArgs(c)
+				}
+				if endpoint := c.String("lmtp"); endpoint != "" {
+					if endpoint == "none" {
+						endpoint = ""
+					}
+					cliHandleErr(api.RcpthostSetLmtpEndpoint(c.Args().First(), endpoint))
+				}
+				if delimiter := c.String("delimiter"); delimiter != "" {
+					if delimiter == "none" {
+						delimiter = ""
+					}
+					cliHandleErr(api.RcpthostSetRcptDelimiter(c.Args().First(), delimiter))
+				}
+				if method := c.String("verify"); method != "" {
+					if method == "none" {
+						method = ""
+					}
+					cliHandleErr(api.RcpthostSetRcptVerify(c.Args().First(), method, c.String("verify-endpoint"), c.Bool("verify-fail-open")))
+				}
+				cliDieOk()
+			},
+		},
 		// List rcpthosts
 		{
 			Name:        "list",
@@ -52,6 +104,23 @@ var Rcpthost = cgCli.Command{
 						} else {
 							line += "remote"
 						}
+						if host.LmtpEndpoint != "" {
+							line += " lmtp: " + host.LmtpEndpoint
+						}
+						if host.RcptDelimiter != "" {
+							line += " delimiter: " + host.RcptDelimiter
+						}
+						if host.RcptVerify != "" {
+							line += " verify: " + host.RcptVerify
+							if host.RcptVerifyEndpoint != "" {
+								line += " " + host.RcptVerifyEndpoint
+							}
+							if host.RcptVerifyFailOpen {
+								line += " (fail-open)"
+							} else {
+								line += " (fail-closed)"
+							}
+						}
 						fmt.Println(line)
 					}
 				}
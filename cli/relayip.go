@@ -2,6 +2,9 @@ package cli
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/toorop/tmail/api"
 	cgCli "github.com/urfave/cli"
@@ -14,13 +17,50 @@ var RelayIP = cgCli.Command{
 		// Add an authorized IP
 		{
 			Name:        "add",
-			Usage:       "Add an authorized IP",
-			Description: "tmail relayip add IP",
+			Usage:       "Add (or replace) an authorized IP or network (CIDR)",
+			Description: "tmail relayip add IP|CIDR [-d DESCRIPTION] [-e EXPIRES] [-s MAX_SIZE] [-f DOMAIN1,DOMAIN2] [--skip-scan]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "description, d",
+					Value: "",
+					Usage: "Description of this entry",
+				},
+				cgCli.StringFlag{
+					Name:  "expires, e",
+					Value: "",
+					Usage: "Expiration date (YYYY-MM-DD or RFC 3339) or duration (eg 72h)",
+				},
+				cgCli.Int64Flag{
+					Name:  "size, s",
+					Value: 0,
+					Usage: "Max message size in bytes. 0 for smtpd default",
+				},
+				cgCli.StringFlag{
+					Name:  "from, f",
+					Value: "",
+					Usage: "Comma separated list of allowed sender domains. Empty for any",
+				},
+				cgCli.BoolFlag{
+					Name:  "skip-scan",
+					Usage: "Do not scan messages relayed from this network",
+				},
+			},
 			Action: func(c *cgCli.Context) {
-				if len(c.Args()) == 0 {
+				if len(c.Args()) != 1 {
 					cliDieBadArgs(c)
 				}
-				cliHandleErr(api.RelayIpAdd(c.Args().First()))
+				var expiresAt *time.Time
+				if c.String("e") != "" {
+					t, err := parseExpires(c.String("e"))
+					cliHandleErr(err)
+					expiresAt = &t
+				}
+				senderDomains := []string{}
+				if c.String("f") != "" {
+					senderDomains = strings.Split(c.String("f"), ",")
+				}
+				cliHandleErr(api.RelayIpAdd(c.Args().First(), c.String("d"), expiresAt, c.Int64("s"), senderDomains, c.Bool("skip-scan")))
+				cliDieOk()
 			},
 		},
 		// List authorized IPs
@@ -35,7 +75,27 @@ var RelayIP = cgCli.Command{
 					println("There no athorized IP.")
 				} else {
 					for _, ip := range ips {
-						fmt.Println(fmt.Sprintf("%d %s", ip.Id, ip.Ip))
+						line := fmt.Sprintf("%d %s", ip.Id, ip.Ip)
+						if ip.Description != "" {
+							line += " (" + ip.Description + ")"
+						}
+						if ip.ExpiresAt != nil {
+							if ip.ExpiresAt.Before(time.Now()) {
+								line += " - expired " + ip.ExpiresAt.Format(time.RFC3339)
+							} else {
+								line += " - until " + ip.ExpiresAt.Format(time.RFC3339)
+							}
+						}
+						if ip.MaxSize != 0 {
+							line += fmt.Sprintf(" - max size: %d", ip.MaxSize)
+						}
+						if ip.SenderDomains != "" {
+							line += " - senders: " + ip.SenderDomains
+						}
+						if ip.SkipScan {
+							line += " - no scan"
+						}
+						fmt.Println(line)
 					}
 				}
 			},
@@ -44,15 +104,31 @@ var RelayIP = cgCli.Command{
 		// Delete relayip
 		{
 			Name:        "del",
-			Usage:       "Delete an authorized IP",
-			Description: "tmail relayip del IP",
+			Usage:       "Delete an authorized IP or network (CIDR)",
+			Description: "tmail relayip del IP|CIDR|ID",
 			Action: func(c *cgCli.Context) {
 				if len(c.Args()) == 0 {
 					cliDieBadArgs(c)
 				}
-				err := api.RelayIpDel(c.Args().First())
+				var err error
+				if id, errConv := strconv.ParseInt(c.Args().First(), 10, 64); errConv == nil {
+					err = api.RelayIpDelById(id)
+				} else {
+					err = api.RelayIpDel(c.Args().First())
+				}
 				cliHandleErr(err)
 			},
 		},
 	},
 }
+
+// parseExpires parses a date (YYYY-MM-DD or RFC 3339) or a duration from now
+func parseExpires(expires string) (time.Time, error) {
+	if d, err := time.ParseDuration(expires); err == nil {
+		return time.Now().Add(d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", expires, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, expires)
+}
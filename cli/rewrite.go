@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Rewrite represents commands for dealing with address rewriting rules
+var Rewrite = cgCli.Command{
+	Name:  "rewrite",
+	Usage: "commands to manage address rewriting rules",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "add",
+			Usage:       "Add a rewriting rule",
+			Description: "tmail rewrite add envelope|header|all sender|recipient|all exact|regex PATTERN REPLACEMENT [-u AUTH_USER] [-p PRIORITY]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "user, u",
+					Usage: "Only rewrite messages sent by this authenticated user",
+				},
+				cgCli.IntFlag{
+					Name:  "priority, p",
+					Value: 0,
+					Usage: "Rules are tried by ascending priority, the first matching one is applied",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 5 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.RewriteRuleAdd(c.Args()[0], c.Args()[1], c.Args()[2], c.Args()[3], c.Args()[4], c.String("u"), c.Int("p")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "list",
+			Usage:       "List rewriting rules",
+			Description: "tmail rewrite list",
+			Action: func(c *cgCli.Context) {
+				rules, err := api.RewriteRuleGetAll()
+				cliHandleErr(err)
+				if len(rules) == 0 {
+					println("There is no rewriting rule.")
+				} else {
+					for _, r := range rules {
+						line := fmt.Sprintf("%d %s %s %s %s -> %s - priority: %d", r.Id, r.Scope, r.Kind, r.Match, r.Pattern, r.Replacement, r.Priority)
+						if r.AuthUser != "" {
+							line += " - user: " + r.AuthUser
+						}
+						fmt.Println(line)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a rewriting rule",
+			Description: "tmail rewrite del ID",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				id, err := strconv.ParseInt(c.Args()[0], 10, 64)
+				cliHandleErr(err)
+				cliHandleErr(api.RewriteRuleDel(id))
+				cliDieOk()
+			},
+		},
+	},
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Sieve represents commands for dealing with users Sieve scripts
+var Sieve = cgCli.Command{
+	Name:  "sieve",
+	Usage: "commands to manage users Sieve scripts",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "list",
+			Usage:       "List Sieve scripts of an user",
+			Description: "tmail sieve list USER",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				scripts, err := api.SieveScriptList(c.Args()[0])
+				cliHandleErr(err)
+				if len(scripts) == 0 {
+					println("There is no sieve script for this user.")
+				} else {
+					for _, script := range scripts {
+						line := script.Name
+						if script.Active {
+							line += " (active)"
+						}
+						fmt.Println(line)
+					}
+				}
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "get",
+			Usage:       "Print a Sieve script",
+			Description: "tmail sieve get USER NAME",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				script, err := api.SieveScriptGet(c.Args()[0], c.Args()[1])
+				cliHandleErr(err)
+				fmt.Print(script.Script)
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "put",
+			Usage:       "Add or replace a Sieve script",
+			Description: "tmail sieve put USER NAME FILE [--activate]",
+			Flags: []cgCli.Flag{
+				cgCli.BoolFlag{
+					Name:  "activate, a",
+					Usage: "Activate the script",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 3 {
+					cliDieBadArgs(c)
+				}
+				script, err := ioutil.ReadFile(c.Args()[2])
+				cliHandleErr(err)
+				cliHandleErr(api.SieveScriptPut(c.Args()[0], c.Args()[1], string(script), c.Bool("activate")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete a Sieve script",
+			Description: "tmail sieve del USER NAME",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.SieveScriptDel(c.Args()[0], c.Args()[1]))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "activate",
+			Usage:       "Activate a Sieve script (without NAME all scripts are deactivated)",
+			Description: "tmail sieve activate USER [NAME]",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 && len(c.Args()) != 2 {
+					cliDieBadArgs(c)
+				}
+				name := ""
+				if len(c.Args()) == 2 {
+					name = c.Args()[1]
+				}
+				cliHandleErr(api.SieveScriptActivate(c.Args()[0], name))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "check",
+			Usage:       "Check syntax of a Sieve script",
+			Description: "tmail sieve check FILE",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				script, err := ioutil.ReadFile(c.Args()[0])
+				cliHandleErr(err)
+				cliHandleErr(api.SieveCheck(string(script)))
+				cliDieOk()
+			},
+		},
+	},
+}
@@ -58,26 +58,39 @@ var user = cgCli.Command{
 			},
 		},
 		// Update to change proprieties of an user
-		// for now only password change is handled
+		// for now password and LMTP endpoint changes are handled
 		{
 			Name:        "update",
 			Usage:       "change proprieties of an user",
-			Description: "tmail user update USER -p NEW_PASSWORD",
+			Description: "tmail user update USER [-p NEW_PASSWORD] [--lmtp ENDPOINT|none]",
 			Flags: []cgCli.Flag{
 				cgCli.StringFlag{
 					Name:  "password, p",
 					Usage: "update user password",
 				},
+				cgCli.StringFlag{
+					Name:  "lmtp",
+					Usage: "LMTP endpoint for local deliveries (unix:/path or host:port), none to remove it",
+				},
 			},
 			Action: func(c *cgCli.Context) {
 				if len(c.Args()) != 1 {
 					cliDieBadArgs(c)
 				}
+				if c.String("p") == "" && c.String("lmtp") == "" {
+					cliDieBadArgs(c)
+				}
 				if c.String("p") != "" {
 					cliHandleErr(api.UserChangePassword(c.Args()[0], c.String("p")))
-					cliDieOk()
 				}
-				cliDieBadArgs(c)
+				if c.String("lmtp") != "" {
+					endpoint := c.String("lmtp")
+					if endpoint == "none" {
+						endpoint = ""
+					}
+					cliHandleErr(api.UserSetLmtpEndpoint(c.Args()[0], endpoint))
+				}
+				cliDieOk()
 			},
 		},
 		{
@@ -114,6 +127,9 @@ var user = cgCli.Command{
 					} else {
 						line += " - catchall: no"
 					}
+					if user.LmtpEndpoint != "" {
+						line += " - lmtp: " + user.LmtpEndpoint
+					}
 					println(line)
 				}
 			},
@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/toorop/tmail/api"
+	cgCli "github.com/urfave/cli"
+)
+
+// Vacation represents commands for dealing with users out of office replies
+var Vacation = cgCli.Command{
+	Name:  "vacation",
+	Usage: "commands to manage users out of office replies",
+	Subcommands: []cgCli.Command{
+		{
+			Name:        "set",
+			Usage:       "Set (and enable) out of office reply of an user",
+			Description: "tmail vacation set USER -b BODY_FILE [-s SUBJECT] [--start DATE] [--end DATE] [-x SENDER1,DOMAIN2] [-d DAYS] [--disabled]",
+			Flags: []cgCli.Flag{
+				cgCli.StringFlag{
+					Name:  "body, b",
+					Usage: "File containing reply text",
+				},
+				cgCli.StringFlag{
+					Name:  "subject, s",
+					Usage: "Reply subject (default: Auto: original subject)",
+				},
+				cgCli.StringFlag{
+					Name:  "start",
+					Usage: "Start date (YYYY-MM-DD or RFC 3339)",
+				},
+				cgCli.StringFlag{
+					Name:  "end",
+					Usage: "End date (YYYY-MM-DD or RFC 3339)",
+				},
+				cgCli.StringFlag{
+					Name:  "exclude, x",
+					Usage: "Senders (addresses or domains) which never get a reply",
+				},
+				cgCli.IntFlag{
+					Name:  "days, d",
+					Value: 7,
+					Usage: "Min days between two replies to a same sender",
+				},
+				cgCli.BoolFlag{
+					Name:  "disabled",
+					Usage: "Save settings without enabling replies",
+				},
+			},
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 || c.String("b") == "" {
+					cliDieBadArgs(c)
+				}
+				body, err := ioutil.ReadFile(c.String("b"))
+				cliHandleErr(err)
+				var startAt, endAt *time.Time
+				if c.String("start") != "" {
+					t, err := parseDate(c.String("start"))
+					cliHandleErr(err)
+					startAt = &t
+				}
+				if c.String("end") != "" {
+					t, err := parseDate(c.String("end"))
+					cliHandleErr(err)
+					// YYYY-MM-DD: end of day
+					if len(c.String("end")) == 10 {
+						t = t.Add(24*time.Hour - time.Second)
+					}
+					endAt = &t
+				}
+				cliHandleErr(api.VacationSet(c.Args()[0], !c.Bool("disabled"), c.String("s"), string(body), startAt, endAt, c.String("x"), c.Int("d")))
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "show",
+			Usage:       "Show out of office reply of an user",
+			Description: "tmail vacation show USER",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				v, err := api.VacationGet(c.Args()[0])
+				cliHandleErr(err)
+				fmt.Println(fmt.Sprintf("Enabled: %v", v.Enabled))
+				if v.StartAt != nil {
+					fmt.Println("Start: " + v.StartAt.Format(time.RFC3339))
+				}
+				if v.EndAt != nil {
+					fmt.Println("End: " + v.EndAt.Format(time.RFC3339))
+				}
+				if v.ExcludedSenders != "" {
+					fmt.Println("Excluded senders: " + v.ExcludedSenders)
+				}
+				fmt.Println(fmt.Sprintf("Days: %d", v.Days))
+				fmt.Println("Subject: " + v.Subject)
+				fmt.Println("")
+				fmt.Println(v.Body)
+				cliDieOk()
+			},
+		},
+		{
+			Name:        "del",
+			Usage:       "Delete out of office reply of an user",
+			Description: "tmail vacation del USER",
+			Action: func(c *cgCli.Context) {
+				if len(c.Args()) != 1 {
+					cliDieBadArgs(c)
+				}
+				cliHandleErr(api.VacationDel(c.Args()[0]))
+				cliDieOk()
+			},
+		},
+	},
+}
+
+// parseDate parses YYYY-MM-DD (local time) or RFC 3339 date
+func parseDate(date string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", date, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, date)
+}
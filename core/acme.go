@@ -0,0 +1,468 @@
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/acme"
+)
+
+// ACME (RFC 8555) client
+// Certificates are obtained for Cfg.GetMe() and rcpthosts (one certificate
+// per name) using HTTP-01 (served on port 80 by LaunchAcmeHTTP01Server and by
+// the REST server) or DNS-01 (via a hook command), they are stored in DB with
+// the account key.
+
+// ACME challenges
+const (
+	AcmeChallengeHTTP01 = "http-01"
+	AcmeChallengeDNS01  = "dns-01"
+)
+
+// AcmeAccount represents an ACME account (one per CA directory)
+type AcmeAccount struct {
+	Id           int64
+	DirectoryUrl string `sql:"unique"`
+	Email        string
+	Key          string `sql:"type:text;"`
+	CreatedAt    time.Time
+}
+
+// AcmeCertificate represents a certificate obtained via ACME
+type AcmeCertificate struct {
+	Id        int64
+	Domain    string `sql:"unique"`
+	Cert      string `sql:"type:text;"`
+	Key       string `sql:"type:text;"`
+	NotAfter  time.Time
+	UpdatedAt time.Time
+}
+
+// AcmeCertificateGetAll returns all ACME certificates
+func AcmeCertificateGetAll() (certs []AcmeCertificate, err error) {
+	certs = []AcmeCertificate{}
+	err = DB.Order("domain").Find(&certs).Error
+	return
+}
+
+// acmeSolver fulfills a challenge
+type acmeSolver interface {
+	challengeType() string
+	present(client *acme.Client, domain string, challenge *acme.Challenge) error
+	cleanup(client *acme.Client, domain string, challenge *acme.Challenge)
+}
+
+// acmeHTTP01Path is the path of HTTP-01 challenges
+const acmeHTTP01Path = "/.well-known/acme-challenge/"
+
+// acmeHTTP01Tokens holds pending HTTP-01 responses (token -> key authorization)
+var acmeHTTP01Tokens = struct {
+	sync.Mutex
+	tokens map[string]string
+}{tokens: map[string]string{}}
+
+// AcmeHTTP01Response returns key authorization for a pending HTTP-01 token
+func AcmeHTTP01Response(token string) (string, bool) {
+	acmeHTTP01Tokens.Lock()
+	defer acmeHTTP01Tokens.Unlock()
+	response, ok := acmeHTTP01Tokens.tokens[token]
+	return response, ok
+}
+
+// acmeHTTP01Handler serves pending HTTP-01 responses
+// (/.well-known/acme-challenge/TOKEN)
+func acmeHTTP01Handler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, acmeHTTP01Path)
+	response, ok := AcmeHTTP01Response(token)
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+// LaunchAcmeHTTP01Server launches the plain HTTP server answering HTTP-01
+// challenges (CAs always validate them on port 80)
+func LaunchAcmeHTTP01Server() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(acmeHTTP01Path, acmeHTTP01Handler)
+	server := &http.Server{
+		Addr:         Cfg.GetAcmeHttp01Listen(),
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	Logger.Info("acme - HTTP-01 challenge server " + server.Addr + " launched")
+	log.Fatalln(server.ListenAndServe())
+}
+
+// acmeHTTP01Solver serves responses via acmeHTTP01Handler
+type acmeHTTP01Solver struct{}
+
+func (s acmeHTTP01Solver) challengeType() string {
+	return AcmeChallengeHTTP01
+}
+
+func (s acmeHTTP01Solver) present(client *acme.Client, domain string, challenge *acme.Challenge) error {
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+	acmeHTTP01Tokens.Lock()
+	acmeHTTP01Tokens.tokens[challenge.Token] = response
+	acmeHTTP01Tokens.Unlock()
+	return nil
+}
+
+func (s acmeHTTP01Solver) cleanup(client *acme.Client, domain string, challenge *acme.Challenge) {
+	acmeHTTP01Tokens.Lock()
+	delete(acmeHTTP01Tokens.tokens, challenge.Token)
+	acmeHTTP01Tokens.Unlock()
+}
+
+// acmeDNS01Solver calls hook to add/remove the TXT record:
+// HOOK present|cleanup _acme-challenge.DOMAIN VALUE
+// hook must return when record is published
+type acmeDNS01Solver struct {
+	hook string
+}
+
+func (s acmeDNS01Solver) challengeType() string {
+	return AcmeChallengeDNS01
+}
+
+func (s acmeDNS01Solver) run(action, domain, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, s.hook, action, "_acme-challenge."+domain, value).CombinedOutput()
+	if err != nil {
+		return errors.New("DNS hook " + action + " failed for " + domain + ": " + err.Error() + " - " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s acmeDNS01Solver) present(client *acme.Client, domain string, challenge *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	return s.run("present", domain, value)
+}
+
+func (s acmeDNS01Solver) cleanup(client *acme.Client, domain string, challenge *acme.Challenge) {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err == nil {
+		err = s.run("cleanup", domain, value)
+	}
+	if err != nil {
+		Logger.Error("acme - " + err.Error())
+	}
+}
+
+// acmeGetSolver returns solver for configured challenge
+func acmeGetSolver() (acmeSolver, error) {
+	switch Cfg.GetAcmeChallenge() {
+	case AcmeChallengeHTTP01:
+		return acmeHTTP01Solver{}, nil
+	case AcmeChallengeDNS01:
+		if Cfg.GetAcmeDnsHook() == "" {
+			return nil, errors.New("ACME DNS-01 challenge needs a DNS hook")
+		}
+		return acmeDNS01Solver{Cfg.GetAcmeDnsHook()}, nil
+	}
+	return nil, errors.New("unsupported ACME challenge " + Cfg.GetAcmeChallenge())
+}
+
+// acmeMarshalKey returns PEM encoded EC key
+func acmeMarshalKey(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+}
+
+// acmeParseKey parses PEM encoded EC key
+func acmeParseKey(data string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM data found in ACME key")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// acmeGetClient returns a client registered with configured CA
+// account key is created on first use
+func acmeGetClient(ctx context.Context) (*acme.Client, error) {
+	directoryUrl := Cfg.GetAcmeDirectoryUrl()
+	account := AcmeAccount{}
+	err := DB.Where("directory_url = ?", directoryUrl).Find(&account).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	var key *ecdsa.PrivateKey
+	if err == gorm.ErrRecordNotFound {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		account = AcmeAccount{
+			DirectoryUrl: directoryUrl,
+			Email:        Cfg.GetAcmeEmail(),
+		}
+		if account.Key, err = acmeMarshalKey(key); err != nil {
+			return nil, err
+		}
+		if err = DB.Save(&account).Error; err != nil {
+			return nil, err
+		}
+	} else if key, err = acmeParseKey(account.Key); err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: directoryUrl,
+		UserAgent:    "tmail/" + Version,
+	}
+	// test CA (pebble...)
+	if Cfg.GetAcmeInsecureSkipVerify() {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	}
+	acct := &acme.Account{}
+	if account.Email != "" {
+		acct.Contact = []string{"mailto:" + account.Email}
+	}
+	// registering an existing account returns its URL
+	if _, err = client.Register(ctx, acct, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, err
+	}
+	return client, nil
+}
+
+// acmeObtain orders a certificate for domain and returns PEM encoded
+// chain and key
+func acmeObtain(ctx context.Context, client *acme.Client, solver acmeSolver, domain string) (certPem, keyPem string, notAfter time.Time, err error) {
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return
+	}
+	for _, authzURL := range order.AuthzURLs {
+		var authz *acme.Authorization
+		if authz, err = client.GetAuthorization(ctx, authzURL); err != nil {
+			return
+		}
+		if authz.Status != acme.StatusPending {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == solver.challengeType() {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			err = errors.New("no " + solver.challengeType() + " challenge offered for " + domain)
+			return
+		}
+		if err = solver.present(client, domain, challenge); err != nil {
+			return
+		}
+		if _, err = client.Accept(ctx, challenge); err == nil {
+			_, err = client.WaitAuthorization(ctx, authz.URI)
+		}
+		solver.cleanup(client, domain, challenge)
+		if err != nil {
+			return
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return
+	}
+
+	// CSR
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, crypto.Signer(key))
+	if err != nil {
+		return
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return
+	}
+	for _, b := range der {
+		certPem += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b}))
+	}
+	if keyPem, err = acmeMarshalKey(key); err != nil {
+		return
+	}
+	return certPem, keyPem, leaf.NotAfter, nil
+}
+
+// acmeDomains returns names we need a certificate for
+func acmeDomains() ([]string, error) {
+	domains := []string{strings.ToLower(Cfg.GetMe())}
+	if Cfg.GetAcmeIncludeRcpthosts() {
+		rcpthosts, err := RcpthostGetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, rcpthost := range rcpthosts {
+			name := strings.ToLower(rcpthost.Hostname)
+			// no wildcard (and no IP)
+			if strings.Contains(name, "*") || net.ParseIP(name) != nil || IsStringInSlice(name, domains) {
+				continue
+			}
+			domains = append(domains, name)
+		}
+	}
+	return domains, nil
+}
+
+// AcmeRenew obtains missing certificates and renews those expiring soon
+// (or all if force is true)
+func AcmeRenew(force bool) error {
+	if !Cfg.GetAcmeEnabled() {
+		return errors.New("ACME is disabled")
+	}
+	domains, err := acmeDomains()
+	if err != nil {
+		return err
+	}
+	solver, err := acmeGetSolver()
+	if err != nil {
+		return err
+	}
+	var client *acme.Client
+	errs := []string{}
+	for _, domain := range domains {
+		cert := AcmeCertificate{}
+		err = DB.Where("domain = ?", domain).Find(&cert).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if !force && err == nil && time.Until(cert.NotAfter) > Cfg.GetAcmeRenewBefore() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if client == nil {
+			if client, err = acmeGetClient(ctx); err != nil {
+				cancel()
+				return err
+			}
+		}
+		Logger.Info("acme - requesting certificate for " + domain)
+		cert.Domain = domain
+		cert.Cert, cert.Key, cert.NotAfter, err = acmeObtain(ctx, client, solver, domain)
+		cancel()
+		if err != nil {
+			Logger.Error("acme - unable to get certificate for " + domain + " - " + err.Error())
+			errs = append(errs, domain+": "+err.Error())
+			continue
+		}
+		if err = DB.Save(&cert).Error; err != nil {
+			return err
+		}
+		acmeCerts.invalidate()
+		Logger.Info("acme - got certificate for " + domain + " valid until " + cert.NotAfter.Format(time.RFC3339))
+	}
+	if len(errs) != 0 {
+		return errors.New("ACME failures - " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// LaunchAcme checks certificates every 12 hours
+func LaunchAcme() {
+	for {
+		if err := AcmeRenew(false); err != nil {
+			Logger.Error("acme - " + err.Error())
+		}
+		time.Sleep(12 * time.Hour)
+	}
+}
+
+// acmeCertCache caches ACME certificates loaded from DB
+type acmeCertCache struct {
+	sync.Mutex
+	loaded bool
+	certs  map[string]*tls.Certificate
+}
+
+var acmeCerts = &acmeCertCache{}
+
+// invalidate forces reload from DB
+func (c *acmeCertCache) invalidate() {
+	c.Lock()
+	c.loaded = false
+	c.Unlock()
+}
+
+// get returns certificate for name (nil if none)
+func (c *acmeCertCache) get(name string) *tls.Certificate {
+	c.Lock()
+	defer c.Unlock()
+	if !c.loaded {
+		certs, err := AcmeCertificateGetAll()
+		if err != nil {
+			Logger.Error("acme - unable to load certificates - " + err.Error())
+			return nil
+		}
+		c.certs = map[string]*tls.Certificate{}
+		for _, cert := range certs {
+			tlsCert, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key))
+			if err != nil {
+				Logger.Error("acme - bad certificate for " + cert.Domain + " - " + err.Error())
+				continue
+			}
+			if tlsCert.Leaf, err = x509.ParseCertificate(tlsCert.Certificate[0]); err != nil {
+				continue
+			}
+			c.certs[cert.Domain] = &tlsCert
+		}
+		c.loaded = true
+	}
+	return c.certs[name]
+}
+
+// AcmeGetCertificate returns ACME certificate for name (Cfg.GetMe() if
+// name is empty), nil if ACME is disabled or there is no certificate
+func AcmeGetCertificate(name string) *tls.Certificate {
+	if !Cfg.GetAcmeEnabled() {
+		return nil
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		name = strings.ToLower(Cfg.GetMe())
+	}
+	return acmeCerts.get(name)
+}
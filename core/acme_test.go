@@ -0,0 +1,265 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+)
+
+// acmeTestCA is a minimal RFC 8555 CA (one order, HTTP-01 only, no JWS
+// verification) validating challenges against challengeUrl
+type acmeTestCA struct {
+	sync.Mutex
+	t            *testing.T
+	server       *httptest.Server
+	accountKey   *ecdsa.PrivateKey
+	challengeUrl string
+	caKey        *ecdsa.PrivateKey
+	caCert       *x509.Certificate
+	nonce        int
+	domain       string
+	token        string
+	authzStatus  string
+	orderStatus  string
+	cert         []byte
+}
+
+func newAcmeTestCA(t *testing.T, accountKey *ecdsa.PrivateKey, challengeUrl string) *acmeTestCA {
+	ca := &acmeTestCA{t: t, accountKey: accountKey, challengeUrl: challengeUrl, token: "tmail-test-token"}
+	var err error
+	ca.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tmail test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, ca.caKey.Public(), ca.caKey)
+	assert.NoError(t, err)
+	ca.caCert, _ = x509.ParseCertificate(der)
+	ca.server = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	return ca
+}
+
+// payload returns the JWS payload of r
+func (ca *acmeTestCA) payload(r *http.Request, v interface{}) {
+	jws := struct{ Payload string }{}
+	body, _ := ioutil.ReadAll(r.Body)
+	assert.NoError(ca.t, json.Unmarshal(body, &jws))
+	if v == nil {
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	assert.NoError(ca.t, err)
+	assert.NoError(ca.t, json.Unmarshal(payload, v))
+}
+
+func (ca *acmeTestCA) order() map[string]interface{} {
+	order := map[string]interface{}{
+		"status":         ca.orderStatus,
+		"identifiers":    []map[string]string{{"type": "dns", "value": ca.domain}},
+		"authorizations": []string{ca.server.URL + "/authz"},
+		"finalize":       ca.server.URL + "/finalize",
+	}
+	if ca.cert != nil {
+		order["certificate"] = ca.server.URL + "/cert"
+	}
+	return order
+}
+
+func (ca *acmeTestCA) challenge() map[string]string {
+	status := "pending"
+	if ca.authzStatus != "pending" {
+		status = ca.authzStatus
+	}
+	return map[string]string{"type": AcmeChallengeHTTP01, "url": ca.server.URL + "/challenge", "token": ca.token, "status": status}
+}
+
+// validate fetches HTTP-01 response like a CA
+func (ca *acmeTestCA) validate() {
+	ca.authzStatus = "invalid"
+	ca.orderStatus = "invalid"
+	req, _ := http.NewRequest("GET", ca.challengeUrl+acmeHTTP01Path+ca.token, nil)
+	req.Host = ca.domain
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	thumbprint, _ := acme.JWKThumbprint(ca.accountKey.Public())
+	if resp.StatusCode == 200 && string(body) == ca.token+"."+thumbprint {
+		ca.authzStatus = "valid"
+		ca.orderStatus = "ready"
+	}
+}
+
+func (ca *acmeTestCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.Lock()
+	defer ca.Unlock()
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", "nonce"+strconv.Itoa(ca.nonce))
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	switch r.URL.Path {
+	case "/directory":
+		reply(200, map[string]string{
+			"newNonce":   ca.server.URL + "/nonce",
+			"newAccount": ca.server.URL + "/account",
+			"newOrder":   ca.server.URL + "/order/new",
+		})
+	case "/nonce":
+		w.WriteHeader(200)
+	case "/account":
+		ca.payload(r, nil)
+		w.Header().Set("Location", ca.server.URL+"/account/1")
+		reply(201, map[string]string{"status": "valid"})
+	case "/order/new":
+		req := struct {
+			Identifiers []struct{ Type, Value string }
+		}{}
+		ca.payload(r, &req)
+		if assert.Len(ca.t, req.Identifiers, 1) {
+			ca.domain = req.Identifiers[0].Value
+		}
+		ca.authzStatus, ca.orderStatus = "pending", "pending"
+		w.Header().Set("Location", ca.server.URL+"/order")
+		reply(201, ca.order())
+	case "/order":
+		ca.payload(r, nil)
+		w.Header().Set("Location", ca.server.URL+"/order")
+		reply(200, ca.order())
+	case "/authz":
+		ca.payload(r, nil)
+		reply(200, map[string]interface{}{
+			"status":     ca.authzStatus,
+			"identifier": map[string]string{"type": "dns", "value": ca.domain},
+			"challenges": []map[string]string{
+				{"type": AcmeChallengeDNS01, "url": ca.server.URL + "/challenge/dns", "token": "dns", "status": "pending"},
+				ca.challenge(),
+			},
+		})
+	case "/challenge":
+		ca.payload(r, nil)
+		ca.validate()
+		reply(200, ca.challenge())
+	case "/finalize":
+		req := struct{ Csr string }{}
+		ca.payload(r, &req)
+		der, err := base64.RawURLEncoding.DecodeString(req.Csr)
+		assert.NoError(ca.t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		if !assert.NoError(ca.t, err) || ca.orderStatus != "ready" {
+			reply(403, map[string]string{"type": "urn:ietf:params:acme:error:orderNotReady", "detail": "order not ready"})
+			return
+		}
+		assert.Equal(ca.t, []string{ca.domain}, csr.DNSNames)
+		ca.cert, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: ca.domain},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second),
+		}, ca.caCert, csr.PublicKey, ca.caKey)
+		assert.NoError(ca.t, err)
+		ca.orderStatus = "valid"
+		w.Header().Set("Location", ca.server.URL+"/order")
+		reply(200, ca.order())
+	case "/cert":
+		ca.payload(r, nil)
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// acmeTestClient returns a client registered with ca
+func acmeTestClient(t *testing.T, ca *acmeTestCA, key *ecdsa.PrivateKey) *acme.Client {
+	client := &acme.Client{Key: key, DirectoryURL: ca.server.URL + "/directory"}
+	_, err := client.Register(context.Background(), &acme.Account{}, acme.AcceptTOS)
+	assert.NoError(t, err)
+	return client
+}
+
+func Test_acmeObtainHTTP01(t *testing.T) {
+	// HTTP-01 challenge server
+	challengeServer := httptest.NewServer(http.HandlerFunc(acmeHTTP01Handler))
+	defer challengeServer.Close()
+
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ca := newAcmeTestCA(t, accountKey, challengeServer.URL)
+	defer ca.server.Close()
+	client := acmeTestClient(t, ca, accountKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	certPem, keyPem, notAfter, err := acmeObtain(ctx, client, acmeHTTP01Solver{}, "mail.example.com")
+	if !assert.NoError(t, err) {
+		return
+	}
+	block, rest := pem.Decode([]byte(certPem))
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mail.example.com"}, leaf.DNSNames)
+	assert.Equal(t, leaf.NotAfter, notAfter)
+	// chain
+	block, _ = pem.Decode(rest)
+	assert.NotNil(t, block)
+	key, err := acmeParseKey(keyPem)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Public(), leaf.PublicKey)
+
+	// tokens are cleaned up
+	_, ok := AcmeHTTP01Response(ca.token)
+	assert.False(t, ok)
+	resp, err := http.Get(challengeServer.URL + acmeHTTP01Path + ca.token)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func Test_acmeObtainHTTP01Unreachable(t *testing.T) {
+	// challenges are not served
+	challengeServer := httptest.NewServer(http.NotFoundHandler())
+	defer challengeServer.Close()
+
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ca := newAcmeTestCA(t, accountKey, challengeServer.URL)
+	defer ca.server.Close()
+	client := acmeTestClient(t, ca, accountKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, _, err = acmeObtain(ctx, client, acmeHTTP01Solver{}, "mail.example.com")
+	assert.Error(t, err)
+	_, ok := AcmeHTTP01Response(ca.token)
+	assert.False(t, ok)
+}
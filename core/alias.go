@@ -3,6 +3,8 @@ package core
 import (
 	"errors"
 	"os/exec"
+	"os/user"
+	"path"
 	"strings"
 
 	"github.com/jinzhu/gorm"
@@ -16,6 +18,12 @@ type Alias struct {
 	Pipe       string `sql:"null"`
 	IsDomAlias bool   `sql:"default:false"`
 	IsMiniList bool   `sql:"default:false"`
+	// local part is a glob pattern (support-*@domain)
+	IsPattern bool `sql:"default:false"`
+	// pipe options (empty or 0: config default)
+	PipeUser    string `sql:"null"`
+	PipeDir     string `sql:"null"`
+	PipeTimeout int
 }
 
 // AliasGet returns an alias
@@ -49,6 +57,17 @@ func AliasAdd(alias, deliverTo, pipe string, isMiniList bool) error {
 		return errors.New("you can't use --minilist option on dmain alias")
 	}
 
+	// pattern (only on local part)
+	if strings.ContainsAny(localDom[len(localDom)-1], "*?[") {
+		return errors.New("wildcards are not allowed in domain part of alias " + alias)
+	}
+	isPattern := !isDomAlias && strings.ContainsAny(localDom[0], "*?[")
+	if isPattern {
+		if _, err := path.Match(localDom[0], ""); err != nil {
+			return errors.New("bad pattern " + localDom[0] + ". " + err.Error())
+		}
+	}
+
 	// exists ?
 	exists, err := AliasExists(alias)
 	if err != nil {
@@ -104,9 +123,12 @@ func AliasAdd(alias, deliverTo, pipe string, isMiniList bool) error {
 		pipe = strings.TrimSpace(pipe)
 		// check the cmd
 		// first part is the command
-		cmd := strings.SplitN(pipe, " ", 1)
+		args, err := parsePipeCommand(pipe)
+		if err != nil {
+			return err
+		}
 		// file existe and is executable ?
-		_, err := exec.LookPath(cmd[0])
+		_, err = exec.LookPath(args[0])
 		if err != nil {
 			return err
 		}
@@ -177,9 +199,98 @@ func AliasAdd(alias, deliverTo, pipe string, isMiniList bool) error {
 		Pipe:       pipe,
 		IsDomAlias: isDomAlias,
 		IsMiniList: isMiniList,
+		IsPattern:  isPattern,
 	}).Error
 }
 
+// AliasMatch returns the alias of address rcpt: the alias rcpt if it exists
+// or else the most specific pattern alias matching rcpt (the one with the
+// most literal characters, the oldest one if there is a tie)
+// It returns gorm.ErrRecordNotFound if there is none
+func AliasMatch(rcpt string) (alias Alias, err error) {
+	rcpt = strings.ToLower(rcpt)
+	alias, err = AliasGet(rcpt)
+	if err != gorm.ErrRecordNotFound {
+		return alias, err
+	}
+	return aliasMatchPattern(rcpt)
+}
+
+// aliasMatchPattern returns the most specific pattern alias matching rcpt
+// (lowercased), gorm.ErrRecordNotFound if there is none
+func aliasMatchPattern(rcpt string) (alias Alias, err error) {
+	i := strings.LastIndex(rcpt, "@")
+	if i == -1 {
+		return alias, gorm.ErrRecordNotFound
+	}
+	patterns := []Alias{}
+	if err = DB.Where("is_pattern = ? AND alias LIKE ?", true, "%@"+rcpt[i+1:]).Order("id").Find(&patterns).Error; err != nil {
+		return alias, err
+	}
+	best := aliasBestPattern(patterns, rcpt)
+	if best == -1 {
+		return Alias{}, gorm.ErrRecordNotFound
+	}
+	return patterns[best], nil
+}
+
+// aliasBestPattern returns the index of the most specific pattern matching
+// rcpt (the first one if there is a tie), -1 if none matches
+func aliasBestPattern(patterns []Alias, rcpt string) int {
+	best := -1
+	for j, pattern := range patterns {
+		if ok, _ := path.Match(pattern.Alias, rcpt); !ok {
+			continue
+		}
+		if best == -1 || aliasPatternWeight(pattern.Alias) > aliasPatternWeight(patterns[best].Alias) {
+			best = j
+		}
+	}
+	return best
+}
+
+// aliasPatternWeight returns the number of literal characters of pattern
+func aliasPatternWeight(pattern string) int {
+	weight := 0
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '\\':
+			i++
+			weight++
+		case c != '*' && c != '?':
+			weight++
+		}
+	}
+	return weight
+}
+
+// AliasSetPipeOptions sets user, working directory and timeout (in seconds)
+// of alias pipe command (empty or 0: config default)
+func AliasSetPipeOptions(aliasStr, runAs, dir string, timeout int) error {
+	alias, err := AliasGet(strings.ToLower(aliasStr))
+	if err != nil {
+		return err
+	}
+	if alias.Pipe == "" {
+		return errors.New("alias " + alias.Alias + " has no pipe command")
+	}
+	if timeout < 0 {
+		return errors.New("timeout must be positive")
+	}
+	if runAs != "" {
+		if _, err = user.Lookup(runAs); err != nil {
+			return err
+		}
+	}
+	alias.PipeUser, alias.PipeDir, alias.PipeTimeout = runAs, dir, timeout
+	return DB.Save(&alias).Error
+}
+
 // AliasDel is used to delete an alias
 func AliasDel(alias string) error {
 	a, err := AliasGet(alias)
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_aliasPatternWeight(t *testing.T) {
+	tests := []struct {
+		pattern string
+		weight  int
+	}{
+		{"*@example.com", 12},
+		{"john@example.com", 16},
+		{"john-*@example.com", 17},
+		{"j?hn@example.com", 15},
+		{"[abc]*@example.com", 12},
+		{"[^a-c]x@example.com", 13},
+		{"\\*@example.com", 13},
+		{"*", 0},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.weight, aliasPatternWeight(test.pattern), test.pattern)
+	}
+}
+
+func Test_aliasBestPattern(t *testing.T) {
+	patterns := []Alias{
+		{Alias: "*@example.com"},
+		{Alias: "sales-*@example.com"},
+		{Alias: "*-eu@example.com"},
+		{Alias: "[st]*@example.com"},
+	}
+	tests := []struct {
+		rcpt string
+		best int
+	}{
+		{"john@example.com", 0},
+		{"sales-us@example.com", 1},
+		// tie: the first (oldest) one
+		{"sales-eu@example.com", 1},
+		{"support-eu@example.com", 2},
+		{"tom@example.com", 0},
+		{"john@example.org", -1},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.best, aliasBestPattern(patterns, test.rcpt), test.rcpt)
+	}
+}
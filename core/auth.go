@@ -0,0 +1,90 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator is an authentication backend
+type Authenticator interface {
+	// Name returns backend name
+	Name() string
+	// Authenticate checks login/passwd and returns corresponding user.
+	// It returns ErrAuthFailed if credentials are rejected
+	Authenticate(login, passwd string) (*User, error)
+}
+
+// authenticators available backends
+var authenticators = map[string]func() Authenticator{
+	"sql":           func() Authenticator { return sqlAuthenticator{} },
+	"ldap":          func() Authenticator { return ldapAuthenticator{} },
+	"dovecot":       func() Authenticator { return dovecotAuthenticator{} },
+	"checkpassword": func() Authenticator { return checkpasswordAuthenticator{} },
+}
+
+// Authenticate checks login/passwd against configured backends, in order.
+// First backend accepting credentials wins.
+func Authenticate(login, passwd string) (user *User, err error) {
+	if len(login) == 0 || len(passwd) == 0 {
+		return nil, ErrAuthFailed
+	}
+	var lastErr error
+	for _, name := range Cfg.GetSmtpdAuthBackends() {
+		newAuthenticator, ok := authenticators[name]
+		if !ok {
+			Logger.Error("auth - unknown authentication backend " + name)
+			continue
+		}
+		user, err = newAuthenticator().Authenticate(login, passwd)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrAuthFailed {
+			Logger.Error("auth - backend " + name + " failed - " + err.Error())
+			lastErr = err
+		}
+	}
+	// if a backend failed we don't know if credentials are bad
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrAuthFailed
+}
+
+// authExternalUser returns User for a login authenticated by an external
+// backend: local user if it exists (AuthRelay, mailbox... are then taken from
+// local DB) or a transient one
+func authExternalUser(login string) (*User, error) {
+	user, err := UserGetByLogin(login)
+	if err == nil {
+		return user, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return &User{
+		Login:     strings.ToLower(login),
+		Active:    "Y",
+		AuthRelay: Cfg.GetSmtpdAuthExternalRelay(),
+	}, nil
+}
+
+// sqlAuthenticator local users table (bcrypt hashes)
+type sqlAuthenticator struct{}
+
+func (a sqlAuthenticator) Name() string {
+	return "sql"
+}
+
+func (a sqlAuthenticator) Authenticate(login, passwd string) (*User, error) {
+	user, err := UserGet(login, passwd)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound || err == bcrypt.ErrMismatchedHashAndPassword {
+			return nil, ErrAuthFailed
+		}
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkpasswordAuthenticator authenticates users with an external
+// checkpassword program (http://cr.yp.to/checkpwd/interface.html)
+// login\0passwd\0timestamp\0 is written on fd 3, exit status 0 means
+// credentials are valid, 1 rejected, anything else is a temporary failure
+type checkpasswordAuthenticator struct{}
+
+func (a checkpasswordAuthenticator) Name() string {
+	return "checkpassword"
+}
+
+func (a checkpasswordAuthenticator) Authenticate(login, passwd string) (*User, error) {
+	command := strings.Fields(Cfg.GetSmtpdAuthCheckpassword())
+	if len(command) == 0 {
+		return nil, errors.New("checkpassword command is not defined")
+	}
+	// checkpassword runs the program given as last argument on success
+	command = append(command, "/bin/true")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(Cfg.GetSmtpdAuthBackendTimeout())*time.Second)
+	defer cancel()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.ExtraFiles = []*os.File{r}
+	if err = cmd.Start(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	_, err = w.Write([]byte(login + "\x00" + passwd + "\x00" + strconv.FormatInt(time.Now().Unix(), 10) + "\x00"))
+	w.Close()
+	if err != nil {
+		cmd.Wait()
+		return nil, err
+	}
+	if err = cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, ErrAuthFailed
+		}
+		if ctx.Err() != nil {
+			return nil, errors.New("checkpassword timeout")
+		}
+		return nil, errors.New("checkpassword failed - " + err.Error())
+	}
+	return authExternalUser(login)
+}
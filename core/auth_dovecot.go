@@ -0,0 +1,89 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dovecotAuthenticator authenticates users against dovecot auth service
+// via its client protocol over unix socket
+// https://doc.dovecot.org/developer_manual/design/auth_protocol/
+type dovecotAuthenticator struct{}
+
+func (a dovecotAuthenticator) Name() string {
+	return "dovecot"
+}
+
+func (a dovecotAuthenticator) Authenticate(login, passwd string) (*User, error) {
+	timeout := time.Duration(Cfg.GetSmtpdAuthBackendTimeout()) * time.Second
+	conn, err := net.DialTimeout("unix", Cfg.GetSmtpdAuthDovecotSocket(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// handshake
+	if _, err = conn.Write([]byte("VERSION\t1\t1\nCPID\t" + strconv.Itoa(os.Getpid()) + "\n")); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	plainSupported := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		t := strings.Split(strings.TrimRight(line, "\n"), "\t")
+		if t[0] == "VERSION" && (len(t) < 2 || t[1] != "1") {
+			return nil, errors.New("dovecot auth - unsupported protocol version " + strings.Join(t[1:], "."))
+		}
+		if t[0] == "MECH" && len(t) > 1 && strings.ToUpper(t[1]) == "PLAIN" {
+			plainSupported = true
+		}
+		if t[0] == "DONE" {
+			break
+		}
+	}
+	if !plainSupported {
+		return nil, errors.New("dovecot auth - PLAIN mechanism is not available")
+	}
+
+	// auth
+	// login & passwd can't contain \t or \n, fields are base64 encoded
+	// TLS policy is enforced by smtpd, so the request is flagged as secured
+	resp := base64.StdEncoding.EncodeToString([]byte("\x00" + login + "\x00" + passwd))
+	if _, err = conn.Write([]byte("AUTH\t1\tPLAIN\tservice=smtp\tsecured\tno-penalty\tresp=" + resp + "\n")); err != nil {
+		return nil, err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	t := strings.Split(strings.TrimRight(line, "\n"), "\t")
+	switch t[0] {
+	case "OK":
+		// dovecot may have canonicalized login
+		for _, field := range t[2:] {
+			if strings.HasPrefix(field, "user=") {
+				login = field[5:]
+			}
+		}
+		return authExternalUser(login)
+	case "FAIL":
+		for _, field := range t[2:] {
+			if field == "temp" {
+				return nil, errors.New("dovecot auth - temporary failure")
+			}
+		}
+		return nil, ErrAuthFailed
+	default:
+		return nil, errors.New("dovecot auth - unexpected response " + t[0])
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ldapAuthenticator authenticates users against a LDAP directory:
+// user entry is searched (as bind DN or anonymously) then we bind as this
+// entry with user password
+type ldapAuthenticator struct{}
+
+func (a ldapAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a ldapAuthenticator) Authenticate(login, passwd string) (*User, error) {
+	if Cfg.GetSmtpdAuthLdapUrl() == "" || Cfg.GetSmtpdAuthLdapBaseDn() == "" {
+		return nil, errors.New("LDAP url or base DN is not defined")
+	}
+	conn, err := ldapDial(Cfg.GetSmtpdAuthLdapUrl(), Cfg.GetSmtpdAuthLdapStartTLS(), time.Duration(Cfg.GetSmtpdAuthBackendTimeout())*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// search user
+	if Cfg.GetSmtpdAuthLdapBindDn() != "" {
+		if err = conn.Bind(Cfg.GetSmtpdAuthLdapBindDn(), Cfg.GetSmtpdAuthLdapBindPasswd()); err != nil {
+			return nil, err
+		}
+	}
+	filter := strings.Replace(Cfg.GetSmtpdAuthLdapFilter(), "%s", ldapEscapeFilter(login), -1)
+	attributes := []string{"1.1"}
+	relayAttribute := strings.ToLower(Cfg.GetSmtpdAuthLdapRelayAttribute())
+	if relayAttribute != "" {
+		attributes = []string{relayAttribute}
+	}
+	entries, err := conn.Search(Cfg.GetSmtpdAuthLdapBaseDn(), filter, attributes)
+	if err != nil {
+		// more than one entry
+		if lerr, ok := err.(*ldapError); ok && lerr.code == 4 {
+			return nil, errors.New("LDAP filter " + filter + " matches more than one entry")
+		}
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrAuthFailed
+	}
+
+	// bind as user
+	if err = conn.Bind(entries[0].dn, passwd); err != nil {
+		if lerr, ok := err.(*ldapError); ok && lerr.code == ldapResultInvalidCredentials {
+			return nil, ErrAuthFailed
+		}
+		return nil, err
+	}
+
+	user, err := authExternalUser(login)
+	if err != nil {
+		return nil, err
+	}
+	// relay granted by directory
+	if relayAttribute != "" {
+		user.AuthRelay = false
+		for _, v := range entries[0].attributes[relayAttribute] {
+			if Cfg.GetSmtpdAuthLdapRelayValue() == "" || strings.EqualFold(v, Cfg.GetSmtpdAuthLdapRelayValue()) {
+				user.AuthRelay = true
+				break
+			}
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/toorop/tmail/message"
+)
+
+// Automatic responses (vacation)
+// https://tools.ietf.org/html/rfc3834
+// https://tools.ietf.org/html/rfc5230
+//
+// A response is sent at most once every N days to a same sender (for a same
+// handle) and is never sent to lists, robots or other automatic responders.
+
+// AutoReplyLog keeps track of responses sent
+type AutoReplyLog struct {
+	Id     int64
+	Login  string
+	Sender string
+	Handle string
+	SentAt time.Time
+}
+
+// autoReply is an automatic response of user login
+type autoReply struct {
+	login     string
+	from      string   // From (login if empty or not owned by user)
+	owned     []string // other addresses of the user allowed as From
+	subject   string   // Subject ("Auto: " + original subject if empty)
+	body      string   // text or MIME entity if mime is true
+	mime      bool     // body is a MIME entity
+	addresses []string // other addresses of the user
+	handle    string   // responses with different handles are tracked apart
+	days      int      // min days between two responses to a same sender
+}
+
+// autoReplyIgnoredSenders are local parts of senders we never reply to
+var autoReplyIgnoredSenders = []string{"mailer-daemon", "postmaster", "listserv", "majordomo", "noreply", "no-reply", "do-not-reply", "donotreply"}
+
+// shouldReply checks if a response has to be sent to sender for message m
+func (r *autoReply) shouldReply(sender string, m *message.Message) bool {
+	// bounces
+	sender = strings.ToLower(sender)
+	if sender == "" || sender == "#@[]" {
+		return false
+	}
+	// robots and lists
+	localPart := strings.Split(sender, "@")[0]
+	for _, ignored := range autoReplyIgnoredSenders {
+		if localPart == ignored {
+			return false
+		}
+	}
+	if strings.HasPrefix(localPart, "owner-") || strings.HasSuffix(localPart, "-request") {
+		return false
+	}
+	if v := strings.ToLower(m.GetHeader("Auto-Submitted")); v != "" && v != "no" {
+		return false
+	}
+	for _, h := range []string{"List-Id", "List-Help", "List-Unsubscribe", "List-Subscribe", "List-Post", "List-Owner", "List-Archive"} {
+		if m.HaveHeader(h) {
+			return false
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(m.GetHeader("Precedence"))) {
+	case "bulk", "list", "junk":
+		return false
+	}
+	if v := strings.ToLower(m.GetHeader("X-Auto-Response-Suppress")); strings.Contains(v, "oof") || strings.Contains(v, "all") {
+		return false
+	}
+
+	// user must be an explicit recipient and not the sender
+	addresses := append([]string{r.login}, r.addresses...)
+	for _, address := range addresses {
+		if strings.EqualFold(address, sender) {
+			return false
+		}
+	}
+	for _, h := range []string{"To", "Cc", "Bcc", "Resent-To", "Resent-Cc", "Resent-Bcc"} {
+		for _, value := range m.GetHeaders(h) {
+			list, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, rcpt := range list {
+				for _, address := range addresses {
+					if strings.EqualFold(rcpt.Address, address) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// alreadySent checks if a response has been sent to sender in the last days
+// and if not records that it will be
+func (r *autoReply) alreadySent(sender string) (bool, error) {
+	days := r.days
+	if days < 1 {
+		days = 1
+	} else if days > 365 {
+		days = 365
+	}
+	log := AutoReplyLog{}
+	err := DB.Where("login = ? and sender = ? and handle = ?", r.login, strings.ToLower(sender), r.handle).Find(&log).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+	if err == nil && log.SentAt.Add(time.Duration(days)*24*time.Hour).After(time.Now()) {
+		return true, nil
+	}
+	log.Login, log.Sender, log.Handle, log.SentAt = r.login, strings.ToLower(sender), r.handle, time.Now()
+	return false, DB.Save(&log).Error
+}
+
+// send queues a response to sender for raw message if needed
+func (r *autoReply) send(sender string, raw []byte) (sent bool, err error) {
+	m, err := message.New(&raw)
+	if err != nil {
+		return false, err
+	}
+	if !r.shouldReply(sender, m) {
+		return false, nil
+	}
+	if _, err = mail.ParseAddress(sender); err != nil {
+		return false, errors.New("bad sender address " + sender)
+	}
+	if sent, err = r.alreadySent(sender); sent || err != nil {
+		return false, err
+	}
+
+	// build response
+	subject := r.subject
+	if subject == "" {
+		subject = m.GetHeader("Subject")
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		subject = "Auto: " + subject
+	}
+	headers := []string{
+		"From: " + r.fromHeader(),
+		"To: " + sender,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Auto-Submitted: auto-replied",
+	}
+	if inReplyTo := strings.TrimSpace(m.GetHeader("Message-Id")); inReplyTo != "" {
+		headers = append(headers, "In-Reply-To: "+inReplyTo)
+		references := strings.TrimSpace(m.GetHeader("References"))
+		if references != "" {
+			references += " "
+		}
+		headers = append(headers, "References: "+references+inReplyTo)
+	}
+	data, err := buildMessage(headers, r.body, r.mime)
+	if err != nil {
+		return false, err
+	}
+	// RFC 3834 3.3: null return path
+	envelope := message.Envelope{MailFrom: "", RcptTo: []string{sender}}
+	if _, err = QueueAddMessage(&data, envelope, ""); err != nil {
+		return false, fmt.Errorf("unable to queue response. %s", err)
+	}
+	return true, nil
+}
+
+// fromHeader returns From of the response: from if it is a valid address
+// owned by user, login otherwise
+func (r *autoReply) fromHeader() string {
+	if r.from == "" || strings.ContainsAny(r.from, "\r\n") {
+		return r.login
+	}
+	address, err := mail.ParseAddress(r.from)
+	if err != nil {
+		return r.login
+	}
+	for _, owned := range append([]string{r.login}, r.owned...) {
+		if strings.EqualFold(address.Address, owned) {
+			return address.String()
+		}
+	}
+	return r.login
+}
+
+// buildMessage returns a message with headers (Date, Message-ID and
+// MIME-Version are added) and a text/plain body or, if isMime is true, a
+// MIME entity (headers + body)
+func buildMessage(headers []string, body string, isMime bool) ([]byte, error) {
+	messageID, err := NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	headers = append(headers,
+		"Date: "+time.Now().Format(Time822),
+		"Message-ID: <"+messageID+"@"+Cfg.GetMe()+">",
+		"MIME-Version: 1.0",
+	)
+	buf := new(bytes.Buffer)
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n")
+	if isMime {
+		buf.WriteString(body)
+	} else {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(buf)
+		if _, err = qp.Write([]byte(body)); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	data := buf.Bytes()
+	if err = Unix2dos(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/toorop/tmail/message"
+)
+
+func Test_autoReplyFromHeader(t *testing.T) {
+	tests := []struct {
+		from     string
+		expected string
+	}{
+		{"", "john@example.com"},
+		{"JOHN@example.com", "<JOHN@example.com>"},
+		{"John Doe <john.doe@example.com>", `"John Doe" <john.doe@example.com>`},
+		{"jane@example.com", "john@example.com"},
+		{"not an address", "john@example.com"},
+		{"john@example.com\r\nBcc: jane@example.com", "john@example.com"},
+		{"john@example.com, jane@example.com", "john@example.com"},
+	}
+	for _, test := range tests {
+		r := autoReply{login: "john@example.com", from: test.from, owned: []string{"john.doe@example.com"}}
+		assert.Equal(t, test.expected, r.fromHeader(), test.from)
+	}
+}
+
+func Test_autoReplyShouldReply(t *testing.T) {
+	r := autoReply{login: "john@example.com", addresses: []string{"john+work@example.com"}}
+	tests := []struct {
+		sender  string
+		headers string
+		reply   bool
+	}{
+		{"jane@example.org", "To: John <john@example.com>\r\n", true},
+		{"jane@example.org", "To: jane@example.org\r\nCc: JOHN+work@example.com\r\n", true},
+		{"jane@example.org", "Resent-To: john@example.com\r\n", true},
+		// not an explicit recipient
+		{"jane@example.org", "To: team@example.com\r\n", false},
+		{"jane@example.org", "To: not an address\r\n", false},
+		// bounces and robots
+		{"", "To: john@example.com\r\n", false},
+		{"#@[]", "To: john@example.com\r\n", false},
+		{"MAILER-DAEMON@example.org", "To: john@example.com\r\n", false},
+		{"no-reply@example.org", "To: john@example.com\r\n", false},
+		{"owner-list@example.org", "To: john@example.com\r\n", false},
+		{"list-request@example.org", "To: john@example.com\r\n", false},
+		// sender is the user
+		{"John@example.com", "To: john@example.com\r\n", false},
+		// automatic messages
+		{"jane@example.org", "To: john@example.com\r\nAuto-Submitted: auto-replied\r\n", false},
+		{"jane@example.org", "To: john@example.com\r\nAuto-Submitted: no\r\n", true},
+		{"jane@example.org", "To: john@example.com\r\nList-Id: <list.example.org>\r\n", false},
+		{"jane@example.org", "To: john@example.com\r\nList-Unsubscribe: <mailto:leave@example.org>\r\n", false},
+		{"jane@example.org", "To: john@example.com\r\nPrecedence: bulk\r\n", false},
+		{"jane@example.org", "To: john@example.com\r\nPrecedence: List\r\n", false},
+		{"jane@example.org", "To: john@example.com\r\nPrecedence: first-class\r\n", true},
+		{"jane@example.org", "To: john@example.com\r\nX-Auto-Response-Suppress: OOF, AutoReply\r\n", false},
+		{"jane@example.org", "To: john@example.com\r\nX-Auto-Response-Suppress: DR\r\n", true},
+	}
+	for _, test := range tests {
+		raw := []byte("From: " + test.sender + "\r\n" + test.headers + "Subject: test\r\n\r\nbody\r\n")
+		m, err := message.New(&raw)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, test.reply, r.shouldReply(test.sender, m), test)
+	}
+}
+
+func Test_autoReplyAlreadySent(t *testing.T) {
+	defer setTestDB(t, &AutoReplyLog{})()
+
+	r := autoReply{login: "john@example.com", handle: "vacation", days: 7}
+	sent, err := r.alreadySent("Jane@example.org")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	sent, err = r.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.True(t, sent)
+
+	// handles and users are tracked apart
+	other := autoReply{login: "john@example.com", handle: "sieve:abc", days: 7}
+	sent, err = other.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	other = autoReply{login: "bob@example.com", handle: "vacation", days: 7}
+	sent, err = other.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	// a new response after N days
+	assert.NoError(t, DB.Model(&AutoReplyLog{}).Where("login = ? and handle = ?", "john@example.com", "vacation").Update("sent_at", time.Now().Add(-8*24*time.Hour)).Error)
+	sent, err = r.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	sent, err = r.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.True(t, sent)
+
+	// days is at least 1
+	r.days = 0
+	assert.NoError(t, DB.Model(&AutoReplyLog{}).Where("login = ? and handle = ?", "john@example.com", "vacation").Update("sent_at", time.Now().Add(-23*time.Hour)).Error)
+	sent, err = r.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.True(t, sent)
+	assert.NoError(t, DB.Model(&AutoReplyLog{}).Where("login = ? and handle = ?", "john@example.com", "vacation").Update("sent_at", time.Now().Add(-25*time.Hour)).Error)
+	sent, err = r.alreadySent("jane@example.org")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	count := 0
+	assert.NoError(t, DB.Model(&AutoReplyLog{}).Count(&count).Error)
+	assert.Equal(t, 3, count)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -47,10 +48,105 @@ type Config struct {
 		SmtpdMaxVrfy             int    `name:"smtpd_max_vrfy" default:"0"`
 		SmtpdClamavEnabled       bool   `name:"smtpd_scan_clamav_enabled" default:"false"`
 		SmtpdClamavDsns          string `name:"smtpd_scan_clamav_dsns" default:""`
+		SmtpdClamavQuarantine    bool   `name:"smtpd_scan_clamav_quarantine" default:"false"`
 		SmtpdConcurrencyIncoming int    `name:"smtpd_concurrency_incoming" default:"20"`
 
-		LaunchDeliverd               bool   `name:"deliverd_launch" default:"false"`
-		LocalIps                     string `name:"deliverd_local_ips" default:"_"`
+		// rate limiting (0 means unlimited)
+		SmtpdRateLimitEnabled           bool `name:"smtpd_ratelimit_enabled" default:"false"`
+		SmtpdRateLimitIpMaxSessions     int  `name:"smtpd_ratelimit_ip_max_sessions" default:"0"`
+		SmtpdRateLimitIpConnPerMinute   int  `name:"smtpd_ratelimit_ip_conn_per_minute" default:"0"`
+		SmtpdRateLimitIpMsgPerHour      int  `name:"smtpd_ratelimit_ip_msg_per_hour" default:"0"`
+		SmtpdRateLimitIpRcptPerHour     int  `name:"smtpd_ratelimit_ip_rcpt_per_hour" default:"0"`
+		SmtpdRateLimitUserMaxSessions   int  `name:"smtpd_ratelimit_user_max_sessions" default:"0"`
+		SmtpdRateLimitUserConnPerMinute int  `name:"smtpd_ratelimit_user_conn_per_minute" default:"0"`
+		SmtpdRateLimitUserMsgPerHour    int  `name:"smtpd_ratelimit_user_msg_per_hour" default:"0"`
+		SmtpdRateLimitUserRcptPerHour   int  `name:"smtpd_ratelimit_user_rcpt_per_hour" default:"0"`
+
+		// submission listeners: none, enforce or rewrite
+		SmtpdSubmissionSenderPolicy string `name:"smtpd_submission_sender_policy" default:"enforce"`
+
+		// SMTP AUTH
+		SmtpdAuthMechanisms          string `name:"smtpd_auth_mechanisms" default:"PLAIN LOGIN CRAM-MD5 SCRAM-SHA-256 XOAUTH2 OAUTHBEARER"`
+		SmtpdAuthPlaintextWithoutTLS bool   `name:"smtpd_auth_plaintext_without_tls" default:"false"`
+		SmtpdAuthOauthJwksFile       string `name:"smtpd_auth_oauth_jwks_file" default:"_"`
+		SmtpdAuthOauthIssuer         string `name:"smtpd_auth_oauth_issuer" default:"_"`
+		SmtpdAuthOauthAudience       string `name:"smtpd_auth_oauth_audience" default:"_"`
+		SmtpdAuthOauthUserClaim      string `name:"smtpd_auth_oauth_user_claim" default:"email"`
+
+		// authentication backends (sql, ldap, dovecot, checkpassword)
+		SmtpdAuthBackends           string `name:"smtpd_auth_backends" default:"sql"`
+		SmtpdAuthBackendTimeout     int    `name:"smtpd_auth_backend_timeout" default:"10"`
+		SmtpdAuthExternalRelay      bool   `name:"smtpd_auth_external_relay" default:"false"`
+		SmtpdAuthLdapUrl            string `name:"smtpd_auth_ldap_url" default:"_"`
+		SmtpdAuthLdapStartTLS       bool   `name:"smtpd_auth_ldap_starttls" default:"false"`
+		SmtpdAuthLdapBindDn         string `name:"smtpd_auth_ldap_bind_dn" default:"_"`
+		SmtpdAuthLdapBindPasswd     string `name:"smtpd_auth_ldap_bind_passwd" default:"_"`
+		SmtpdAuthLdapBaseDn         string `name:"smtpd_auth_ldap_base_dn" default:"_"`
+		SmtpdAuthLdapFilter         string `name:"smtpd_auth_ldap_filter" default:"(mail=%s)"`
+		SmtpdAuthLdapRelayAttribute string `name:"smtpd_auth_ldap_relay_attribute" default:"_"`
+		SmtpdAuthLdapRelayValue     string `name:"smtpd_auth_ldap_relay_value" default:"_"`
+		SmtpdAuthDovecotSocket      string `name:"smtpd_auth_dovecot_socket" default:"/var/run/dovecot/auth-client"`
+		SmtpdAuthCheckpassword      string `name:"smtpd_auth_checkpassword" default:"_"`
+
+		// brute force protection (durations in seconds, 0 failures means no ban/lock)
+		SmtpdAuthBruteforceEnabled      bool `name:"smtpd_auth_bruteforce_enabled" default:"true"`
+		SmtpdAuthBruteforceWindow       int  `name:"smtpd_auth_bruteforce_window" default:"900"`
+		SmtpdAuthBruteforceMaxDelay     int  `name:"smtpd_auth_bruteforce_max_delay" default:"10"`
+		SmtpdAuthBruteforceIpFailures   int  `name:"smtpd_auth_bruteforce_ip_failures" default:"10"`
+		SmtpdAuthBruteforceIpBan        int  `name:"smtpd_auth_bruteforce_ip_ban" default:"3600"`
+		SmtpdAuthBruteforceUserFailures int  `name:"smtpd_auth_bruteforce_user_failures" default:"20"`
+		SmtpdAuthBruteforceUserLock     int  `name:"smtpd_auth_bruteforce_user_lock" default:"1800"`
+
+		// PROXY protocol (listeners with proxy flag in dsn)
+		SmtpdProxyProtocolTrusted string `name:"smtpd_proxy_protocol_trusted" default:"127.0.0.1 ::1"`
+		SmtpdProxyProtocolTimeout int    `name:"smtpd_proxy_protocol_timeout" default:"5"`
+
+		// TLS certificates
+		SmtpdTLSSniDir         string `name:"smtpd_tls_sni_dir" default:"_"`
+		SmtpdTLSReloadInterval int    `name:"smtpd_tls_reload_interval" default:"60"`
+
+		// XCLIENT/XFORWARD trusted networks
+		SmtpdXclientTrusted  string `name:"smtpd_xclient_trusted" default:"_"`
+		SmtpdXforwardTrusted string `name:"smtpd_xforward_trusted" default:"_"`
+
+		// Recipient verification for relay domains
+		SmtpdRcptVerifyTimeout     int    `name:"smtpd_rcpt_verify_timeout" default:"10"`
+		SmtpdRcptVerifyCacheTtl    int    `name:"smtpd_rcpt_verify_cache_ttl" default:"3600"`
+		SmtpdRcptVerifyNegativeTtl int    `name:"smtpd_rcpt_verify_negative_ttl" default:"600"`
+		SmtpdRcptVerifySender      string `name:"smtpd_rcpt_verify_sender" default:"_"`
+		SmtpdRcptVerifyLdapFilter  string `name:"smtpd_rcpt_verify_ldap_filter" default:"(mail=%s)"`
+
+		LaunchDeliverd            bool   `name:"deliverd_launch" default:"false"`
+		LocalIps                  string `name:"deliverd_local_ips" default:"_"`
+		DeliverdIpPreference      string `name:"deliverd_ip_preference" default:"any"`
+		DeliverdLocalAgent        string `name:"deliverd_local_agent" default:"_"`
+		DeliverdLmtpEndpoint      string `name:"deliverd_lmtp_endpoint" default:"_"`
+		DeliverdLmtpBatchWait     int    `name:"deliverd_lmtp_batch_wait" default:"500"`
+		DeliverdLmtpTimeout       int    `name:"deliverd_lmtp_timeout" default:"300"`
+		DeliverdLmtpDelimiter     string `name:"deliverd_lmtp_delimiter" default:"+"`
+		DeliverdPipeTimeout       int    `name:"deliverd_pipe_timeout" default:"300"`
+		DeliverdPipeUser          string `name:"deliverd_pipe_user" default:"_"`
+		DeliverdPipeDir           string `name:"deliverd_pipe_dir" default:"_"`
+		DeliverdPipeLimitCpu      int    `name:"deliverd_pipe_limit_cpu" default:"0"`
+		DeliverdPipeLimitAs       string `name:"deliverd_pipe_limit_as" default:"_"`
+		DeliverdPipeLimitNofile   int    `name:"deliverd_pipe_limit_nofile" default:"0"`
+		DeliverdPipeLimitFsize    string `name:"deliverd_pipe_limit_fsize" default:"_"`
+		DeliverdSieveEnabled      bool   `name:"deliverd_sieve_enabled" default:"true"`
+		DeliverdSieveMaxRedirects int    `name:"deliverd_sieve_max_redirects" default:"4"`
+		DeliverdRcptDetailFolder  bool   `name:"deliverd_rcpt_detail_folder" default:"false"`
+
+		MailingListBaseUrl         string `name:"mailinglist_base_url" default:"_"`
+		MailingListBounceThreshold int    `name:"mailinglist_bounce_threshold" default:"5"`
+
+		QuarantineTtl            int    `name:"quarantine_ttl" default:"30"`
+		QuarantineDigestInterval int    `name:"quarantine_digest_interval" default:"0"`
+		QuarantineBaseUrl        string `name:"quarantine_base_url" default:"_"`
+		QuarantineSecret         string `name:"quarantine_secret" default:"_"`
+
+		JournalStoreDriver string `name:"journal_store_driver" default:"disk"`
+		JournalStoreSource string `name:"journal_store_source" default:"_"`
+		JournalRetention   int    `name:"journal_retention" default:"0"`
+
 		DeliverdConcurrencyLocal     int    `name:"deliverd_concurrency_local" default:"50"`
 		DeliverdConcurrencyRemote    int    `name:"deliverd_concurrency_remote" default:"50"`
 		DeliverdQueueLifetime        int    `name:"deliverd_queue_lifetime" default:"10080"`
@@ -59,6 +155,8 @@ type Config struct {
 		DeliverdRemoteTLSSkipVerify  bool   `name:"deliverd_remote_tls_skipverify" default:"false"`
 		DeliverdRemoteTLSFallback    bool   `name:"deliverd_remote_tls_fallback" default:"false"`
 		DeliverdDkimSign             bool   `name:"deliverd_dkim_sign" default:"false"`
+		DeliverdDisclaimerSigned     string `name:"deliverd_disclaimer_signed" default:"skip"`
+		DeliverdDisclaimerFailure    string `name:"deliverd_disclaimer_failure" default:"send"`
 
 		// RFC compliance
 		// RFC 5321 2.3.5: the domain name givent MUST be either a primary hostname
@@ -91,8 +189,20 @@ type Config struct {
 		RestServerLogin  string `name:"rest_server_login" default:""`
 		RestServerPasswd string `name:"rest_server_passwd" default:""`
 
+		// ACME
+		AcmeEnabled            bool   `name:"acme_enabled" default:"false"`
+		AcmeDirectoryUrl       string `name:"acme_directory_url" default:"https://acme-v02.api.letsencrypt.org/directory"`
+		AcmeEmail              string `name:"acme_email" default:"_"`
+		AcmeChallenge          string `name:"acme_challenge" default:"http-01"`
+		AcmeHttp01Listen       string `name:"acme_http01_listen" default:":80"`
+		AcmeDnsHook            string `name:"acme_dns_hook" default:"_"`
+		AcmeIncludeRcpthosts   bool   `name:"acme_include_rcpthosts" default:"true"`
+		AcmeRenewBefore        int    `name:"acme_renew_before" default:"30"`
+		AcmeInsecureSkipVerify bool   `name:"acme_insecure_skip_verify" default:"false"`
+
 		UsersHomeBase           string `name:"users_home_base" default:"/home"`
 		UserMailboxDefaultQuota string `name:"users_mailbox_default_quota" default:""`
+		UsersMaildir            string `name:"users_maildir" default:"Maildir"`
 
 		DovecotLda            string `name:"dovecot_lda" default:""`
 		DovecotSupportEnabled bool   `name:"dovecot_support_enabled" default:"false"`
@@ -332,6 +442,14 @@ func (c *Config) GetSmtpdClamavEnabled() bool {
 	return c.cfg.SmtpdClamavEnabled
 }
 
+// GetSmtpdClamavQuarantine returns true if infected messages are
+// quarantined instead of rejected
+func (c *Config) GetSmtpdClamavQuarantine() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdClamavQuarantine
+}
+
 // GetSmtpdClamavDsns returns clamav dsns
 func (c *Config) GetSmtpdClamavDsns() string {
 	c.Lock()
@@ -346,6 +464,355 @@ func (c *Config) GetSmtpdConcurrencyIncoming() int {
 	return c.cfg.SmtpdConcurrencyIncoming
 }
 
+// GetSmtpdSubmissionSenderPolicy returns how MAIL FROM/From are checked
+// against authenticated user on submission listeners
+func (c *Config) GetSmtpdSubmissionSenderPolicy() string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.ToLower(c.cfg.SmtpdSubmissionSenderPolicy)
+}
+
+// GetSmtpdAuthMechanisms returns SASL mechanisms enabled for smtpd
+func (c *Config) GetSmtpdAuthMechanisms() []string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.Fields(strings.ToUpper(c.cfg.SmtpdAuthMechanisms))
+}
+
+// GetSmtpdAuthPlaintextWithoutTLS returns true if plaintext mechanisms
+// (PLAIN, LOGIN, bearer tokens) are allowed on clear connections
+func (c *Config) GetSmtpdAuthPlaintextWithoutTLS() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthPlaintextWithoutTLS
+}
+
+// GetSmtpdAuthOauthJwksFile returns path to the JWKS file used to validate
+// OAUTHBEARER/XOAUTH2 tokens (empty if OAuth is disabled)
+func (c *Config) GetSmtpdAuthOauthJwksFile() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthOauthJwksFile == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthOauthJwksFile
+}
+
+// GetSmtpdAuthOauthIssuer returns expected issuer of OAuth tokens
+func (c *Config) GetSmtpdAuthOauthIssuer() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthOauthIssuer == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthOauthIssuer
+}
+
+// GetSmtpdAuthOauthAudience returns expected audience of OAuth tokens
+func (c *Config) GetSmtpdAuthOauthAudience() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthOauthAudience == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthOauthAudience
+}
+
+// GetSmtpdAuthOauthUserClaim returns the token claim holding user login
+func (c *Config) GetSmtpdAuthOauthUserClaim() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthOauthUserClaim
+}
+
+// GetSmtpdAuthBackends returns authentication backends, in order
+func (c *Config) GetSmtpdAuthBackends() []string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.Fields(strings.ToLower(c.cfg.SmtpdAuthBackends))
+}
+
+// GetSmtpdAuthBackendTimeout returns timeout (in seconds) for external
+// authentication backends
+func (c *Config) GetSmtpdAuthBackendTimeout() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthBackendTimeout
+}
+
+// GetSmtpdAuthExternalRelay returns AuthRelay for users authenticated by an
+// external backend without local account
+func (c *Config) GetSmtpdAuthExternalRelay() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthExternalRelay
+}
+
+// GetSmtpdAuthLdapUrl returns LDAP server URL (ldap:// or ldaps://)
+func (c *Config) GetSmtpdAuthLdapUrl() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthLdapUrl == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthLdapUrl
+}
+
+// GetSmtpdAuthLdapStartTLS returns true if STARTTLS must be used on ldap://
+func (c *Config) GetSmtpdAuthLdapStartTLS() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthLdapStartTLS
+}
+
+// GetSmtpdAuthLdapBindDn returns DN used to search users (empty for anonymous)
+func (c *Config) GetSmtpdAuthLdapBindDn() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthLdapBindDn == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthLdapBindDn
+}
+
+// GetSmtpdAuthLdapBindPasswd returns password of search DN
+func (c *Config) GetSmtpdAuthLdapBindPasswd() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthLdapBindPasswd == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthLdapBindPasswd
+}
+
+// GetSmtpdAuthLdapBaseDn returns base DN for users search
+func (c *Config) GetSmtpdAuthLdapBaseDn() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthLdapBaseDn == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthLdapBaseDn
+}
+
+// GetSmtpdAuthLdapFilter returns users search filter (%s is replaced by login)
+func (c *Config) GetSmtpdAuthLdapFilter() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthLdapFilter
+}
+
+// GetSmtpdAuthLdapRelayAttribute returns the attribute granting relay
+func (c *Config) GetSmtpdAuthLdapRelayAttribute() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthLdapRelayAttribute == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthLdapRelayAttribute
+}
+
+// GetSmtpdAuthLdapRelayValue returns the value of relay attribute granting
+// relay (empty: any value)
+func (c *Config) GetSmtpdAuthLdapRelayValue() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthLdapRelayValue == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthLdapRelayValue
+}
+
+// GetSmtpdAuthDovecotSocket returns path to dovecot auth-client socket
+func (c *Config) GetSmtpdAuthDovecotSocket() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthDovecotSocket
+}
+
+// GetSmtpdAuthCheckpassword returns checkpassword command
+func (c *Config) GetSmtpdAuthCheckpassword() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdAuthCheckpassword == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdAuthCheckpassword
+}
+
+// GetSmtpdAuthBruteforceEnabled returns true if failed auth are tracked
+func (c *Config) GetSmtpdAuthBruteforceEnabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthBruteforceEnabled
+}
+
+// GetSmtpdAuthBruteforceWindow returns the period (in seconds) over which
+// failures are counted
+func (c *Config) GetSmtpdAuthBruteforceWindow() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdAuthBruteforceWindow) * time.Second
+}
+
+// GetSmtpdAuthBruteforceMaxDelay returns max delay (in seconds) before
+// replying to a failed auth
+func (c *Config) GetSmtpdAuthBruteforceMaxDelay() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthBruteforceMaxDelay
+}
+
+// GetSmtpdAuthBruteforceIpFailures returns number of failures before IP ban
+func (c *Config) GetSmtpdAuthBruteforceIpFailures() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthBruteforceIpFailures
+}
+
+// GetSmtpdAuthBruteforceIpBan returns IP ban duration
+func (c *Config) GetSmtpdAuthBruteforceIpBan() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdAuthBruteforceIpBan) * time.Second
+}
+
+// GetSmtpdAuthBruteforceUserFailures returns number of failures before
+// user lockout
+func (c *Config) GetSmtpdAuthBruteforceUserFailures() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdAuthBruteforceUserFailures
+}
+
+// GetSmtpdAuthBruteforceUserLock returns user lockout duration
+func (c *Config) GetSmtpdAuthBruteforceUserLock() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdAuthBruteforceUserLock) * time.Second
+}
+
+// GetSmtpdProxyProtocolTrusted returns IPs and networks allowed to send
+// a PROXY protocol header
+func (c *Config) GetSmtpdProxyProtocolTrusted() []string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.Fields(c.cfg.SmtpdProxyProtocolTrusted)
+}
+
+// GetSmtpdProxyProtocolTimeout returns timeout for reading PROXY protocol header
+func (c *Config) GetSmtpdProxyProtocolTimeout() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdProxyProtocolTimeout) * time.Second
+}
+
+// GetSmtpdTLSSniDir returns directory of per hostname certificates
+func (c *Config) GetSmtpdTLSSniDir() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdTLSSniDir == "_" {
+		return path.Join(GetBasePath(), "ssl/sni")
+	}
+	return c.cfg.SmtpdTLSSniDir
+}
+
+// GetSmtpdTLSReloadInterval returns how often certificates files are checked
+// for changes
+func (c *Config) GetSmtpdTLSReloadInterval() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdTLSReloadInterval) * time.Second
+}
+
+// GetSmtpdXclientTrusted returns IPs and networks allowed to use XCLIENT
+func (c *Config) GetSmtpdXclientTrusted() []string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdXclientTrusted == "_" {
+		return []string{}
+	}
+	return strings.Fields(c.cfg.SmtpdXclientTrusted)
+}
+
+// GetSmtpdXforwardTrusted returns IPs and networks allowed to use XFORWARD
+func (c *Config) GetSmtpdXforwardTrusted() []string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdXforwardTrusted == "_" {
+		return []string{}
+	}
+	return strings.Fields(c.cfg.SmtpdXforwardTrusted)
+}
+
+// GetSmtpdRcptVerifyTimeout returns timeout of recipient verification
+func (c *Config) GetSmtpdRcptVerifyTimeout() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdRcptVerifyTimeout) * time.Second
+}
+
+// GetSmtpdRcptVerifyCacheTtl returns how long a valid recipient is cached
+func (c *Config) GetSmtpdRcptVerifyCacheTtl() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdRcptVerifyCacheTtl) * time.Second
+}
+
+// GetSmtpdRcptVerifyNegativeTtl returns how long an unknown recipient is
+// cached
+func (c *Config) GetSmtpdRcptVerifyNegativeTtl() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.SmtpdRcptVerifyNegativeTtl) * time.Second
+}
+
+// GetSmtpdRcptVerifySender returns sender used by SMTP/LMTP callouts
+// (empty: null sender)
+func (c *Config) GetSmtpdRcptVerifySender() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.SmtpdRcptVerifySender == "_" {
+		return ""
+	}
+	return c.cfg.SmtpdRcptVerifySender
+}
+
+// GetSmtpdRcptVerifyLdapFilter returns LDAP filter used to verify
+// recipients (%s is replaced by address)
+func (c *Config) GetSmtpdRcptVerifyLdapFilter() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdRcptVerifyLdapFilter
+}
+
+// GetSmtpdRateLimitEnabled returns true if per IP/user rate limiting is enabled
+func (c *Config) GetSmtpdRateLimitEnabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.SmtpdRateLimitEnabled
+}
+
+// GetSmtpdRateLimitDefaults returns default rate limits for scope scope ("ip" or "user")
+func (c *Config) GetSmtpdRateLimitDefaults(scope string) RateLimitValues {
+	c.Lock()
+	defer c.Unlock()
+	if scope == RateLimitScopeUser {
+		return RateLimitValues{
+			MaxSessions:   c.cfg.SmtpdRateLimitUserMaxSessions,
+			ConnPerMinute: c.cfg.SmtpdRateLimitUserConnPerMinute,
+			MsgPerHour:    c.cfg.SmtpdRateLimitUserMsgPerHour,
+			RcptPerHour:   c.cfg.SmtpdRateLimitUserRcptPerHour,
+		}
+	}
+	return RateLimitValues{
+		MaxSessions:   c.cfg.SmtpdRateLimitIpMaxSessions,
+		ConnPerMinute: c.cfg.SmtpdRateLimitIpConnPerMinute,
+		MsgPerHour:    c.cfg.SmtpdRateLimitIpMsgPerHour,
+		RcptPerHour:   c.cfg.SmtpdRateLimitIpRcptPerHour,
+	}
+}
+
 // GetLaunchDeliverd returns true if deliverd have to be launched
 func (c *Config) GetLaunchDeliverd() bool {
 	c.Lock()
@@ -527,8 +994,314 @@ func (c *Config) SetRestServerPasswd(passwd string) {
 	c.cfg.RestServerPasswd = passwd
 }
 
+// ACME
+
+// GetAcmeEnabled returns true if ACME client is enabled
+func (c *Config) GetAcmeEnabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.AcmeEnabled
+}
+
+// GetAcmeDirectoryUrl returns ACME CA directory URL
+func (c *Config) GetAcmeDirectoryUrl() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.AcmeDirectoryUrl
+}
+
+// GetAcmeEmail returns ACME account contact email
+func (c *Config) GetAcmeEmail() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.AcmeEmail == "_" {
+		return ""
+	}
+	return c.cfg.AcmeEmail
+}
+
+// GetAcmeChallenge returns ACME challenge type (http-01 or dns-01)
+func (c *Config) GetAcmeChallenge() string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.ToLower(c.cfg.AcmeChallenge)
+}
+
+// GetAcmeHttp01Listen returns address of the plain HTTP server answering
+// HTTP-01 challenges (empty: challenges are only served by the REST server)
+func (c *Config) GetAcmeHttp01Listen() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.AcmeHttp01Listen == "_" {
+		return ""
+	}
+	return c.cfg.AcmeHttp01Listen
+}
+
+// GetAcmeDnsHook returns command used to publish DNS-01 records
+func (c *Config) GetAcmeDnsHook() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.AcmeDnsHook == "_" {
+		return ""
+	}
+	return c.cfg.AcmeDnsHook
+}
+
+// GetAcmeIncludeRcpthosts returns true if certificates must be obtained for
+// rcpthosts too
+func (c *Config) GetAcmeIncludeRcpthosts() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.AcmeIncludeRcpthosts
+}
+
+// GetAcmeRenewBefore returns how long before expiration certificates are renewed
+func (c *Config) GetAcmeRenewBefore() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.AcmeRenewBefore) * 24 * time.Hour
+}
+
+// GetAcmeInsecureSkipVerify returns true if CA TLS certificate must not be
+// verified (test CA)
+func (c *Config) GetAcmeInsecureSkipVerify() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.AcmeInsecureSkipVerify
+}
+
 // deliverd
 
+// GetDeliverdIpPreference returns IP family to try first (ipv4, ipv6 or any)
+func (c *Config) GetDeliverdIpPreference() string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.ToLower(c.cfg.DeliverdIpPreference)
+}
+
+// GetDeliverdLocalAgent returns local delivery agent (dovecot, maildir or lmtp)
+// if not set dovecot is used when dovecot support is enabled
+func (c *Config) GetDeliverdLocalAgent() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdLocalAgent == "_" {
+		if c.cfg.DovecotSupportEnabled {
+			return "dovecot"
+		}
+		return "maildir"
+	}
+	return strings.ToLower(c.cfg.DeliverdLocalAgent)
+}
+
+// GetDeliverdLmtpEndpoint returns default LMTP endpoint
+func (c *Config) GetDeliverdLmtpEndpoint() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdLmtpEndpoint == "_" {
+		return ""
+	}
+	return c.cfg.DeliverdLmtpEndpoint
+}
+
+// GetDeliverdLmtpBatchWait returns how long a LMTP delivery waits for other
+// recipients of the same message
+func (c *Config) GetDeliverdLmtpBatchWait() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.DeliverdLmtpBatchWait) * time.Millisecond
+}
+
+// GetDeliverdLmtpTimeout returns timeout for LMTP commands
+func (c *Config) GetDeliverdLmtpTimeout() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.DeliverdLmtpTimeout) * time.Second
+}
+
+// GetDeliverdLmtpDelimiter returns delimiter used to pass folders to the
+// LMTP server (user+folder@domain), empty if folders can't be passed
+func (c *Config) GetDeliverdLmtpDelimiter() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdLmtpDelimiter == "_" {
+		return ""
+	}
+	return c.cfg.DeliverdLmtpDelimiter
+}
+
+// GetDeliverdPipeTimeout returns timeout for alias pipe commands
+func (c *Config) GetDeliverdPipeTimeout() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.DeliverdPipeTimeout) * time.Second
+}
+
+// GetDeliverdPipeUser returns user alias pipe commands are run as (empty:
+// tmail user)
+func (c *Config) GetDeliverdPipeUser() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdPipeUser == "_" {
+		return ""
+	}
+	return c.cfg.DeliverdPipeUser
+}
+
+// GetDeliverdPipeDir returns working directory of alias pipe commands
+// (default: temp dir)
+func (c *Config) GetDeliverdPipeDir() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdPipeDir == "_" {
+		return c.cfg.TempDir
+	}
+	return c.cfg.DeliverdPipeDir
+}
+
+// GetDeliverdPipeLimitCpu returns CPU time limit of alias pipe commands in
+// seconds (0: no limit)
+func (c *Config) GetDeliverdPipeLimitCpu() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.DeliverdPipeLimitCpu
+}
+
+// GetDeliverdPipeLimitAs returns address space limit of alias pipe commands
+// (eg: 512M, empty: no limit)
+func (c *Config) GetDeliverdPipeLimitAs() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdPipeLimitAs == "_" {
+		return ""
+	}
+	return c.cfg.DeliverdPipeLimitAs
+}
+
+// GetDeliverdPipeLimitNofile returns max open files of alias pipe commands
+// (0: no limit)
+func (c *Config) GetDeliverdPipeLimitNofile() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.DeliverdPipeLimitNofile
+}
+
+// GetDeliverdPipeLimitFsize returns max size of files written by alias pipe
+// commands (eg: 100M, empty: no limit)
+func (c *Config) GetDeliverdPipeLimitFsize() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.DeliverdPipeLimitFsize == "_" {
+		return ""
+	}
+	return c.cfg.DeliverdPipeLimitFsize
+}
+
+// GetDeliverdSieveEnabled returns true if users Sieve scripts are run on
+// local deliveries
+func (c *Config) GetDeliverdSieveEnabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.DeliverdSieveEnabled
+}
+
+// GetDeliverdSieveMaxRedirects returns max redirects per message for a Sieve
+// script
+func (c *Config) GetDeliverdSieveMaxRedirects() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.DeliverdSieveMaxRedirects
+}
+
+// GetDeliverdRcptDetailFolder returns true if messages to user+detail are
+// delivered in folder detail of user (if there is no Sieve fileinto)
+func (c *Config) GetDeliverdRcptDetailFolder() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.DeliverdRcptDetailFolder
+}
+
+// GetMailingListBaseUrl returns public URL of REST server used for
+// one-click unsubscribe links (empty: mailto only)
+func (c *Config) GetMailingListBaseUrl() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.MailingListBaseUrl == "_" {
+		return ""
+	}
+	return strings.TrimSuffix(c.cfg.MailingListBaseUrl, "/")
+}
+
+// GetMailingListBounceThreshold returns number of bounces after which a
+// member is removed from a mailing list
+func (c *Config) GetMailingListBounceThreshold() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.MailingListBounceThreshold
+}
+
+// GetQuarantineTtl returns how long quarantined messages are kept
+func (c *Config) GetQuarantineTtl() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.QuarantineTtl) * 24 * time.Hour
+}
+
+// GetQuarantineDigestInterval returns interval between quarantine digests
+// (0: no digest)
+func (c *Config) GetQuarantineDigestInterval() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.QuarantineDigestInterval) * time.Hour
+}
+
+// GetQuarantineBaseUrl returns public URL of REST server used for release
+// links of quarantine digests (empty: no link)
+func (c *Config) GetQuarantineBaseUrl() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.QuarantineBaseUrl == "_" {
+		return ""
+	}
+	return strings.TrimSuffix(c.cfg.QuarantineBaseUrl, "/")
+}
+
+// GetQuarantineSecret returns key used to sign release links
+func (c *Config) GetQuarantineSecret() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.QuarantineSecret == "_" {
+		return ""
+	}
+	return c.cfg.QuarantineSecret
+}
+
+// GetJournalStoreDriver returns driver of the journal archive store
+func (c *Config) GetJournalStoreDriver() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.JournalStoreDriver
+}
+
+// GetJournalStoreSource returns source of the journal archive store (empty:
+// no archive store)
+func (c *Config) GetJournalStoreSource() string {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg.JournalStoreSource == "_" {
+		return ""
+	}
+	return c.cfg.JournalStoreSource
+}
+
+// GetJournalRetention returns how long copies are kept in the journal
+// archive store (0: forever)
+func (c *Config) GetJournalRetention() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return time.Duration(c.cfg.JournalRetention) * 24 * time.Hour
+}
+
 // GetDeliverdMaxInFlight returns DeliverdMaxInFlight
 func (c *Config) GetDeliverdConcurrencyLocal() int {
 	c.Lock()
@@ -625,6 +1398,22 @@ func (c *Config) GetDeliverdDkimSign() bool {
 	return c.cfg.DeliverdDkimSign
 }
 
+// GetDeliverdDisclaimerSigned returns how disclaimers are added to signed
+// or encrypted messages (skip or wrap)
+func (c *Config) GetDeliverdDisclaimerSigned() string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.ToLower(c.cfg.DeliverdDisclaimerSigned)
+}
+
+// GetDeliverdDisclaimerFailure returns what to do with messages when their
+// disclaimer can't be added (send or tempfail)
+func (c *Config) GetDeliverdDisclaimerFailure() string {
+	c.Lock()
+	defer c.Unlock()
+	return strings.ToLower(c.cfg.DeliverdDisclaimerFailure)
+}
+
 // GetUsersHomeBase returns users home base
 func (c *Config) GetUsersHomeBase() string {
 	c.Lock()
@@ -639,6 +1428,13 @@ func (c *Config) GetUserMailboxDefaultQuota() string {
 	return c.cfg.UserMailboxDefaultQuota
 }
 
+// GetUsersMaildir returns maildir path relative to user home
+func (c *Config) GetUsersMaildir() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.cfg.UsersMaildir
+}
+
 // GetDovecotSupportEnabled returns DovecotSupportEnabled
 func (c *Config) GetDovecotSupportEnabled() bool {
 	c.Lock()
@@ -0,0 +1,828 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/toorop/tmail/message"
+)
+
+// Content rules
+// Rules are evaluated by smtpd when DATA is received (before the "data"
+// plugins), by ascending priority. A rule matches if all its conditions
+// match (a rule without condition matches all messages), then its actions
+// are applied. Reject, tempfail, discard, quarantine and stop actions end
+// the evaluation.
+//
+// Conditions are "FIELD OPERATOR [VALUE]":
+//  fields: mailfrom, rcptto, rcptcount, clientip, authuser, authenticated,
+//          size, header:NAME, body, mime.type, mime.parts, mime.malformed,
+//          attachment.filename, attachment.type, attachment.count
+//  operators: is, isnot, contains, notcontains, matches, notmatches (glob),
+//             regex, notregex, exists, notexists, in, notin (clientip CIDR
+//             list), =, !=, >, >=, <, <= (numeric fields, K, M, G suffixes)
+// Fields can have several values (recipients, headers, attachments...):
+// positive operators match if one value matches, negative ones if none
+// matches.
+//
+// Actions are "ACTION [ARGUMENT]":
+//  reject [message], tempfail [message], discard, quarantine [reason],
+//  addheader Name: value, delheader Name (Name* for a prefix),
+//  redirect addr[,addr...], bcc addr[,addr...], stop
+//
+// Rules are versioned: an update adds a new version of the rule, previous
+// ones are kept for history and rollback.
+
+const (
+	// ContentVerdictAccept means message is queued
+	ContentVerdictAccept = "accept"
+	// ContentVerdictReject means message is rejected (5xx)
+	ContentVerdictReject = "reject"
+	// ContentVerdictTempfail means message is temporary rejected (4xx)
+	ContentVerdictTempfail = "tempfail"
+	// ContentVerdictDiscard means message is accepted and dropped
+	ContentVerdictDiscard = "discard"
+	// ContentVerdictQuarantine means message is accepted and quarantined
+	ContentVerdictQuarantine = "quarantine"
+)
+
+// content fields (field -> numeric)
+var contentFields = map[string]bool{
+	"mailfrom":            false,
+	"rcptto":              false,
+	"rcptcount":           true,
+	"clientip":            false,
+	"authuser":            false,
+	"authenticated":       false,
+	"size":                true,
+	"body":                false,
+	"mime.type":           false,
+	"mime.parts":          true,
+	"mime.malformed":      false,
+	"attachment.filename": false,
+	"attachment.type":     false,
+	"attachment.count":    true,
+}
+
+// string operators (negative operator -> positive one)
+var contentNegativeOps = map[string]string{
+	"isnot":       "is",
+	"notcontains": "contains",
+	"notmatches":  "matches",
+	"notregex":    "regex",
+	"notin":       "in",
+}
+
+// ContentRule is a content policy rule (one version of it)
+type ContentRule struct {
+	Id      int64
+	Name    string `sql:"not null"`
+	Version int
+	// Latest is true for the version in use
+	Latest   bool
+	Disabled bool
+	Priority int
+	// one condition per line
+	Conditions string `sql:"type:text"`
+	// one action per line
+	Actions   string `sql:"type:text"`
+	Comment   string `sql:"null"`
+	CreatedAt time.Time
+}
+
+// ContentRuleResult is the result of content rules evaluation
+type ContentRuleResult struct {
+	// matched rules (name vVersion)
+	Matched []string
+	Verdict string
+	// reply for reject and tempfail, reason for quarantine
+	Message    string
+	AddHeaders []string
+	DelHeaders []string
+	// if not empty recipients are replaced by these ones
+	Redirect []string
+	Bcc      []string
+}
+
+// contentCondition is a parsed condition
+type contentCondition struct {
+	field  string
+	header string
+	op     string
+	value  string
+	number int64
+	re     *regexp.Regexp
+	ipNets []*net.IPNet
+}
+
+// contentAction is a parsed action
+type contentAction struct {
+	name string
+	arg  string
+}
+
+// contentParsedRule is an enabled rule with its parsed conditions and
+// actions
+type contentParsedRule struct {
+	rule       ContentRule
+	conditions []*contentCondition
+	actions    []contentAction
+}
+
+// contentRulesCache holds enabled rules, parsed
+type contentRulesCache struct {
+	sync.Mutex
+	rules    []contentParsedRule
+	loadedAt time.Time
+}
+
+var contentRules = &contentRulesCache{}
+
+// flush forces reload of rules from DB
+func (c *contentRulesCache) flush() {
+	c.Lock()
+	c.loadedAt = time.Time{}
+	c.Unlock()
+}
+
+// get returns enabled rules from DB (cached for one minute)
+func (c *contentRulesCache) get() ([]contentParsedRule, error) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < time.Minute {
+		return c.rules, nil
+	}
+	rules, err := ContentRuleGetAll()
+	if err != nil {
+		return nil, err
+	}
+	c.rules = []contentParsedRule{}
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		conditions, actions, err := rule.parse()
+		if err != nil {
+			Logger.Error("content rules - rule " + rule.Name + " ignored. " + err.Error())
+			continue
+		}
+		c.rules = append(c.rules, contentParsedRule{rule, conditions, actions})
+	}
+	c.loadedAt = time.Now()
+	return c.rules, nil
+}
+
+// ContentRuleAdd adds a content rule
+func ContentRuleAdd(name string, conditions, actions []string, priority int, comment string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("rule name must not be empty")
+	}
+	_, err := ContentRuleGet(name)
+	if err == nil {
+		return errors.New("rule " + name + " already exists")
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return contentRuleSave(&ContentRule{
+		Name:       name,
+		Priority:   priority,
+		Conditions: strings.Join(conditions, "\n"),
+		Actions:    strings.Join(actions, "\n"),
+		Comment:    comment,
+	}, nil)
+}
+
+// ContentRuleUpdate adds a new version of rule name
+func ContentRuleUpdate(name string, conditions, actions []string, priority int, comment string, disabled bool) error {
+	previous, err := ContentRuleGet(name)
+	if err != nil {
+		return err
+	}
+	return contentRuleSave(&ContentRule{
+		Name:       previous.Name,
+		Disabled:   disabled,
+		Priority:   priority,
+		Conditions: strings.Join(conditions, "\n"),
+		Actions:    strings.Join(actions, "\n"),
+		Comment:    comment,
+	}, previous)
+}
+
+// ContentRuleRollback adds a new version of rule name which is a copy of
+// version
+func ContentRuleRollback(name string, version int) error {
+	previous, err := ContentRuleGet(name)
+	if err != nil {
+		return err
+	}
+	old := &ContentRule{}
+	if err = DB.Where("name = ? AND version = ?", previous.Name, version).Find(old).Error; err != nil {
+		return err
+	}
+	return contentRuleSave(&ContentRule{
+		Name:       old.Name,
+		Disabled:   old.Disabled,
+		Priority:   old.Priority,
+		Conditions: old.Conditions,
+		Actions:    old.Actions,
+		Comment:    old.Comment,
+	}, previous)
+}
+
+// contentRuleSave checks rule and saves it as the latest version after
+// previous (nil for a new rule)
+func contentRuleSave(rule *ContentRule, previous *ContentRule) error {
+	if _, _, err := rule.parse(); err != nil {
+		return err
+	}
+	rule.Version = 1
+	rule.Latest = true
+	tx := DB.Begin()
+	if previous != nil {
+		rule.Version = previous.Version + 1
+		if err := tx.Model(previous).Update("latest", false).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Save(rule).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	contentRules.flush()
+	return nil
+}
+
+// ContentRuleDel deletes rule name (all its versions)
+func ContentRuleDel(name string) error {
+	if _, err := ContentRuleGet(name); err != nil {
+		return err
+	}
+	if err := DB.Where("name = ?", name).Delete(&ContentRule{}).Error; err != nil {
+		return err
+	}
+	contentRules.flush()
+	return nil
+}
+
+// ContentRuleGet returns the latest version of rule name
+func ContentRuleGet(name string) (rule *ContentRule, err error) {
+	rule = &ContentRule{}
+	err = DB.Where("name = ? AND latest = ?", strings.TrimSpace(name), true).Find(rule).Error
+	return
+}
+
+// ContentRuleGetAll returns the latest version of rules ordered by priority
+func ContentRuleGetAll() (rules []ContentRule, err error) {
+	rules = []ContentRule{}
+	err = DB.Where("latest = ?", true).Order("priority, id").Find(&rules).Error
+	return
+}
+
+// ContentRuleGetHistory returns all versions of rule name, latest first
+func ContentRuleGetHistory(name string) (rules []ContentRule, err error) {
+	rules = []ContentRule{}
+	err = DB.Where("name = ?", strings.TrimSpace(name)).Order("version desc").Find(&rules).Error
+	return
+}
+
+// ContentRulesTest evaluates rules against raw (dry run)
+func ContentRulesTest(raw []byte, mailFrom string, rcptTo []string, clientIp, authUser string) (*ContentRuleResult, error) {
+	return contentRulesEval(raw, message.Envelope{MailFrom: mailFrom, RcptTo: rcptTo}, clientIp, authUser)
+}
+
+// parse returns conditions and actions of rule
+func (rule *ContentRule) parse() (conditions []*contentCondition, actions []contentAction, err error) {
+	for _, line := range strings.Split(rule.Conditions, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		condition, err := parseContentCondition(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	for _, line := range strings.Split(rule.Actions, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		action, err := parseContentAction(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		actions = append(actions, action)
+	}
+	if len(actions) == 0 {
+		return nil, nil, errors.New("rule " + rule.Name + " has no action")
+	}
+	return
+}
+
+// parseContentCondition parses a "FIELD OPERATOR [VALUE]" condition
+func parseContentCondition(line string) (*contentCondition, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, errors.New("bad condition " + line + ", must be FIELD OPERATOR [VALUE]")
+	}
+	c := &contentCondition{field: strings.ToLower(parts[0]), op: strings.ToLower(parts[1])}
+	if len(parts) == 3 {
+		c.value = strings.TrimSpace(parts[2])
+	}
+	numeric, ok := contentFields[c.field]
+	if strings.HasPrefix(c.field, "header:") {
+		c.header = textproto.CanonicalMIMEHeaderKey(parts[0][7:])
+		c.field = "header"
+		ok = c.header != ""
+	}
+	if !ok {
+		return nil, errors.New("unknown field " + parts[0] + " in condition " + line)
+	}
+	if numeric {
+		switch c.op {
+		case "=", "!=", ">", ">=", "<", "<=":
+		default:
+			return nil, errors.New("bad operator " + c.op + " for numeric field " + c.field + ", must be =, !=, >, >=, < or <=")
+		}
+		number, err := parseContentNumber(c.value)
+		if err != nil {
+			return nil, errors.New("bad number " + c.value + " in condition " + line)
+		}
+		c.number = number
+		return c, nil
+	}
+	op := c.op
+	if positive, ok := contentNegativeOps[op]; ok {
+		op = positive
+	}
+	switch op {
+	case "exists", "notexists":
+		return c, nil
+	case "is", "contains", "matches":
+	case "regex":
+		re, err := regexp.Compile("(?i)" + c.value)
+		if err != nil {
+			return nil, errors.New("bad regex " + c.value + ". " + err.Error())
+		}
+		c.re = re
+	case "in":
+		if c.field != "clientip" {
+			return nil, errors.New("operator " + c.op + " is only available for clientip")
+		}
+		for _, cidr := range strings.Split(c.value, ",") {
+			cidr = strings.TrimSpace(cidr)
+			if !strings.Contains(cidr, "/") {
+				if strings.Contains(cidr, ":") {
+					cidr += "/128"
+				} else {
+					cidr += "/32"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, errors.New("bad network " + cidr + " in condition " + line)
+			}
+			c.ipNets = append(c.ipNets, ipNet)
+		}
+		return c, nil
+	default:
+		return nil, errors.New("unknown operator " + c.op + " in condition " + line)
+	}
+	if op == "matches" {
+		if _, err := path.Match(c.value, ""); err != nil {
+			return nil, errors.New("bad pattern " + c.value + " in condition " + line)
+		}
+	}
+	if c.value == "" {
+		return nil, errors.New("missing value in condition " + line)
+	}
+	return c, nil
+}
+
+// parseContentNumber parses a number with an optional K, M or G suffix
+func parseContentNumber(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	return number * multiplier, err
+}
+
+// parseContentAction parses an "ACTION [ARGUMENT]" action
+func parseContentAction(line string) (action contentAction, err error) {
+	parts := strings.SplitN(line, " ", 2)
+	action.name = strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		action.arg = strings.TrimSpace(parts[1])
+	}
+	switch action.name {
+	case "reject", "tempfail", "quarantine":
+	case "discard", "stop":
+		if action.arg != "" {
+			return action, errors.New("action " + action.name + " has no argument")
+		}
+	case "addheader":
+		colon := strings.Index(action.arg, ":")
+		if colon < 1 || strings.ContainsAny(action.arg[:colon], " \t") {
+			return action, errors.New("bad header " + action.arg + ", must be Name: value")
+		}
+	case "delheader":
+		if action.arg == "" || strings.ContainsAny(action.arg, " \t:") {
+			return action, errors.New("bad header name " + action.arg)
+		}
+	case "redirect", "bcc":
+		for _, address := range strings.Split(action.arg, ",") {
+			if strings.Count(strings.TrimSpace(address), "@") != 1 {
+				return action, errors.New("bad address " + address + " in action " + line)
+			}
+		}
+	default:
+		return action, errors.New("unknown action " + parts[0])
+	}
+	return action, nil
+}
+
+// contentContext is the message on which rules are evaluated
+type contentContext struct {
+	raw      []byte
+	envelope message.Envelope
+	clientIp string
+	authUser string
+	// parsed on first use
+	parsed *contentMessage
+}
+
+// contentMessage is a parsed message
+type contentMessage struct {
+	header mail.Header
+	// content types of all parts (multiparts included)
+	types []string
+	// number of leaf parts
+	parts       int
+	filenames   []string
+	attachTypes []string
+	// decoded text parts
+	text []byte
+	// a part (or the message) can't be parsed
+	malformed bool
+}
+
+// message returns the parsed message
+func (ctx *contentContext) message() *contentMessage {
+	if ctx.parsed == nil {
+		ctx.parsed = parseContentMessage(ctx.raw)
+	}
+	return ctx.parsed
+}
+
+// parseContentMessage parses raw MIME structure. Parts with a malformed
+// header are parsed as parts without header, if the message itself can't
+// be parsed (bad header, too deeply nested) parsing stops at the first
+// error. In both cases the message is flagged as malformed.
+func parseContentMessage(raw []byte) *contentMessage {
+	m := &contentMessage{header: mail.Header{}}
+	root, err := message.ParseMIME(raw)
+	if err != nil {
+		m.malformed = true
+		message.WalkMIME(bytes.NewReader(raw), func(p *message.Part, body io.Reader) error {
+			if body == nil {
+				m.addPart(p, nil)
+			} else {
+				m.addPart(p, func() ([]byte, error) { return ioutil.ReadAll(body) })
+			}
+			return nil
+		})
+		return m
+	}
+	root.Walk(func(p *message.Part) bool {
+		if p.Malformed() {
+			m.malformed = true
+		}
+		if p.IsMultipart() {
+			m.addPart(p, nil)
+		} else {
+			m.addPart(p, p.Decode)
+		}
+		return true
+	})
+	return m
+}
+
+// addPart adds part p to m, body returns its decoded body (nil for
+// multiparts)
+func (m *contentMessage) addPart(p *message.Part, body func() ([]byte, error)) {
+	if len(m.types) == 0 {
+		m.header = mail.Header(p.Header)
+	}
+	m.types = append(m.types, p.ContentType)
+	// multipart
+	if body == nil {
+		return
+	}
+	m.parts++
+	filename := p.Filename()
+	if filename != "" {
+		m.filenames = append(m.filenames, filename)
+	}
+	if filename != "" || p.IsAttachment() {
+		m.attachTypes = append(m.attachTypes, p.ContentType)
+		return
+	}
+	if p.ContentType != "text/plain" && p.ContentType != "text/html" {
+		return
+	}
+	data, _ := body()
+	text, err := message.DecodeCharset(p.Charset(), data)
+	if err != nil {
+		text = string(data)
+	}
+	m.text = append(m.text, text...)
+	m.text = append(m.text, '\n')
+}
+
+// values returns the values of field
+func (ctx *contentContext) values(c *contentCondition) []string {
+	switch c.field {
+	case "mailfrom":
+		return []string{ctx.envelope.MailFrom}
+	case "rcptto":
+		return ctx.envelope.RcptTo
+	case "clientip":
+		return []string{ctx.clientIp}
+	case "authuser":
+		if ctx.authUser == "" {
+			return []string{}
+		}
+		return []string{ctx.authUser}
+	case "authenticated":
+		return []string{strconv.FormatBool(ctx.authUser != "")}
+	case "header":
+		values := []string{}
+		for _, value := range ctx.message().header[c.header] {
+			values = append(values, message.DecodeHeader(value))
+		}
+		return values
+	case "body":
+		return []string{string(ctx.message().text)}
+	case "mime.type":
+		return ctx.message().types
+	case "mime.malformed":
+		return []string{strconv.FormatBool(ctx.message().malformed)}
+	case "attachment.filename":
+		return ctx.message().filenames
+	case "attachment.type":
+		return ctx.message().attachTypes
+	}
+	return []string{}
+}
+
+// number returns the value of numeric field
+func (ctx *contentContext) number(c *contentCondition) int64 {
+	switch c.field {
+	case "rcptcount":
+		return int64(len(ctx.envelope.RcptTo))
+	case "size":
+		return int64(len(ctx.raw))
+	case "mime.parts":
+		return int64(ctx.message().parts)
+	case "attachment.count":
+		return int64(len(ctx.message().attachTypes))
+	}
+	return 0
+}
+
+// match returns true if condition c matches
+func (c *contentCondition) match(ctx *contentContext) bool {
+	if contentFields[c.field] {
+		n := ctx.number(c)
+		switch c.op {
+		case "=":
+			return n == c.number
+		case "!=":
+			return n != c.number
+		case ">":
+			return n > c.number
+		case ">=":
+			return n >= c.number
+		case "<":
+			return n < c.number
+		case "<=":
+			return n <= c.number
+		}
+		return false
+	}
+	values := ctx.values(c)
+	switch c.op {
+	case "exists":
+		return len(values) != 0
+	case "notexists":
+		return len(values) == 0
+	}
+	op, negative := contentNegativeOps[c.op]
+	if !negative {
+		op = c.op
+	}
+	for _, value := range values {
+		if c.matchValue(op, value) {
+			return !negative
+		}
+	}
+	return negative
+}
+
+// matchValue returns true if value matches positive operator op
+func (c *contentCondition) matchValue(op, value string) bool {
+	switch op {
+	case "is":
+		return strings.EqualFold(value, c.value)
+	case "contains":
+		return strings.Contains(strings.ToLower(value), strings.ToLower(c.value))
+	case "matches":
+		matched, _ := path.Match(strings.ToLower(c.value), strings.ToLower(value))
+		return matched
+	case "regex":
+		return c.re.MatchString(value)
+	case "in":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range c.ipNets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contentRulesEval evaluates rules against raw
+func contentRulesEval(raw []byte, envelope message.Envelope, clientIp, authUser string) (*ContentRuleResult, error) {
+	result := &ContentRuleResult{
+		Matched:    []string{},
+		Verdict:    ContentVerdictAccept,
+		AddHeaders: []string{},
+		DelHeaders: []string{},
+		Redirect:   []string{},
+		Bcc:        []string{},
+	}
+	rules, err := contentRules.get()
+	if err != nil {
+		return nil, err
+	}
+	ctx := &contentContext{raw: raw, envelope: envelope, clientIp: clientIp, authUser: authUser}
+	for _, parsed := range rules {
+		rule := parsed.rule
+		matched := true
+		for _, condition := range parsed.conditions {
+			if !condition.match(ctx) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		result.Matched = append(result.Matched, fmt.Sprintf("%s v%d", rule.Name, rule.Version))
+		stop := false
+		for _, action := range parsed.actions {
+			switch action.name {
+			case "reject", "tempfail", "discard", "quarantine":
+				result.Verdict = action.name
+				result.Message = action.arg
+				stop = true
+			case "addheader":
+				result.AddHeaders = append(result.AddHeaders, action.arg)
+			case "delheader":
+				result.DelHeaders = append(result.DelHeaders, action.arg)
+			case "redirect", "bcc":
+				for _, address := range strings.Split(action.arg, ",") {
+					if action.name == "redirect" {
+						result.Redirect = append(result.Redirect, strings.TrimSpace(address))
+					} else {
+						result.Bcc = append(result.Bcc, strings.TrimSpace(address))
+					}
+				}
+			case "stop":
+				stop = true
+			}
+		}
+		if stop {
+			break
+		}
+	}
+	return result, nil
+}
+
+// apply applies header and recipient changes of result to raw and envelope
+func (result *ContentRuleResult) apply(raw *[]byte, envelope *message.Envelope) {
+	for _, name := range result.DelHeaders {
+		message.RawDelHeader(raw, name)
+	}
+	for _, header := range result.AddHeaders {
+		h := []byte(header)
+		message.FoldHeader(&h)
+		h = append(h, []byte{13, 10}...)
+		*raw = append(h, *raw...)
+	}
+	if len(result.Redirect) != 0 {
+		envelope.RcptTo = append([]string{}, result.Redirect...)
+	}
+	for _, address := range result.Bcc {
+		if !IsStringInSlice(address, envelope.RcptTo) {
+			envelope.RcptTo = append(envelope.RcptTo, address)
+		}
+	}
+}
+
+// contentPolicy applies content rules to the current message. It returns
+// false if the message must not be queued (reply has been sent).
+func (s *SMTPServerSession) contentPolicy() bool {
+	authUser := ""
+	if s.user != nil {
+		authUser = s.user.Login
+	}
+	result, err := contentRulesEval(s.CurrentRawMail, s.Envelope, ipFromAddr(s.Conn.RemoteAddr()).String(), authUser)
+	if err != nil {
+		s.LogError("DATA - unable to evaluate content rules. " + err.Error())
+		s.Out("451 4.3.0 oops, problem with content policy")
+		s.SMTPResponseCode = 451
+		s.Reset()
+		return false
+	}
+	if len(result.Matched) == 0 {
+		return true
+	}
+	s.Log("DATA - content rules matched: " + strings.Join(result.Matched, ", ") + " - verdict: " + result.Verdict)
+	switch result.Verdict {
+	case ContentVerdictReject:
+		if result.Message == "" {
+			result.Message = "message rejected by content policy"
+		}
+		s.Out("550 5.7.1 " + result.Message)
+		s.SMTPResponseCode = 550
+	case ContentVerdictTempfail:
+		if result.Message == "" {
+			result.Message = "message temporarily rejected by content policy"
+		}
+		s.Out("451 4.7.1 " + result.Message)
+		s.SMTPResponseCode = 451
+	case ContentVerdictDiscard:
+		if err := journalReceived(&s.CurrentRawMail, s.Envelope, authUser, s.isOutbound()); err != nil {
+			s.LogError("DATA - unable to journal discarded message. " + err.Error())
+			s.Out("451 4.3.0 oops, problem with journaling")
+			s.SMTPResponseCode = 451
+			break
+		}
+		s.Out("250 2.0.0 Ok: discarded")
+		s.SMTPResponseCode = 250
+	case ContentVerdictQuarantine:
+		if result.Message == "" {
+			result.Message = "content policy"
+		}
+		q, err := quarantineAdd(&s.CurrentRawMail, s.Envelope, authUser, s.isOutbound(), result.Message, "content rules: "+strings.Join(result.Matched, ", "))
+		if err != nil {
+			s.LogError("DATA - unable to quarantine message. " + err.Error())
+			s.Out("451 4.3.0 oops, problem with quarantine")
+			s.SMTPResponseCode = 451
+		} else {
+			s.Log(fmt.Sprintf("DATA - message quarantined as %d", q.Id))
+			s.Out("250 2.0.0 Ok: quarantined")
+			s.SMTPResponseCode = 250
+		}
+	default:
+		result.apply(&s.CurrentRawMail, &s.Envelope)
+		return true
+	}
+	s.xforward = nil
+	s.Reset()
+	return false
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/toorop/tmail/message"
+)
+
+func Test_parseContentCondition(t *testing.T) {
+	tests := []struct {
+		line   string
+		field  string
+		header string
+		op     string
+		value  string
+		number int64
+	}{
+		{"mailfrom is john@example.com", "mailfrom", "", "is", "john@example.com", 0},
+		{"Header:x-spam-flag Exists", "header", "X-Spam-Flag", "exists", "", 0},
+		{"header:subject contains  free money ", "header", "Subject", "contains", "free money", 0},
+		{"attachment.filename matches *.exe", "attachment.filename", "", "matches", "*.exe", 0},
+		{"body notregex ^hello", "body", "", "notregex", "^hello", 0},
+		{"size > 10M", "size", "", ">", "10M", 10 << 20},
+		{"rcptcount >= 50", "rcptcount", "", ">=", "50", 50},
+		{"attachment.count != 0", "attachment.count", "", "!=", "0", 0},
+		{"clientip in 192.0.2.0/24, 2001:db8::1", "clientip", "", "in", "192.0.2.0/24, 2001:db8::1", 0},
+	}
+	for _, test := range tests {
+		c, err := parseContentCondition(test.line)
+		if !assert.NoError(t, err, test.line) {
+			continue
+		}
+		assert.Equal(t, test.field, c.field, test.line)
+		assert.Equal(t, test.header, c.header, test.line)
+		assert.Equal(t, test.op, c.op, test.line)
+		assert.Equal(t, test.value, c.value, test.line)
+		assert.Equal(t, test.number, c.number, test.line)
+	}
+
+	for _, line := range []string{
+		"",
+		"mailfrom",
+		"subject is test",
+		"header: exists",
+		"size contains 10",
+		"size > big",
+		"mailfrom is",
+		"mailfrom like x",
+		"body regex (",
+		"attachment.filename matches [",
+		"mailfrom in 192.0.2.0/24",
+		"clientip in 192.0.2.0/33",
+	} {
+		_, err := parseContentCondition(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func Test_parseContentNumber(t *testing.T) {
+	tests := []struct {
+		value  string
+		number int64
+	}{
+		{"0", 0},
+		{"1500", 1500},
+		{"2k", 2048},
+		{" 10M ", 10 << 20},
+		{"1G", 1 << 30},
+	}
+	for _, test := range tests {
+		number, err := parseContentNumber(test.value)
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.number, number, test.value)
+	}
+	for _, value := range []string{"", "M", "1T", "ten"} {
+		_, err := parseContentNumber(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func Test_parseContentAction(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		arg  string
+	}{
+		{"reject", "reject", ""},
+		{"REJECT no executables please", "reject", "no executables please"},
+		{"tempfail try later", "tempfail", "try later"},
+		{"quarantine", "quarantine", ""},
+		{"discard", "discard", ""},
+		{"stop", "stop", ""},
+		{"addheader X-Flag: yes", "addheader", "X-Flag: yes"},
+		{"delheader X-Mailer", "delheader", "X-Mailer"},
+		{"redirect abuse@example.com", "redirect", "abuse@example.com"},
+		{"bcc a@example.com, b@example.com", "bcc", "a@example.com, b@example.com"},
+	}
+	for _, test := range tests {
+		action, err := parseContentAction(test.line)
+		assert.NoError(t, err, test.line)
+		assert.Equal(t, contentAction{name: test.name, arg: test.arg}, action, test.line)
+	}
+	for _, line := range []string{
+		"",
+		"drop",
+		"discard now",
+		"stop here",
+		"addheader X-Flag",
+		"addheader X Flag: yes",
+		"delheader",
+		"delheader X-Flag: yes",
+		"redirect example.com",
+		"bcc a@example.com, b",
+	} {
+		_, err := parseContentAction(line)
+		assert.Error(t, err, line)
+	}
+}
+
+const contentTestMail = "From: john@example.com\r\nTo: jane@example.org\r\nSubject: =?iso-8859-1?q?r=E9sum=E9?=\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+	"--b\r\nContent-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nvoici mon r=E9sum=E9\r\n" +
+	"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"CV.EXE\"\r\n\r\nMZ\r\n" +
+	"--b--\r\n"
+
+func Test_contentConditionMatch(t *testing.T) {
+	ctx := &contentContext{
+		raw:      []byte(contentTestMail),
+		envelope: message.Envelope{MailFrom: "john@example.com", RcptTo: []string{"jane@example.org", "bob@example.org"}},
+		clientIp: "192.0.2.10",
+	}
+	tests := []struct {
+		line  string
+		match bool
+	}{
+		{"mailfrom is JOHN@example.com", true},
+		{"mailfrom isnot john@example.com", false},
+		{"rcptto matches bob@*", true},
+		{"rcptto notmatches *@example.org", false},
+		{"rcptcount = 2", true},
+		{"rcptcount > 2", false},
+		{"clientip in 192.0.2.0/24", true},
+		{"clientip notin 192.0.2.0/24, 198.51.100.1", false},
+		{"clientip in 2001:db8::/32", false},
+		{"authenticated is false", true},
+		{"authuser exists", false},
+		{"authuser notexists", true},
+		{"header:subject is résumé", true},
+		{"header:x-spam-flag exists", false},
+		{"body contains mon résumé", true},
+		{"body regex r.sum.", true},
+		{"mime.type is multipart/mixed", true},
+		{"mime.parts = 2", true},
+		{"mime.malformed is false", true},
+		{"attachment.filename matches *.exe", true},
+		{"attachment.type is application/octet-stream", true},
+		{"attachment.count = 1", true},
+		{"size < 1K", true},
+	}
+	for _, test := range tests {
+		c, err := parseContentCondition(test.line)
+		if assert.NoError(t, err, test.line) {
+			assert.Equal(t, test.match, c.match(ctx), test.line)
+		}
+	}
+}
+
+func Test_parseContentMessage(t *testing.T) {
+	// a malformed part doesn't hide the next ones
+	raw := []byte("From: john@example.com\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nBad Header Line\r\nContent-Type: text/plain\r\n\r\nhello\r\n" +
+		"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"evil.exe\"\r\nContent-Transfer-Encoding: base64\r\n\r\nTVo=\r\n" +
+		"--b--\r\n")
+	m := parseContentMessage(raw)
+	assert.True(t, m.malformed)
+	assert.Equal(t, []string{"multipart/mixed", "text/plain", "application/octet-stream"}, m.types)
+	assert.Equal(t, 2, m.parts)
+	assert.Equal(t, []string{"evil.exe"}, m.filenames)
+	assert.Contains(t, string(m.text), "hello")
+	assert.Equal(t, "john@example.com", m.header.Get("From"))
+
+	ctx := &contentContext{raw: raw}
+	for _, line := range []string{"attachment.filename matches *.exe", "mime.malformed is true"} {
+		c, err := parseContentCondition(line)
+		if assert.NoError(t, err, line) {
+			assert.True(t, c.match(ctx), line)
+		}
+	}
+
+	// message header can't be parsed
+	m = parseContentMessage([]byte("Bad Header Line\r\n\r\nbody\r\n"))
+	assert.True(t, m.malformed)
+
+	m = parseContentMessage([]byte(contentTestMail))
+	assert.False(t, m.malformed)
+	assert.Equal(t, []string{"CV.EXE"}, m.filenames)
+}
+
+func Test_contentRulesCache(t *testing.T) {
+	defer setTestDB(t, &ContentRule{})()
+	contentRules.flush()
+	defer contentRules.flush()
+	raw := []byte(contentTestMail)
+	envelope := message.Envelope{MailFrom: "john@example.com", RcptTo: []string{"jane@example.org"}}
+
+	assert.NoError(t, ContentRuleAdd("exe", []string{"attachment.filename matches *.exe"}, []string{"reject no exe"}, 10, ""))
+	result, err := contentRulesEval(raw, envelope, "192.0.2.10", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ContentVerdictReject, result.Verdict)
+
+	// changes made by ContentRule* functions are seen at once
+	assert.NoError(t, ContentRuleUpdate("exe", []string{"attachment.filename matches *.exe"}, []string{"quarantine exe"}, 10, "", false))
+	result, err = contentRulesEval(raw, envelope, "192.0.2.10", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ContentVerdictQuarantine, result.Verdict)
+	assert.Equal(t, []string{"exe v2"}, result.Matched)
+
+	// others are seen once cache has expired
+	assert.NoError(t, DB.Model(&ContentRule{}).Where("latest = ?", true).Update("disabled", true).Error)
+	result, err = contentRulesEval(raw, envelope, "192.0.2.10", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ContentVerdictQuarantine, result.Verdict)
+	contentRules.loadedAt = contentRules.loadedAt.Add(-time.Minute)
+	result, err = contentRulesEval(raw, envelope, "192.0.2.10", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ContentVerdictAccept, result.Verdict)
+
+	assert.NoError(t, ContentRuleRollback("exe", 1))
+	result, err = contentRulesEval(raw, envelope, "192.0.2.10", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ContentVerdictReject, result.Verdict)
+	assert.NoError(t, ContentRuleDel("exe"))
+	result, err = contentRulesEval(raw, envelope, "192.0.2.10", "")
+	assert.NoError(t, err)
+	assert.Empty(t, result.Matched)
+}
@@ -34,6 +34,57 @@ func IsOkDB(DB *gorm.DB) bool {
 	if !DB.HasTable(&DkimConfig{}) {
 		return false
 	}
+	if !DB.HasTable(&RateLimit{}) {
+		return false
+	}
+	if !DB.HasTable(&AuthBan{}) {
+		return false
+	}
+	if !DB.HasTable(&AcmeAccount{}) {
+		return false
+	}
+	if !DB.HasTable(&AcmeCertificate{}) {
+		return false
+	}
+	if !DB.HasTable(&SieveScript{}) {
+		return false
+	}
+	if !DB.HasTable(&SieveRedirect{}) {
+		return false
+	}
+	if !DB.HasTable(&AutoReplyLog{}) {
+		return false
+	}
+	if !DB.HasTable(&Vacation{}) {
+		return false
+	}
+	if !DB.HasTable(&MailingList{}) {
+		return false
+	}
+	if !DB.HasTable(&MailingListMember{}) {
+		return false
+	}
+	if !DB.HasTable(&MailingListPending{}) {
+		return false
+	}
+	if !DB.HasTable(&RewriteRule{}) {
+		return false
+	}
+	if !DB.HasTable(&ContentRule{}) {
+		return false
+	}
+	if !DB.HasTable(&QuarantinedMessage{}) {
+		return false
+	}
+	if !DB.HasTable(&JournalRule{}) {
+		return false
+	}
+	if !DB.HasTable(&JournalEntry{}) {
+		return false
+	}
+	if !DB.HasTable(&Disclaimer{}) {
+		return false
+	}
 	return true
 }
 
@@ -113,13 +164,156 @@ func InitDB(DB *gorm.DB) error {
 		}
 	}
 
+	// smtpd rate limits overrides
+	if !DB.HasTable(&RateLimit{}) {
+		if err = DB.CreateTable(&RateLimit{}).Error; err != nil {
+			return errors.New("Unable to create table rate_limit - " + err.Error())
+		}
+		// Index
+		if err = DB.Model(&RateLimit{}).AddIndex("idx_rate_limit_scope_target", "scope", "target").Error; err != nil {
+			return errors.New("Unable to add index idx_rate_limit_scope_target on table rate_limit - " + err.Error())
+		}
+	}
+
+	// smtpd auth bans
+	if !DB.HasTable(&AuthBan{}) {
+		if err = DB.CreateTable(&AuthBan{}).Error; err != nil {
+			return errors.New("Unable to create table auth_ban - " + err.Error())
+		}
+		// Index
+		if err = DB.Model(&AuthBan{}).AddIndex("idx_auth_ban_scope_target", "scope", "target").Error; err != nil {
+			return errors.New("Unable to add index idx_auth_ban_scope_target on table auth_ban - " + err.Error())
+		}
+	}
+
+	// ACME accounts
+	if !DB.HasTable(&AcmeAccount{}) {
+		if err = DB.CreateTable(&AcmeAccount{}).Error; err != nil {
+			return errors.New("Unable to create table acme_account - " + err.Error())
+		}
+	}
+
+	// ACME certificates
+	if !DB.HasTable(&AcmeCertificate{}) {
+		if err = DB.CreateTable(&AcmeCertificate{}).Error; err != nil {
+			return errors.New("Unable to create table acme_certificate - " + err.Error())
+		}
+	}
+
+	// Sieve scripts
+	if !DB.HasTable(&SieveScript{}) {
+		if err = DB.CreateTable(&SieveScript{}).Error; err != nil {
+			return errors.New("Unable to create table sieve_script - " + err.Error())
+		}
+		if err = DB.Model(&SieveScript{}).AddUniqueIndex("idx_sieve_script_login_name", "login", "name").Error; err != nil {
+			return errors.New("Unable to add index idx_sieve_script_login_name on table sieve_script - " + err.Error())
+		}
+	}
+	if !DB.HasTable(&SieveRedirect{}) {
+		if err = DB.CreateTable(&SieveRedirect{}).Error; err != nil {
+			return errors.New("Unable to create table sieve_redirect - " + err.Error())
+		}
+		if err = DB.Model(&SieveRedirect{}).AddIndex("idx_sieve_redirect_uuid", "uuid").Error; err != nil {
+			return errors.New("Unable to add index idx_sieve_redirect_uuid on table sieve_redirect - " + err.Error())
+		}
+	}
+
+	// Auto replies
+	if !DB.HasTable(&AutoReplyLog{}) {
+		if err = DB.CreateTable(&AutoReplyLog{}).Error; err != nil {
+			return errors.New("Unable to create table auto_reply_log - " + err.Error())
+		}
+		if err = DB.Model(&AutoReplyLog{}).AddIndex("idx_auto_reply_log_login_sender", "login", "sender").Error; err != nil {
+			return errors.New("Unable to add index idx_auto_reply_log_login_sender on table auto_reply_log - " + err.Error())
+		}
+	}
+
+	// Vacations
+	if !DB.HasTable(&Vacation{}) {
+		if err = DB.CreateTable(&Vacation{}).Error; err != nil {
+			return errors.New("Unable to create table vacation - " + err.Error())
+		}
+	}
+
+	// Mailing lists
+	if !DB.HasTable(&MailingList{}) {
+		if err = DB.CreateTable(&MailingList{}).Error; err != nil {
+			return errors.New("Unable to create table mailing_list - " + err.Error())
+		}
+	}
+	if !DB.HasTable(&MailingListMember{}) {
+		if err = DB.CreateTable(&MailingListMember{}).Error; err != nil {
+			return errors.New("Unable to create table mailing_list_member - " + err.Error())
+		}
+		if err = DB.Model(&MailingListMember{}).AddUniqueIndex("idx_mailing_list_member_list_address", "list_id", "address").Error; err != nil {
+			return errors.New("Unable to add index idx_mailing_list_member_list_address on table mailing_list_member - " + err.Error())
+		}
+		if err = DB.Model(&MailingListMember{}).AddIndex("idx_mailing_list_member_token", "token").Error; err != nil {
+			return errors.New("Unable to add index idx_mailing_list_member_token on table mailing_list_member - " + err.Error())
+		}
+	}
+	if !DB.HasTable(&MailingListPending{}) {
+		if err = DB.CreateTable(&MailingListPending{}).Error; err != nil {
+			return errors.New("Unable to create table mailing_list_pending - " + err.Error())
+		}
+	}
+
+	// Rewrite rules
+	if !DB.HasTable(&RewriteRule{}) {
+		if err = DB.CreateTable(&RewriteRule{}).Error; err != nil {
+			return errors.New("Unable to create table rewrite_rule - " + err.Error())
+		}
+	}
+
+	// Content rules
+	if !DB.HasTable(&ContentRule{}) {
+		if err = DB.CreateTable(&ContentRule{}).Error; err != nil {
+			return errors.New("Unable to create table content_rule - " + err.Error())
+		}
+		if err = DB.Model(&ContentRule{}).AddUniqueIndex("idx_content_rule_name_version", "name", "version").Error; err != nil {
+			return errors.New("Unable to add index idx_content_rule_name_version on table content_rule - " + err.Error())
+		}
+	}
+
+	// Quarantine
+	if !DB.HasTable(&QuarantinedMessage{}) {
+		if err = DB.CreateTable(&QuarantinedMessage{}).Error; err != nil {
+			return errors.New("Unable to create table quarantined_message - " + err.Error())
+		}
+		if err = DB.Model(&QuarantinedMessage{}).AddIndex("idx_quarantined_message_expire_at", "expire_at").Error; err != nil {
+			return errors.New("Unable to add index idx_quarantined_message_expire_at on table quarantined_message - " + err.Error())
+		}
+	}
+
+	// Journaling
+	if !DB.HasTable(&JournalRule{}) {
+		if err = DB.CreateTable(&JournalRule{}).Error; err != nil {
+			return errors.New("Unable to create table journal_rule - " + err.Error())
+		}
+	}
+	if !DB.HasTable(&JournalEntry{}) {
+		if err = DB.CreateTable(&JournalEntry{}).Error; err != nil {
+			return errors.New("Unable to create table journal_entry - " + err.Error())
+		}
+		if err = DB.Model(&JournalEntry{}).AddIndex("idx_journal_entry_expire_at", "expire_at").Error; err != nil {
+			return errors.New("Unable to add index idx_journal_entry_expire_at on table journal_entry - " + err.Error())
+		}
+	}
+
+	// Disclaimers
+	if !DB.HasTable(&Disclaimer{}) {
+		if err = DB.CreateTable(&Disclaimer{}).Error; err != nil {
+			return errors.New("Unable to create table disclaimer - " + err.Error())
+		}
+	}
+
 	return nil
 }
 
 // AutoMigrateDB will keep tables reflecting structs
 func AutoMigrateDB(DB *gorm.DB) error {
 	// if tables exists check if they reflects struts
-	if err := DB.AutoMigrate(&User{}, &Alias{}, &RcptHost{}, &RelayIpOk{}, &QMessage{}, &Route{}, &DkimConfig{}).Error; err != nil {
+	if err := DB.AutoMigrate(&User{}, &Alias{}, &RcptHost{}, &RelayIpOk{}, &QMessage{}, &Route{}, &DkimConfig{}, &RateLimit{}, &AuthBan{}, &AcmeAccount{}, &AcmeCertificate{}, &SieveScript{}, &SieveRedirect{}, &AutoReplyLog{}, &Vacation{}, &MailingList{}, &MailingListMember{}, &MailingListPending{}, &RewriteRule{}, &ContentRule{}, &QuarantinedMessage{}, &JournalRule{}, &JournalEntry{}, &Disclaimer{}).Error; err != nil {
 		return errors.New("Unable autoMigrateDB - " + err.Error())
 	}
 	return nil
@@ -26,6 +26,7 @@ type Delivery struct {
 	StartAt                time.Time
 	IsLocal                bool
 	LocalAddr              string
+	RcptDetail             string // detail of local rcpt (user+detail@domain)
 	RemoteRoutes           []Route
 	RemoteAddr             string
 	RemoteSMTPresponseCode int
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/toorop/tmail/sieve"
+)
+
+// LMTP delivery (RFC 2033)
+// Local deliveries of the same queued message (Uuid) to the same LMTP
+// endpoint are batched if their data are identical (not modified by Sieve):
+// the first one waits Cfg.GetDeliverdLmtpBatchWait() for the others, then a
+// single LHLO/MAIL/RCPT.../DATA exchange is done and the per-recipient
+// replies are dispatched to each delivery.
+// Folders are passed as recipient detail (user+folder@domain), IMAP flags
+// set by Sieve are ignored.
+
+// lmtpReply is the LMTP server reply for one recipient
+type lmtpReply struct {
+	code int
+	msg  string
+}
+
+// lmtpRcpt is a recipient waiting for its reply
+type lmtpRcpt struct {
+	rcptTo  string
+	reply   chan lmtpReply
+	replied bool
+}
+
+// setReply sends reply to recipient (only the first one is sent)
+func (r *lmtpRcpt) setReply(code int, msg string) {
+	if r.replied {
+		return
+	}
+	r.replied = true
+	r.reply <- lmtpReply{code, msg}
+}
+
+// lmtpBatch represents recipients of a message delivered in a single LMTP
+// transaction
+type lmtpBatch struct {
+	network  string
+	address  string
+	mailFrom string
+	data     []byte
+	rcpts    []*lmtpRcpt
+}
+
+// pending batches (endpoint|uuid|data hash -> batch)
+var lmtpBatches = struct {
+	sync.Mutex
+	batches map[string]*lmtpBatch
+}{batches: map[string]*lmtpBatch{}}
+
+// parseLmtpEndpoint parses LMTP endpoint and returns network and address
+// unix:/path/to/socket, /path/to/socket, tcp:host:port or host:port
+func parseLmtpEndpoint(endpoint string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(endpoint, "unix:"):
+		network, address = "unix", endpoint[5:]
+	case strings.HasPrefix(endpoint, "/"):
+		network, address = "unix", endpoint
+	case strings.HasPrefix(endpoint, "tcp:"):
+		network, address = "tcp", endpoint[4:]
+	default:
+		network, address = "tcp", endpoint
+	}
+	if address == "" {
+		return "", "", errors.New("bad LMTP endpoint " + endpoint)
+	}
+	if network == "tcp" {
+		if _, _, err = net.SplitHostPort(address); err != nil {
+			return "", "", errors.New("bad LMTP endpoint " + endpoint + " - " + err.Error())
+		}
+	}
+	return network, address, nil
+}
+
+// lmtpGetEndpoint returns LMTP endpoint to use for rcptTo ("" if none)
+// user endpoint first, then rcpthost one, then default one if local
+// agent is lmtp
+func lmtpGetEndpoint(user *User, rcptTo string) (string, error) {
+	if user != nil && user.LmtpEndpoint != "" {
+		return user.LmtpEndpoint, nil
+	}
+	if t := strings.Split(rcptTo, "@"); len(t) == 2 {
+		rcpthost, err := RcpthostGet(strings.ToLower(t[1]))
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return "", err
+		}
+		if err == nil && rcpthost.LmtpEndpoint != "" {
+			return rcpthost.LmtpEndpoint, nil
+		}
+	}
+	if Cfg.GetDeliverdLocalAgent() == "lmtp" {
+		if Cfg.GetDeliverdLmtpEndpoint() == "" {
+			return "", errors.New("no default LMTP endpoint (TMAIL_DELIVERD_LMTP_ENDPOINT)")
+		}
+		return Cfg.GetDeliverdLmtpEndpoint(), nil
+	}
+	return "", nil
+}
+
+// lmtpDotAtom matches local parts which don't need to be quoted
+var lmtpDotAtom = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+/=?^_`{|}~-]+(\\.[A-Za-z0-9!#$%&'*+/=?^_`{|}~-]+)*$")
+
+// lmtpFolderRcpt returns the LMTP recipient delivering rcptTo in folder
+// (local+folder@domain), ok is false if folder can't be passed
+func lmtpFolderRcpt(rcptTo, folder, delimiter string) (rcpt string, ok bool) {
+	if folder == "" || strings.EqualFold(folder, "INBOX") {
+		return rcptTo, true
+	}
+	i := strings.LastIndex(rcptTo, "@")
+	if delimiter == "" || i <= 0 || strings.ContainsAny(folder, "\x00\r\n") {
+		return rcptTo, false
+	}
+	local := rcptTo[:i]
+	if len(local) > 1 && strings.HasPrefix(local, `"`) && strings.HasSuffix(local, `"`) {
+		local = strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(local[1 : len(local)-1])
+	}
+	local += delimiter + folder
+	// quoted string (RFC 5321 4.1.2)
+	if !lmtpDotAtom.MatchString(local) {
+		local = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(local) + `"`
+	}
+	return local + rcptTo[i:], true
+}
+
+// lmtpQueue adds recipients to the pending batch for this message, data and
+// endpoint (a new batch is created if needed) and waits for their replies
+func lmtpQueue(endpoint, uuid, mailFrom string, rcptTos []string, data []byte) ([]lmtpReply, error) {
+	network, address, err := parseLmtpEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	rcpts := []*lmtpRcpt{}
+	for _, rcptTo := range rcptTos {
+		rcpts = append(rcpts, &lmtpRcpt{
+			rcptTo: rcptTo,
+			reply:  make(chan lmtpReply, 1),
+		})
+	}
+	hash := sha256.Sum256(data)
+	key := endpoint + "|" + uuid + "|" + hex.EncodeToString(hash[:])
+	lmtpBatches.Lock()
+	batch, ok := lmtpBatches.batches[key]
+	if !ok {
+		batch = &lmtpBatch{
+			network:  network,
+			address:  address,
+			mailFrom: mailFrom,
+			data:     data,
+		}
+		lmtpBatches.batches[key] = batch
+		go func() {
+			time.Sleep(Cfg.GetDeliverdLmtpBatchWait())
+			lmtpBatches.Lock()
+			delete(lmtpBatches.batches, key)
+			lmtpBatches.Unlock()
+			batch.send()
+		}()
+	}
+	batch.rcpts = append(batch.rcpts, rcpts...)
+	lmtpBatches.Unlock()
+	replies := []lmtpReply{}
+	for _, rcpt := range rcpts {
+		replies = append(replies, <-rcpt.reply)
+	}
+	return replies, nil
+}
+
+// send delivers batch and dispatches replies to recipients
+func (b *lmtpBatch) send() {
+	// never leave a delivery waiting
+	defer func() {
+		if err := recover(); err != nil {
+			Logger.Error(fmt.Sprintf("delivery-local: LMTP %s PANIC - %s", b.address, err))
+		}
+		for _, rcpt := range b.rcpts {
+			rcpt.setReply(451, "4.4.0 LMTP transaction aborted")
+		}
+	}()
+	// connection or protocol error: temp failure for recipients without reply
+	failed := func(msg string, err error) {
+		Logger.Info(fmt.Sprintf("delivery-local: LMTP %s %s - %s", b.address, msg, err))
+		for _, rcpt := range b.rcpts {
+			rcpt.setReply(451, "4.4.0 "+msg+" - "+err.Error())
+		}
+	}
+
+	timeout := Cfg.GetDeliverdLmtpTimeout()
+	conn, err := net.DialTimeout(b.network, b.address, timeout)
+	if err != nil {
+		failed("unable to connect", err)
+		return
+	}
+	text := textproto.NewConn(conn)
+	defer text.Close()
+	cmd := func(expectedCode int, format string, args ...interface{}) (int, string, error) {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := text.Cmd(format, args...); err != nil {
+			return 0, "", err
+		}
+		return text.ReadResponse(expectedCode)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, _, err = text.ReadResponse(220); err != nil {
+		failed("bad greeting", err)
+		return
+	}
+	if _, _, err = cmd(250, "LHLO %s", Cfg.GetMe()); err != nil {
+		failed("LHLO failed", err)
+		return
+	}
+	if code, msg, err := cmd(250, "MAIL FROM:<%s>", b.mailFrom); err != nil {
+		if code != 0 {
+			// MAIL rejected: same reply for all recipients
+			for _, rcpt := range b.rcpts {
+				rcpt.setReply(code, msg)
+			}
+			cmd(221, "QUIT")
+			return
+		}
+		failed("MAIL FROM failed", err)
+		return
+	}
+
+	// RCPT: rejected recipients get their reply now
+	accepted := []*lmtpRcpt{}
+	for _, rcpt := range b.rcpts {
+		code, msg, err := cmd(-1, "RCPT TO:<%s>", rcpt.rcptTo)
+		if err != nil && code == 0 {
+			failed("RCPT TO failed", err)
+			return
+		}
+		if code/100 == 2 {
+			accepted = append(accepted, rcpt)
+			continue
+		}
+		rcpt.setReply(code, msg)
+	}
+	if len(accepted) == 0 {
+		cmd(221, "QUIT")
+		return
+	}
+
+	// DATA
+	code, msg, err := cmd(354, "DATA")
+	if err != nil {
+		if code != 0 {
+			for _, rcpt := range accepted {
+				rcpt.setReply(code, msg)
+			}
+			cmd(221, "QUIT")
+			return
+		}
+		failed("DATA failed", err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	dw := text.DotWriter()
+	if _, err = dw.Write(b.data); err == nil {
+		err = dw.Close()
+	}
+	if err != nil {
+		failed("unable to send data", err)
+		return
+	}
+	// one reply per accepted recipient, in RCPT order
+	for _, rcpt := range accepted {
+		conn.SetDeadline(time.Now().Add(timeout))
+		code, msg, err := text.ReadResponse(-1)
+		if err != nil && code == 0 {
+			failed("unable to read DATA reply", err)
+			return
+		}
+		rcpt.setReply(code, msg)
+	}
+	cmd(221, "QUIT")
+}
+
+// deliverLmtp delivers message to endpoint via LMTP
+// each store is a copy of the message (folder "" is INBOX)
+func deliverLmtp(d *Delivery, endpoint, rcptTo string, stores []sieve.Store) {
+	rcptTos := []string{}
+	for _, store := range stores {
+		rcpt, ok := lmtpFolderRcpt(rcptTo, store.Folder, Cfg.GetDeliverdLmtpDelimiter())
+		if !ok {
+			Logger.Error(fmt.Sprintf("delivery-local %s: folder %s can't be passed to LMTP %s, message filed in INBOX", d.ID, store.Folder, endpoint))
+		}
+		if !IsStringInSlice(rcpt, rcptTos) {
+			rcptTos = append(rcptTos, rcpt)
+		}
+	}
+	replies, err := lmtpQueue(endpoint, d.QMsg.Uuid, d.QMsg.MailFrom, rcptTos, *d.RawData)
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: %s", d.ID, err), true)
+		return
+	}
+	// delivered if a copy is stored, else the first failure is reported
+	reply := replies[0]
+	for i, r := range replies {
+		if r.code/100 == 2 {
+			Logger.Info(fmt.Sprintf("delivery-local %s: delivered to %s via LMTP %s - %d %s", d.ID, rcptTos[i], endpoint, r.code, r.msg))
+			reply = r
+		}
+	}
+	if reply.code/100 == 2 {
+		for i, r := range replies {
+			if r.code/100 != 2 {
+				// we can't fail now, message is already stored
+				Logger.Error(fmt.Sprintf("delivery-local %s: unable to store copy for %s via LMTP %s - %d %s", d.ID, rcptTos[i], endpoint, r.code, r.msg))
+			}
+		}
+	}
+	switch reply.code / 100 {
+	case 2:
+		d.dieOk()
+	case 5:
+		d.diePerm(fmt.Sprintf("delivery-local %s: LMTP %s rejected %s - %d %s", d.ID, endpoint, rcptTo, reply.code, reply.msg), true)
+	default:
+		d.dieTemp(fmt.Sprintf("delivery-local %s: LMTP %s temp failure for %s - %d %s", d.ID, endpoint, rcptTo, reply.code, reply.msg), true)
+	}
+}
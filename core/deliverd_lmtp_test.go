@@ -0,0 +1,186 @@
+package core
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseLmtpEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		network  string
+		address  string
+		err      bool
+	}{
+		{"unix:/var/run/dovecot/lmtp", "unix", "/var/run/dovecot/lmtp", false},
+		{"/var/run/dovecot/lmtp", "unix", "/var/run/dovecot/lmtp", false},
+		{"tcp:127.0.0.1:24", "tcp", "127.0.0.1:24", false},
+		{"[::1]:24", "tcp", "[::1]:24", false},
+		{"unix:", "", "", true},
+		{"localhost", "", "", true},
+	}
+	for _, test := range tests {
+		network, address, err := parseLmtpEndpoint(test.endpoint)
+		if test.err {
+			assert.Error(t, err, test.endpoint)
+			continue
+		}
+		assert.NoError(t, err, test.endpoint)
+		assert.Equal(t, test.network, network, test.endpoint)
+		assert.Equal(t, test.address, address, test.endpoint)
+	}
+}
+
+func Test_lmtpFolderRcpt(t *testing.T) {
+	tests := []struct {
+		rcptTo    string
+		folder    string
+		delimiter string
+		rcpt      string
+		ok        bool
+	}{
+		{"john@example.com", "", "+", "john@example.com", true},
+		{"john@example.com", "INBOX", "", "john@example.com", true},
+		{"john@example.com", "Lists", "+", "john+Lists@example.com", true},
+		{"john@example.com", "Lists/tmail", "-", "john-Lists/tmail@example.com", true},
+		{"john@example.com", "My Lists", "+", "\"john+My Lists\"@example.com", true},
+		{"john@example.com", "a\"b", "+", "\"john+a\\\"b\"@example.com", true},
+		{"\"john doe\"@example.com", "Lists", "+", "\"john doe+Lists\"@example.com", true},
+		{"john@example.com", "Lists", "", "john@example.com", false},
+		{"john@example.com", "Lists\r\nRSET", "+", "john@example.com", false},
+		{"john", "Lists", "+", "john", false},
+	}
+	for _, test := range tests {
+		rcpt, ok := lmtpFolderRcpt(test.rcptTo, test.folder, test.delimiter)
+		assert.Equal(t, test.ok, ok, test.rcptTo+" "+test.folder)
+		assert.Equal(t, test.rcpt, rcpt, test.rcptTo+" "+test.folder)
+	}
+}
+
+// lmtpTestTransaction is a transaction received by lmtpTestServer
+type lmtpTestTransaction struct {
+	rcpts []string
+	data  string
+}
+
+// lmtpTestServer runs a fake LMTP server rejecting unknown@ recipients
+func lmtpTestServer(t *testing.T) (addr string, transactions func() []lmtpTestTransaction) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var mu sync.Mutex
+	received := []lmtpTestTransaction{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write([]byte("220 lmtp ready\r\n"))
+				tr := lmtpTestTransaction{}
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.ToUpper(strings.SplitN(strings.TrimSpace(line), " ", 2)[0])
+					switch cmd {
+					case "RCPT":
+						if strings.Contains(line, "unknown@") {
+							conn.Write([]byte("550 5.1.1 unknown user\r\n"))
+							continue
+						}
+						tr.rcpts = append(tr.rcpts, line[9:len(line)-3])
+						conn.Write([]byte("250 2.1.5 ok\r\n"))
+					case "DATA":
+						conn.Write([]byte("354 go ahead\r\n"))
+						for {
+							line, err = r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if line == ".\r\n" {
+								break
+							}
+							tr.data += line
+						}
+						for range tr.rcpts {
+							conn.Write([]byte("250 2.0.0 saved\r\n"))
+						}
+						mu.Lock()
+						received = append(received, tr)
+						mu.Unlock()
+					case "QUIT":
+						conn.Write([]byte("221 bye\r\n"))
+						return
+					default:
+						conn.Write([]byte("250 ok\r\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), func() []lmtpTestTransaction {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+}
+
+func Test_lmtpQueue(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	Cfg = &Config{}
+	Cfg.cfg.Me = "tmail.test"
+	Cfg.cfg.DeliverdLmtpBatchWait = 200
+	Cfg.cfg.DeliverdLmtpTimeout = 5
+	addr, transactions := lmtpTestServer(t)
+
+	// same message: deliveries with identical data are batched
+	deliveries := []struct {
+		rcpts []string
+		data  string
+		codes []int
+	}{
+		{[]string{"a@example.com"}, "Subject: a\r\n\r\nhello\r\n", []int{250}},
+		{[]string{"b@example.com", "b+Lists@example.com"}, "Subject: a\r\n\r\nhello\r\n", []int{250, 250}},
+		{[]string{"unknown@example.com"}, "Subject: a\r\n\r\nhello\r\n", []int{550}},
+		// modified by Sieve
+		{[]string{"c@example.com"}, "X-Sieve: c\r\nSubject: a\r\n\r\nhello\r\n", []int{250}},
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(rcpts []string, data string, codes []int) {
+			defer wg.Done()
+			replies, err := lmtpQueue("tcp:"+addr, "uuid", "sender@example.org", rcpts, []byte(data))
+			assert.NoError(t, err)
+			if assert.Len(t, replies, len(codes)) {
+				for i, code := range codes {
+					assert.Equal(t, code, replies[i].code, rcpts[i])
+				}
+			}
+		}(delivery.rcpts, delivery.data, delivery.codes)
+	}
+	wg.Wait()
+
+	received := transactions()
+	sort.Slice(received, func(i, j int) bool { return len(received[i].rcpts) > len(received[j].rcpts) })
+	if assert.Len(t, received, 2) {
+		sort.Strings(received[0].rcpts)
+		assert.Equal(t, []string{"a@example.com", "b+Lists@example.com", "b@example.com"}, received[0].rcpts)
+		assert.Equal(t, "Subject: a\r\n\r\nhello\r\n", received[0].data)
+		assert.Equal(t, []string{"c@example.com"}, received[1].rcpts)
+		assert.Equal(t, "X-Sieve: c\r\nSubject: a\r\n\r\nhello\r\n", received[1].data)
+	}
+}
@@ -2,44 +2,61 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/jinzhu/gorm"
 
 	"github.com/toorop/tmail/message"
+	"github.com/toorop/tmail/sieve"
 )
 
 // deliverLocal handle local delivery
 func deliverLocal(d *Delivery) {
-	var dataBuf *bytes.Buffer
 	mailboxAvailable := false
 	localRcpt := []string{}
 
 	Logger.Info(fmt.Sprintf("delivery-local %s: starting new delivery from %s to %s - Message-Id: %s - Queue-Id: %s", d.ID, d.QMsg.MailFrom, d.QMsg.RcptTo, d.QMsg.MessageId, d.QMsg.Uuid))
 	deliverTo := d.QMsg.RcptTo
 
+	// user+detail@domain
+	rcpt, detail, err := splitRcptDetail(d.QMsg.RcptTo)
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get recipient delimiter for %s. %s", d.ID, d.QMsg.RcptTo, err), true)
+		return
+	}
+
 	// if it's not a local user checks for alias
-	user, err := UserGetByLogin(d.QMsg.RcptTo)
+	user, err := getRcptMailbox(d.QMsg.RcptTo, rcpt)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to check if %s is a real user. %s", d.ID, d.QMsg.RcptTo, err), true)
 		return
 	}
 	// user exists
 	if err == nil {
-		mailboxAvailable = user.HaveMailbox
+		mailboxAvailable = true
+		deliverTo = user.Login
+		if strings.EqualFold(user.Login, rcpt) {
+			d.RcptDetail = detail
+		}
 	}
 
 	// If there non mailbox for this RCPT
 	if !mailboxAvailable {
+		// mailing list ?
+		if deliverMailingList(d) {
+			return
+		}
+
 		localDom := strings.Split(d.QMsg.RcptTo, "@")
-		// first checks if it's an email alias ?
-		alias, err := AliasGet(d.QMsg.RcptTo)
+		// first checks if it's an email alias (or a pattern) ?
+		alias, err := getRcptAlias(d.QMsg.RcptTo, rcpt)
 		if err != nil && err != gorm.ErrRecordNotFound {
 			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to check if %s is an alias. %s", d.ID, d.QMsg.RcptTo, err), true)
 			return
@@ -60,52 +77,9 @@ func deliverLocal(d *Delivery) {
 		if err == nil {
 			// Pipe
 			if alias.Pipe != "" {
-				// expected exit status for pipe cmd
-				// 0: OK
-				// 4: temp fail
-				// 5: perm fail
-				dataBuf := bytes.NewBuffer(*d.RawData)
-
-				cmd := exec.Command(strings.Join(strings.Split(alias.Pipe, " "), ","))
-				stdin, err := cmd.StdinPipe()
-				if err != nil {
-					d.dieTemp(fmt.Sprintf("delivery-local %s: unable to create stddin pipe to %s. %s", d.ID, alias.Pipe, err.Error()), true)
-					return
-				}
-				if err != nil {
-					d.dieTemp(fmt.Sprintf("delivery-local %s: unable to create stdout pipe from %s. %s", d.ID, alias.Pipe, err.Error()), true)
-				}
-				if err := cmd.Start(); err != nil {
-					d.dieTemp(fmt.Sprintf("delivery-local %s: unable to exec pipe  %s. %s", d.ID, alias.Pipe, err.Error()), true)
-					return
-				}
-				_, err = io.Copy(stdin, dataBuf)
-				if err != nil {
-					d.dieTemp(fmt.Sprintf("delivery-local %s: unable to pipe mail to cmd %s. %s", d.ID, alias.Pipe, err.Error()), true)
+				if !deliverPipe(d, &alias) {
 					return
 				}
-				stdin.Close()
-
-				if err := cmd.Wait(); err != nil {
-					if msg, ok := err.(*exec.ExitError); ok {
-						exitStatus := msg.Sys().(syscall.WaitStatus).ExitStatus()
-						switch exitStatus {
-						case 5:
-							d.diePerm(fmt.Sprintf("delivery-local %s: cmd %s failed with exit code 5 (perm failure)", d.ID, alias.Pipe), true)
-							return
-						case 4:
-							d.dieTemp(fmt.Sprintf("delivery-local %s: cmd %s failed with exit code 4 (temp failure)", d.ID, alias.Pipe), true)
-							return
-						default:
-							d.diePerm(fmt.Sprintf("delivery-local %s: cmd %s return unexpected exit code %d", d.ID, alias.Pipe, exitStatus), true)
-							return
-						}
-					} else {
-						d.diePerm(fmt.Sprintf("delivery-local %s: cmd %s oops something went wrong %s", d.ID, alias.Pipe, err), true)
-						return
-					}
-				}
-				Logger.Info(fmt.Sprintf("delivery-local %s: cmd %s succeeded", d.ID, alias.Pipe))
 			}
 
 			// deliverTo
@@ -122,7 +96,8 @@ func deliverLocal(d *Delivery) {
 				if enveloppe.MailFrom != "" && alias.IsMiniList && !alias.IsDomAlias {
 					enveloppe.MailFrom = alias.Alias
 				}
-				uuid, err := QueueAddMessage(d.RawData, enveloppe, "")
+				// journaled when received
+				uuid, err := queueAdd(d.RawData, enveloppe, "")
 				if err != nil {
 					d.dieTemp(fmt.Sprintf("delivery-local %s: unable to requeue aliased msg: %s", d.ID, err), true)
 					return
@@ -146,8 +121,53 @@ func deliverLocal(d *Delivery) {
 	// TODO Remove return path
 	//msg.DelHeader("return-path")
 
+	lmtpEndpoint, err := lmtpGetEndpoint(user, deliverTo)
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get LMTP endpoint for %s. %s", d.ID, deliverTo, err), true)
+		return
+	}
+
 	// Received
-	*d.RawData = append([]byte("Received: tmail deliverd local "+d.ID+"; "+time.Now().Format(Time822)+"\r\n"), *d.RawData...)
+	// LMTP: queue ID, copies of recipients must be identical to be batched
+	receivedId := d.ID
+	if lmtpEndpoint != "" {
+		receivedId = d.QMsg.Uuid
+	}
+	*d.RawData = append([]byte("Received: tmail deliverd local "+receivedId+"; "+time.Now().Format(Time822)+"\r\n"), *d.RawData...)
+
+	// Sieve
+	stores := []sieve.Store{{}}
+	sieveVacation := false
+	if user != nil && Cfg.GetDeliverdSieveEnabled() {
+		var done bool
+		if stores, sieveVacation, done = d.sieveFilter(user); done {
+			return
+		}
+	}
+
+	// Vacation (sieve one replaces it)
+	if user != nil && !sieveVacation {
+		d.vacationReply(user)
+	}
+
+	// detail as folder (if there is no sieve fileinto)
+	if d.RcptDetail != "" && Cfg.GetDeliverdRcptDetailFolder() && len(stores) == 1 && stores[0].Folder == "" {
+		stores[0].Folder = d.RcptDetail
+		// native maildir: only to existing folders
+		if Cfg.GetDeliverdLocalAgent() == "maildir" {
+			if dir, err := maildirFolderPath(userMaildir(user), d.RcptDetail); err != nil {
+				stores[0].Folder = ""
+			} else if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				stores[0].Folder = ""
+			}
+		}
+	}
+
+	// LMTP (server adds Delivered-To and Return-Path)
+	if lmtpEndpoint != "" {
+		deliverLmtp(d, lmtpEndpoint, deliverTo, stores)
+		return
+	}
 
 	// Delivered-To
 	*d.RawData = append([]byte("Delivered-To: "+deliverTo+"\r\n"), *d.RawData...)
@@ -155,53 +175,81 @@ func deliverLocal(d *Delivery) {
 	// Return path
 	*d.RawData = append([]byte("Return-Path: "+d.QMsg.MailFrom+"\r\n"), *d.RawData...)
 
-	dataBuf = bytes.NewBuffer(*d.RawData)
+	// native maildir
+	if Cfg.GetDeliverdLocalAgent() == "maildir" {
+		deliverMaildir(d, user, stores)
+		return
+	}
 
-	cmd := exec.Command(Cfg.GetDovecotLda(), "-d", deliverTo)
+	// dovecot
+	for i, store := range stores {
+		perm, err := dovecotDeliver(deliverTo, store.Folder, *d.RawData)
+		if err != nil && i != 0 {
+			// we can't fail now, message is already stored
+			Logger.Error(fmt.Sprintf("delivery-local %s: unable to store copy in folder %s of %s. %s", d.ID, store.Folder, deliverTo, err))
+			continue
+		}
+		if err != nil {
+			if perm {
+				d.diePerm(fmt.Sprintf("delivery-local %s: %s", d.ID, err), true)
+			} else {
+				d.dieTemp(fmt.Sprintf("delivery-local %s: %s", d.ID, err), true)
+			}
+			return
+		}
+		if store.Folder == "" {
+			Logger.Info(fmt.Sprintf("delivery-local %s: delivered to %s", d.ID, deliverTo))
+		} else {
+			Logger.Info(fmt.Sprintf("delivery-local %s: delivered to %s in folder %s", d.ID, deliverTo, store.Folder))
+		}
+	}
+	d.dieOk()
+}
+
+// dovecotDeliver delivers data to folder ("" for INBOX) of deliverTo using
+// dovecot-lda, perm is true if error is a permanent failure
+func dovecotDeliver(deliverTo, folder string, data []byte) (perm bool, err error) {
+	args := []string{"-d", deliverTo}
+	if folder != "" {
+		args = append(args, "-m", folder)
+	}
+	cmd := exec.Command(Cfg.GetDovecotLda(), args...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to create pipe to dovecot-lda stdin: %s", d.ID, err), true)
-		return
+		return false, fmt.Errorf("unable to create pipe to dovecot-lda stdin: %s", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to run dovecot-lda: %s", d.ID, err), true)
-		return
+		return false, fmt.Errorf("unable to run dovecot-lda: %s", err)
 	}
 
-	_, err = io.Copy(stdin, dataBuf)
+	_, err = io.Copy(stdin, bytes.NewBuffer(data))
 	if err != nil {
-		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to pipe mail to dovecot-lda: %s", d.ID, err), true)
-		return
+		return false, fmt.Errorf("unable to pipe mail to dovecot-lda: %s", err)
 	}
 	stdin.Close()
 
 	if err := cmd.Wait(); err != nil {
 		t := strings.Split(err.Error(), " ")
 		if len(t) != 3 {
-			d.dieTemp(fmt.Sprintf("delivery-local %s: unexpected response from dovecot-lda: %s", d.ID, err), true)
-			return
+			return false, fmt.Errorf("unexpected response from dovecot-lda: %s", err)
 		}
 		errCode, err := strconv.ParseUint(t[2], 10, 64)
 		if err != nil {
-			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to parse response from dovecot-lda: %s", d.ID, err), true)
-			return
+			return false, fmt.Errorf("unable to parse response from dovecot-lda: %s", err)
 		}
 		switch errCode {
 		case 64:
-			d.dieTemp(fmt.Sprintf("delivery-local %s: dovecot-lda return: 64 - Invalid parameter given", d.ID), true)
+			return false, errors.New("dovecot-lda return: 64 - Invalid parameter given")
 		case 67:
-			d.diePerm(fmt.Sprintf("delivery-local %s: the destination user %s was not found", d.ID, deliverTo), true)
+			return true, fmt.Errorf("the destination user %s was not found", deliverTo)
 		case 77:
-			d.diePerm(fmt.Sprintf("delivery-local %s: the destination user %s is over quota", d.ID, deliverTo), true)
+			return true, fmt.Errorf("the destination user %s is over quota", deliverTo)
 		case 75:
-			d.dieTemp(fmt.Sprintf("delivery-local %s: dovecot temporary failure. Checks dovecot log for more info", d.ID), true)
+			return false, errors.New("dovecot temporary failure. Checks dovecot log for more info")
 		default:
-			d.dieTemp(fmt.Sprintf("delivery-local %s: unexpected response code recieved from dovecot-lda: %d", d.ID, errCode), true)
+			return false, fmt.Errorf("unexpected response code recieved from dovecot-lda: %d", errCode)
 		}
-		return
 	}
-	Logger.Info(fmt.Sprintf("delivery-local %s: delivered to %s", d.ID, deliverTo))
-
-	d.dieOk()
+	return false, nil
 }
@@ -0,0 +1,395 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/toorop/tmail/sieve"
+)
+
+// Native Maildir++ delivery
+// http://cr.yp.to/proto/maildir.html
+// http://www.courier-mta.org/imap/README.maildirquota.html
+//
+// Messages are written in tmp/ (with LF line endings) then moved to new/
+// (atomic), folders are .Folder.Sub directories under the maildir root and
+// quota usage is tracked in maildirsize.
+
+var (
+	// errMaildirOverQuota is returned when message doesn't fit in mailbox quota
+	errMaildirOverQuota = errors.New("mailbox is over quota")
+
+	// errMaildirBadFolder is returned when folder name is invalid or folder
+	// can't be created
+	errMaildirBadFolder = errors.New("invalid folder")
+)
+
+// maildirsize is recalculated when it grows over this size (Maildir++)
+const maildirsizeMaxSize = 5120
+
+// maildirDeliveries is used to build unique file names
+var maildirDeliveries uint64
+
+// userHome returns default home for login (base/d/domain/u/user)
+func userHome(login string) string {
+	t := strings.Split(login, "@")
+	if len(t) != 2 || t[0] == "" || t[1] == "" {
+		return ""
+	}
+	return Cfg.GetUsersHomeBase() + "/" + string(t[1][0]) + "/" + t[1] + "/" + string(t[0][0]) + "/" + t[0]
+}
+
+// userMaildir returns maildir root of user
+func userMaildir(user *User) string {
+	home := user.Home
+	if home == "" {
+		home = userHome(user.Login)
+	}
+	return path.Join(home, Cfg.GetUsersMaildir())
+}
+
+// parseQuota parses quota (eg: 1G, 100M, 100K, 10000000) and returns it
+// in bytes, 0 means no quota
+func parseQuota(quota string) (int64, error) {
+	quota = strings.ToUpper(strings.TrimSpace(quota))
+	if quota == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch quota[len(quota)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	case 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier != 1 {
+		quota = quota[:len(quota)-1]
+	}
+	size, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("bad quota " + quota)
+	}
+	return size * multiplier, nil
+}
+
+// maildirFolderPath returns path of folder (INBOX if empty)
+// folder hierarchy can be separated by / or . (Archives/2024 -> .Archives.2024)
+func maildirFolderPath(root, folder string) (string, error) {
+	folder = strings.Trim(strings.Replace(folder, "/", ".", -1), ".")
+	if folder == "" || strings.EqualFold(folder, "INBOX") {
+		return root, nil
+	}
+	if strings.HasPrefix(strings.ToUpper(folder), "INBOX.") {
+		folder = folder[6:]
+	}
+	for _, part := range strings.Split(folder, ".") {
+		if part == "" || strings.ContainsAny(part, "\x00\r\n") {
+			return "", errMaildirBadFolder
+		}
+	}
+	return path.Join(root, "."+folder), nil
+}
+
+// maildirCreate creates maildir (cur, new, tmp) if needed
+func maildirCreate(dir string, isFolder bool) error {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(path.Join(dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	if isFolder {
+		f, err := os.OpenFile(path.Join(dir, "maildirfolder"), os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+// maildirUniqueName returns an unique file name for a new message
+func maildirUniqueName(size int) string {
+	now := time.Now()
+	host, err := os.Hostname()
+	if err != nil {
+		host = Cfg.GetMe()
+	}
+	host = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(host)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s,S=%d", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&maildirDeliveries, 1), host, size)
+}
+
+// maildirMessageSize returns size of message file (from S= if available)
+func maildirMessageSize(dir, name string) int64 {
+	if i := strings.Index(name, ",S="); i != -1 {
+		sizeStr := name[i+3:]
+		if j := strings.IndexAny(sizeStr, ",:"); j != -1 {
+			sizeStr = sizeStr[:j]
+		}
+		if size, err := strconv.ParseInt(sizeStr, 10, 64); err == nil {
+			return size
+		}
+	}
+	if fi, err := os.Stat(path.Join(dir, name)); err == nil {
+		return fi.Size()
+	}
+	return 0
+}
+
+// maildirCalcUsage returns size and number of messages stored in maildir
+// (all folders)
+func maildirCalcUsage(root string) (size, count int64, err error) {
+	dirs := []string{root}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), ".") && entry.Name() != "." && entry.Name() != ".." {
+			dirs = append(dirs, path.Join(root, entry.Name()))
+		}
+	}
+	for _, dir := range dirs {
+		for _, sub := range []string{"cur", "new"} {
+			files, err := ioutil.ReadDir(path.Join(dir, sub))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return 0, 0, err
+			}
+			for _, file := range files {
+				if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+					continue
+				}
+				size += maildirMessageSize(path.Join(dir, sub), file.Name())
+				count++
+			}
+		}
+	}
+	return size, count, nil
+}
+
+// maildirQuotaUsage returns current usage from maildirsize, file is
+// (re)calculated if it doesn't exist, is too big or has another quota
+// definition
+func maildirQuotaUsage(root, quotaDef string) (size, count int64, err error) {
+	sizeFile := path.Join(root, "maildirsize")
+	data, err := ioutil.ReadFile(sizeFile)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if err == nil && len(data) < maildirsizeMaxSize {
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		if scanner.Scan() && scanner.Text() == quotaDef {
+			valid := true
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) != 2 {
+					valid = false
+					break
+				}
+				s, err1 := strconv.ParseInt(fields[0], 10, 64)
+				c, err2 := strconv.ParseInt(fields[1], 10, 64)
+				if err1 != nil || err2 != nil {
+					valid = false
+					break
+				}
+				size += s
+				count += c
+			}
+			if valid {
+				return size, count, nil
+			}
+		}
+	}
+
+	// recalculate
+	if size, count, err = maildirCalcUsage(root); err != nil {
+		return 0, 0, err
+	}
+	tmpFile := path.Join(root, "tmp", maildirUniqueName(0)+".maildirsize")
+	if err = ioutil.WriteFile(tmpFile, []byte(fmt.Sprintf("%s\n%d %d\n", quotaDef, size, count)), 0600); err != nil {
+		return 0, 0, err
+	}
+	if err = os.Rename(tmpFile, sizeFile); err != nil {
+		os.Remove(tmpFile)
+		return 0, 0, err
+	}
+	return size, count, nil
+}
+
+// maildirQuotaAdd adds message to maildirsize
+func maildirQuotaAdd(root string, size int64) error {
+	f, err := os.OpenFile(path.Join(root, "maildirsize"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(fmt.Sprintf("%d 1\n", size)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maildirInfo returns the info part (":2,FLAGS") of a message file name for
+// IMAP flags, unknown flags are ignored
+func maildirInfo(flags []string) string {
+	info := []string{}
+	for _, flag := range flags {
+		switch strings.ToLower(flag) {
+		case "\\draft":
+			info = append(info, "D")
+		case "\\flagged":
+			info = append(info, "F")
+		case "\\answered":
+			info = append(info, "R")
+		case "\\seen":
+			info = append(info, "S")
+		case "\\deleted":
+			info = append(info, "T")
+		}
+	}
+	if len(info) == 0 {
+		return ""
+	}
+	sort.Strings(info)
+	return ":2," + strings.Join(info, "")
+}
+
+// maildirDeliver delivers data to folder of maildir root
+// quota is in bytes (0: no quota)
+// if flags are set message is moved to cur/ instead of new/
+// errMaildirOverQuota is a permanent failure, on errMaildirBadFolder message
+// must be delivered to INBOX, other errors are temporary ones
+func maildirDeliver(root, folder string, flags []string, data []byte, quota int64) error {
+	dir, err := maildirFolderPath(root, folder)
+	if err != nil {
+		return err
+	}
+	if err = maildirCreate(root, false); err != nil {
+		return err
+	}
+	if dir != root {
+		if err = maildirCreate(dir, true); err != nil {
+			Logger.Error("delivery-local: unable to create folder " + dir + " - " + err.Error())
+			return errMaildirBadFolder
+		}
+	}
+
+	// quota
+	if quota != 0 {
+		size, _, err := maildirQuotaUsage(root, fmt.Sprintf("%dS", quota))
+		if err != nil {
+			return err
+		}
+		if size+int64(len(data)) > quota {
+			return errMaildirOverQuota
+		}
+	}
+
+	// tmp -> new (or cur)
+	name := maildirUniqueName(len(data))
+	tmpFile := path.Join(dir, "tmp", name)
+	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	dest := path.Join(dir, "new", name)
+	if info := maildirInfo(flags); info != "" {
+		dest = path.Join(dir, "cur", name+info)
+	}
+	if err = os.Rename(tmpFile, dest); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if quota != 0 {
+		if err = maildirQuotaAdd(root, int64(len(data))); err != nil {
+			// message is delivered, next delivery will recalculate usage
+			Logger.Error("delivery-local: unable to update " + path.Join(root, "maildirsize") + " - " + err.Error())
+		}
+	}
+	return nil
+}
+
+// deliverMaildir delivers message to user maildir
+// each store is a copy of the message (folder "" is INBOX)
+func deliverMaildir(d *Delivery, user *User, stores []sieve.Store) {
+	if user == nil || !user.HaveMailbox {
+		d.diePerm(fmt.Sprintf("delivery-local %s: the destination user %s was not found", d.ID, d.QMsg.RcptTo), true)
+		return
+	}
+	quota, err := parseQuota(user.MailboxQuota)
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get quota of %s. %s", d.ID, user.Login, err), true)
+		return
+	}
+	root := userMaildir(user)
+	// maildir messages use LF line endings (size in maildirsize and S= is
+	// the size on disk)
+	data := *d.RawData
+	Dos2unix(&data)
+	stored := 0
+	inbox := false
+	for _, store := range stores {
+		if store.Folder == "" && inbox {
+			continue
+		}
+		err = maildirDeliver(root, store.Folder, store.Flags, data, quota)
+		// RFC 5228 2.10.6: message is filed in INBOX if folder is invalid
+		// or can't be created
+		if err == errMaildirBadFolder {
+			Logger.Error(fmt.Sprintf("delivery-local %s: invalid folder %s for user %s, message filed in INBOX", d.ID, store.Folder, user.Login))
+			if inbox {
+				continue
+			}
+			store.Folder = ""
+			err = maildirDeliver(root, "", store.Flags, data, quota)
+		}
+		if err != nil && stored != 0 {
+			// we can't fail now, message is already stored
+			Logger.Error(fmt.Sprintf("delivery-local %s: unable to store copy in folder %s of %s. %s", d.ID, store.Folder, user.Login, err))
+			continue
+		}
+		switch err {
+		case nil:
+		case errMaildirOverQuota:
+			d.diePerm(fmt.Sprintf("delivery-local %s: the destination user %s is over quota", d.ID, user.Login), true)
+			return
+		default:
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to write message in %s. %s", d.ID, root, err), true)
+			return
+		}
+		stored++
+		if store.Folder == "" {
+			inbox = true
+			Logger.Info(fmt.Sprintf("delivery-local %s: delivered to %s (maildir %s)", d.ID, user.Login, root))
+		} else {
+			Logger.Info(fmt.Sprintf("delivery-local %s: delivered to %s in folder %s (maildir %s)", d.ID, user.Login, store.Folder, root))
+		}
+	}
+	d.dieOk()
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseQuota(t *testing.T) {
+	tests := []struct {
+		quota string
+		size  int64
+		err   bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"10000000", 10000000, false},
+		{"100K", 100 << 10, false},
+		{"100k", 100 << 10, false},
+		{" 100M ", 100 << 20, false},
+		{"1G", 1 << 30, false},
+		{"2T", 2 << 40, false},
+		{"G", 0, true},
+		{"1.5G", 0, true},
+		{"-1M", 0, true},
+		{"10X", 0, true},
+	}
+	for _, test := range tests {
+		size, err := parseQuota(test.quota)
+		if test.err {
+			assert.Error(t, err, test.quota)
+			continue
+		}
+		assert.NoError(t, err, test.quota)
+		assert.Equal(t, test.size, size, test.quota)
+	}
+}
+
+func Test_maildirFolderPath(t *testing.T) {
+	tests := []struct {
+		folder string
+		path   string
+		err    error
+	}{
+		{"", "/m", nil},
+		{"INBOX", "/m", nil},
+		{"inbox", "/m", nil},
+		{"Archives", "/m/.Archives", nil},
+		{"Archives/2024", "/m/.Archives.2024", nil},
+		{"Archives.2024", "/m/.Archives.2024", nil},
+		{"/Archives/", "/m/.Archives", nil},
+		{"INBOX/Lists", "/m/.Lists", nil},
+		{"INBOX.Lists", "/m/.Lists", nil},
+		{"Archives//2024", "", errMaildirBadFolder},
+		{"Bad\nname", "", errMaildirBadFolder},
+		{"a/../b", "", errMaildirBadFolder},
+		{"../..", "/m", nil},
+	}
+	for _, test := range tests {
+		p, err := maildirFolderPath("/m", test.folder)
+		assert.Equal(t, test.err, err, test.folder)
+		assert.Equal(t, test.path, p, test.folder)
+	}
+}
+
+func Test_maildirInfo(t *testing.T) {
+	assert.Equal(t, "", maildirInfo(nil))
+	assert.Equal(t, "", maildirInfo([]string{"$label1"}))
+	assert.Equal(t, ":2,FS", maildirInfo([]string{"\\Seen", "\\Flagged", "$label1"}))
+}
+
+func Test_maildirDeliver(t *testing.T) {
+	root := path.Join(t.TempDir(), "Maildir")
+	data := []byte("Subject: test\n\nhello\n")
+	Dos2unix(&data)
+
+	assert.NoError(t, maildirDeliver(root, "", nil, data, 0))
+	assert.NoError(t, maildirDeliver(root, "Archives/2024", []string{"\\Seen"}, data, 0))
+	assert.Equal(t, errMaildirBadFolder, maildirDeliver(root, "Archives//2024", nil, data, 0))
+
+	files, err := ioutil.ReadDir(path.Join(root, "new"))
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		stored, _ := ioutil.ReadFile(path.Join(root, "new", files[0].Name()))
+		assert.Equal(t, data, stored)
+	}
+	files, err = ioutil.ReadDir(path.Join(root, ".Archives.2024", "cur"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	size, count, err := maildirCalcUsage(root)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*len(data)), size)
+	assert.Equal(t, int64(2), count)
+
+	// quota
+	quota := int64(3*len(data) + 1)
+	assert.NoError(t, maildirDeliver(root, "", nil, data, quota))
+	size, count, err = maildirQuotaUsage(root, fmt.Sprintf("%dS", quota))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3*len(data)), size)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, errMaildirOverQuota, maildirDeliver(root, "", nil, data, quota))
+}
+
+func Test_Dos2unix(t *testing.T) {
+	data := []byte("Subject: test\r\n\r\nline 1\r\nline 2\rline 3\n")
+	Dos2unix(&data)
+	assert.Equal(t, "Subject: test\n\nline 1\nline 2\rline 3\n", string(data))
+}
@@ -0,0 +1,355 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/toorop/tmail/message"
+)
+
+// mailingListHeaders are removed from posts before distribution
+var mailingListHeaders = []string{"List-*", "Precedence", "X-Loop", "Return-Path", "Delivered-To"}
+
+// deliverMailingList handles mails for list addresses
+// returns false if rcpt is not a list address
+func deliverMailingList(d *Delivery) bool {
+	list, role, member, err := mailingListMatch(d.QMsg.RcptTo)
+	if err == gorm.ErrRecordNotFound {
+		return false
+	}
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to check if %s is a mailing list. %s", d.ID, d.QMsg.RcptTo, err), true)
+		return true
+	}
+	switch role {
+	case "post":
+		d.mailingListPost(list)
+	case "request":
+		d.mailingListRequest(list)
+	case "owner":
+		moderators := list.moderators()
+		if len(moderators) == 0 {
+			d.diePerm(fmt.Sprintf("delivery-local %s: mailing list %s has no owner", d.ID, list.Address), true)
+			return true
+		}
+		// journaled when received
+		uuid, err := queueAdd(d.RawData, message.Envelope{MailFrom: d.QMsg.MailFrom, RcptTo: moderators}, "")
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to forward message to %s owners. %s", d.ID, list.Address, err), true)
+			return true
+		}
+		Logger.Info(fmt.Sprintf("delivery-local %s: message for %s forwarded to moderators, queued with ID %s", d.ID, list.ownerAddress(), uuid))
+		d.dieOk()
+	case "bounces":
+		removed, err := list.bounce(member)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to record bounce of %s for list %s. %s", d.ID, member, list.Address, err), true)
+			return true
+		}
+		if removed {
+			Logger.Info(fmt.Sprintf("delivery-local %s: %s removed from list %s (too many bounces)", d.ID, member, list.Address))
+		} else {
+			Logger.Info(fmt.Sprintf("delivery-local %s: bounce recorded for %s on list %s", d.ID, member, list.Address))
+		}
+		d.dieOk()
+	}
+	return true
+}
+
+// mailingListSender returns From address of message m (mailFrom if From is
+// not parsable)
+func mailingListSender(m *message.Message, mailFrom string) string {
+	if from, err := mail.ParseAddress(m.GetHeader("From")); err == nil {
+		return strings.ToLower(from.Address)
+	}
+	return strings.ToLower(mailFrom)
+}
+
+// actions on posts
+const (
+	mailingListPostDistribute = "distribute"
+	mailingListPostReject     = "reject"
+	mailingListPostModerate   = "moderate"
+)
+
+// postAction returns what to do with a post according to list policy
+// Envelope sender mailFrom is checked, From header is only displayed
+func (l *MailingList) postAction(mailFrom string) (string, error) {
+	if l.isModerator(mailFrom) || l.Policy == MailingListPolicyOpen {
+		return mailingListPostDistribute, nil
+	}
+	if l.Policy != MailingListPolicyMembers {
+		return mailingListPostModerate, nil
+	}
+	_, err := l.getMember(mailFrom)
+	if err == nil {
+		return mailingListPostDistribute, nil
+	}
+	if err == gorm.ErrRecordNotFound {
+		return mailingListPostReject, nil
+	}
+	return "", err
+}
+
+// mailingListPost handles a post to list
+func (d *Delivery) mailingListPost(list *MailingList) {
+	m, err := message.New(d.RawData)
+	if err != nil {
+		d.diePerm(fmt.Sprintf("delivery-local %s: unable to parse message posted to %s. %s", d.ID, list.Address, err), true)
+		return
+	}
+	// loops and bounces
+	for _, loop := range m.GetHeaders("X-Loop") {
+		if strings.EqualFold(strings.TrimSpace(loop), list.Address) {
+			Logger.Info(fmt.Sprintf("delivery-local %s: loop detected on list %s, message discarded", d.ID, list.Address))
+			d.dieOk()
+			return
+		}
+	}
+	if d.QMsg.MailFrom == "" {
+		Logger.Info(fmt.Sprintf("delivery-local %s: bounce sent to list %s, message discarded", d.ID, list.Address))
+		d.dieOk()
+		return
+	}
+
+	// policy
+	sender := mailingListSender(m, d.QMsg.MailFrom)
+	action, err := list.postAction(d.QMsg.MailFrom)
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get members of %s. %s", d.ID, list.Address, err), true)
+		return
+	}
+	switch action {
+	case mailingListPostDistribute:
+		if err = list.distribute(*d.RawData); err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to distribute message to %s. %s", d.ID, list.Address, err), true)
+			return
+		}
+		Logger.Info(fmt.Sprintf("delivery-local %s: message from %s distributed to list %s", d.ID, sender, list.Address))
+	case mailingListPostReject:
+		d.diePerm(fmt.Sprintf("delivery-local %s: only members can post to %s", d.ID, list.Address), true)
+		return
+	default:
+		// hold for moderation
+		token, err := list.addPending(mailingListPendingModeration, sender, d.QMsg.MailFrom, *d.RawData)
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to hold message for %s. %s", d.ID, list.Address, err), true)
+			return
+		}
+		subject, _ := new(mime.WordDecoder).DecodeHeader(m.GetHeader("Subject"))
+		body := fmt.Sprintf("A message for %s is waiting for moderation.\n\nFrom: %s\nSubject: %s\n\n"+
+			"To approve it, send a mail to %s with subject:\napprove %s\n\nTo reject it:\nreject %s\n",
+			list.Address, sender, subject, list.requestAddress(), token, token)
+		if err = list.notify(list.moderators(), "Moderation needed for "+list.Address, body); err != nil {
+			Logger.Error(fmt.Sprintf("delivery-local %s: unable to notify moderators of %s. %s", d.ID, list.Address, err))
+		}
+		Logger.Info(fmt.Sprintf("delivery-local %s: message from %s to list %s held for moderation", d.ID, sender, list.Address))
+	}
+	d.dieOk()
+}
+
+// mailingListCommand returns command of a mail sent to request address:
+// subject or, if empty, first body line
+func mailingListCommand(m *message.Message) string {
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.GetHeader("Subject"))
+	subject = strings.TrimSpace(subject)
+	for {
+		lower := strings.ToLower(subject)
+		if !strings.HasPrefix(lower, "re:") && !strings.HasPrefix(lower, "fwd:") {
+			break
+		}
+		subject = strings.TrimSpace(subject[strings.Index(subject, ":")+1:])
+	}
+	if subject != "" {
+		return subject
+	}
+	scanner := bufio.NewScanner(m.Body)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// mailingListRequest handles a command sent to list request address
+func (d *Delivery) mailingListRequest(list *MailingList) {
+	m, err := message.New(d.RawData)
+	if err != nil {
+		d.diePerm(fmt.Sprintf("delivery-local %s: unable to parse message sent to %s. %s", d.ID, list.requestAddress(), err), true)
+		return
+	}
+	// never answer to bounces and robots
+	if v := strings.ToLower(m.GetHeader("Auto-Submitted")); d.QMsg.MailFrom == "" || (v != "" && v != "no") {
+		Logger.Info(fmt.Sprintf("delivery-local %s: automatic message sent to %s discarded", d.ID, list.requestAddress()))
+		d.dieOk()
+		return
+	}
+	sender := mailingListSender(m, d.QMsg.MailFrom)
+	fields := strings.Fields(mailingListCommand(m))
+	command, token := "help", ""
+	if len(fields) != 0 {
+		command = strings.ToLower(fields[0])
+	}
+	if len(fields) > 1 {
+		token = fields[1]
+	}
+
+	var to, subject, body string
+	switch command {
+	case "subscribe", "unsubscribe":
+		if command == "unsubscribe" {
+			if _, err = list.getMember(sender); err == gorm.ErrRecordNotFound {
+				err = nil
+				to, subject, body = sender, "Not subscribed to "+list.Address, sender+" is not a member of "+list.Address+".\n"
+				break
+			}
+		}
+		if err != nil && err != gorm.ErrRecordNotFound {
+			break
+		}
+		kind := mailingListPendingSubscribe
+		if command == "unsubscribe" {
+			kind = mailingListPendingUnsubscribe
+		}
+		if token, err = list.addPending(kind, sender, d.QMsg.MailFrom, nil); err != nil {
+			break
+		}
+		to, subject = sender, "confirm "+token
+		body = fmt.Sprintf("Someone (hopefully you) asked to %s %s on %s.\n\n"+
+			"To confirm, reply to this message or send a mail to %s with subject:\nconfirm %s\n\n"+
+			"If you didn't ask for it, just ignore this message.\n", command, sender, list.Address, list.requestAddress(), token)
+	case "confirm":
+		var confirmed *MailingList
+		var pending *MailingListPending
+		confirmed, pending, err = mailingListConfirm(token)
+		if err == gorm.ErrRecordNotFound || (err == nil && confirmed.Id != list.Id) {
+			err = nil
+			to, subject, body = sender, "Unknown token", "Token "+token+" is unknown or has expired.\n"
+			break
+		}
+		if err != nil {
+			break
+		}
+		to = pending.Address
+		if pending.Kind == mailingListPendingSubscribe {
+			subject, body = "Welcome to "+list.Address, "You are now a member of "+list.Address+".\n"
+		} else {
+			subject, body = "Goodbye from "+list.Address, "You are no longer a member of "+list.Address+".\n"
+		}
+		Logger.Info(fmt.Sprintf("delivery-local %s: %s confirmed for %s on list %s", d.ID, pending.Kind, pending.Address, list.Address))
+	case "approve", "reject":
+		// envelope sender must be a moderator
+		to = sender
+		if !list.isModerator(d.QMsg.MailFrom) {
+			subject, body = "Not allowed", d.QMsg.MailFrom+" is not a moderator of "+list.Address+".\n"
+			break
+		}
+		err = MailingListModerate(token, command == "approve")
+		if err == gorm.ErrRecordNotFound {
+			err = nil
+			subject, body = "Unknown token", "Token "+token+" is unknown or has expired.\n"
+			break
+		}
+		if err != nil {
+			break
+		}
+		subject, body = "Message "+command+"d", "Message "+token+" has been "+command+"d.\n"
+		Logger.Info(fmt.Sprintf("delivery-local %s: message %s %sd by %s on list %s", d.ID, token, command, sender, list.Address))
+	default:
+		to, subject = sender, "Help for "+list.Address
+		body = fmt.Sprintf("Commands are sent to %s in subject (or first line of body):\n\n"+
+			"subscribe: subscribe to the list\n"+
+			"unsubscribe: unsubscribe from the list\n"+
+			"confirm TOKEN: confirm a subscription or an unsubscription\n"+
+			"approve TOKEN, reject TOKEN: moderate a post (moderators only)\n"+
+			"help: this message\n\n"+
+			"Posts are sent to %s.\n", list.requestAddress(), list.Address)
+	}
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to process command %s for list %s. %s", d.ID, command, list.Address, err), true)
+		return
+	}
+	if err = list.notify([]string{to}, subject, body); err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to reply to command %s for list %s. %s", d.ID, command, list.Address, err), true)
+		return
+	}
+	d.dieOk()
+}
+
+// notify sends a message from list request address to rcpts
+func (l *MailingList) notify(rcpts []string, subject, body string) error {
+	if len(rcpts) == 0 {
+		return nil
+	}
+	headers := []string{
+		"From: " + l.requestAddress(),
+		"To: " + strings.Join(rcpts, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Auto-Submitted: auto-replied",
+		"List-Id: " + l.listIdHeader(),
+		"X-Loop: " + l.Address,
+	}
+	data, err := buildMessage(headers, body, false)
+	if err != nil {
+		return err
+	}
+	_, err = QueueAddMessage(&data, message.Envelope{MailFrom: "", RcptTo: rcpts}, "")
+	return err
+}
+
+// listIdHeader returns List-Id header value
+func (l *MailingList) listIdHeader() string {
+	if l.Description == "" {
+		return "<" + l.listId() + ">"
+	}
+	return mime.QEncoding.Encode("utf-8", l.Description) + " <" + l.listId() + ">"
+}
+
+// memberHeaders returns list headers of a post sent to member, with a
+// one-click unsubscribe link if baseURL (of REST server) is not empty
+func (l *MailingList) memberHeaders(member MailingListMember, baseURL string) []string {
+	headers := []string{
+		"List-Id: " + l.listIdHeader(),
+		"List-Post: <mailto:" + l.Address + ">",
+		"List-Help: <mailto:" + l.requestAddress() + "?subject=help>",
+		"List-Subscribe: <mailto:" + l.requestAddress() + "?subject=subscribe>",
+		"List-Owner: <mailto:" + l.ownerAddress() + ">",
+		"Precedence: list",
+		"X-Loop: " + l.Address,
+	}
+	if baseURL != "" {
+		return append(headers,
+			"List-Unsubscribe: <"+baseURL+"/unsubscribe/"+member.Token+">, <mailto:"+l.requestAddress()+"?subject=unsubscribe>",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click")
+	}
+	return append(headers, "List-Unsubscribe: <mailto:"+l.requestAddress()+"?subject=unsubscribe>")
+}
+
+// distribute sends data to each member of list with list headers (RFC 2369,
+// RFC 2919 and RFC 8058) and a VERP return path. Copies are not journaled,
+// the post was journaled when it was received.
+// errors for a member are only logged (the others must not get duplicates)
+func (l *MailingList) distribute(data []byte) error {
+	members := []MailingListMember{}
+	if err := DB.Where("list_id = ?", l.Id).Find(&members).Error; err != nil {
+		return err
+	}
+	for _, h := range mailingListHeaders {
+		message.RawDelHeader(&data, h)
+	}
+	baseURL := Cfg.GetMailingListBaseUrl()
+	for _, member := range members {
+		msg := append([]byte(strings.Join(l.memberHeaders(member, baseURL), "\r\n")+"\r\n"), data...)
+		envelope := message.Envelope{MailFrom: l.bounceAddress(member.Address), RcptTo: []string{member.Address}}
+		if _, err := queueAdd(&msg, envelope, ""); err != nil {
+			Logger.Error(fmt.Sprintf("mailinglist %s: unable to queue message for %s. %s", l.Address, member.Address, err))
+		}
+	}
+	return nil
+}
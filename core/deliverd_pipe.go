@@ -0,0 +1,374 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Pipe delivery (aliases with a pipe command)
+//
+// The command is run without shell, arguments are split on spaces
+// ("double quotes", 'single quotes' and \ escapes are supported), with a
+// clean environment:
+//  SENDER, RECIPIENT, LOCAL, DOMAIN: envelope
+//  QUEUE_ID, DELIVERY_ID, MESSAGE_ID
+//  HOME, USER, PATH
+//
+// The message is written on stdin and the exit code is interpreted as:
+//  0: success
+//  4: temporary failure
+//  5: permanent failure
+//  sysexits.h codes: 71 (EX_OSERR), 74 (EX_IOERR), 75 (EX_TEMPFAIL) and
+//  78 (EX_CONFIG) are temporary failures, other ones (64-77) are permanent
+//  failures
+//  other codes: permanent failure
+// The command is killed (temporary failure) after the timeout. First
+// pipeMaxOutput bytes of its output (stdout and stderr) are logged and
+// added to the bounce.
+// When resource limits are set, tmail re-execs itself as pipeExecName: it
+// sets the limits and execs the command, so they apply from its first
+// instruction.
+
+// pipeMaxOutput is the max size of command output kept
+const pipeMaxOutput = 4096
+
+// pipeSysexits are sysexits.h codes (true: temporary failure)
+var pipeSysexits = map[int]struct {
+	name string
+	temp bool
+}{
+	64: {"EX_USAGE", false},
+	65: {"EX_DATAERR", false},
+	66: {"EX_NOINPUT", false},
+	67: {"EX_NOUSER", false},
+	68: {"EX_NOHOST", false},
+	69: {"EX_UNAVAILABLE", false},
+	70: {"EX_SOFTWARE", false},
+	71: {"EX_OSERR", true},
+	72: {"EX_OSFILE", false},
+	73: {"EX_CANTCREAT", false},
+	74: {"EX_IOERR", true},
+	75: {"EX_TEMPFAIL", true},
+	76: {"EX_PROTOCOL", false},
+	77: {"EX_NOPERM", false},
+	78: {"EX_CONFIG", true},
+}
+
+// pipeExecName is argv[0] of tmail re-executed to set resource limits
+const pipeExecName = "tmail-pipe-exec"
+
+func init() {
+	// tmail-pipe-exec LIMITS PATH ARGV...
+	if len(os.Args) > 3 && os.Args[0] == pipeExecName {
+		pipeExec(os.Args[1], os.Args[2], os.Args[3:])
+	}
+}
+
+// pipeRlimits are resource limits of pipe commands (0: no limit)
+type pipeRlimits struct {
+	cpu, as, nofile, fsize uint64
+}
+
+// pipeRlimitsFromConfig returns resource limits set in config
+func pipeRlimitsFromConfig() (limits pipeRlimits, err error) {
+	if Cfg.GetDeliverdPipeLimitCpu() < 0 || Cfg.GetDeliverdPipeLimitNofile() < 0 {
+		return limits, errors.New("negative limit")
+	}
+	limits.cpu = uint64(Cfg.GetDeliverdPipeLimitCpu())
+	limits.nofile = uint64(Cfg.GetDeliverdPipeLimitNofile())
+	as, err := parseQuota(Cfg.GetDeliverdPipeLimitAs())
+	if err != nil {
+		return limits, err
+	}
+	limits.as = uint64(as)
+	fsize, err := parseQuota(Cfg.GetDeliverdPipeLimitFsize())
+	if err != nil {
+		return limits, err
+	}
+	limits.fsize = uint64(fsize)
+	return limits, nil
+}
+
+// isSet returns true if at least one limit is set
+func (l pipeRlimits) isSet() bool {
+	return l != pipeRlimits{}
+}
+
+// String returns limits as passed to pipeExecName: cpu,as,nofile,fsize
+func (l pipeRlimits) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d", l.cpu, l.as, l.nofile, l.fsize)
+}
+
+// parsePipeRlimits parses limits returned by pipeRlimits.String
+func parsePipeRlimits(s string) (limits pipeRlimits, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return limits, errors.New("bad limits " + s)
+	}
+	for i, v := range []*uint64{&limits.cpu, &limits.as, &limits.nofile, &limits.fsize} {
+		if *v, err = strconv.ParseUint(parts[i], 10, 64); err != nil {
+			return limits, errors.New("bad limits " + s)
+		}
+	}
+	return limits, nil
+}
+
+// set sets limits of the current process
+func (l pipeRlimits) set() error {
+	for _, limit := range []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CPU, l.cpu},
+		{syscall.RLIMIT_AS, l.as},
+		{syscall.RLIMIT_NOFILE, l.nofile},
+		{syscall.RLIMIT_FSIZE, l.fsize},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(limit.resource, &syscall.Rlimit{Cur: limit.value, Max: limit.value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipeExec sets limits and execs command path (never returns), failures
+// exit with EX_OSERR (temporary failure)
+func pipeExec(limits, path string, argv []string) {
+	rlimits, err := parsePipeRlimits(limits)
+	if err == nil {
+		err = rlimits.set()
+	}
+	if err == nil {
+		err = syscall.Exec(path, argv, os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "unable to exec %s: %s\n", path, err)
+	os.Exit(71)
+}
+
+// parsePipeCommand splits command in arguments
+func parsePipeCommand(command string) ([]string, error) {
+	args := []string{}
+	arg := []rune{}
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			arg = append(arg, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg = append(arg, c)
+			}
+		case c == '"' || c == '\'':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = []rune{}, false
+			}
+		default:
+			arg, inArg = append(arg, c), true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape in command " + command)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
+
+// limitedBuffer keeps the first max bytes written
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// deliverPipe pipes message to alias command
+// returns false if delivery has failed (d is already closed)
+func deliverPipe(d *Delivery, alias *Alias) bool {
+	args, err := parsePipeCommand(alias.Pipe)
+	if err != nil {
+		d.diePerm(fmt.Sprintf("delivery-local %s: bad pipe command %s. %s", d.ID, alias.Pipe, err), true)
+		return false
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+
+	// resource limits
+	limits, err := pipeRlimitsFromConfig()
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: bad pipe resource limits. %s", d.ID, err), true)
+		return false
+	}
+	if limits.isSet() {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to exec pipe %s. %s", d.ID, alias.Pipe, err), true)
+			return false
+		}
+		self, err := os.Executable()
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get tmail executable to set pipe limits. %s", d.ID, err), true)
+			return false
+		}
+		cmd = &exec.Cmd{Path: self, Args: append([]string{pipeExecName, limits.String(), path}, args...)}
+	}
+
+	// run as
+	runAs := alias.PipeUser
+	if runAs == "" {
+		runAs = Cfg.GetDeliverdPipeUser()
+	}
+	u := &user.User{}
+	if runAs != "" {
+		if u, err = user.Lookup(runAs); err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get user to run pipe %s as. %s", d.ID, alias.Pipe, err), true)
+			return false
+		}
+	} else if current, err := user.Current(); err == nil {
+		u = current
+	}
+	// own process group to kill children on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if runAs != "" {
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: bad uid for user %s. %s", d.ID, runAs, err), true)
+			return false
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: bad gid for user %s. %s", d.ID, runAs, err), true)
+			return false
+		}
+		// supplementary groups of the user
+		groupIds, err := u.GroupIds()
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get groups of user %s. %s", d.ID, runAs, err), true)
+			return false
+		}
+		groups := make([]uint32, 0, len(groupIds))
+		for _, groupId := range groupIds {
+			group, err := strconv.ParseUint(groupId, 10, 32)
+			if err != nil {
+				d.dieTemp(fmt.Sprintf("delivery-local %s: bad group id for user %s. %s", d.ID, runAs, err), true)
+				return false
+			}
+			groups = append(groups, uint32(group))
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+	}
+
+	cmd.Dir = alias.PipeDir
+	if cmd.Dir == "" {
+		cmd.Dir = Cfg.GetDeliverdPipeDir()
+	}
+	localDom := strings.SplitN(d.QMsg.RcptTo, "@", 2)
+	if len(localDom) != 2 {
+		localDom = append(localDom, "")
+	}
+	cmd.Env = []string{
+		"SENDER=" + d.QMsg.MailFrom,
+		"RECIPIENT=" + d.QMsg.RcptTo,
+		"LOCAL=" + localDom[0],
+		"DOMAIN=" + localDom[1],
+		"QUEUE_ID=" + d.QMsg.Uuid,
+		"DELIVERY_ID=" + d.ID,
+		"MESSAGE_ID=" + d.QMsg.MessageId,
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+	}
+	cmd.Stdin = bytes.NewReader(*d.RawData)
+	output := &limitedBuffer{max: pipeMaxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err = cmd.Start(); err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to exec pipe %s. %s", d.ID, alias.Pipe, err), true)
+		return false
+	}
+	timeout := Cfg.GetDeliverdPipeTimeout()
+	if alias.PipeTimeout != 0 {
+		timeout = time.Duration(alias.PipeTimeout) * time.Second
+	}
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	err = cmd.Wait()
+	timedOut := !timer.Stop()
+
+	out := strings.TrimSpace(output.String())
+	if out != "" {
+		Logger.Info(fmt.Sprintf("delivery-local %s: cmd %s output: %s", d.ID, alias.Pipe, out))
+		out = " - output: " + out
+	}
+	if err == nil {
+		Logger.Info(fmt.Sprintf("delivery-local %s: cmd %s succeeded", d.ID, alias.Pipe))
+		return true
+	}
+	if timedOut {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: cmd %s killed after %s timeout%s", d.ID, alias.Pipe, timeout, out), true)
+		return false
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: cmd %s failed. %s%s", d.ID, alias.Pipe, err, out), true)
+		return false
+	}
+	status := exitErr.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: cmd %s killed by signal %s%s", d.ID, alias.Pipe, status.Signal(), out), true)
+		return false
+	}
+	exitStatus := status.ExitStatus()
+	switch exitStatus {
+	case 4:
+		d.dieTemp(fmt.Sprintf("delivery-local %s: cmd %s failed with exit code 4 (temp failure)%s", d.ID, alias.Pipe, out), true)
+	case 5:
+		d.diePerm(fmt.Sprintf("delivery-local %s: cmd %s failed with exit code 5 (perm failure)%s", d.ID, alias.Pipe, out), true)
+	default:
+		sysexit, ok := pipeSysexits[exitStatus]
+		switch {
+		case !ok:
+			d.diePerm(fmt.Sprintf("delivery-local %s: cmd %s return unexpected exit code %d%s", d.ID, alias.Pipe, exitStatus, out), true)
+		case sysexit.temp:
+			d.dieTemp(fmt.Sprintf("delivery-local %s: cmd %s failed with exit code %d %s (temp failure)%s", d.ID, alias.Pipe, exitStatus, sysexit.name, out), true)
+		default:
+			d.diePerm(fmt.Sprintf("delivery-local %s: cmd %s failed with exit code %d %s (perm failure)%s", d.ID, alias.Pipe, exitStatus, sysexit.name, out), true)
+		}
+	}
+	return false
+}
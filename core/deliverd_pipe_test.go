@@ -0,0 +1,111 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parsePipeCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"/usr/bin/procmail", []string{"/usr/bin/procmail"}},
+		{"  /bin/cmd   -a\t-b  ", []string{"/bin/cmd", "-a", "-b"}},
+		{`/bin/cmd "a b" 'c d'`, []string{"/bin/cmd", "a b", "c d"}},
+		{`/bin/cmd a\ b \"c\"`, []string{"/bin/cmd", "a b", `"c"`}},
+		{`/bin/cmd 'a\b' "a\"b"`, []string{"/bin/cmd", `a\b`, `a"b`}},
+		{`/bin/cmd "" x""y`, []string{"/bin/cmd", "", "xy"}},
+		{"/bin/cmd é", []string{"/bin/cmd", "é"}},
+	}
+	for _, test := range tests {
+		args, err := parsePipeCommand(test.command)
+		assert.NoError(t, err, test.command)
+		assert.Equal(t, test.args, args, test.command)
+	}
+
+	for _, command := range []string{"", "   ", `/bin/cmd "a`, "/bin/cmd 'a", `/bin/cmd a\`} {
+		_, err := parsePipeCommand(command)
+		assert.Error(t, err, command)
+	}
+}
+
+func Test_limitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+	n, err := b.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, _ = b.Write([]byte("defgh"))
+	assert.Equal(t, 5, n)
+	b.Write([]byte("ij"))
+	assert.Equal(t, "abcde", b.String())
+}
+
+func Test_pipeRlimits(t *testing.T) {
+	assert.False(t, pipeRlimits{}.isSet())
+	limits := pipeRlimits{cpu: 10, as: 512 << 20, nofile: 64, fsize: 0}
+	assert.True(t, limits.isSet())
+	assert.Equal(t, "10,536870912,64,0", limits.String())
+	parsed, err := parsePipeRlimits(limits.String())
+	assert.NoError(t, err)
+	assert.Equal(t, limits, parsed)
+	for _, s := range []string{"", "1,2,3", "1,2,3,4,5", "1,-2,3,4", "a,2,3,4"} {
+		_, err = parsePipeRlimits(s)
+		assert.Error(t, err, s)
+	}
+
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	Cfg = &Config{}
+	Cfg.cfg.DeliverdPipeLimitCpu = 60
+	Cfg.cfg.DeliverdPipeLimitAs = "512M"
+	Cfg.cfg.DeliverdPipeLimitFsize = "_"
+	limits, err = pipeRlimitsFromConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, pipeRlimits{cpu: 60, as: 512 << 20}, limits)
+	Cfg.cfg.DeliverdPipeLimitFsize = "big"
+	_, err = pipeRlimitsFromConfig()
+	assert.Error(t, err)
+}
+
+func Test_pipeExec(t *testing.T) {
+	self, err := os.Executable()
+	assert.NoError(t, err)
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("cat not found")
+	}
+	// the test binary is re-executed as pipeExecName by init
+	limits := pipeRlimits{cpu: 30, nofile: 32, fsize: 1 << 20}
+	cmd := &exec.Cmd{Path: self, Args: []string{pipeExecName, limits.String(), cat, "cat", "/proc/self/limits"}}
+	out, err := cmd.CombinedOutput()
+	if !assert.NoError(t, err, string(out)) {
+		return
+	}
+	found := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "Max cpu time"):
+			assert.Equal(t, []string{"30", "30"}, fields[3:5])
+		case strings.HasPrefix(line, "Max open files"):
+			assert.Equal(t, []string{"32", "32"}, fields[3:5])
+		case strings.HasPrefix(line, "Max file size"):
+			assert.Equal(t, []string{"1048576", "1048576"}, fields[3:5])
+		default:
+			continue
+		}
+		found++
+	}
+	assert.Equal(t, 3, found)
+
+	// exec failure is a temporary failure
+	cmd = &exec.Cmd{Path: self, Args: []string{pipeExecName, limits.String(), "/nonexistent", "x"}}
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); assert.True(t, ok) {
+		assert.Equal(t, 71, exitErr.ExitCode())
+	}
+}
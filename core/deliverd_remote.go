@@ -54,6 +54,30 @@ func deliverRemote(d *Delivery) {
 		return
 	}
 
+	// address rewriting: envelope sender and headers
+	mailFrom := d.QMsg.MailFrom
+	envelopeRewriter, err := newRewriter(RewriteScopeEnvelope, d.QMsg.AuthUser)
+	if err != nil {
+		d.dieTemp("unable to get rewrite rules. "+err.Error(), true)
+		return
+	}
+	if mailFrom = envelopeRewriter.rewrite(mailFrom, RewriteKindSender); mailFrom != d.QMsg.MailFrom {
+		Logger.Info(fmt.Sprintf("deliverd-remote %s - sender rewritten %s -> %s", d.ID, d.QMsg.MailFrom, mailFrom))
+	}
+	headerRewriter, err := newRewriter(RewriteScopeHeader, d.QMsg.AuthUser)
+	if err != nil {
+		d.dieTemp("unable to get rewrite rules. "+err.Error(), true)
+		return
+	}
+	if headerRewriter.rewriteHeaders(d.RawData) {
+		Logger.Info(fmt.Sprintf("deliverd-remote %s - headers rewritten", d.ID))
+	}
+
+	// disclaimer (before DKIM signing)
+	if !d.addDisclaimer() {
+		return
+	}
+
 	// Get client
 	client, err := newSMTPClient(d, d.RemoteRoutes, Cfg.GetDeliverdRemoteTimeout())
 	if err != nil {
@@ -152,10 +176,10 @@ func deliverRemote(d *Delivery) {
 	}
 
 	// MAIL FROM
-	code, msg, err = client.Mail(d.QMsg.MailFrom)
+	code, msg, err = client.Mail(mailFrom)
 	d.RemoteSMTPresponseCode = code
 	if err != nil {
-		message := fmt.Sprintf("deliverd-remote %s - %s - MAIL FROM %s failed %s - %s", d.ID, client.RemoteAddr(), d.QMsg.MailFrom, msg, err)
+		message := fmt.Sprintf("deliverd-remote %s - %s - MAIL FROM %s failed %s - %s", d.ID, client.RemoteAddr(), mailFrom, msg, err)
 		Logger.Error(message)
 		d.handleSMTPError(code, message)
 		return
@@ -186,7 +210,7 @@ func deliverRemote(d *Delivery) {
 
 	// DKIM ?
 	if Cfg.GetDeliverdDkimSign() {
-		userDomain := strings.SplitN(d.QMsg.MailFrom, "@", 2)
+		userDomain := strings.SplitN(mailFrom, "@", 2)
 		if len(userDomain) == 2 {
 			dkc, err := DkimGetConfig(userDomain[1])
 			if err != nil {
@@ -56,7 +56,15 @@ func AddRoute(host, localIp, remoteHost string, remotePort, priority int, user,
 	if strings.Index(localIp, "&") != -1 && strings.Index(localIp, "|") != -1 {
 		return errors.New("mixed & and | are not allowed in routes")
 	}
-	if err = route.LocalIp.Scan(strings.TrimSpace(localIp)); err != nil {
+	localIp = strings.TrimSpace(localIp)
+	if localIp != "" {
+		for _, ip := range strings.FieldsFunc(localIp, func(r rune) bool { return r == '&' || r == '|' }) {
+			if net.ParseIP(ip) == nil {
+				return errors.New("invalid local IP " + ip + " (IPv4 or IPv6 expected)")
+			}
+		}
+	}
+	if err = route.LocalIp.Scan(localIp); err != nil {
 		return err
 	}
 
@@ -217,7 +225,16 @@ func getRoutes(mailFrom, host, authUser string) (routes []Route, err error) {
 	if len(routes) == 0 {
 		mxs, err := net.LookupMX(host)
 		if err != nil {
-			return routes, err
+			// no MX: RFC 5321 5.1 implicit MX, host itself (A or AAAA)
+			if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+				if _, errIP := net.LookupIP(host); errIP == nil {
+					mxs = []*net.MX{{Host: host, Pref: 0}}
+					err = nil
+				}
+			}
+			if err != nil {
+				return routes, err
+			}
 		}
 		for _, mx := range mxs {
 			routes = append(routes, Route{
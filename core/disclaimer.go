@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/toorop/tmail/message"
+)
+
+// Disclaimers
+// Before remote deliveries (and DKIM signing) deliverd adds the disclaimer
+// of the sender (user disclaimer, or disclaimer of its domain) at the end
+// of the message body: the text disclaimer to the first text/plain part,
+// the HTML one before </body> of the first text/html part (attachments and
+// forwarded messages are untouched).
+// Signed or encrypted messages (S/MIME, PGP) can't be modified, depending
+// on TMAIL_DELIVERD_DISCLAIMER_SIGNED they are sent as is (skip) or wrapped
+// in a multipart/mixed with the text disclaimer as second part (wrap).
+// The disclaimer is added once per queued message: the message with its
+// disclaimer is kept in the store (key UUID-disclaimer) for the other remote
+// deliveries and deleted with the message. If it can't be added, depending
+// on TMAIL_DELIVERD_DISCLAIMER_FAILURE the message is sent as is (send) or
+// the delivery is a temporary failure (tempfail).
+
+const (
+	// DisclaimerSignedSkip sends signed messages without disclaimer
+	DisclaimerSignedSkip = "skip"
+	// DisclaimerSignedWrap wraps signed messages with the disclaimer
+	DisclaimerSignedWrap = "wrap"
+
+	// DisclaimerFailureSend sends messages without disclaimer if it can't
+	// be added
+	DisclaimerFailureSend = "send"
+	// DisclaimerFailureTempfail temporary fails deliveries if the disclaimer
+	// can't be added
+	DisclaimerFailureTempfail = "tempfail"
+)
+
+// disclaimerSignedTypes are media types of signed or encrypted parts
+var disclaimerSignedTypes = []string{"multipart/signed", "multipart/encrypted", "application/pkcs7-mime", "application/x-pkcs7-mime", "application/pgp-encrypted"}
+
+// disclaimerBodyEnd matches the end of the HTML body
+var disclaimerBodyEnd = regexp.MustCompile(`(?i)</body\s*>`)
+
+// Disclaimer is the disclaimer of a domain or of an user
+type Disclaimer struct {
+	Id     int64
+	Domain string `sql:"null"`
+	User   string `sql:"null"`
+	Text   string `sql:"type:text;not null"`
+	// if empty, text is used for HTML parts
+	Html string `sql:"type:text;null"`
+}
+
+// DisclaimerSet sets the disclaimer of domain or of user
+func DisclaimerSet(domain, user, text, html string) error {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	user = strings.ToLower(strings.TrimSpace(user))
+	if (domain == "") == (user == "") {
+		return errors.New("a disclaimer applies to a domain or to an user")
+	}
+	if user != "" && strings.Count(user, "@") != 1 {
+		return errors.New("invalid user " + user)
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("text disclaimer is mandatory")
+	}
+	disclaimers, err := DisclaimerGetAll()
+	if err != nil {
+		return err
+	}
+	disclaimer := Disclaimer{Domain: domain, User: user}
+	for _, d := range disclaimers {
+		if d.Domain == domain && d.User == user {
+			disclaimer = d
+			break
+		}
+	}
+	disclaimer.Text = text
+	disclaimer.Html = html
+	return DB.Save(&disclaimer).Error
+}
+
+// DisclaimerDel deletes a disclaimer
+func DisclaimerDel(id int64) error {
+	return DB.Delete(&Disclaimer{Id: id}).Error
+}
+
+// DisclaimerGetAll returns all disclaimers
+func DisclaimerGetAll() (disclaimers []Disclaimer, err error) {
+	disclaimers = []Disclaimer{}
+	err = DB.Order("id").Find(&disclaimers).Error
+	return
+}
+
+// disclaimerGet returns the disclaimer for messages from mailFrom sent by
+// authUser (nil if there is none)
+func disclaimerGet(mailFrom, authUser string) (*Disclaimer, error) {
+	disclaimers, err := DisclaimerGetAll()
+	if err != nil || len(disclaimers) == 0 {
+		return nil, err
+	}
+	for _, user := range []string{mailFrom, authUser} {
+		for _, d := range disclaimers {
+			if user != "" && strings.EqualFold(d.User, user) {
+				return &d, nil
+			}
+		}
+	}
+	if strings.Count(mailFrom, "@") == 1 {
+		domain := message.GetHostFromAddress(mailFrom)
+		for _, d := range disclaimers {
+			if d.User == "" && d.Domain == domain {
+				return &d, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// disclaimerKey returns the store key of the message uuid with its
+// disclaimer
+func disclaimerKey(uuid string) string {
+	return uuid + "-disclaimer"
+}
+
+// addDisclaimer adds the disclaimer of the sender to the raw message of d
+// (once per queued message), returns false if d has failed
+func (d *Delivery) addDisclaimer() bool {
+	disclaimer, err := disclaimerGet(d.QMsg.MailFrom, d.QMsg.AuthUser)
+	if err != nil {
+		d.dieTemp("unable to get disclaimer. "+err.Error(), true)
+		return false
+	}
+	if disclaimer == nil {
+		return true
+	}
+	key := disclaimerKey(d.QMsg.Uuid)
+	// already added for another recipient
+	if reader, err := d.QStore.Get(key); err == nil {
+		if data, err := ioutil.ReadAll(reader); err == nil {
+			*d.RawData = data
+			return true
+		}
+	}
+	added, err := disclaimer.apply(d.RawData)
+	if err != nil {
+		if Cfg.GetDeliverdDisclaimerFailure() == DisclaimerFailureTempfail {
+			d.dieTemp(fmt.Sprintf("deliverd-remote %s - unable to add disclaimer - %s", d.ID, err.Error()), true)
+			return false
+		}
+		Logger.Error(fmt.Sprintf("deliverd-remote %s - unable to add disclaimer, message sent without it - %s", d.ID, err.Error()))
+	} else if added {
+		Logger.Info(fmt.Sprintf("deliverd-remote %s - disclaimer added", d.ID))
+	}
+	if err = d.QStore.Put(key, bytes.NewReader(*d.RawData)); err != nil {
+		Logger.Error(fmt.Sprintf("deliverd-remote %s - unable to store message with disclaimer - %s", d.ID, err.Error()))
+	}
+	return true
+}
+
+// disclaimerIsSigned returns true if message root contains a signed or encrypted part
+func disclaimerIsSigned(root *message.Part) (signed bool) {
+	root.Walk(func(p *message.Part) bool {
+		if p.ContentType == "message/rfc822" || signed {
+			return false
+		}
+		if IsStringInSlice(p.ContentType, disclaimerSignedTypes) {
+			signed = true
+			return false
+		}
+		// inline PGP
+		if strings.HasPrefix(p.ContentType, "text/") && !p.IsAttachment() {
+			text, err := p.Decode()
+			signed = err == nil && strings.Contains(string(text), "-----BEGIN PGP ")
+		}
+		return true
+	})
+	return
+}
+
+// apply adds disclaimer d to raw, returns true if raw has been modified
+func (d *Disclaimer) apply(raw *[]byte) (bool, error) {
+	root, err := message.ParseMIME(*raw)
+	if err != nil {
+		return false, err
+	}
+	text := []byte(strings.TrimRight(d.Text, "\r\n"))
+	if err = Unix2dos(&text); err != nil {
+		return false, err
+	}
+	if disclaimerIsSigned(root) {
+		if Cfg.GetDeliverdDisclaimerSigned() != DisclaimerSignedWrap {
+			return false, nil
+		}
+		boundary, err := NewUUID()
+		if err != nil {
+			return false, err
+		}
+		if err = root.Wrap(boundary, message.NewTextPart("text/plain", string(text)+"\r\n")); err != nil {
+			return false, err
+		}
+		*raw = root.Bytes()
+		return true, nil
+	}
+
+	var textPart, htmlPart *message.Part
+	root.Walk(func(p *message.Part) bool {
+		if p.IsAttachment() || p.ContentType == "message/rfc822" {
+			return false
+		}
+		switch {
+		case p.ContentType == "text/plain" && textPart == nil:
+			textPart = p
+		case p.ContentType == "text/html" && htmlPart == nil:
+			htmlPart = p
+		}
+		return true
+	})
+	modified := false
+	if textPart != nil {
+		if body, err := textPart.Text(); err == nil {
+			// keep the line ending of the body (if any)
+			trimmed := strings.TrimRight(body, "\r\n")
+			if err = textPart.SetText(trimmed + "\r\n\r\n" + string(text) + body[len(trimmed):]); err != nil {
+				return false, err
+			}
+			modified = true
+		}
+	}
+	if htmlPart != nil {
+		if body, err := htmlPart.Text(); err == nil {
+			disclaimer := d.Html
+			if disclaimer == "" {
+				disclaimer = "<p>" + strings.Replace(html.EscapeString(string(text)), "\r\n", "<br>\r\n", -1) + "</p>"
+			}
+			if ends := disclaimerBodyEnd.FindAllStringIndex(body, -1); len(ends) != 0 {
+				i := ends[len(ends)-1][0]
+				body = body[:i] + disclaimer + "\r\n" + body[i:]
+			} else {
+				trimmed := strings.TrimRight(body, "\r\n")
+				body = trimmed + "\r\n" + disclaimer + body[len(trimmed):]
+			}
+			if err = htmlPart.SetText(body); err != nil {
+				return false, err
+			}
+			modified = true
+		}
+	}
+	if modified {
+		*raw = root.Bytes()
+	}
+	return modified, nil
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/toorop/tmail/message"
+)
+
+func Test_disclaimerApply(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	Cfg = &Config{}
+	d := &Disclaimer{Text: "Confidential\n"}
+
+	// text and HTML parts
+	raw := []byte("From: john@example.com\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nhello\r\n" +
+		"--b\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<html><body>hello</body></html>\r\n" +
+		"--b--\r\n")
+	added, err := d.apply(&raw)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	root, err := message.ParseMIME(raw)
+	assert.NoError(t, err)
+	text, _ := root.Parts[0].Text()
+	assert.Equal(t, "hello\r\n\r\nConfidential", strings.TrimRight(text, "\r\n"))
+	html, _ := root.Parts[1].Text()
+	assert.Equal(t, "<html><body>hello<p>Confidential</p>\r\n</body></html>", strings.TrimRight(html, "\r\n"))
+
+	// attachments are untouched
+	raw = []byte("From: john@example.com\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain; name=\"a.txt\"\r\nContent-Disposition: attachment; filename=\"a.txt\"\r\n\r\nattached\r\n" +
+		"--b--\r\n")
+	added, err = d.apply(&raw)
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	// signed messages
+	signed := "From: john@example.com\r\nMIME-Version: 1.0\r\nContent-Type: multipart/signed; protocol=\"application/pgp-signature\"; boundary=\"sig\"\r\n\r\n" +
+		"--sig\r\nContent-Type: text/plain\r\n\r\nsigned\r\n--sig\r\nContent-Type: application/pgp-signature\r\n\r\nSIGNATURE\r\n--sig--\r\n"
+	Cfg.cfg.DeliverdDisclaimerSigned = DisclaimerSignedSkip
+	raw = []byte(signed)
+	added, err = d.apply(&raw)
+	assert.NoError(t, err)
+	assert.False(t, added)
+	assert.Equal(t, signed, string(raw))
+	Cfg.cfg.DeliverdDisclaimerSigned = DisclaimerSignedWrap
+	added, err = d.apply(&raw)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	root, err = message.ParseMIME(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", root.ContentType)
+	assert.Equal(t, "multipart/signed", root.Parts[0].ContentType)
+	text, _ = root.Parts[1].Text()
+	assert.Equal(t, "Confidential", strings.TrimRight(text, "\r\n"))
+
+	// malformed message
+	raw = []byte("From: john@example.com\r\nnot a header\r\n\r\nbody\r\n")
+	_, err = d.apply(&raw)
+	assert.Error(t, err)
+}
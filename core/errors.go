@@ -7,6 +7,9 @@ import (
 var (
 	// ErrNonAsciiCharDetected when an email body does not contain only 7 bits ascii char
 	ErrNonAsciiCharDetected = errors.New("email must contains only 7-bit ASCII characters")
+
+	// ErrAuthFailed when credentials are rejected by authentication backends
+	ErrAuthFailed = errors.New("authentication failed")
 )
 
 // ErrBadDsn when dsn is wrong
@@ -0,0 +1,380 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/toorop/tmail/message"
+)
+
+// Journaling
+// When a message is queued (received by smtpd or generated by tmail: sieve
+// redirects, mailing list notifications, autoreplies, bounces...), each
+// matching journal rule sends a copy of it to its destination:
+//  - an address: the copy is queued with a null sender (journal failures
+//    never bounce to the sender) and delivered as any message (local
+//    mailbox, routes...)
+//  - the archive store (TMAIL_JOURNAL_STORE_*): the copy is kept for the
+//    retention of the rule
+// The copy is the raw message (as a BCC) or, with the envelope format, a
+// report with the envelope and the message attached (message/rfc822).
+// The original message is queued unchanged with its copies, if a copy can't
+// be made the message is not queued (smtpd temporary rejects it). Copies are
+// not journaled.
+// Messages discarded or quarantined by smtpd are journaled when they are
+// received, quarantined messages are not journaled again when they are
+// released. Received messages requeued unchanged for other recipients
+// (aliases, mailing list posts and owner forwards) are not journaled again
+// either.
+
+const (
+	// JournalDirectionInbound rules match messages for local recipients
+	JournalDirectionInbound = "inbound"
+	// JournalDirectionOutbound rules match messages from authenticated users,
+	// relay IPs or local senders
+	JournalDirectionOutbound = "outbound"
+	// JournalDirectionBoth rules match inbound and outbound messages
+	JournalDirectionBoth = "both"
+
+	// JournalFormatRaw rules send a copy of the message
+	JournalFormatRaw = "raw"
+	// JournalFormatEnvelope rules send an envelope report with the message
+	// attached
+	JournalFormatEnvelope = "envelope"
+
+	// JournalDestinationStore is the destination of copies kept in the
+	// archive store
+	JournalDestinationStore = "store"
+)
+
+// JournalRule is a journaling rule
+type JournalRule struct {
+	Id        int64
+	Direction string `sql:"not null"`
+	// only for this domain or this user (sender for outbound messages,
+	// recipients for inbound ones), all messages if both are empty
+	Domain string `sql:"null"`
+	User   string `sql:"null"`
+	Format string `sql:"not null"`
+	// journal address or "store"
+	Destination string `sql:"not null"`
+	// Retention in days of archived copies (0: TMAIL_JOURNAL_RETENTION)
+	Retention int
+}
+
+// JournalEntry is a copy in the archive store
+type JournalEntry struct {
+	Id        int64
+	Key       string `sql:"unique"`
+	RuleId    int64
+	MailFrom  string
+	RcptTo    string `sql:"type:text"`
+	MessageId string `sql:"null"`
+	CreatedAt time.Time
+	// nil: kept forever
+	ExpireAt *time.Time
+}
+
+// JournalRuleAdd adds a journaling rule
+func JournalRuleAdd(direction, format, destination, domain, user string, retention int) error {
+	direction = strings.ToLower(strings.TrimSpace(direction))
+	format = strings.ToLower(strings.TrimSpace(format))
+	destination = strings.ToLower(strings.TrimSpace(destination))
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	user = strings.ToLower(strings.TrimSpace(user))
+	switch direction {
+	case JournalDirectionInbound, JournalDirectionOutbound, JournalDirectionBoth:
+	default:
+		return errors.New("invalid direction " + direction + ", must be inbound, outbound or both")
+	}
+	switch format {
+	case JournalFormatRaw, JournalFormatEnvelope:
+	default:
+		return errors.New("invalid format " + format + ", must be raw or envelope")
+	}
+	if destination != JournalDestinationStore {
+		if strings.Count(destination, "@") != 1 {
+			return errors.New("invalid destination " + destination + ", must be an address or store")
+		}
+		if retention != 0 {
+			return errors.New("retention is only available for the store destination")
+		}
+	} else if Cfg.GetJournalStoreSource() == "" {
+		return errors.New("TMAIL_JOURNAL_STORE_SOURCE is not defined")
+	}
+	if domain != "" && user != "" {
+		return errors.New("a rule applies to a domain or to an user, not both")
+	}
+	if user != "" && strings.Count(user, "@") != 1 {
+		return errors.New("invalid user " + user)
+	}
+	if retention < 0 {
+		return errors.New("retention must be positive")
+	}
+	return DB.Save(&JournalRule{
+		Direction:   direction,
+		Domain:      domain,
+		User:        user,
+		Format:      format,
+		Destination: destination,
+		Retention:   retention,
+	}).Error
+}
+
+// JournalRuleDel deletes a journaling rule
+func JournalRuleDel(id int64) error {
+	return DB.Delete(&JournalRule{Id: id}).Error
+}
+
+// JournalRuleGetAll returns all journaling rules
+func JournalRuleGetAll() (rules []JournalRule, err error) {
+	rules = []JournalRule{}
+	err = DB.Order("id").Find(&rules).Error
+	return
+}
+
+// matchAddress returns true if address is in the domain or is the user of r
+func (r *JournalRule) matchAddress(address string) bool {
+	switch {
+	case r.User != "":
+		return strings.EqualFold(address, r.User)
+	case r.Domain != "":
+		return strings.EqualFold(message.GetHostFromAddress(address), r.Domain)
+	}
+	return true
+}
+
+// match returns true if r applies to a message from mailFrom (or
+// authUser) for local recipients inboundRcpts, outbound is true if the
+// message is sent by an user
+func (r *JournalRule) match(mailFrom, authUser string, inboundRcpts []string, outbound bool) bool {
+	if r.Direction != JournalDirectionOutbound {
+		for _, rcpt := range inboundRcpts {
+			if r.matchAddress(rcpt) {
+				return true
+			}
+		}
+	}
+	if r.Direction != JournalDirectionInbound && outbound {
+		if r.matchAddress(mailFrom) || (authUser != "" && r.matchAddress(authUser)) {
+			return true
+		}
+	}
+	return false
+}
+
+// journalCopies are journal copies of a message, copies for addresses are
+// stored in queue and must be published with the message
+type journalCopies struct {
+	queued   []*queuedMessage
+	archived []JournalEntry
+}
+
+// publish publishes copies for addresses to deliverd, if it fails copies
+// not published yet are discarded
+func (c *journalCopies) publish() error {
+	for i, qm := range c.queued {
+		if err := qm.publish(); err != nil {
+			(&journalCopies{queued: c.queued[i+1:], archived: c.archived}).discard()
+			return err
+		}
+	}
+	return nil
+}
+
+// discard deletes copies
+func (c *journalCopies) discard() {
+	for _, qm := range c.queued {
+		qm.discard()
+	}
+	if len(c.archived) == 0 {
+		return
+	}
+	store, err := NewStore(Cfg.GetJournalStoreDriver(), Cfg.GetJournalStoreSource())
+	if err != nil {
+		Logger.Error("journal - unable to discard archived copies. " + err.Error())
+		return
+	}
+	for i := range c.archived {
+		store.Del(c.archived[i].Key)
+		DB.Delete(&c.archived[i])
+	}
+}
+
+// journalMessage makes copies of raw for the destinations of matching rules
+// If a copy can't be made, copies already made are discarded.
+func journalMessage(raw *[]byte, envelope message.Envelope, authUser string, outbound bool) (copies *journalCopies, err error) {
+	copies = &journalCopies{}
+	rules, err := JournalRuleGetAll()
+	if err != nil || len(rules) == 0 {
+		return copies, err
+	}
+	inboundRcpts := []string{}
+	for _, rcpt := range envelope.RcptTo {
+		local, err := IsInRcptHost(message.GetHostFromAddress(rcpt))
+		if err != nil {
+			return copies, err
+		}
+		if local {
+			inboundRcpts = append(inboundRcpts, rcpt)
+		}
+	}
+	// one copy per destination and format
+	done := map[string]bool{}
+	for _, rule := range rules {
+		if !rule.match(envelope.MailFrom, authUser, inboundRcpts, outbound) || done[rule.Destination+" "+rule.Format] {
+			continue
+		}
+		done[rule.Destination+" "+rule.Format] = true
+		data := *raw
+		if rule.Format == JournalFormatEnvelope {
+			if data, err = journalWrap(raw, envelope, authUser, len(inboundRcpts) != 0, outbound); err != nil {
+				copies.discard()
+				return nil, err
+			}
+		}
+		if rule.Destination == JournalDestinationStore {
+			var entry *JournalEntry
+			if entry, err = journalArchive(&rule, &data, envelope, string(message.RawGetMessageId(raw))); err == nil {
+				copies.archived = append(copies.archived, *entry)
+			}
+		} else {
+			// copies are not journaled
+			var qm *queuedMessage
+			if qm, err = queueStore(&data, message.Envelope{MailFrom: "", RcptTo: []string{rule.Destination}}, ""); err == nil {
+				copies.queued = append(copies.queued, qm)
+			}
+		}
+		if err != nil {
+			copies.discard()
+			return nil, fmt.Errorf("journal rule %d: %s", rule.Id, err)
+		}
+	}
+	return copies, nil
+}
+
+// journalReceived journals raw, received but not queued (discarded or
+// quarantined)
+func journalReceived(raw *[]byte, envelope message.Envelope, authUser string, outbound bool) error {
+	copies, err := journalMessage(raw, envelope, authUser, outbound)
+	if err != nil {
+		return err
+	}
+	return copies.publish()
+}
+
+// journalWrap returns an envelope report with raw attached
+func journalWrap(raw *[]byte, envelope message.Envelope, authUser string, inbound, outbound bool) ([]byte, error) {
+	boundary, err := NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	directions := []string{}
+	if inbound {
+		directions = append(directions, JournalDirectionInbound)
+	}
+	if outbound {
+		directions = append(directions, JournalDirectionOutbound)
+	}
+	headers := []string{
+		"From: postmaster@" + Cfg.GetMe(),
+		"To: undisclosed-recipients:;",
+		"Subject: Journal report",
+		"Auto-Submitted: auto-generated",
+		"X-Envelope-From: <" + envelope.MailFrom + ">",
+	}
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n\r\n", boundary)
+	fmt.Fprintf(body, "--%s\r\nContent-Type: text/x-envelope; charset=utf-8\r\n\r\n", boundary)
+	fmt.Fprintf(body, "Sender: %s\r\n", envelope.MailFrom)
+	for _, rcpt := range envelope.RcptTo {
+		fmt.Fprintf(body, "Recipient: %s\r\n", rcpt)
+	}
+	if authUser != "" {
+		fmt.Fprintf(body, "Authenticated-User: %s\r\n", authUser)
+	}
+	fmt.Fprintf(body, "Message-ID: <%s>\r\n", message.RawGetMessageId(raw))
+	fmt.Fprintf(body, "Direction: %s\r\n", strings.Join(directions, ", "))
+	fmt.Fprintf(body, "Received-At: %s\r\n", time.Now().Format(Time822))
+	fmt.Fprintf(body, "\r\n--%s\r\nContent-Type: message/rfc822\r\n\r\n", boundary)
+	body.Write(*raw)
+	if !bytes.HasSuffix(*raw, []byte("\r\n")) {
+		body.WriteString("\r\n")
+	}
+	fmt.Fprintf(body, "--%s--\r\n", boundary)
+	return buildMessage(headers, body.String(), true)
+}
+
+// journalArchive writes data in the archive store and returns its entry
+func journalArchive(rule *JournalRule, data *[]byte, envelope message.Envelope, messageId string) (*JournalEntry, error) {
+	store, err := NewStore(Cfg.GetJournalStoreDriver(), Cfg.GetJournalStoreSource())
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	if err = store.Put(key, bytes.NewReader(*data)); err != nil {
+		return nil, err
+	}
+	entry := JournalEntry{
+		Key:       key,
+		RuleId:    rule.Id,
+		MailFrom:  envelope.MailFrom,
+		RcptTo:    strings.Join(envelope.RcptTo, ";"),
+		MessageId: messageId,
+		CreatedAt: time.Now(),
+	}
+	retention := time.Duration(rule.Retention) * 24 * time.Hour
+	if rule.Retention == 0 {
+		retention = Cfg.GetJournalRetention()
+	}
+	if retention != 0 {
+		expireAt := entry.CreatedAt.Add(retention)
+		entry.ExpireAt = &expireAt
+	}
+	if err = DB.Save(&entry).Error; err != nil {
+		store.Del(key)
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// journalPurge deletes expired copies from the archive store
+func journalPurge() error {
+	expired := []JournalEntry{}
+	if err := DB.Where("expire_at < ?", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	store, err := NewStore(Cfg.GetJournalStoreDriver(), Cfg.GetJournalStoreSource())
+	if err != nil {
+		return err
+	}
+	for _, entry := range expired {
+		if err = store.Del(entry.Key); err != nil {
+			Logger.Error("journal - unable to remove " + entry.Key + " from archive store. " + err.Error())
+		}
+		if err = DB.Delete(&entry).Error; err != nil {
+			return err
+		}
+	}
+	Logger.Info(fmt.Sprintf("journal - %d expired copies deleted", len(expired)))
+	return nil
+}
+
+// LaunchJournal purges expired copies every hour
+func LaunchJournal() {
+	for {
+		if err := journalPurge(); err != nil {
+			Logger.Error("journal - unable to purge expired copies. " + err.Error())
+		}
+		time.Sleep(time.Hour)
+	}
+}
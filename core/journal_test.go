@@ -0,0 +1,41 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_journalRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule         JournalRule
+		mailFrom     string
+		authUser     string
+		inboundRcpts []string
+		outbound     bool
+		match        bool
+	}{
+		// all messages
+		{JournalRule{Direction: JournalDirectionBoth}, "john@example.net", "", []string{"jane@example.com"}, false, true},
+		{JournalRule{Direction: JournalDirectionBoth}, "john@example.com", "", []string{}, true, true},
+		{JournalRule{Direction: JournalDirectionBoth}, "john@example.net", "", []string{}, false, false},
+		// inbound
+		{JournalRule{Direction: JournalDirectionInbound, Domain: "example.com"}, "john@example.net", "", []string{"a@example.org", "Jane@Example.com"}, false, true},
+		{JournalRule{Direction: JournalDirectionInbound, Domain: "example.com"}, "john@example.com", "", []string{"a@example.org"}, true, false},
+		{JournalRule{Direction: JournalDirectionInbound, User: "jane@example.com"}, "john@example.net", "", []string{"bob@example.com"}, false, false},
+		// outbound
+		{JournalRule{Direction: JournalDirectionOutbound, Domain: "example.com"}, "john@example.com", "", []string{}, true, true},
+		{JournalRule{Direction: JournalDirectionOutbound, Domain: "example.com"}, "john@example.com", "", []string{}, false, false},
+		{JournalRule{Direction: JournalDirectionOutbound, Domain: "example.com"}, "john@example.net", "", []string{"jane@example.com"}, false, false},
+		{JournalRule{Direction: JournalDirectionOutbound, User: "ceo@example.com"}, "", "CEO@example.com", []string{}, true, true},
+		{JournalRule{Direction: JournalDirectionOutbound, User: "ceo@example.com"}, "ceo@example.com", "", []string{}, true, true},
+		{JournalRule{Direction: JournalDirectionOutbound, User: "ceo@example.com"}, "cfo@example.com", "cfo@example.com", []string{}, true, false},
+		// both: a recipient or the sender
+		{JournalRule{Direction: JournalDirectionBoth, User: "ceo@example.com"}, "john@example.net", "", []string{"ceo@example.com"}, false, true},
+		{JournalRule{Direction: JournalDirectionBoth, User: "ceo@example.com"}, "ceo@example.com", "", []string{}, true, true},
+		{JournalRule{Direction: JournalDirectionBoth, User: "ceo@example.com"}, "ceo@example.com", "", []string{}, false, false},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.match, test.rule.match(test.mailFrom, test.authUser, test.inboundRcpts, test.outbound), i)
+	}
+}
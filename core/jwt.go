@@ -0,0 +1,248 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwk is a JSON Web Key (RFC 7517), only public RSA and EC keys are supported
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwks is a cached JWKS file
+type jwks struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	keys    []jwk
+}
+
+var oauthJwks = &jwks{}
+
+// getKeys returns keys from JWKS file path, file is reloaded if modified
+func (j *jwks) getKeys(path string) ([]jwk, error) {
+	j.Lock()
+	defer j.Unlock()
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if path == j.path && fi.ModTime().Equal(j.modTime) {
+		return j.keys, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, errors.New("unable to parse JWKS file " + path + ": " + err.Error())
+	}
+	j.path = path
+	j.modTime = fi.ModTime()
+	j.keys = set.Keys
+	return j.keys, nil
+}
+
+// JWTClaims represents claims of a validated JWT
+type JWTClaims map[string]interface{}
+
+// GetString returns claim as string (empty if missing)
+func (c JWTClaims) GetString(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// jwtB64Decode decodes base64url without padding
+func jwtB64Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// JWTValidate validates token signature against keys from the JWKS file
+// and standard claims (exp, nbf, iss, aud)
+// issuer and audience are ignored if empty
+func JWTValidate(token, jwksPath, issuer, audience string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+	rawHeader, err := jwtB64Decode(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed JWT header")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, errors.New("malformed JWT header")
+	}
+	signature, err := jwtB64Decode(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+
+	keys, err := oauthJwks.getKeys(jwksPath)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if header.Kid != "" && key.Kid != header.Kid {
+			continue
+		}
+		if key.Alg != "" && key.Alg != header.Alg {
+			continue
+		}
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if jwtVerify(header.Alg, key, signed, signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid JWT signature")
+	}
+
+	// claims
+	rawClaims, err := jwtB64Decode(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed JWT claims")
+	}
+	claims := JWTClaims{}
+	if err = json.Unmarshal(rawClaims, &claims); err != nil {
+		return nil, errors.New("malformed JWT claims")
+	}
+	now := float64(time.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok || now >= exp {
+		return nil, errors.New("JWT expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, errors.New("JWT not yet valid")
+	}
+	if issuer != "" && claims.GetString("iss") != issuer {
+		return nil, errors.New("bad JWT issuer")
+	}
+	if audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == audience
+		case []interface{}:
+			for _, a := range aud {
+				if s, ok := a.(string); ok && s == audience {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, errors.New("bad JWT audience")
+		}
+	}
+	return claims, nil
+}
+
+// jwtVerify verifies signature of signed with key for alg
+func jwtVerify(alg string, key jwk, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return errors.New("unsupported JWT alg " + alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return errors.New("unsupported JWT alg " + alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	hashed := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		if key.Kty != "RSA" {
+			return errors.New("key type mismatch")
+		}
+		n, err := jwtB64Decode(key.N)
+		if err != nil {
+			return err
+		}
+		e, err := jwtB64Decode(key.E)
+		if err != nil {
+			return err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(pub, hash, hashed, signature)
+		}
+		return rsa.VerifyPSS(pub, hash, hashed, signature, nil)
+	case "ES":
+		if key.Kty != "EC" {
+			return errors.New("key type mismatch")
+		}
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return errors.New("unsupported curve " + key.Crv)
+		}
+		x, err := jwtB64Decode(key.X)
+		if err != nil {
+			return err
+		}
+		y, err := jwtB64Decode(key.Y)
+		if err != nil {
+			return err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("bad ECDSA signature size")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, hashed, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported JWT alg " + alg)
+}
@@ -0,0 +1,470 @@
+package core
+
+// Minimal LDAPv3 client (RFC 4511): simple bind, search and StartTLS.
+// Only what is needed for authentication.
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ldapMaxMessageSize is the max size of a LDAP message we accept from a
+// server (we only ask for a few attributes of at most 2 entries)
+const ldapMaxMessageSize = 1 << 20
+
+// LDAP result codes
+const (
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+)
+
+// ldapEntry is a search result entry
+type ldapEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// ldapConn is a LDAP connection
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int
+	timeout   time.Duration
+}
+
+// ldapError is an LDAP result != success
+type ldapError struct {
+	code    int
+	message string
+}
+
+func (e *ldapError) Error() string {
+	return "LDAP result code " + strconv.Itoa(e.code) + " " + e.message
+}
+
+// ldapDial connects to LDAP server URL (ldap:// or ldaps://)
+func ldapDial(rawurl string, startTLS bool, timeout time.Duration) (*ldapConn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, errors.New("unsupported LDAP URL scheme " + u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if startTLS && u.Scheme == "ldap" {
+		if err = l.startTLS(u.Hostname()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Close sends unbind request and closes connection
+func (l *ldapConn) Close() {
+	l.send(berTLV(0x42, nil))
+	l.conn.Close()
+}
+
+// send sends protocolOp
+func (l *ldapConn) send(protocolOp []byte) error {
+	l.messageID++
+	l.conn.SetDeadline(time.Now().Add(l.timeout))
+	_, err := l.conn.Write(berTLV(0x30, append(berInt(0x02, l.messageID), protocolOp...)))
+	return err
+}
+
+// receive returns next protocolOp (tag, content)
+func (l *ldapConn) receive() (tag byte, content []byte, err error) {
+	_, message, err := berReadFrom(l.reader)
+	if err != nil {
+		return
+	}
+	// messageID
+	_, _, rest, err := berParse(message)
+	if err != nil {
+		return
+	}
+	tag, content, _, err = berParse(rest)
+	return
+}
+
+// parseResult parses LDAPResult
+func ldapParseResult(content []byte) error {
+	_, code, rest, err := berParse(content)
+	if err != nil {
+		return err
+	}
+	// matchedDN
+	_, _, rest, err = berParse(rest)
+	if err != nil {
+		return err
+	}
+	_, message, _, err := berParse(rest)
+	if err != nil {
+		return err
+	}
+	if c := berParseInt(code); c != ldapResultSuccess {
+		return &ldapError{c, string(message)}
+	}
+	return nil
+}
+
+// startTLS upgrades connection (extended operation 1.3.6.1.4.1.1466.20037)
+func (l *ldapConn) startTLS(serverName string) error {
+	if err := l.send(berTLV(0x77, berTLV(0x80, []byte("1.3.6.1.4.1.1466.20037")))); err != nil {
+		return err
+	}
+	tag, content, err := l.receive()
+	if err != nil {
+		return err
+	}
+	if tag != 0x78 {
+		return errors.New("unexpected LDAP response to StartTLS")
+	}
+	if err = ldapParseResult(content); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(l.conn, &tls.Config{ServerName: serverName})
+	if err = tlsConn.Handshake(); err != nil {
+		return err
+	}
+	l.conn = tlsConn
+	l.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind does a simple bind
+func (l *ldapConn) Bind(dn, passwd string) error {
+	req := berInt(0x02, 3)
+	req = append(req, berTLV(0x04, []byte(dn))...)
+	req = append(req, berTLV(0x80, []byte(passwd))...)
+	if err := l.send(berTLV(0x60, req)); err != nil {
+		return err
+	}
+	tag, content, err := l.receive()
+	if err != nil {
+		return err
+	}
+	if tag != 0x61 {
+		return errors.New("unexpected LDAP response to bind")
+	}
+	return ldapParseResult(content)
+}
+
+// Search searches subtree of baseDn
+func (l *ldapConn) Search(baseDn, filter string, attributes []string) (entries []ldapEntry, err error) {
+	encodedFilter, rest, err := ldapCompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("bad LDAP filter " + filter)
+	}
+	req := berTLV(0x04, []byte(baseDn))
+	req = append(req, berInt(0x0a, 2)...) // wholeSubtree
+	req = append(req, berInt(0x0a, 0)...) // neverDerefAliases
+	req = append(req, berInt(0x02, 2)...) // sizeLimit
+	req = append(req, berInt(0x02, int(l.timeout/time.Second))...)
+	req = append(req, berTLV(0x01, []byte{0})...) // typesOnly
+	req = append(req, encodedFilter...)
+	attrs := []byte{}
+	for _, a := range attributes {
+		attrs = append(attrs, berTLV(0x04, []byte(a))...)
+	}
+	req = append(req, berTLV(0x30, attrs)...)
+	if err = l.send(berTLV(0x63, req)); err != nil {
+		return nil, err
+	}
+	for {
+		tag, content, err := l.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		// SearchResultEntry
+		case 0x64:
+			entry := ldapEntry{attributes: map[string][]string{}}
+			_, dn, rest, err := berParse(content)
+			if err != nil {
+				return nil, err
+			}
+			entry.dn = string(dn)
+			_, attrList, _, err := berParse(rest)
+			if err != nil {
+				return nil, err
+			}
+			for len(attrList) != 0 {
+				var attr, name, vals []byte
+				if _, attr, attrList, err = berParse(attrList); err != nil {
+					return nil, err
+				}
+				if _, name, vals, err = berParse(attr); err != nil {
+					return nil, err
+				}
+				if _, vals, _, err = berParse(vals); err != nil {
+					return nil, err
+				}
+				key := strings.ToLower(string(name))
+				for len(vals) != 0 {
+					var val []byte
+					if _, val, vals, err = berParse(vals); err != nil {
+						return nil, err
+					}
+					entry.attributes[key] = append(entry.attributes[key], string(val))
+				}
+			}
+			entries = append(entries, entry)
+		// SearchResultDone
+		case 0x65:
+			return entries, ldapParseResult(content)
+		// SearchResultReference: ignored
+		case 0x73:
+		default:
+			return nil, errors.New("unexpected LDAP response to search")
+		}
+	}
+}
+
+// ldapEscapeFilter escapes value for use in a filter (RFC 4515)
+func ldapEscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			b.WriteString("\\" + hex.EncodeToString([]byte{c}))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapUnescapeFilterValue decodes \XX escapes
+func ldapUnescapeFilterValue(value string) ([]byte, error) {
+	out := []byte{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out = append(out, value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return nil, errors.New("bad escape in LDAP filter value " + value)
+		}
+		b, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return nil, errors.New("bad escape in LDAP filter value " + value)
+		}
+		out = append(out, b...)
+		i += 2
+	}
+	return out, nil
+}
+
+// ldapCompileFilter compiles string filter (RFC 4515) to BER
+// returns remaining of filter string
+func ldapCompileFilter(filter string) (encoded []byte, rest string, err error) {
+	if len(filter) < 3 || filter[0] != '(' {
+		return nil, "", errors.New("bad LDAP filter " + filter)
+	}
+	switch filter[1] {
+	case '&', '|':
+		tag := byte(0xa0)
+		if filter[1] == '|' {
+			tag = 0xa1
+		}
+		rest = filter[2:]
+		content := []byte{}
+		for len(rest) != 0 && rest[0] == '(' {
+			var sub []byte
+			if sub, rest, err = ldapCompileFilter(rest); err != nil {
+				return
+			}
+			content = append(content, sub...)
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.New("bad LDAP filter " + filter)
+		}
+		return berTLV(tag, content), rest[1:], nil
+	case '!':
+		var sub []byte
+		if sub, rest, err = ldapCompileFilter(filter[2:]); err != nil {
+			return
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.New("bad LDAP filter " + filter)
+		}
+		return berTLV(0xa2, sub), rest[1:], nil
+	}
+
+	// item
+	end := strings.IndexByte(filter, ')')
+	if end == -1 {
+		return nil, "", errors.New("bad LDAP filter " + filter)
+	}
+	item := filter[1:end]
+	rest = filter[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, "", errors.New("bad LDAP filter item " + item)
+	}
+	attr := item[:eq]
+	value := item[eq+1:]
+	tag := byte(0xa3)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = 0xa5, attr[:len(attr)-1]
+	case '<':
+		tag, attr = 0xa6, attr[:len(attr)-1]
+	case '~':
+		tag, attr = 0xa8, attr[:len(attr)-1]
+	}
+	// present
+	if tag == 0xa3 && value == "*" {
+		return berTLV(0x87, []byte(attr)), rest, nil
+	}
+	// substrings
+	if tag == 0xa3 && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := []byte{}
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := ldapUnescapeFilterValue(part)
+			if err != nil {
+				return nil, "", err
+			}
+			subTag := byte(0x81)
+			if i == 0 {
+				subTag = 0x80
+			} else if i == len(parts)-1 {
+				subTag = 0x82
+			}
+			subs = append(subs, berTLV(subTag, v)...)
+		}
+		return berTLV(0xa4, append(berTLV(0x04, []byte(attr)), berTLV(0x30, subs)...)), rest, nil
+	}
+	v, err := ldapUnescapeFilterValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berTLV(tag, append(berTLV(0x04, []byte(attr)), berTLV(0x04, v)...)), rest, nil
+}
+
+// BER helpers (definite length only)
+
+// berTLV encodes tag/length/value
+func berTLV(tag byte, content []byte) []byte {
+	l := len(content)
+	out := []byte{tag}
+	if l < 128 {
+		out = append(out, byte(l))
+	} else {
+		lb := []byte{}
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		out = append(out, 0x80|byte(len(lb)))
+		out = append(out, lb...)
+	}
+	return append(out, content...)
+}
+
+// berInt encodes an integer (or enumerated) with tag tag
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	// positive number: high bit must be 0
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+// berParseInt decodes integer content
+func berParseInt(content []byte) int {
+	v := 0
+	for _, b := range content {
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+// berParse parses first TLV of data
+func berParse(data []byte) (tag byte, content, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("BER: truncated data")
+	}
+	tag = data[0]
+	l := int(data[1])
+	offset := 2
+	if l&0x80 != 0 {
+		n := l & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return 0, nil, nil, errors.New("BER: bad length")
+		}
+		l = berParseInt(data[2 : 2+n])
+		offset += n
+	}
+	if len(data) < offset+l {
+		return 0, nil, nil, errors.New("BER: truncated data")
+	}
+	return tag, data[offset : offset+l], data[offset+l:], nil
+}
+
+// berReadFrom reads a TLV from reader
+func berReadFrom(r *bufio.Reader) (tag byte, content []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	tag = header[0]
+	l := int(header[1])
+	if l&0x80 != 0 {
+		n := l & 0x7f
+		if n == 0 || n > 4 {
+			return 0, nil, errors.New("BER: bad length")
+		}
+		lb := make([]byte, n)
+		if _, err = io.ReadFull(r, lb); err != nil {
+			return
+		}
+		l = berParseInt(lb)
+	}
+	if l > ldapMaxMessageSize {
+		return 0, nil, errors.New("BER: message too large (" + strconv.Itoa(l) + " bytes)")
+	}
+	content = make([]byte, l)
+	_, err = io.ReadFull(r, content)
+	return
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ldapEscapeFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"john", "john"},
+		{"jo*hn", "jo\\2ahn"},
+		{"(admin)", "\\28admin\\29"},
+		{"a\\b", "a\\5cb"},
+		{"a\x00b", "a\\00b"},
+		{"é@example.com", "é@example.com"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.escaped, ldapEscapeFilter(test.value), test.value)
+	}
+}
+
+func Test_ldapCompileFilter(t *testing.T) {
+	str := func(s string) []byte { return []byte(s) }
+	equality := func(attr, value string) []byte {
+		return berTLV(0xa3, append(berTLV(0x04, str(attr)), berTLV(0x04, str(value))...))
+	}
+	tests := []struct {
+		filter  string
+		encoded []byte
+	}{
+		{"(uid=john)", []byte{0xa3, 0x0b, 0x04, 0x03, 'u', 'i', 'd', 0x04, 0x04, 'j', 'o', 'h', 'n'}},
+		{"(mail=*)", berTLV(0x87, str("mail"))},
+		{"(uid=\\2a\\28)", equality("uid", "*(")},
+		{"(uidNumber>=1000)", berTLV(0xa5, append(berTLV(0x04, str("uidNumber")), berTLV(0x04, str("1000"))...))},
+		{"(uidNumber<=10)", berTLV(0xa6, append(berTLV(0x04, str("uidNumber")), berTLV(0x04, str("10"))...))},
+		{"(cn~=jon)", berTLV(0xa8, append(berTLV(0x04, str("cn")), berTLV(0x04, str("jon"))...))},
+		{"(cn=j*o*n)", berTLV(0xa4, append(berTLV(0x04, str("cn")), berTLV(0x30, append(append(berTLV(0x80, str("j")), berTLV(0x81, str("o"))...), berTLV(0x82, str("n"))...))...))},
+		{"(cn=*jo*)", berTLV(0xa4, append(berTLV(0x04, str("cn")), berTLV(0x30, berTLV(0x81, str("jo")))...))},
+		{"(&(objectClass=person)(uid=john))", berTLV(0xa0, append(equality("objectClass", "person"), equality("uid", "john")...))},
+		{"(|(uid=a)(!(uid=b)))", berTLV(0xa1, append(equality("uid", "a"), berTLV(0xa2, equality("uid", "b"))...))},
+	}
+	for _, test := range tests {
+		encoded, rest, err := ldapCompileFilter(test.filter)
+		assert.NoError(t, err, test.filter)
+		assert.Equal(t, "", rest, test.filter)
+		assert.Equal(t, test.encoded, encoded, test.filter)
+	}
+
+	for _, filter := range []string{"", "uid=john", "(uid=john", "(=john)", "(&(uid=a)", "(!(uid=a)", "(uid=\\zz)", "(uid=\\2)"} {
+		_, _, err := ldapCompileFilter(filter)
+		assert.Error(t, err, filter)
+	}
+}
+
+func Test_berReadFrom(t *testing.T) {
+	tag, content, err := berReadFrom(bufio.NewReader(bytes.NewReader(berTLV(0x04, bytes.Repeat([]byte("a"), 300)))))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x04), tag)
+	assert.Len(t, content, 300)
+
+	// a 4 GiB length must not be allocated
+	_, _, err = berReadFrom(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x84, 0xff, 0xff, 0xff, 0xff})))
+	assert.Error(t, err)
+	_, _, err = berReadFrom(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x85, 1, 1, 1, 1, 1})))
+	assert.Error(t, err)
+	_, _, err = berReadFrom(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x05, 1})))
+	assert.Error(t, err)
+}
+
+// ldapTestResult returns a LDAPResult protocolOp
+func ldapTestResult(tag byte, code int, message string) []byte {
+	result := berInt(0x0a, code)
+	result = append(result, berTLV(0x04, nil)...)
+	result = append(result, berTLV(0x04, []byte(message))...)
+	return berTLV(tag, result)
+}
+
+// ldapTestServer is a fake LDAP server: uid=john,dc=example,dc=com with
+// password secret
+func ldapTestServer(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	write := func(messageID []byte, op []byte) {
+		conn.Write(berTLV(0x30, append(berTLV(0x02, messageID), op...)))
+	}
+	for {
+		_, message, err := berReadFrom(reader)
+		if err != nil {
+			return
+		}
+		_, messageID, rest, err := berParse(message)
+		if !assert.NoError(t, err) {
+			return
+		}
+		tag, op, _, err := berParse(rest)
+		if !assert.NoError(t, err) {
+			return
+		}
+		switch tag {
+		// bind
+		case 0x60:
+			_, version, rest, _ := berParse(op)
+			_, dn, rest, _ := berParse(rest)
+			_, passwd, _, _ := berParse(rest)
+			assert.Equal(t, 3, berParseInt(version))
+			if string(dn) == "uid=john,dc=example,dc=com" && string(passwd) == "secret" {
+				write(messageID, ldapTestResult(0x61, ldapResultSuccess, ""))
+			} else {
+				write(messageID, ldapTestResult(0x61, ldapResultInvalidCredentials, "invalid credentials"))
+			}
+		// search
+		case 0x63:
+			_, baseDn, rest, _ := berParse(op)
+			assert.Equal(t, "dc=example,dc=com", string(baseDn))
+			// scope, deref, size limit, time limit, types only
+			for i := 0; i < 5; i++ {
+				_, _, rest, _ = berParse(rest)
+			}
+			_, filter, _, _ := berParse(rest)
+			if bytes.Contains(filter, []byte("john")) {
+				attrs := berTLV(0x30, append(berTLV(0x04, []byte("mail")), berTLV(0x31, append(berTLV(0x04, []byte("john@example.com")), berTLV(0x04, []byte("j@example.com"))...))...))
+				write(messageID, berTLV(0x64, append(berTLV(0x04, []byte("uid=john,dc=example,dc=com")), berTLV(0x30, attrs)...)))
+				write(messageID, berTLV(0x73, berTLV(0x04, []byte("ldap://other/"))))
+			}
+			write(messageID, ldapTestResult(0x65, ldapResultSuccess, ""))
+		// unbind
+		case 0x42:
+			return
+		}
+	}
+}
+
+func Test_ldapBindSearch(t *testing.T) {
+	client, server := net.Pipe()
+	go ldapTestServer(t, server)
+	conn := &ldapConn{conn: client, reader: bufio.NewReader(client), timeout: 5 * time.Second}
+	defer conn.Close()
+
+	err := conn.Bind("uid=john,dc=example,dc=com", "bad")
+	if assert.Error(t, err) {
+		assert.Equal(t, ldapResultInvalidCredentials, err.(*ldapError).code)
+	}
+	assert.NoError(t, conn.Bind("uid=john,dc=example,dc=com", "secret"))
+
+	entries, err := conn.Search("dc=example,dc=com", "(&(objectClass=person)(uid="+ldapEscapeFilter("john")+"))", []string{"mail"})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "uid=john,dc=example,dc=com", entries[0].dn)
+		assert.Equal(t, []string{"john@example.com", "j@example.com"}, entries[0].attributes["mail"])
+	}
+
+	entries, err = conn.Search("dc=example,dc=com", "(uid=jane)", []string{"mail"})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	_, err = conn.Search("dc=example,dc=com", "(uid=jane", []string{"mail"})
+	assert.Error(t, err)
+}
+
+func Test_ldapMessageTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		berReadFrom(bufio.NewReader(server))
+		server.Write([]byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff})
+	}()
+	conn := &ldapConn{conn: client, reader: bufio.NewReader(client), timeout: 5 * time.Second}
+	defer client.Close()
+	assert.Error(t, conn.Bind("uid=john,dc=example,dc=com", "secret"))
+}
@@ -24,33 +24,131 @@ func isLocalDelivery(rcpt string) (bool, error) {
 	return rcpthost.IsLocal, nil
 }
 
-// IsValidLocalRcpt checks if rcpt is a valid local destination
-// Mailbox (or wildcard)
+// rcpthostRcptDelimiter returns recipient delimiter(s) of domain ("" if
+// domain is not a rcpthost)
+func rcpthostRcptDelimiter(domain string) (string, error) {
+	rcpthost, err := RcpthostGet(strings.ToLower(domain))
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	}
+	return rcpthost.RcptDelimiter, err
+}
+
+// splitRcptDetail splits rcpt in address without detail and detail using
+// recipient delimiter of its domain (user+detail@domain -> user@domain,
+// detail). base is rcpt if there is no detail.
+func splitRcptDetail(rcpt string) (base, detail string, err error) {
+	i := strings.LastIndex(rcpt, "@")
+	if i == -1 {
+		return rcpt, "", nil
+	}
+	delimiter, err := rcpthostRcptDelimiter(rcpt[i+1:])
+	if err != nil {
+		return rcpt, "", err
+	}
+	base, detail = splitDetail(rcpt, delimiter)
+	return base, detail, nil
+}
+
+// splitDetail splits rcpt on the first char of delimiter (a set of chars)
+func splitDetail(rcpt, delimiter string) (base, detail string) {
+	i := strings.LastIndex(rcpt, "@")
+	if i == -1 || delimiter == "" {
+		return rcpt, ""
+	}
+	j := strings.IndexAny(rcpt[:i], delimiter)
+	// no delimiter or empty user part
+	if j < 1 {
+		return rcpt, ""
+	}
+	return rcpt[:j] + rcpt[i:], rcpt[j+1 : i]
+}
+
+// getRcptMailbox returns user with a mailbox for rcpt, trying rcpt then base
+// (rcpt without detail), gorm.ErrRecordNotFound if there is none
+func getRcptMailbox(rcpt, base string) (*User, error) {
+	for _, address := range []string{rcpt, base} {
+		user, err := UserGetByLogin(address)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.HaveMailbox {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// getRcptAlias returns alias of rcpt, by order of precedence: alias rcpt,
+// alias base (rcpt without detail), pattern matching rcpt, pattern matching
+// base. It returns gorm.ErrRecordNotFound if there is none
+func getRcptAlias(rcpt, base string) (alias Alias, err error) {
+	addresses := []string{strings.ToLower(rcpt)}
+	if base != rcpt {
+		addresses = append(addresses, strings.ToLower(base))
+	}
+	for _, address := range addresses {
+		if alias, err = AliasGet(address); err != gorm.ErrRecordNotFound {
+			return alias, err
+		}
+	}
+	for _, address := range addresses {
+		if alias, err = aliasMatchPattern(address); err != gorm.ErrRecordNotFound {
+			return alias, err
+		}
+	}
+	return Alias{}, gorm.ErrRecordNotFound
+}
+
+// IsValidLocalRcpt checks if rcpt is a valid local destination, by order of
+// precedence:
+// Mailbox
+// Mailing list
 // Alias
-// catchall
+// Pattern alias (the most specific)
+// Domain alias
+// Catchall
+// For mailboxes and aliases user+detail@domain is checked first, then
+// user@domain if domain has a recipient delimiter (aliases of both before
+// patterns)
 func IsValidLocalRcpt(rcpt string) (bool, error) {
+	localDom := strings.Split(rcpt, "@")
+	if len(localDom) != 2 {
+		return false, errors.New("bad address format in IsValidLocalRcpt. Got " + rcpt)
+	}
+	base, _, err := splitRcptDetail(rcpt)
+	if err != nil {
+		return false, err
+	}
 	// mailbox
-	u, err := UserGetByLogin(rcpt)
+	_, err = getRcptMailbox(rcpt, base)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+	if err == nil {
+		return true, nil
+	}
+	// mailing list
+	_, _, _, err = mailingListMatch(rcpt)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return false, err
 	}
-	if err == nil && u.HaveMailbox {
+	if err == nil {
 		return true, nil
 	}
 	// email alias
-	exists, err := AliasExists(rcpt)
-	if err != nil {
+	_, err = getRcptAlias(rcpt, base)
+	if err != nil && err != gorm.ErrRecordNotFound {
 		return false, err
 	}
-	if exists {
+	if err == nil {
 		return true, nil
 	}
 	// domain alias
-	localDom := strings.Split(rcpt, "@")
-	if len(localDom) != 2 {
-		return false, errors.New("bad address format in IsValidLocalRcpt. Got " + rcpt)
-	}
-	exists, err = AliasExists(localDom[1])
+	exists, err := AliasExists(localDom[1])
 	if err != nil {
 		return false, err
 	}
@@ -62,7 +160,7 @@ func IsValidLocalRcpt(rcpt string) (bool, error) {
 		return IsValidLocalRcpt(localDom[0] + "@" + alias.DeliverTo)
 	}
 	// Catchall
-	u, err = UserGetCatchallForDomain(localDom[1])
+	_, err = UserGetCatchallForDomain(localDom[1])
 	if err != nil && err != gorm.ErrRecordNotFound {
 		return false, err
 	}
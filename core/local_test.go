@@ -0,0 +1,68 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_splitDetail(t *testing.T) {
+	tests := []struct {
+		rcpt, delimiter string
+		base, detail    string
+	}{
+		{"john+folder@example.com", "+", "john@example.com", "folder"},
+		{"john+a+b@example.com", "+", "john@example.com", "a+b"},
+		{"john-folder@example.com", "+-", "john@example.com", "folder"},
+		{"john+@example.com", "+", "john@example.com", ""},
+		{"john@example.com", "+", "john@example.com", ""},
+		{"+folder@example.com", "+", "+folder@example.com", ""},
+		{"john+folder@example.com", "", "john+folder@example.com", ""},
+		{"john+folder", "+", "john+folder", ""},
+	}
+	for _, test := range tests {
+		base, detail := splitDetail(test.rcpt, test.delimiter)
+		assert.Equal(t, test.base, base, test.rcpt)
+		assert.Equal(t, test.detail, detail, test.rcpt)
+	}
+}
+
+func Test_getRcptAlias(t *testing.T) {
+	defer setTestDB(t, &Alias{}, &RcptHost{}, &User{}, &MailingList{})()
+	assert.NoError(t, DB.Create(&RcptHost{Hostname: "example.com", IsLocal: true, RcptDelimiter: "+"}).Error)
+	for _, alias := range []Alias{
+		{Alias: "*@example.com", DeliverTo: "catchall@example.org", IsPattern: true},
+		{Alias: "john@example.com", DeliverTo: "john@example.org"},
+		{Alias: "sales-*@example.com", DeliverTo: "sales@example.org", IsPattern: true},
+		{Alias: "sales-eu+vip@example.com", DeliverTo: "vip@example.org"},
+	} {
+		assert.NoError(t, DB.Create(&alias).Error)
+	}
+
+	tests := []struct {
+		rcpt      string
+		deliverTo string
+	}{
+		// exact alias of base wins over a pattern matching rcpt
+		{"john+x@example.com", "john@example.org"},
+		{"John@example.com", "john@example.org"},
+		{"sales-eu+vip@example.com", "vip@example.org"},
+		{"sales-eu+other@example.com", "sales@example.org"},
+		{"jane+x@example.com", "catchall@example.org"},
+	}
+	for _, test := range tests {
+		base, _, err := splitRcptDetail(test.rcpt)
+		assert.NoError(t, err)
+		alias, err := getRcptAlias(test.rcpt, base)
+		if assert.NoError(t, err, test.rcpt) {
+			assert.Equal(t, test.deliverTo, alias.DeliverTo, test.rcpt)
+		}
+		valid, err := IsValidLocalRcpt(test.rcpt)
+		assert.NoError(t, err, test.rcpt)
+		assert.True(t, valid, test.rcpt)
+	}
+
+	_, err := getRcptAlias("john@example.org", "john@example.org")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
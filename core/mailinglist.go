@@ -0,0 +1,496 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Mailing lists
+//
+// For a list list@example.com tmail handles:
+//  - list@example.com: posts, distributed to members
+//  - list-request@example.com: commands (subscribe, unsubscribe, confirm,
+//    approve, reject, help) in subject or first body line
+//  - list-owner@example.com: forwarded to moderators
+//  - list-bounces+member=domain@example.com: VERP bounces, members are
+//    removed after Cfg.GetMailingListBounceThreshold() bounces
+//
+// Subscriptions, unsubscriptions and held posts are pending until their
+// token is confirmed (or approved by a moderator).
+
+// Mailing list posting policies
+const (
+	// MailingListPolicyOpen anyone can post
+	MailingListPolicyOpen = "open"
+	// MailingListPolicyMembers only members (and moderators) can post
+	MailingListPolicyMembers = "members"
+	// MailingListPolicyModerated posts are held until a moderator approves them
+	MailingListPolicyModerated = "moderated"
+)
+
+// Mailing list pending actions
+const (
+	mailingListPendingSubscribe   = "subscribe"
+	mailingListPendingUnsubscribe = "unsubscribe"
+	mailingListPendingModeration  = "moderation"
+)
+
+// mailingListPendingLifetime is how long pending actions wait for confirmation
+const mailingListPendingLifetime = 7 * 24 * time.Hour
+
+// mailingListBounceReset: bounce counter is reset if last bounce is older
+const mailingListBounceReset = 30 * 24 * time.Hour
+
+// MailingList represents a mailing list
+type MailingList struct {
+	Id          int64
+	Address     string `sql:"unique"`
+	Description string
+	Policy      string
+	// Moderators addresses, comma separated
+	Moderators string
+	CreatedAt  time.Time
+}
+
+// MailingListMember represents a member of a mailing list
+type MailingListMember struct {
+	Id           int64
+	ListId       int64
+	Address      string
+	Token        string // unsubscribe token (List-Unsubscribe)
+	Bounces      int
+	LastBounceAt *time.Time
+	CreatedAt    time.Time
+}
+
+// MailingListPending represents an action waiting for confirmation
+type MailingListPending struct {
+	Id        int64
+	ListId    int64
+	Token     string `sql:"unique"`
+	Kind      string
+	Address   string
+	MailFrom  string
+	Data      string `sql:"type:text;"` // held message
+	CreatedAt time.Time
+}
+
+// localPart returns local part of list address
+func (l *MailingList) localPart() string {
+	return strings.Split(l.Address, "@")[0]
+}
+
+// domain returns domain of list address
+func (l *MailingList) domain() string {
+	return strings.Split(l.Address, "@")[1]
+}
+
+// requestAddress returns command address of list
+func (l *MailingList) requestAddress() string {
+	return l.localPart() + "-request@" + l.domain()
+}
+
+// ownerAddress returns owner address of list
+func (l *MailingList) ownerAddress() string {
+	return l.localPart() + "-owner@" + l.domain()
+}
+
+// bounceAddress returns VERP return path for member
+func (l *MailingList) bounceAddress(member string) string {
+	return l.localPart() + "-bounces+" + strings.Replace(member, "@", "=", 1) + "@" + l.domain()
+}
+
+// listId returns RFC 2919 list identifier
+func (l *MailingList) listId() string {
+	return l.localPart() + "." + l.domain()
+}
+
+// moderators returns moderators of list
+func (l *MailingList) moderators() []string {
+	moderators := []string{}
+	for _, m := range strings.Split(l.Moderators, ",") {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" {
+			moderators = append(moderators, m)
+		}
+	}
+	return moderators
+}
+
+// isModerator returns true if address is a moderator of list
+func (l *MailingList) isModerator(address string) bool {
+	for _, m := range l.moderators() {
+		if strings.EqualFold(m, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// mailingListCheck checks policy and moderators
+func mailingListCheck(policy, moderators string) error {
+	switch policy {
+	case MailingListPolicyOpen, MailingListPolicyMembers, MailingListPolicyModerated:
+	default:
+		return errors.New("bad policy " + policy + " (open, members or moderated expected)")
+	}
+	for _, m := range strings.Split(moderators, ",") {
+		if m = strings.TrimSpace(m); m != "" && strings.Count(m, "@") != 1 {
+			return errors.New("bad moderator address " + m)
+		}
+	}
+	if policy == MailingListPolicyModerated && strings.TrimSpace(moderators) == "" {
+		return errors.New("a moderated list must have moderators")
+	}
+	return nil
+}
+
+// MailingListAdd creates a mailing list
+// if address is a minilist alias, alias is replaced by the list and its
+// recipients become members
+func MailingListAdd(address, description, policy, moderators string) error {
+	address = strings.ToLower(strings.TrimSpace(address))
+	localDom := strings.Split(address, "@")
+	if len(localDom) != 2 || localDom[0] == "" {
+		return errors.New("mailing list address should be a valid email address. " + address + " given")
+	}
+	if strings.HasSuffix(localDom[0], "-request") || strings.HasSuffix(localDom[0], "-owner") || strings.Contains(localDom[0], "-bounces+") {
+		return errors.New("local part of mailing list address can't end with -request or -owner")
+	}
+	if policy == "" {
+		policy = MailingListPolicyMembers
+	}
+	if err := mailingListCheck(policy, moderators); err != nil {
+		return err
+	}
+
+	// domain part must be a local domain
+	rcpthost, err := RcpthostGet(localDom[1])
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errors.New("domain " + localDom[1] + " is not handled by tmail")
+		}
+		return err
+	}
+	if !rcpthost.IsLocal {
+		return errors.New("domain part of mailing list must be a local domain handled by tmail")
+	}
+
+	// address must be free
+	if _, err = MailingListGet(address); err == nil {
+		return errors.New(address + " already exists")
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	for _, a := range []string{address, localDom[0] + "-request@" + localDom[1], localDom[0] + "-owner@" + localDom[1]} {
+		exists, err := UserExists(a)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.New(a + " is an existing user")
+		}
+	}
+	alias, err := AliasGet(address)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if err == nil && !alias.IsMiniList {
+		return errors.New(address + " is an existing alias")
+	}
+	importAlias := err == nil
+
+	tx := DB.Begin()
+	list := MailingList{
+		Address:     address,
+		Description: description,
+		Policy:      policy,
+		Moderators:  strings.ToLower(moderators),
+	}
+	if err = tx.Save(&list).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if importAlias {
+		for _, rcpt := range strings.Split(alias.DeliverTo, ";") {
+			if rcpt = strings.TrimSpace(rcpt); rcpt == "" {
+				continue
+			}
+			token, err := NewUUID()
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err = tx.Save(&MailingListMember{ListId: list.Id, Address: rcpt, Token: token}).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err = tx.Where("alias = ?", address).Delete(&Alias{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// MailingListUpdate updates description, policy and moderators of a list
+func MailingListUpdate(address, description, policy, moderators string) error {
+	list, err := MailingListGet(address)
+	if err != nil {
+		return err
+	}
+	if err = mailingListCheck(policy, moderators); err != nil {
+		return err
+	}
+	list.Description, list.Policy, list.Moderators = description, policy, strings.ToLower(moderators)
+	return DB.Save(list).Error
+}
+
+// MailingListGet returns a mailing list
+func MailingListGet(address string) (list *MailingList, err error) {
+	list = &MailingList{}
+	err = DB.Where("address = ?", strings.ToLower(address)).Find(list).Error
+	return
+}
+
+// MailingListGetAll returns all mailing lists
+func MailingListGetAll() (lists []MailingList, err error) {
+	lists = []MailingList{}
+	err = DB.Order("address").Find(&lists).Error
+	return
+}
+
+// MailingListDel deletes a mailing list with its members and pending actions
+func MailingListDel(address string) error {
+	list, err := MailingListGet(address)
+	if err != nil {
+		return err
+	}
+	tx := DB.Begin()
+	for _, model := range []interface{}{&MailingListMember{}, &MailingListPending{}} {
+		if err = tx.Where("list_id = ?", list.Id).Delete(model).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Delete(list).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// MailingListMemberAdd adds member to list (without confirmation)
+func MailingListMemberAdd(address, member string) error {
+	list, err := MailingListGet(address)
+	if err != nil {
+		return err
+	}
+	member = strings.ToLower(strings.TrimSpace(member))
+	if strings.Count(member, "@") != 1 {
+		return errors.New("bad member address " + member)
+	}
+	return list.addMember(member)
+}
+
+// addMember adds member to list (no error if member is already subscribed)
+func (l *MailingList) addMember(member string) error {
+	m := MailingListMember{}
+	err := DB.Where("list_id = ? and address = ?", l.Id, member).Find(&m).Error
+	if err == nil {
+		return nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	token, err := NewUUID()
+	if err != nil {
+		return err
+	}
+	return DB.Save(&MailingListMember{ListId: l.Id, Address: member, Token: token}).Error
+}
+
+// MailingListMemberDel removes member from list
+func MailingListMemberDel(address, member string) error {
+	list, err := MailingListGet(address)
+	if err != nil {
+		return err
+	}
+	m, err := list.getMember(member)
+	if err != nil {
+		return err
+	}
+	return DB.Delete(m).Error
+}
+
+// getMember returns member of list
+func (l *MailingList) getMember(member string) (m *MailingListMember, err error) {
+	m = &MailingListMember{}
+	err = DB.Where("list_id = ? and address = ?", l.Id, strings.ToLower(member)).Find(m).Error
+	return
+}
+
+// MailingListMemberGetAll returns members of list
+func MailingListMemberGetAll(address string) (members []MailingListMember, err error) {
+	list, err := MailingListGet(address)
+	if err != nil {
+		return nil, err
+	}
+	members = []MailingListMember{}
+	err = DB.Where("list_id = ?", list.Id).Order("address").Find(&members).Error
+	return
+}
+
+// MailingListUnsubscribe removes member having unsubscribe token (RFC 8058
+// one-click)
+func MailingListUnsubscribe(token string) error {
+	if token == "" {
+		return gorm.ErrRecordNotFound
+	}
+	m := MailingListMember{}
+	if err := DB.Where("token = ?", token).Find(&m).Error; err != nil {
+		return err
+	}
+	return DB.Delete(&m).Error
+}
+
+// MailingListPendingGetAll returns pending actions of list
+func MailingListPendingGetAll(address string) (pending []MailingListPending, err error) {
+	list, err := MailingListGet(address)
+	if err != nil {
+		return nil, err
+	}
+	pending = []MailingListPending{}
+	err = DB.Where("list_id = ? and created_at > ?", list.Id, time.Now().Add(-mailingListPendingLifetime)).Order("created_at").Find(&pending).Error
+	return
+}
+
+// addPending records a pending action and returns its token
+// expired actions are purged
+func (l *MailingList) addPending(kind, address, mailFrom string, data []byte) (string, error) {
+	if err := DB.Where("created_at < ?", time.Now().Add(-mailingListPendingLifetime)).Delete(&MailingListPending{}).Error; err != nil {
+		return "", err
+	}
+	token, err := NewUUID()
+	if err != nil {
+		return "", err
+	}
+	return token, DB.Save(&MailingListPending{
+		ListId:   l.Id,
+		Token:    token,
+		Kind:     kind,
+		Address:  address,
+		MailFrom: mailFrom,
+		Data:     string(data),
+	}).Error
+}
+
+// mailingListGetPending returns unexpired pending action for token
+func mailingListGetPending(token string) (p *MailingListPending, err error) {
+	p = &MailingListPending{}
+	err = DB.Where("token = ? and created_at > ?", token, time.Now().Add(-mailingListPendingLifetime)).Find(p).Error
+	return
+}
+
+// MailingListModerate approves (post is distributed) or rejects a held post
+func MailingListModerate(token string, approve bool) error {
+	p, err := mailingListGetPending(token)
+	if err != nil {
+		return err
+	}
+	if p.Kind != mailingListPendingModeration {
+		return gorm.ErrRecordNotFound
+	}
+	list := &MailingList{}
+	if err = DB.Where("id = ?", p.ListId).Find(list).Error; err != nil {
+		return err
+	}
+	if approve {
+		data := []byte(p.Data)
+		if err = list.distribute(data); err != nil {
+			return err
+		}
+	}
+	return DB.Delete(p).Error
+}
+
+// mailingListConfirm applies a pending subscription or unsubscription
+func mailingListConfirm(token string) (*MailingList, *MailingListPending, error) {
+	p, err := mailingListGetPending(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := &MailingList{}
+	if err = DB.Where("id = ?", p.ListId).Find(list).Error; err != nil {
+		return nil, nil, err
+	}
+	switch p.Kind {
+	case mailingListPendingSubscribe:
+		err = list.addMember(p.Address)
+	case mailingListPendingUnsubscribe:
+		var m *MailingListMember
+		if m, err = list.getMember(p.Address); err == nil {
+			err = DB.Delete(m).Error
+		} else if err == gorm.ErrRecordNotFound {
+			err = nil
+		}
+	default:
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return list, p, DB.Delete(p).Error
+}
+
+// bounce records a bounce for member of list, member is removed
+// when threshold is reached
+func (l *MailingList) bounce(member string) (removed bool, err error) {
+	m, err := l.getMember(member)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if m.LastBounceAt != nil && now.Sub(*m.LastBounceAt) > mailingListBounceReset {
+		m.Bounces = 0
+	}
+	m.Bounces++
+	m.LastBounceAt = &now
+	if m.Bounces >= Cfg.GetMailingListBounceThreshold() {
+		return true, DB.Delete(m).Error
+	}
+	return false, DB.Save(m).Error
+}
+
+// mailingListMatch returns list and role (post, request, owner or bounces)
+// of rcpt, for bounces extra is the member address
+func mailingListMatch(rcpt string) (list *MailingList, role, extra string, err error) {
+	rcpt = strings.ToLower(rcpt)
+	localDom := strings.Split(rcpt, "@")
+	if len(localDom) != 2 {
+		return nil, "", "", gorm.ErrRecordNotFound
+	}
+	local, domain := localDom[0], localDom[1]
+	role = "post"
+	switch {
+	case strings.HasSuffix(local, "-request"):
+		local, role = strings.TrimSuffix(local, "-request"), "request"
+	case strings.HasSuffix(local, "-owner"):
+		local, role = strings.TrimSuffix(local, "-owner"), "owner"
+	case strings.Contains(local, "-bounces+"):
+		t := strings.SplitN(local, "-bounces+", 2)
+		i := strings.LastIndex(t[1], "=")
+		if i == -1 {
+			return nil, "", "", gorm.ErrRecordNotFound
+		}
+		local, role, extra = t[0], "bounces", t[1][:i]+"@"+t[1][i+1:]
+	}
+	list, err = MailingListGet(local + "@" + domain)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return list, role, extra, nil
+}
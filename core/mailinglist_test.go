@@ -0,0 +1,214 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/toorop/tmail/message"
+)
+
+func Test_mailingListPostAction(t *testing.T) {
+	defer setTestDB(t, &MailingListMember{})()
+	assert.NoError(t, DB.Create(&MailingListMember{ListId: 1, Address: "member@example.org"}).Error)
+
+	tests := []struct {
+		policy   string
+		mailFrom string
+		action   string
+	}{
+		{MailingListPolicyOpen, "anyone@example.org", mailingListPostDistribute},
+		{MailingListPolicyMembers, "Member@example.org", mailingListPostDistribute},
+		{MailingListPolicyMembers, "MODERATOR@example.com", mailingListPostDistribute},
+		{MailingListPolicyMembers, "anyone@example.org", mailingListPostReject},
+		{MailingListPolicyModerated, "member@example.org", mailingListPostModerate},
+		{MailingListPolicyModerated, "moderator@example.com", mailingListPostDistribute},
+	}
+	for _, test := range tests {
+		list := &MailingList{Id: 1, Address: "team@example.com", Policy: test.policy, Moderators: "moderator@example.com, boss@example.com"}
+		action, err := list.postAction(test.mailFrom)
+		assert.NoError(t, err, test)
+		assert.Equal(t, test.action, action, test)
+	}
+}
+
+func Test_mailingListMatch(t *testing.T) {
+	defer setTestDB(t, &MailingList{})()
+	assert.NoError(t, DB.Create(&MailingList{Address: "team@example.com", Policy: MailingListPolicyOpen}).Error)
+
+	tests := []struct {
+		rcpt  string
+		role  string
+		extra string
+	}{
+		{"team@example.com", "post", ""},
+		{"Team-Request@example.com", "request", ""},
+		{"team-owner@example.com", "owner", ""},
+		{"team-bounces+john=example.org@example.com", "bounces", "john@example.org"},
+		{"team-bounces+a=b=example.org@example.com", "bounces", "a=b@example.org"},
+	}
+	for _, test := range tests {
+		list, role, extra, err := mailingListMatch(test.rcpt)
+		if !assert.NoError(t, err, test.rcpt) {
+			continue
+		}
+		assert.Equal(t, "team@example.com", list.Address, test.rcpt)
+		assert.Equal(t, test.role, role, test.rcpt)
+		assert.Equal(t, test.extra, extra, test.rcpt)
+	}
+	for _, rcpt := range []string{"other@example.com", "team@example.org", "team-bounces+john@example.com", "team"} {
+		_, _, _, err := mailingListMatch(rcpt)
+		assert.Equal(t, gorm.ErrRecordNotFound, err, rcpt)
+	}
+
+	// VERP return path is decoded back to the member
+	list := &MailingList{Address: "team@example.com"}
+	for member, verp := range map[string]string{
+		"john@example.org": "team-bounces+john=example.org@example.com",
+		"a=b@example.org":  "team-bounces+a=b=example.org@example.com",
+	} {
+		assert.Equal(t, verp, list.bounceAddress(member))
+		_, role, extra, err := mailingListMatch(list.bounceAddress(member))
+		assert.NoError(t, err)
+		assert.Equal(t, "bounces", role)
+		assert.Equal(t, member, extra)
+	}
+}
+
+func Test_mailingListBounce(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer setTestDB(t, &MailingListMember{})()
+	Cfg = &Config{}
+	Cfg.cfg.MailingListBounceThreshold = 2
+
+	list := &MailingList{Id: 1, Address: "team@example.com"}
+	assert.NoError(t, list.addMember("john@example.org"))
+	assert.NoError(t, list.addMember("jane@example.org"))
+
+	removed, err := list.bounce("john@example.org")
+	assert.NoError(t, err)
+	assert.False(t, removed)
+
+	// counter is reset after a while
+	old := time.Now().Add(-mailingListBounceReset - time.Hour)
+	assert.NoError(t, DB.Model(&MailingListMember{}).Where("address = ?", "john@example.org").Update("last_bounce_at", old).Error)
+	removed, err = list.bounce("john@example.org")
+	assert.NoError(t, err)
+	assert.False(t, removed)
+
+	removed, err = list.bounce("John@example.org")
+	assert.NoError(t, err)
+	assert.True(t, removed)
+	_, err = list.getMember("john@example.org")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+	_, err = list.getMember("jane@example.org")
+	assert.NoError(t, err)
+
+	_, err = list.bounce("john@example.org")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
+func Test_mailingListConfirm(t *testing.T) {
+	defer setTestDB(t, &MailingList{}, &MailingListMember{}, &MailingListPending{})()
+	list := &MailingList{Address: "team@example.com", Policy: MailingListPolicyModerated, Moderators: "boss@example.com"}
+	assert.NoError(t, DB.Create(list).Error)
+
+	// subscribe
+	token, err := list.addPending(mailingListPendingSubscribe, "john@example.org", "john@example.org", nil)
+	assert.NoError(t, err)
+	confirmed, pending, err := mailingListConfirm(token)
+	assert.NoError(t, err)
+	assert.Equal(t, list.Id, confirmed.Id)
+	assert.Equal(t, "john@example.org", pending.Address)
+	_, err = list.getMember("john@example.org")
+	assert.NoError(t, err)
+	// tokens are used once
+	_, _, err = mailingListConfirm(token)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	// unsubscribe
+	token, err = list.addPending(mailingListPendingUnsubscribe, "john@example.org", "john@example.org", nil)
+	assert.NoError(t, err)
+	_, _, err = mailingListConfirm(token)
+	assert.NoError(t, err)
+	_, err = list.getMember("john@example.org")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	// expired
+	token, err = list.addPending(mailingListPendingSubscribe, "jane@example.org", "jane@example.org", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, DB.Model(&MailingListPending{}).Where("token = ?", token).Update("created_at", time.Now().Add(-mailingListPendingLifetime-time.Hour)).Error)
+	_, _, err = mailingListConfirm(token)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+	// and purged
+	_, err = list.addPending(mailingListPendingSubscribe, "jane@example.org", "jane@example.org", nil)
+	assert.NoError(t, err)
+	count := 0
+	assert.NoError(t, DB.Model(&MailingListPending{}).Where("token = ?", token).Count(&count).Error)
+	assert.Equal(t, 0, count)
+
+	// held posts are not confirmed by members but moderated
+	token, err = list.addPending(mailingListPendingModeration, "jane@example.org", "jane@example.org", []byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.NoError(t, err)
+	_, _, err = mailingListConfirm(token)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+	assert.NoError(t, MailingListModerate(token, false))
+	assert.Equal(t, gorm.ErrRecordNotFound, MailingListModerate(token, false))
+	assert.Equal(t, gorm.ErrRecordNotFound, MailingListModerate("", true))
+}
+
+func Test_mailingListMemberHeaders(t *testing.T) {
+	list := &MailingList{Address: "team@example.com", Description: "The team"}
+	member := MailingListMember{Address: "john@example.org", Token: "token1"}
+
+	assert.Equal(t, []string{
+		"List-Id: The team <team.example.com>",
+		"List-Post: <mailto:team@example.com>",
+		"List-Help: <mailto:team-request@example.com?subject=help>",
+		"List-Subscribe: <mailto:team-request@example.com?subject=subscribe>",
+		"List-Owner: <mailto:team-owner@example.com>",
+		"Precedence: list",
+		"X-Loop: team@example.com",
+		"List-Unsubscribe: <mailto:team-request@example.com?subject=unsubscribe>",
+	}, list.memberHeaders(member, ""))
+
+	// RFC 8058 one-click
+	headers := list.memberHeaders(member, "https://mail.example.com")
+	assert.Equal(t, []string{
+		"List-Unsubscribe: <https://mail.example.com/unsubscribe/token1>, <mailto:team-request@example.com?subject=unsubscribe>",
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+	}, headers[len(headers)-2:])
+
+	list.Description = ""
+	assert.Equal(t, "List-Id: <team.example.com>", list.memberHeaders(member, "")[0])
+}
+
+func Test_mailingListDistributeJournal(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &MailingList{}, &MailingListMember{}, &QMessage{}, &JournalRule{}, &RcptHost{})()
+	Cfg = &Config{}
+	queue, restore := setTestQueue(t)
+	defer restore()
+	assert.NoError(t, DB.Create(&RcptHost{Hostname: "example.com", IsLocal: true}).Error)
+	assert.NoError(t, DB.Create(&JournalRule{Direction: JournalDirectionBoth, Format: JournalFormatRaw, Destination: "journal@example.org"}).Error)
+	list := &MailingList{Address: "team@example.com", Policy: "open"}
+	assert.NoError(t, DB.Create(list).Error)
+	for _, address := range []string{"john@example.com", "jane@example.com", "bob@example.net"} {
+		assert.NoError(t, DB.Create(&MailingListMember{ListId: list.Id, Address: address, Token: address}).Error)
+	}
+
+	// received by smtpd, then distributed
+	raw := []byte("From: alice@example.net\r\nTo: team@example.com\r\nSubject: hi\r\n\r\nhello\r\n")
+	_, err := queueAddMessage(&raw, message.Envelope{MailFrom: "alice@example.net", RcptTo: []string{"team@example.com"}}, "", "", false)
+	assert.NoError(t, err)
+	assert.NoError(t, list.distribute(raw))
+
+	rcpts := []string{}
+	for _, qmsg := range queue.messages() {
+		rcpts = append(rcpts, qmsg.RcptTo)
+	}
+	// one copy per received message
+	assert.Equal(t, []string{"journal@example.org", "team@example.com", "john@example.com", "jane@example.com", "bob@example.net"}, rcpts)
+}
@@ -49,6 +49,10 @@ func (q *QMessage) Delete() error {
 	if err != nil {
 		return err
 	}*/
+	// message with its disclaimer (if any)
+	Store.Del(disclaimerKey(q.Uuid))
+	// sieve redirects done for it
+	DB.Where("uuid = ?", q.Uuid).Delete(&SieveRedirect{})
 	err = Store.Del(q.Uuid)
 	// Si le fichier n'existe pas ce n'est pas une véritable erreur
 	if err != nil && strings.Contains(err.Error(), "no such file") {
@@ -113,28 +117,84 @@ func QueueGetExpiredMessages() (messages []QMessage, err error) {
 }
 
 // QueueAddMessage add a new mail in queue
+// The message is journaled, as outbound if it's sent by authUser or by a
+// local sender.
 func QueueAddMessage(rawMess *[]byte, envelope message.Envelope, authUser string) (uuid string, err error) {
-	qStore, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
+	outbound := authUser != ""
+	if !outbound && envelope.MailFrom != "" {
+		if outbound, err = IsInRcptHost(message.GetHostFromAddress(envelope.MailFrom)); err != nil {
+			return
+		}
+	}
+	return queueAddMessage(rawMess, envelope, authUser, authUser, outbound)
+}
+
+// queueAddMessage queues rawMess with its journal copies (see
+// journalMessage), journalUser is the user the message is sent by. The
+// message is not queued if a copy can't be made.
+func queueAddMessage(rawMess *[]byte, envelope message.Envelope, authUser, journalUser string, outbound bool) (uuid string, err error) {
+	copies, err := journalMessage(rawMess, envelope, journalUser, outbound)
 	if err != nil {
 		return
 	}
+	qm, err := queueStore(rawMess, envelope, authUser)
+	if err != nil {
+		copies.discard()
+		return
+	}
+	// copies first: a message is never delivered without them
+	if err = copies.publish(); err != nil {
+		qm.discard()
+		return
+	}
+	if err = qm.publish(); err != nil {
+		return
+	}
+	return qm.uuid, nil
+}
 
-	uuid, err = NewUUID()
+// queueAdd queues rawMess without journaling it (messages journaled when
+// they were received)
+func queueAdd(rawMess *[]byte, envelope message.Envelope, authUser string) (uuid string, err error) {
+	qm, err := queueStore(rawMess, envelope, authUser)
 	if err != nil {
 		return
 	}
-	err = qStore.Put(uuid, bytes.NewReader(*rawMess))
+	if err = qm.publish(); err != nil {
+		return
+	}
+	return qm.uuid, nil
+}
+
+// queuedMessage is a message stored in queue, not yet published to deliverd
+type queuedMessage struct {
+	uuid      string
+	qmessages []QMessage
+}
+
+// queueStore stores rawMess and creates its queue records, the message must
+// then be published or discarded
+func queueStore(rawMess *[]byte, envelope message.Envelope, authUser string) (qm *queuedMessage, err error) {
+	qStore, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
 	if err != nil {
 		return
 	}
 
+	qm = &queuedMessage{qmessages: []QMessage{}}
+	qm.uuid, err = NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	err = qStore.Put(qm.uuid, bytes.NewReader(*rawMess))
+	if err != nil {
+		return nil, err
+	}
+
 	messageId := message.RawGetMessageId(rawMess)
 
-	cloop := 0
-	qmessages := []QMessage{}
 	for _, rcptTo := range envelope.RcptTo {
-		qm := QMessage{
-			Uuid:                    uuid,
+		qm.qmessages = append(qm.qmessages, QMessage{
+			Uuid:                    qm.uuid,
 			AuthUser:                authUser,
 			MailFrom:                envelope.MailFrom,
 			RcptTo:                  rcptTo,
@@ -145,43 +205,52 @@ func QueueAddMessage(rawMess *[]byte, envelope message.Envelope, authUser string
 			NextDeliveryScheduledAt: time.Now(),
 			Status:                  2,
 			DeliveryFailedCount:     0,
-		}
+		})
 
 		// create record in db
-		err = DB.Create(&qm).Error
+		err = DB.Create(&qm.qmessages[len(qm.qmessages)-1]).Error
 		if err != nil {
-			if cloop == 0 {
-				qStore.Del(uuid)
-			}
-			return
+			qm.qmessages = qm.qmessages[:len(qm.qmessages)-1]
+			qm.discard()
+			return nil, err
 		}
-		cloop++
-		qmessages = append(qmessages, qm)
 	}
+	return qm, nil
+}
 
-	// publish qmessage
+// publish publishes qm to deliverd, records not published are deleted
+func (qm *queuedMessage) publish() error {
 	// TODO: to avoid the copy of the Lock -> qmsg.Publish()
-	for _, qmsg := range qmessages {
-		var jMsg []byte
-		jMsg, err = json.Marshal(qmsg)
-		if err != nil {
-			if cloop == 1 {
-				qStore.Del(uuid)
-			}
-			DB.Delete(&qmsg)
-			return
+	for i := range qm.qmessages {
+		jMsg, err := json.Marshal(&qm.qmessages[i])
+		if err == nil {
+			// queue local  | queue remote
+			err = NsqQueueProducer.Publish("todeliver", jMsg)
 		}
-		// queue local  | queue remote
-		err = NsqQueueProducer.Publish("todeliver", jMsg)
 		if err != nil {
-			if cloop == 1 {
-				qStore.Del(uuid)
+			unpublished := &queuedMessage{qmessages: qm.qmessages[i:]}
+			// raw message is kept for published records
+			if i == 0 {
+				unpublished.uuid = qm.uuid
 			}
-			DB.Delete(&qmsg)
-			return
+			unpublished.discard()
+			return err
 		}
 	}
-	return
+	return nil
+}
+
+// discard deletes records of qm and its raw message (if uuid is set)
+func (qm *queuedMessage) discard() {
+	for i := range qm.qmessages {
+		DB.Delete(&qm.qmessages[i])
+	}
+	if qm.uuid == "" {
+		return
+	}
+	if qStore, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource()); err == nil {
+		qStore.Del(qm.uuid)
+	}
 }
 
 // QueueListMessages return all messages in queue
@@ -0,0 +1,380 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/toorop/tmail/message"
+)
+
+// Quarantine
+// Quarantined messages are kept in the store (key quarantine-UUID) with
+// their metadata in DB until they are released (requeued), deleted or
+// expired (TMAIL_QUARANTINE_TTL).
+// If TMAIL_QUARANTINE_DIGEST_INTERVAL is set, local recipients periodically
+// get the list of their new quarantined messages with release links signed
+// with TMAIL_QUARANTINE_SECRET (a link releases the message for this
+// recipient only, until the message expires). Messages infected by a virus
+// have no release link.
+
+// QuarantineReasonVirus is the reason of messages quarantined by the
+// antivirus
+const QuarantineReasonVirus = "virus"
+
+// QuarantinedMessage is a message in quarantine
+type QuarantinedMessage struct {
+	Id       int64
+	Key      string `sql:"unique"`
+	MailFrom string
+	// recipients, ; separated
+	RcptTo    string `sql:"type:text"`
+	AuthUser  string `sql:"null"`
+	Subject   string `sql:"null"`
+	MessageId string `sql:"null"`
+	Size      int
+	Reason    string `sql:"null"`
+	// scanner verdict (virus name, matched content rules...)
+	Verdict string `sql:"null"`
+	// Notified is true if recipients got the message in a digest
+	Notified  bool
+	CreatedAt time.Time
+	ExpireAt  time.Time
+}
+
+// rcpts returns recipients of q
+func (q *QuarantinedMessage) rcpts() []string {
+	rcpts := []string{}
+	for _, rcpt := range strings.Split(q.RcptTo, ";") {
+		if rcpt != "" {
+			rcpts = append(rcpts, rcpt)
+		}
+	}
+	return rcpts
+}
+
+// quarantineAdd puts raw in quarantine instead of the queue, raw is
+// journaled (outbound if it's sent by an user)
+func quarantineAdd(raw *[]byte, envelope message.Envelope, authUser string, outbound bool, reason, verdict string) (q *QuarantinedMessage, err error) {
+	store, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
+	if err != nil {
+		return nil, err
+	}
+	copies, err := journalMessage(raw, envelope, authUser, outbound)
+	if err != nil {
+		return nil, err
+	}
+	uuid, err := NewUUID()
+	if err != nil {
+		copies.discard()
+		return nil, err
+	}
+	subject := message.DecodeHeader(message.RawGetHeader(raw, "Subject"))
+	now := time.Now()
+	q = &QuarantinedMessage{
+		Key:       "quarantine-" + uuid,
+		MailFrom:  envelope.MailFrom,
+		RcptTo:    strings.Join(envelope.RcptTo, ";"),
+		AuthUser:  authUser,
+		Subject:   subject,
+		MessageId: string(message.RawGetMessageId(raw)),
+		Size:      len(*raw),
+		Reason:    reason,
+		Verdict:   verdict,
+		CreatedAt: now,
+		ExpireAt:  now.Add(Cfg.GetQuarantineTtl()),
+	}
+	if err = store.Put(q.Key, bytes.NewReader(*raw)); err != nil {
+		copies.discard()
+		return nil, err
+	}
+	if err = DB.Save(q).Error; err != nil {
+		store.Del(q.Key)
+		copies.discard()
+		return nil, err
+	}
+	if err = copies.publish(); err != nil {
+		q.delete()
+		return nil, err
+	}
+	Logger.Info(fmt.Sprintf("quarantine - message %d from %s to %s quarantined: %s (%s)", q.Id, q.MailFrom, strings.Join(envelope.RcptTo, ", "), reason, verdict))
+	return q, nil
+}
+
+// QuarantineGetAll returns quarantined messages, newest first
+func QuarantineGetAll() (messages []QuarantinedMessage, err error) {
+	messages = []QuarantinedMessage{}
+	err = DB.Order("id desc").Find(&messages).Error
+	return
+}
+
+// QuarantineGet returns quarantined message id
+func QuarantineGet(id int64) (q *QuarantinedMessage, err error) {
+	q = &QuarantinedMessage{}
+	err = DB.Where("id = ?", id).Find(q).Error
+	return
+}
+
+// QuarantineGetRaw returns raw content of quarantined message id
+func QuarantineGetRaw(id int64) ([]byte, error) {
+	q, err := QuarantineGet(id)
+	if err != nil {
+		return nil, err
+	}
+	return q.raw()
+}
+
+// raw returns raw content of q
+func (q *QuarantinedMessage) raw() ([]byte, error) {
+	store, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
+	if err != nil {
+		return nil, err
+	}
+	reader, err := store.Get(q.Key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// QuarantineGetHeaders returns headers of quarantined message id
+func QuarantineGetHeaders(id int64) (string, error) {
+	raw, err := QuarantineGetRaw(id)
+	if err != nil {
+		return "", err
+	}
+	headers := &bytes.Buffer{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), len(raw)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		headers.WriteString(line + "\n")
+	}
+	return headers.String(), scanner.Err()
+}
+
+// QuarantineRelease requeues quarantined message id for rcpt ("" for all
+// its recipients). The message is deleted when it has been released for
+// all its recipients.
+func QuarantineRelease(id int64, rcpt string) error {
+	q, err := QuarantineGet(id)
+	if err != nil {
+		return err
+	}
+	rcpts := q.rcpts()
+	released, remaining := rcpts, []string{}
+	if rcpt != "" {
+		released, remaining = []string{}, []string{}
+		for _, r := range rcpts {
+			if strings.EqualFold(r, rcpt) {
+				released = append(released, r)
+			} else {
+				remaining = append(remaining, r)
+			}
+		}
+		if len(released) == 0 {
+			return errors.New(rcpt + " is not a recipient of quarantined message " + strconv.FormatInt(id, 10))
+		}
+	}
+	raw, err := q.raw()
+	if err != nil {
+		return err
+	}
+	// journaled when quarantined
+	uuid, err := queueAdd(&raw, message.Envelope{MailFrom: q.MailFrom, RcptTo: released}, q.AuthUser)
+	if err != nil {
+		return err
+	}
+	Logger.Info(fmt.Sprintf("quarantine - message %d released for %s, queued as %s", q.Id, strings.Join(released, ", "), uuid))
+	if len(remaining) != 0 {
+		q.RcptTo = strings.Join(remaining, ";")
+		return DB.Save(q).Error
+	}
+	return q.delete()
+}
+
+// QuarantineDel deletes quarantined message id
+func QuarantineDel(id int64) error {
+	q, err := QuarantineGet(id)
+	if err != nil {
+		return err
+	}
+	return q.delete()
+}
+
+// delete removes q from store and DB
+func (q *QuarantinedMessage) delete() error {
+	store, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
+	if err != nil {
+		return err
+	}
+	if err = store.Del(q.Key); err != nil {
+		Logger.Error("quarantine - unable to remove " + q.Key + " from store. " + err.Error())
+	}
+	return DB.Delete(q).Error
+}
+
+// quarantinePurge deletes expired messages
+func quarantinePurge() error {
+	expired := []QuarantinedMessage{}
+	if err := DB.Where("expire_at < ?", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+	for _, q := range expired {
+		if err := q.delete(); err != nil {
+			return err
+		}
+		Logger.Info(fmt.Sprintf("quarantine - message %d expired", q.Id))
+	}
+	return nil
+}
+
+// quarantineReleaseSignature returns the signature of release link of
+// message key for rcpt, valid until expires (unix time)
+func quarantineReleaseSignature(key, rcpt string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(Cfg.GetQuarantineSecret()))
+	mac.Write([]byte(fmt.Sprintf("%s:%s:%d", key, strings.ToLower(rcpt), expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// QuarantineReleaseSigned releases message id for rcpt if signature is
+// valid and has not expired (release link of digests)
+func QuarantineReleaseSigned(id int64, rcpt string, expires int64, signature string) error {
+	if Cfg.GetQuarantineSecret() == "" {
+		return errors.New("TMAIL_QUARANTINE_SECRET is not defined")
+	}
+	if time.Now().Unix() > expires {
+		return errors.New("release link has expired")
+	}
+	q, err := QuarantineGet(id)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(quarantineReleaseSignature(q.Key, rcpt, expires))) {
+		return errors.New("bad signature")
+	}
+	return QuarantineRelease(id, rcpt)
+}
+
+// releaseURL returns release link of q for rcpt (valid until q expires)
+func (q *QuarantinedMessage) releaseURL(rcpt string) string {
+	expires := q.ExpireAt.Unix()
+	values := url.Values{}
+	values.Set("id", strconv.FormatInt(q.Id, 10))
+	values.Set("rcpt", rcpt)
+	values.Set("exp", strconv.FormatInt(expires, 10))
+	values.Set("sig", quarantineReleaseSignature(q.Key, rcpt, expires))
+	return Cfg.GetQuarantineBaseUrl() + "/quarantine-release?" + values.Encode()
+}
+
+// quarantineSendDigests sends to each local recipient the list of its new
+// quarantined messages
+func quarantineSendDigests() error {
+	messages := []QuarantinedMessage{}
+	if err := DB.Where("notified = ?", false).Order("id").Find(&messages).Error; err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	digests := map[string][]QuarantinedMessage{}
+	for _, q := range messages {
+		for _, rcpt := range q.rcpts() {
+			rcpt = strings.ToLower(rcpt)
+			rcpthost, err := RcpthostGet(message.GetHostFromAddress(rcpt))
+			if err != nil || !rcpthost.IsLocal {
+				continue
+			}
+			digests[rcpt] = append(digests[rcpt], q)
+		}
+	}
+	rcpts := []string{}
+	for rcpt := range digests {
+		rcpts = append(rcpts, rcpt)
+	}
+	sort.Strings(rcpts)
+	for _, rcpt := range rcpts {
+		if err := quarantineSendDigest(rcpt, digests[rcpt]); err != nil {
+			return err
+		}
+	}
+	for _, q := range messages {
+		if err := DB.Model(&q).Update("notified", true).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantineSendDigest sends digest of messages to rcpt
+func quarantineSendDigest(rcpt string, messages []QuarantinedMessage) error {
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "%d message(s) for %s have been quarantined.\n", len(messages), rcpt)
+	if Cfg.GetQuarantineBaseUrl() != "" && Cfg.GetQuarantineSecret() != "" {
+		body.WriteString("If one of them is legitimate, you can get it with its release link.\n")
+	}
+	for _, q := range messages {
+		fmt.Fprintf(body, "\nDate: %s\nFrom: %s\nSubject: %s\nReason: %s\nExpires: %s\n",
+			q.CreatedAt.Format(Time822), q.MailFrom, q.Subject, q.Reason, q.ExpireAt.Format(Time822))
+		if Cfg.GetQuarantineBaseUrl() == "" || Cfg.GetQuarantineSecret() == "" {
+			continue
+		}
+		// infected messages are only released by postmaster
+		if q.Reason == QuarantineReasonVirus {
+			body.WriteString("Release: not available, contact your postmaster\n")
+		} else {
+			fmt.Fprintf(body, "Release: %s\n", q.releaseURL(rcpt))
+		}
+	}
+	headers := []string{
+		"From: postmaster@" + Cfg.GetMe(),
+		"To: " + rcpt,
+		"Subject: " + mime.QEncoding.Encode("utf-8", fmt.Sprintf("Quarantine: %d new message(s)", len(messages))),
+		"Auto-Submitted: auto-generated",
+	}
+	data, err := buildMessage(headers, body.String(), false)
+	if err != nil {
+		return err
+	}
+	_, err = QueueAddMessage(&data, message.Envelope{MailFrom: "", RcptTo: []string{rcpt}}, "")
+	return err
+}
+
+// LaunchQuarantine purges expired messages every hour and sends digests
+// every TMAIL_QUARANTINE_DIGEST_INTERVAL
+func LaunchQuarantine() {
+	lastDigest := time.Now()
+	for {
+		if err := quarantinePurge(); err != nil {
+			Logger.Error("quarantine - unable to purge expired messages. " + err.Error())
+		}
+		if interval := Cfg.GetQuarantineDigestInterval(); interval != 0 && time.Since(lastDigest) >= interval {
+			if err := quarantineSendDigests(); err != nil {
+				Logger.Error("quarantine - unable to send digests. " + err.Error())
+			}
+			lastDigest = time.Now()
+		}
+		// wake up for the next digest if it's due within the hour
+		sleep := time.Hour
+		if interval := Cfg.GetQuarantineDigestInterval(); interval != 0 {
+			if next := interval - time.Since(lastDigest); next < sleep {
+				sleep = next
+			}
+		}
+		time.Sleep(sleep)
+	}
+}
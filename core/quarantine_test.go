@@ -0,0 +1,270 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/toorop/tmail/message"
+)
+
+// testQueue records messages published to deliverd by a fake nsqd
+type testQueue struct {
+	sync.Mutex
+	published []*QMessage
+}
+
+// messages returns published messages
+func (q *testQueue) messages() []*QMessage {
+	q.Lock()
+	defer q.Unlock()
+	return append([]*QMessage{}, q.published...)
+}
+
+// serve handles a nsqd connection (IDENTIFY, PUB and NOP only)
+func (q *testQueue) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return
+	}
+	ok := []byte{0, 0, 0, 6, 0, 0, 0, 0, 'O', 'K'}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		name := strings.Fields(line)[0]
+		if name == "NOP" {
+			continue
+		}
+		var size uint32
+		if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+		body := make([]byte, size)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return
+		}
+		if name == "PUB" {
+			qmsg := &QMessage{}
+			json.Unmarshal(body, qmsg)
+			q.Lock()
+			q.published = append(q.published, qmsg)
+			q.Unlock()
+		}
+		conn.Write(ok)
+	}
+}
+
+// setTestQueue sets a disk store in a temporary directory and a producer
+// publishing to a fake nsqd, returns it and a func restoring them.
+// Cfg must be set.
+func setTestQueue(t *testing.T) (*testQueue, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := &testQueue{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go queue.serve(conn)
+		}
+	}()
+	producer, err := nsq.NewProducer(listener.Addr().String(), nsq.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer.SetLogger(nil, nsq.LogLevelError)
+	Cfg.cfg.StoreDriver = "disk"
+	Cfg.cfg.StroreSource = t.TempDir()
+	saved := NsqQueueProducer
+	NsqQueueProducer = producer
+	return queue, func() {
+		NsqQueueProducer = saved
+		producer.Stop()
+		listener.Close()
+	}
+}
+
+// storeHas returns true if key is in the store
+func storeHas(key string) bool {
+	store, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
+	if err != nil {
+		return false
+	}
+	_, err = store.Get(key)
+	return err == nil
+}
+
+const quarantineTestMail = "From: john@example.net\r\nTo: a@example.com\r\nSubject: test\r\nMessage-ID: <1@example.net>\r\n\r\nbody\r\n"
+
+// quarantineTestAdd quarantines quarantineTestMail for rcpts
+func quarantineTestAdd(t *testing.T, reason string, rcpts ...string) *QuarantinedMessage {
+	raw := []byte(quarantineTestMail)
+	q, err := quarantineAdd(&raw, message.Envelope{MailFrom: "john@example.net", RcptTo: rcpts}, "", false, reason, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func Test_QuarantineRelease(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &QuarantinedMessage{}, &QMessage{}, &JournalRule{}, &RcptHost{})()
+	Cfg = &Config{}
+	Cfg.cfg.QuarantineTtl = 30
+	queue, restore := setTestQueue(t)
+	defer restore()
+
+	q := quarantineTestAdd(t, "spam", "a@example.com", "b@example.com", "c@example.com")
+	assert.True(t, storeHas(q.Key))
+
+	// partial release
+	assert.NoError(t, QuarantineRelease(q.Id, "B@example.com"))
+	q, err := QuarantineGet(q.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "a@example.com;c@example.com", q.RcptTo)
+	assert.Error(t, QuarantineRelease(q.Id, "b@example.com"))
+	if published := queue.messages(); assert.Len(t, published, 1) {
+		assert.Equal(t, "b@example.com", published[0].RcptTo)
+		assert.Equal(t, "john@example.net", published[0].MailFrom)
+	}
+
+	// release for the remaining recipients
+	assert.NoError(t, QuarantineRelease(q.Id, ""))
+	published := queue.messages()
+	if assert.Len(t, published, 3) {
+		assert.Equal(t, "a@example.com", published[1].RcptTo)
+		assert.Equal(t, "c@example.com", published[2].RcptTo)
+	}
+	_, err = QuarantineGet(q.Id)
+	assert.Error(t, err)
+	assert.False(t, storeHas(q.Key))
+	assert.True(t, storeHas(published[0].Uuid))
+}
+
+func Test_QuarantineReleaseSigned(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &QuarantinedMessage{}, &QMessage{}, &JournalRule{}, &RcptHost{})()
+	Cfg = &Config{}
+	Cfg.cfg.QuarantineTtl = 30
+	Cfg.cfg.QuarantineBaseUrl = "https://mail.example.com:8080/"
+	Cfg.cfg.QuarantineSecret = "secret"
+	queue, restore := setTestQueue(t)
+	defer restore()
+
+	q := quarantineTestAdd(t, "spam", "a@example.com", "b@example.com")
+	link, err := url.Parse(q.releaseURL("a@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "https://mail.example.com:8080/quarantine-release", link.Scheme+"://"+link.Host+link.Path)
+	query := link.Query()
+	assert.Equal(t, strconv.FormatInt(q.Id, 10), query.Get("id"))
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, q.ExpireAt.Unix(), expires)
+	sig := query.Get("sig")
+
+	// signature is bound to recipient, expiry and message
+	assert.EqualError(t, QuarantineReleaseSigned(q.Id, "b@example.com", expires, sig), "bad signature")
+	assert.EqualError(t, QuarantineReleaseSigned(q.Id, "a@example.com", expires+1, sig), "bad signature")
+	other := quarantineTestAdd(t, "spam", "a@example.com")
+	assert.EqualError(t, QuarantineReleaseSigned(other.Id, "a@example.com", expires, sig), "bad signature")
+	past := time.Now().Add(-time.Minute).Unix()
+	assert.EqualError(t, QuarantineReleaseSigned(q.Id, "a@example.com", past, quarantineReleaseSignature(q.Key, "a@example.com", past)), "release link has expired")
+	Cfg.cfg.QuarantineSecret = ""
+	assert.Error(t, QuarantineReleaseSigned(q.Id, "a@example.com", expires, sig))
+	Cfg.cfg.QuarantineSecret = "secret"
+	assert.Empty(t, queue.messages())
+
+	assert.NoError(t, QuarantineReleaseSigned(q.Id, "A@example.com", expires, sig))
+	if published := queue.messages(); assert.Len(t, published, 1) {
+		assert.Equal(t, "a@example.com", published[0].RcptTo)
+	}
+	q, err = QuarantineGet(q.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "b@example.com", q.RcptTo)
+}
+
+func Test_quarantinePurge(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &QuarantinedMessage{}, &QMessage{}, &JournalRule{}, &RcptHost{})()
+	Cfg = &Config{}
+	Cfg.cfg.QuarantineTtl = 30
+	_, restore := setTestQueue(t)
+	defer restore()
+
+	expired := quarantineTestAdd(t, "spam", "a@example.com")
+	kept := quarantineTestAdd(t, "spam", "a@example.com")
+	assert.NoError(t, DB.Model(expired).Update("expire_at", time.Now().Add(-time.Second)).Error)
+	assert.NoError(t, quarantinePurge())
+	messages, err := QuarantineGetAll()
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, kept.Id, messages[0].Id)
+	}
+	assert.False(t, storeHas(expired.Key))
+	assert.True(t, storeHas(kept.Key))
+}
+
+func Test_quarantineSendDigest(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &QuarantinedMessage{}, &QMessage{}, &JournalRule{}, &RcptHost{})()
+	Cfg = &Config{}
+	Cfg.cfg.Me = "mail.example.com"
+	Cfg.cfg.QuarantineTtl = 30
+	Cfg.cfg.QuarantineBaseUrl = "https://mail.example.com:8080"
+	Cfg.cfg.QuarantineSecret = "secret"
+	queue, restore := setTestQueue(t)
+	defer restore()
+
+	spam := quarantineTestAdd(t, "spam", "a@example.com")
+	virus := quarantineTestAdd(t, QuarantineReasonVirus, "a@example.com")
+	assert.NoError(t, quarantineSendDigest("a@example.com", []QuarantinedMessage{*spam, *virus}))
+	published := queue.messages()
+	if !assert.Len(t, published, 1) {
+		return
+	}
+	assert.Equal(t, "a@example.com", published[0].RcptTo)
+	store, err := NewStore(Cfg.GetStoreDriver(), Cfg.GetStoreSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := store.Get(published[0].Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ioutil.ReadAll(reader)
+	root, err := message.ParseMIME(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := root.Text()
+	assert.NoError(t, err)
+	// infected messages have no release link
+	assert.Equal(t, 1, strings.Count(text, "/quarantine-release?"))
+	assert.Contains(t, text, "id="+strconv.FormatInt(spam.Id, 10)+"&")
+	assert.Contains(t, text, "Reason: virus\r\nExpires: "+virus.ExpireAt.Format(Time822)+"\r\nRelease: not available, contact your postmaster\r\n")
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"errors"
+	"net"
 	"strings"
 
 	"github.com/jinzhu/gorm"
@@ -13,6 +14,15 @@ type RcptHost struct {
 	Hostname string `sql:"unique"`
 	IsLocal  bool   `sql:"default:false"`
 	IsAlias  bool   `sql:"default:false"`
+	// LMTP server for local deliveries (users can override it)
+	LmtpEndpoint string `sql:"null"`
+	// recipient delimiter(s) (user+detail@hostname), "" to disable
+	RcptDelimiter string `sql:"null"`
+	// recipient verification for relay hosts (smtp, lmtp, ldap or "")
+	RcptVerify         string `sql:"null"`
+	RcptVerifyEndpoint string `sql:"null"`
+	// accept recipients if verification fails (backend down, timeout)
+	RcptVerifyFailOpen bool `sql:"default:false"`
 }
 
 // IsInRcptHost checks if domain is in the RcptHost list (-> relay authorized)
@@ -59,6 +69,85 @@ func RcpthostAdd(hostname string, isLocal, isAlias bool) error {
 	return DB.Save(&h).Error
 }
 
+// RcpthostSetLmtpEndpoint sets LMTP endpoint used for local deliveries to
+// hostname ("" to use default one)
+func RcpthostSetLmtpEndpoint(hostname, endpoint string) error {
+	if endpoint != "" {
+		if _, _, err := parseLmtpEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+	rcpthost, err := RcpthostGet(strings.ToLower(hostname))
+	if err != nil {
+		return err
+	}
+	if !rcpthost.IsLocal {
+		return errors.New("rcpthost " + rcpthost.Hostname + " is not local")
+	}
+	rcpthost.LmtpEndpoint = endpoint
+	return DB.Save(&rcpthost).Error
+}
+
+// RcpthostSetRcptDelimiter sets recipient delimiter characters of hostname
+// ("+" for user+detail@hostname, "" to disable subaddresses)
+// If there is more than one character, the first one found in the local
+// part is used
+func RcpthostSetRcptDelimiter(hostname, delimiter string) error {
+	for _, c := range delimiter {
+		if c <= ' ' || c > '~' || c == '@' || c == '"' || c == '\\' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			return errors.New("invalid recipient delimiter " + delimiter)
+		}
+	}
+	rcpthost, err := RcpthostGet(strings.ToLower(hostname))
+	if err != nil {
+		return err
+	}
+	if !rcpthost.IsLocal {
+		return errors.New("rcpthost " + rcpthost.Hostname + " is not local")
+	}
+	rcpthost.RcptDelimiter = delimiter
+	return DB.Save(&rcpthost).Error
+}
+
+// RcpthostSetRcptVerify sets recipient verification of relay host hostname
+// method is smtp (endpoint: host[:port], default routes of hostname), lmtp
+// (endpoint: LMTP endpoint), ldap or "" to disable it
+func RcpthostSetRcptVerify(hostname, method, endpoint string, failOpen bool) error {
+	method = strings.ToLower(method)
+	switch method {
+	case "":
+		endpoint, failOpen = "", false
+	case RcptVerifySmtp:
+		if endpoint != "" {
+			if _, _, err := net.SplitHostPort(endpoint); err != nil && strings.Contains(endpoint, ":") {
+				return errors.New("bad SMTP endpoint " + endpoint + " - " + err.Error())
+			}
+		}
+	case RcptVerifyLmtp:
+		if endpoint == "" {
+			return errors.New("LMTP endpoint is required")
+		}
+		if _, _, err := parseLmtpEndpoint(endpoint); err != nil {
+			return err
+		}
+	case RcptVerifyLdap:
+		if endpoint != "" {
+			return errors.New("ldap verification doesn't take an endpoint (TMAIL_SMTPD_AUTH_LDAP_URL is used)")
+		}
+	default:
+		return errors.New("invalid verification method " + method + ", must be smtp, lmtp or ldap")
+	}
+	rcpthost, err := RcpthostGet(strings.ToLower(hostname))
+	if err != nil {
+		return err
+	}
+	if rcpthost.IsLocal {
+		return errors.New("rcpthost " + rcpthost.Hostname + " is local, recipients are already checked")
+	}
+	rcpthost.RcptVerify, rcpthost.RcptVerifyEndpoint, rcpthost.RcptVerifyFailOpen = method, endpoint, failOpen
+	return DB.Save(&rcpthost).Error
+}
+
 // RcpthostDel delete a hostname from rcpthosts list
 func RcpthostDel(hostname string) error {
 	//var err error
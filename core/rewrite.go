@@ -0,0 +1,334 @@
+package core
+
+import (
+	"errors"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toorop/tmail/message"
+)
+
+// Address rewriting
+// Rules are applied by smtpd to the envelope (after MAIL FROM and RCPT TO)
+// and by deliverd before remote deliveries to the envelope sender and to
+// the headers (messages generated by tmail don't go through smtpd).
+// For each address the first matching rule (by priority, then by id) is
+// applied. As a rule can be applied twice to the same message, its
+// replacement should not match the rule itself.
+//
+// Exact rules match an address (old@example.com) or all the addresses of
+// a domain (@host.example.com). For a domain rule a domain replacement
+// (@example.com) keeps the local part.
+// Regex rules match the whole address (case insensitive), replacement can
+// use $1, ${name}...
+
+const (
+	// RewriteScopeEnvelope rules are applied to MAIL FROM and RCPT TO
+	RewriteScopeEnvelope = "envelope"
+	// RewriteScopeHeader rules are applied to address headers
+	RewriteScopeHeader = "header"
+	// RewriteScopeAll rules are applied to envelope and headers
+	RewriteScopeAll = "all"
+
+	// RewriteKindSender rules are applied to MAIL FROM and From, Sender,
+	// Reply-To headers
+	RewriteKindSender = "sender"
+	// RewriteKindRecipient rules are applied to RCPT TO and To, Cc headers
+	RewriteKindRecipient = "recipient"
+	// RewriteKindAll rules are applied to senders and recipients
+	RewriteKindAll = "all"
+
+	// RewriteMatchExact rules match an address or a domain
+	RewriteMatchExact = "exact"
+	// RewriteMatchRegex rules match a regular expression
+	RewriteMatchRegex = "regex"
+)
+
+// rewriteHeaders are address headers rewritten (header -> kind)
+var rewriteHeaders = []struct {
+	name string
+	kind string
+}{
+	{"From", RewriteKindSender},
+	{"Sender", RewriteKindSender},
+	{"Reply-To", RewriteKindSender},
+	{"To", RewriteKindRecipient},
+	{"Cc", RewriteKindRecipient},
+}
+
+// RewriteRule is an address rewriting rule
+type RewriteRule struct {
+	Id          int64
+	Scope       string `sql:"not null"`
+	Kind        string `sql:"not null"`
+	Match       string `sql:"not null"`
+	Pattern     string `sql:"not null"`
+	Replacement string `sql:"not null"`
+	// only for messages of this authenticated user ("" for all)
+	AuthUser string `sql:"null"`
+	Priority int
+}
+
+// RewriteRuleAdd adds a rewriting rule
+func RewriteRuleAdd(scope, kind, match, pattern, replacement, authUser string, priority int) error {
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	match = strings.ToLower(strings.TrimSpace(match))
+	pattern = strings.TrimSpace(pattern)
+	replacement = strings.TrimSpace(replacement)
+	switch scope {
+	case RewriteScopeEnvelope, RewriteScopeHeader, RewriteScopeAll:
+	default:
+		return errors.New("invalid scope " + scope + ", must be envelope, header or all")
+	}
+	switch kind {
+	case RewriteKindSender, RewriteKindRecipient, RewriteKindAll:
+	default:
+		return errors.New("invalid kind " + kind + ", must be sender, recipient or all")
+	}
+	if pattern == "" || replacement == "" {
+		return errors.New("pattern and replacement must not be empty")
+	}
+	switch match {
+	case RewriteMatchExact:
+		pattern = strings.ToLower(pattern)
+		if strings.Count(pattern, "@") != 1 {
+			return errors.New("exact pattern must be an address or @domain, " + pattern + " given")
+		}
+		if strings.Count(replacement, "@") != 1 {
+			return errors.New("replacement must be an address or @domain, " + replacement + " given")
+		}
+		if strings.HasPrefix(replacement, "@") && !strings.HasPrefix(pattern, "@") {
+			return errors.New("a domain replacement needs a domain pattern")
+		}
+	case RewriteMatchRegex:
+		if _, err := rewriteCompile(pattern); err != nil {
+			return errors.New("bad regex " + pattern + ". " + err.Error())
+		}
+	default:
+		return errors.New("invalid match " + match + ", must be exact or regex")
+	}
+	err := DB.Save(&RewriteRule{
+		Scope:       scope,
+		Kind:        kind,
+		Match:       match,
+		Pattern:     pattern,
+		Replacement: replacement,
+		AuthUser:    strings.ToLower(strings.TrimSpace(authUser)),
+		Priority:    priority,
+	}).Error
+	if err != nil {
+		return err
+	}
+	rewriteRules.flush()
+	return nil
+}
+
+// RewriteRuleDel deletes a rewriting rule
+func RewriteRuleDel(id int64) error {
+	if err := DB.Delete(&RewriteRule{Id: id}).Error; err != nil {
+		return err
+	}
+	rewriteRules.flush()
+	return nil
+}
+
+// RewriteRuleGetAll returns all rewriting rules ordered by priority
+func RewriteRuleGetAll() (rules []RewriteRule, err error) {
+	rules = []RewriteRule{}
+	err = DB.Order("priority, id").Find(&rules).Error
+	return
+}
+
+// rewriteCompile compiles a regex rule pattern (whole address, case
+// insensitive)
+func rewriteCompile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?i:" + pattern + ")$")
+}
+
+// rewriter applies rules of a scope for an authenticated user
+type rewriter struct {
+	rules   []RewriteRule
+	regexps map[int64]*regexp.Regexp
+}
+
+// rewriteRulesCache holds all the rules, compiled
+type rewriteRulesCache struct {
+	sync.Mutex
+	all      *rewriter
+	loadedAt time.Time
+}
+
+var rewriteRules = &rewriteRulesCache{}
+
+// flush forces reload of rules from DB
+func (c *rewriteRulesCache) flush() {
+	c.Lock()
+	c.loadedAt = time.Time{}
+	c.Unlock()
+}
+
+// get returns a rewriter with all the rules from DB (cached for one minute)
+func (c *rewriteRulesCache) get() (*rewriter, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.all != nil && time.Since(c.loadedAt) < time.Minute {
+		return c.all, nil
+	}
+	rules, err := RewriteRuleGetAll()
+	if err != nil {
+		return nil, err
+	}
+	c.all = compileRewriteRules(rules)
+	c.loadedAt = time.Now()
+	return c.all, nil
+}
+
+// newRewriter returns a rewriter with rules of scope (envelope or header)
+// for messages of authUser ("" if not authenticated)
+func newRewriter(scope, authUser string) (*rewriter, error) {
+	all, err := rewriteRules.get()
+	if err != nil {
+		return nil, err
+	}
+	return all.filter(scope, authUser), nil
+}
+
+// newRewriterFromRules returns a rewriter with rules (ordered by priority)
+// of scope for messages of authUser
+func newRewriterFromRules(rules []RewriteRule, scope, authUser string) *rewriter {
+	return compileRewriteRules(rules).filter(scope, authUser)
+}
+
+// compileRewriteRules returns a rewriter with rules, rules with a bad regex
+// are ignored
+func compileRewriteRules(rules []RewriteRule) *rewriter {
+	r := &rewriter{
+		rules:   []RewriteRule{},
+		regexps: map[int64]*regexp.Regexp{},
+	}
+	for _, rule := range rules {
+		if rule.Match == RewriteMatchRegex {
+			re, err := rewriteCompile(rule.Pattern)
+			if err != nil {
+				Logger.Error("rewrite - bad regex in rule " + rule.Pattern + ". " + err.Error())
+				continue
+			}
+			r.regexps[rule.Id] = re
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r
+}
+
+// filter returns a rewriter with rules of r of scope for messages of
+// authUser (compiled regexps are shared)
+func (r *rewriter) filter(scope, authUser string) *rewriter {
+	filtered := &rewriter{
+		rules:   []RewriteRule{},
+		regexps: r.regexps,
+	}
+	for _, rule := range r.rules {
+		if rule.Scope != scope && rule.Scope != RewriteScopeAll {
+			continue
+		}
+		if rule.AuthUser != "" && !strings.EqualFold(rule.AuthUser, authUser) {
+			continue
+		}
+		filtered.rules = append(filtered.rules, rule)
+	}
+	return filtered
+}
+
+// rewrite returns address rewritten by the first rule of kind (sender or
+// recipient) matching it
+func (r *rewriter) rewrite(address, kind string) string {
+	// null sender
+	if address == "" {
+		return address
+	}
+	lower := strings.ToLower(address)
+	at := strings.LastIndex(lower, "@")
+	for _, rule := range r.rules {
+		if rule.Kind != kind && rule.Kind != RewriteKindAll {
+			continue
+		}
+		if rule.Match == RewriteMatchRegex {
+			re := r.regexps[rule.Id]
+			if match := re.FindStringSubmatchIndex(address); match != nil {
+				return string(re.ExpandString(nil, rule.Replacement, address, match))
+			}
+			continue
+		}
+		// domain
+		if strings.HasPrefix(rule.Pattern, "@") {
+			if at == -1 || lower[at:] != rule.Pattern {
+				continue
+			}
+			if strings.HasPrefix(rule.Replacement, "@") {
+				return address[:at] + rule.Replacement
+			}
+			return rule.Replacement
+		}
+		if lower == rule.Pattern {
+			return rule.Replacement
+		}
+	}
+	return address
+}
+
+// rewriteHeaders rewrites addresses of From, Sender, Reply-To, To and Cc
+// headers of raw. Headers are only modified if an address changed.
+func (r *rewriter) rewriteHeaders(raw *[]byte) (rewritten bool) {
+	if len(r.rules) == 0 {
+		return false
+	}
+	for _, header := range rewriteHeaders {
+		value := message.RawGetHeader(raw, header.name)
+		if value == "" {
+			continue
+		}
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			continue
+		}
+		changed := false
+		addresses := make([]string, len(list))
+		for i, addr := range list {
+			if newAddress := r.rewrite(addr.Address, header.kind); newAddress != addr.Address {
+				addr.Address = newAddress
+				changed = true
+			}
+			addresses[i] = addr.String()
+		}
+		if changed {
+			message.RawSetHeader(raw, header.name, strings.Join(addresses, ", "))
+			rewritten = true
+		}
+	}
+	return
+}
+
+// rewriteEnvelope rewrites envelope address (MAIL FROM or RCPT TO) of
+// session. It returns false if an error has been replied.
+func (s *SMTPServerSession) rewriteEnvelope(address *string, kind string) bool {
+	authUser := ""
+	if s.user != nil {
+		authUser = s.user.Login
+	}
+	r, err := newRewriter(RewriteScopeEnvelope, authUser)
+	if err != nil {
+		s.LogError("unable to get rewrite rules. " + err.Error())
+		s.Out("451 4.3.0 oops, problem with address rewriting")
+		s.SMTPResponseCode = 451
+		return false
+	}
+	if rewritten := r.rewrite(*address, kind); rewritten != *address {
+		s.Log("rewrite " + kind + " " + *address + " -> " + rewritten)
+		*address = rewritten
+	}
+	return true
+}
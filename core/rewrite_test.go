@@ -0,0 +1,94 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rewriteTestRules are rules as returned by RewriteRuleGetAll
+var rewriteTestRules = []RewriteRule{
+	{Id: 1, Scope: RewriteScopeAll, Kind: RewriteKindSender, Match: RewriteMatchExact, Pattern: "old@example.com", Replacement: "new@example.com"},
+	{Id: 2, Scope: RewriteScopeAll, Kind: RewriteKindAll, Match: RewriteMatchExact, Pattern: "@host.example.com", Replacement: "@example.com"},
+	{Id: 3, Scope: RewriteScopeEnvelope, Kind: RewriteKindRecipient, Match: RewriteMatchExact, Pattern: "@legacy.example.com", Replacement: "postmaster@example.com"},
+	{Id: 4, Scope: RewriteScopeHeader, Kind: RewriteKindSender, Match: RewriteMatchRegex, Pattern: `(?P<user>[a-z]+)\.(?P<dept>[a-z]+)@corp\.example\.com`, Replacement: "${dept}-$user@example.com"},
+	{Id: 5, Scope: RewriteScopeAll, Kind: RewriteKindSender, Match: RewriteMatchExact, Pattern: "@example.org", Replacement: "noreply@example.org", AuthUser: "john@example.com"},
+	{Id: 6, Scope: RewriteScopeAll, Kind: RewriteKindSender, Match: RewriteMatchRegex, Pattern: `.*@example\.org`, Replacement: "catchall@example.org"},
+}
+
+func Test_rewriterRewrite(t *testing.T) {
+	tests := []struct {
+		scope, authUser string
+		address, kind   string
+		rewritten       string
+	}{
+		// exact address, case insensitive
+		{RewriteScopeEnvelope, "", "Old@Example.com", RewriteKindSender, "new@example.com"},
+		{RewriteScopeEnvelope, "", "old@example.com", RewriteKindRecipient, "old@example.com"},
+		// domain rule keeps local part
+		{RewriteScopeHeader, "", "John@host.example.com", RewriteKindRecipient, "John@example.com"},
+		{RewriteScopeHeader, "", "john@sub.host.example.com", RewriteKindRecipient, "john@sub.host.example.com"},
+		// domain to address
+		{RewriteScopeEnvelope, "", "john@legacy.example.com", RewriteKindRecipient, "postmaster@example.com"},
+		{RewriteScopeHeader, "", "john@legacy.example.com", RewriteKindRecipient, "john@legacy.example.com"},
+		// regex with named groups, only for headers
+		{RewriteScopeHeader, "", "john.sales@corp.example.com", RewriteKindSender, "sales-john@example.com"},
+		{RewriteScopeEnvelope, "", "john.sales@corp.example.com", RewriteKindSender, "john.sales@corp.example.com"},
+		// regex matches the whole address
+		{RewriteScopeHeader, "", "john.sales@corp.example.com.evil", RewriteKindSender, "john.sales@corp.example.com.evil"},
+		// authenticated user rules, first matching rule wins
+		{RewriteScopeEnvelope, "John@Example.com", "jane@example.org", RewriteKindSender, "noreply@example.org"},
+		{RewriteScopeEnvelope, "", "jane@example.org", RewriteKindSender, "catchall@example.org"},
+		// null sender and no match
+		{RewriteScopeEnvelope, "", "", RewriteKindSender, ""},
+		{RewriteScopeEnvelope, "", "john@example.net", RewriteKindSender, "john@example.net"},
+	}
+	for _, test := range tests {
+		r := newRewriterFromRules(rewriteTestRules, test.scope, test.authUser)
+		assert.Equal(t, test.rewritten, r.rewrite(test.address, test.kind), test.scope+" "+test.authUser+" "+test.address)
+	}
+}
+
+func Test_rewriterRewriteHeaders(t *testing.T) {
+	r := newRewriterFromRules(rewriteTestRules, RewriteScopeHeader, "")
+	raw := []byte("From: John <old@example.com>\r\nTo: a@host.example.com, b@example.net\r\nCc: c@example.net\r\nSubject: test\r\n\r\nbody\r\n")
+	assert.True(t, r.rewriteHeaders(&raw))
+	assert.Equal(t, "From: \"John\" <new@example.com>\r\nTo: <a@example.com>, <b@example.net>\r\nCc: c@example.net\r\nSubject: test\r\n\r\nbody\r\n", string(raw))
+
+	raw = []byte("From: john@example.net\r\n\r\nbody\r\n")
+	assert.False(t, r.rewriteHeaders(&raw))
+	assert.False(t, newRewriterFromRules(nil, RewriteScopeHeader, "").rewriteHeaders(&raw))
+}
+
+func Test_rewriteRulesCache(t *testing.T) {
+	defer setTestDB(t, &RewriteRule{})()
+	rewriteRules.flush()
+	defer rewriteRules.flush()
+
+	assert.NoError(t, RewriteRuleAdd("all", "sender", "regex", `(.*)@old\.example\.com`, "$1@example.com", "", 0))
+	r, err := newRewriter(RewriteScopeEnvelope, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", r.rewrite("john@old.example.com", RewriteKindSender))
+	all := rewriteRules.all
+	r, err = newRewriter(RewriteScopeHeader, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", r.rewrite("john@old.example.com", RewriteKindSender))
+	// regexps are compiled once
+	assert.True(t, all == rewriteRules.all)
+
+	// rules changed in DB are seen once cache has expired
+	assert.NoError(t, DB.Model(&RewriteRule{}).Update("replacement", "$1@example.net").Error)
+	r, _ = newRewriter(RewriteScopeEnvelope, "")
+	assert.Equal(t, "john@example.com", r.rewrite("john@old.example.com", RewriteKindSender))
+	rewriteRules.loadedAt = rewriteRules.loadedAt.Add(-time.Minute)
+	r, _ = newRewriter(RewriteScopeEnvelope, "")
+	assert.Equal(t, "john@example.net", r.rewrite("john@old.example.com", RewriteKindSender))
+
+	// at once if changed by RewriteRule* functions
+	rules, err := RewriteRuleGetAll()
+	assert.NoError(t, err)
+	assert.NoError(t, RewriteRuleDel(rules[0].Id))
+	r, _ = newRewriter(RewriteScopeEnvelope, "")
+	assert.Equal(t, "john@old.example.com", r.rewrite("john@old.example.com", RewriteKindSender))
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/toorop/tmail/message"
+	"github.com/toorop/tmail/sieve"
+)
+
+// SieveScript represents a Sieve script of an user
+// only one script per user can be active
+type SieveScript struct {
+	Id        int64
+	Login     string
+	Name      string
+	Script    string `sql:"type:text;"`
+	Active    bool   `sql:"default:false"`
+	UpdatedAt time.Time
+}
+
+// SieveRedirect records that a queued message has been redirected by the
+// script of an user (redirects are not done again if delivery is retried)
+type SieveRedirect struct {
+	Id        int64
+	Uuid      string `sql:"not null"`
+	Login     string `sql:"not null"`
+	CreatedAt time.Time
+}
+
+// SieveCheck checks script syntax
+func SieveCheck(script string) error {
+	_, err := sieve.Parse(script)
+	return err
+}
+
+// SieveScriptPut adds or replaces script name of user login
+func SieveScriptPut(login, name, script string, activate bool) error {
+	login = strings.ToLower(login)
+	if name == "" || len(name) > 128 {
+		return errors.New("script name must have between 1 and 128 chars")
+	}
+	if err := SieveCheck(script); err != nil {
+		return errors.New("bad script - " + err.Error())
+	}
+	exists, err := UserExists(login)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("no such user " + login)
+	}
+	s := SieveScript{}
+	err = DB.Where("login = ? and name = ?", login, name).Find(&s).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	s.Login, s.Name, s.Script = login, name, script
+	if err = DB.Save(&s).Error; err != nil {
+		return err
+	}
+	if activate {
+		return SieveScriptActivate(login, name)
+	}
+	return nil
+}
+
+// SieveScriptGet returns script name of user login
+func SieveScriptGet(login, name string) (script *SieveScript, err error) {
+	script = &SieveScript{}
+	err = DB.Where("login = ? and name = ?", strings.ToLower(login), name).Find(script).Error
+	return
+}
+
+// SieveScriptList returns scripts of user login
+func SieveScriptList(login string) (scripts []SieveScript, err error) {
+	scripts = []SieveScript{}
+	err = DB.Where("login = ?", strings.ToLower(login)).Order("name").Find(&scripts).Error
+	return
+}
+
+// SieveScriptDel deletes script name of user login
+func SieveScriptDel(login, name string) error {
+	script, err := SieveScriptGet(login, name)
+	if err != nil {
+		return err
+	}
+	return DB.Delete(script).Error
+}
+
+// SieveScriptActivate activates script name of user login (other scripts
+// are deactivated), if name is empty all scripts are deactivated
+func SieveScriptActivate(login, name string) error {
+	login = strings.ToLower(login)
+	if name != "" {
+		if _, err := SieveScriptGet(login, name); err != nil {
+			return err
+		}
+	}
+	tx := DB.Begin()
+	if err := tx.Model(SieveScript{}).Where("login = ?", login).Update("active", false).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if name != "" {
+		if err := tx.Model(SieveScript{}).Where("login = ? and name = ?", login, name).Update("active", true).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// sieveGetActive returns active script of user login (nil if none)
+func sieveGetActive(login string) (*SieveScript, error) {
+	script := &SieveScript{}
+	err := DB.Where("login = ? and active = ?", strings.ToLower(login), true).Find(script).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return script, err
+}
+
+// sieveRedirected checks if message uuid has already been redirected by
+// script of user login
+func sieveRedirected(uuid, login string) (bool, error) {
+	err := DB.Where("uuid = ? and login = ?", uuid, login).Find(&SieveRedirect{}).Error
+	if err == nil {
+		return true, nil
+	}
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+// sieveFilter runs active script of user on the message and performs
+// redirect, reject and vacation actions
+// It returns copies to store (implicit keep if there is no script), true
+// if the script has a vacation action and true if delivery is over
+// (rejected, discarded or failed)
+func (d *Delivery) sieveFilter(user *User) ([]sieve.Store, bool, bool) {
+	keep := []sieve.Store{{}}
+	script, err := sieveGetActive(user.Login)
+	if err != nil {
+		d.dieTemp(fmt.Sprintf("delivery-local %s: unable to get sieve script of %s. %s", d.ID, user.Login, err), true)
+		return nil, false, true
+	}
+	if script == nil {
+		return keep, false, false
+	}
+	// on error message is kept (RFC 5228 2.10.6)
+	parsed, err := sieve.Parse(script.Script)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("delivery-local %s: bad sieve script %s of %s, message kept. %s", d.ID, script.Name, user.Login, err))
+		return keep, false, false
+	}
+	msg := sieve.Message{MailFrom: d.QMsg.MailFrom, RcptTo: d.QMsg.RcptTo, Raw: *d.RawData}
+	if i := strings.LastIndex(d.QMsg.RcptTo, "@"); i != -1 {
+		if msg.Delimiter, err = rcpthostRcptDelimiter(d.QMsg.RcptTo[i+1:]); err != nil {
+			Logger.Error(fmt.Sprintf("delivery-local %s: unable to get recipient delimiter for %s. %s", d.ID, d.QMsg.RcptTo, err))
+		}
+	}
+	result, err := parsed.Execute(msg)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("delivery-local %s: sieve script %s of %s failed, message kept. %s", d.ID, script.Name, user.Login, err))
+		return keep, false, false
+	}
+
+	// reject
+	if result.Rejected {
+		d.diePerm(fmt.Sprintf("delivery-local %s: message rejected by %s: %s", d.ID, user.Login, result.Reject), true)
+		return nil, false, true
+	}
+
+	// redirect
+	if len(result.Redirects) != 0 {
+		if sieveIsLoop(*d.RawData, user.Login) {
+			Logger.Info(fmt.Sprintf("delivery-local %s: sieve redirect loop detected for %s, message kept", d.ID, user.Login))
+			return keep, false, false
+		}
+		// already done by a previous delivery attempt
+		redirected, err := sieveRedirected(d.QMsg.Uuid, user.Login)
+		if err != nil {
+			d.dieTemp(fmt.Sprintf("delivery-local %s: unable to check sieve redirects of %s. %s", d.ID, user.Login, err), true)
+			return nil, false, true
+		}
+		if redirected {
+			Logger.Info(fmt.Sprintf("delivery-local %s: already redirected by sieve script of %s", d.ID, user.Login))
+		} else {
+			redirects := result.Redirects
+			if max := Cfg.GetDeliverdSieveMaxRedirects(); len(redirects) > max {
+				Logger.Info(fmt.Sprintf("delivery-local %s: too many sieve redirects for %s, only %d first are done", d.ID, user.Login, max))
+				redirects = redirects[:max]
+			}
+			// Delivered-To is used to detect loops, envelope sender is kept
+			data := append([]byte("Delivered-To: "+user.Login+"\r\n"), *d.RawData...)
+			envelope := message.Envelope{MailFrom: d.QMsg.MailFrom, RcptTo: redirects}
+			// journaled as sent by user
+			uuid, err := queueAddMessage(&data, envelope, "", user.Login, true)
+			if err != nil {
+				d.dieTemp(fmt.Sprintf("delivery-local %s: unable to queue sieve redirect. %s", d.ID, err), true)
+				return nil, false, true
+			}
+			Logger.Info(fmt.Sprintf("delivery-local %s: redirected by sieve to %s, queued with ID %s", d.ID, strings.Join(redirects, " "), uuid))
+			if err = DB.Create(&SieveRedirect{Uuid: d.QMsg.Uuid, Login: user.Login, CreatedAt: time.Now()}).Error; err != nil {
+				Logger.Error(fmt.Sprintf("delivery-local %s: unable to record sieve redirect of %s. %s", d.ID, user.Login, err))
+			}
+		}
+	}
+
+	// vacation
+	if result.Vacation != nil {
+		d.sieveVacation(user, result.Vacation)
+	}
+
+	if len(result.Stores) == 0 {
+		Logger.Info(fmt.Sprintf("delivery-local %s: message not stored by sieve script of %s", d.ID, user.Login))
+		d.dieOk()
+		return nil, result.Vacation != nil, true
+	}
+	return result.Stores, result.Vacation != nil, false
+}
+
+// sieveIsLoop checks if message has already been delivered to login
+func sieveIsLoop(raw []byte, login string) bool {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return false
+	}
+	for _, deliveredTo := range m.Header["Delivered-To"] {
+		if strings.EqualFold(strings.TrimSpace(deliveredTo), login) {
+			return true
+		}
+	}
+	return false
+}
+
+// sieveVacation sends vacation auto-reply (errors are only logged)
+func (d *Delivery) sieveVacation(user *User, v *sieve.Vacation) {
+	handle := v.Handle
+	if handle == "" {
+		handle = fmt.Sprintf("%x", sha1.Sum([]byte(v.Subject+"\x00"+v.From+"\x00"+v.Reason+"\x00"+fmt.Sprint(v.Mime))))
+	}
+	reply := autoReply{
+		login:     user.Login,
+		from:      v.From,
+		owned:     []string{d.QMsg.RcptTo},
+		subject:   v.Subject,
+		body:      v.Reason,
+		mime:      v.Mime,
+		addresses: append(v.Addresses, d.QMsg.RcptTo),
+		handle:    "sieve:" + handle,
+		days:      v.Days,
+	}
+	sent, err := reply.send(d.QMsg.MailFrom, *d.RawData)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("delivery-local %s: unable to send vacation reply of %s. %s", d.ID, user.Login, err))
+		return
+	}
+	if sent {
+		Logger.Info(fmt.Sprintf("delivery-local %s: vacation reply of %s sent to %s", d.ID, user.Login, d.QMsg.MailFrom))
+	}
+}
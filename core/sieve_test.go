@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sieveRedirected(t *testing.T) {
+	defer setTestDB(t, &SieveRedirect{})()
+
+	redirected, err := sieveRedirected("uuid1", "john@example.com")
+	assert.NoError(t, err)
+	assert.False(t, redirected)
+
+	assert.NoError(t, DB.Create(&SieveRedirect{Uuid: "uuid1", Login: "john@example.com", CreatedAt: time.Now()}).Error)
+	redirected, err = sieveRedirected("uuid1", "john@example.com")
+	assert.NoError(t, err)
+	assert.True(t, redirected)
+
+	// per message and per user
+	redirected, err = sieveRedirected("uuid2", "john@example.com")
+	assert.NoError(t, err)
+	assert.False(t, redirected)
+	redirected, err = sieveRedirected("uuid1", "jane@example.com")
+	assert.NoError(t, err)
+	assert.False(t, redirected)
+}
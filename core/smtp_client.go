@@ -12,6 +12,7 @@ import (
 	"math/rand"
 	"net"
 	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -89,8 +90,8 @@ func newSMTPClient(d *Delivery, routes []Route, timeoutBasePerCmd int) (client *
 
 		// remoteAdresses
 		// Hostname or IP
-		// IP ?
-		ip := net.ParseIP(route.RemoteHost)
+		// IP ? (IPv6 may be given as [2001:db8::1] or [IPv6:2001:db8::1])
+		ip := parseAddressLiteral(route.RemoteHost)
 		if ip != nil { // ip
 			remoteAddresses = append(remoteAddresses, net.TCPAddr{
 				IP:   ip,
@@ -111,6 +112,10 @@ func newSMTPClient(d *Delivery, routes []Route, timeoutBasePerCmd int) (client *
 			}
 		}
 
+		// IPv4/IPv6 preference
+		sortIPsByPreference(localIPs, Cfg.GetDeliverdIpPreference())
+		sortTCPAddrsByPreference(remoteAddresses, Cfg.GetDeliverdIpPreference())
+
 		// try routes & returns first OK
 		for _, localIP := range localIPs {
 			for _, remoteAddr := range remoteAddresses {
@@ -125,7 +130,7 @@ func newSMTPClient(d *Delivery, routes []Route, timeoutBasePerCmd int) (client *
 					continue
 				}
 
-				localAddr, err := net.ResolveTCPAddr("tcp", localIP.String()+":0")
+				localAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(localIP.String(), "0"))
 				if err != nil {
 					return nil, errors.New("bad local IP: " + localIP.String() + ". " + err.Error())
 				}
@@ -186,7 +191,7 @@ func newSMTPClient(d *Delivery, routes []Route, timeoutBasePerCmd int) (client *
 						Logger.Error("Bolt - ", errBolt)
 					}
 				}
-				Logger.Info(fmt.Sprintf("deliverd-remote %s - unable to get a SMTP client for %s->%s:%d - %s ", d.ID, localIP, remoteAddr.IP.String(), remoteAddr.Port, err.Error()))
+				Logger.Info(fmt.Sprintf("deliverd-remote %s - unable to get a SMTP client for %s->%s - %s ", d.ID, localIP, remoteAddr.String(), err.Error()))
 			}
 		}
 	}
@@ -194,6 +199,36 @@ func newSMTPClient(d *Delivery, routes []Route, timeoutBasePerCmd int) (client *
 	return nil, errors.New("unable to get a client, all routes have been tested")
 }
 
+// ipFamilyRank returns 0 if ip belongs to the preferred family, 1 otherwise
+func ipFamilyRank(ip net.IP, preference string) int {
+	switch preference {
+	case "ipv4":
+		if ip.To4() == nil {
+			return 1
+		}
+	case "ipv6":
+		if ip.To4() != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortIPsByPreference puts IPs of the preferred family first
+// (order is kept inside a family)
+func sortIPsByPreference(ips []net.IP, preference string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ipFamilyRank(ips[i], preference) < ipFamilyRank(ips[j], preference)
+	})
+}
+
+// sortTCPAddrsByPreference puts addresses of the preferred family first
+func sortTCPAddrsByPreference(addrs []net.TCPAddr, preference string) {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return ipFamilyRank(addrs[i].IP, preference) < ipFamilyRank(addrs[j].IP, preference)
+	})
+}
+
 // CloseConn close connection
 func (s *smtpClient) close() error {
 	return s.text.Close()
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sortTCPAddrsByPreference(t *testing.T) {
+	addrs := func() []net.TCPAddr {
+		return []net.TCPAddr{
+			{IP: net.ParseIP("2001:db8::1"), Port: 25},
+			{IP: net.ParseIP("192.0.2.1"), Port: 25},
+			{IP: net.ParseIP("2001:db8::2"), Port: 25},
+			{IP: net.ParseIP("192.0.2.2"), Port: 25},
+		}
+	}
+	order := func(addrs []net.TCPAddr) (ips []string) {
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP.String())
+		}
+		return
+	}
+
+	sorted := addrs()
+	sortTCPAddrsByPreference(sorted, "ipv4")
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"}, order(sorted))
+
+	sorted = addrs()
+	sortTCPAddrsByPreference(sorted, "ipv6")
+	assert.Equal(t, []string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"}, order(sorted))
+
+	// no preference: order is kept
+	sorted = addrs()
+	sortTCPAddrsByPreference(sorted, "")
+	assert.Equal(t, order(addrs()), order(sorted))
+}
+
+func Test_sortIPsByPreference(t *testing.T) {
+	ips := []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}
+	sortIPsByPreference(ips, "ipv4")
+	assert.Equal(t, []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}, ips)
+	sortIPsByPreference(ips, "ipv6")
+	assert.Equal(t, []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}, ips)
+}
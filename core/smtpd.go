@@ -4,7 +4,6 @@ import (
 	"crypto/tls"
 	"log"
 	"net"
-	"path"
 )
 
 // Smtpd SMTP Server
@@ -24,27 +23,16 @@ func (s *Smtpd) ListenAndServe() {
 	var tlsConfig *tls.Config
 	// SSL ?
 	if s.dsn.ssl {
-		cert, err := tls.LoadX509KeyPair(path.Join(GetBasePath(), "ssl/server.crt"), path.Join(GetBasePath(), "ssl/server.key"))
+		// certificates are selected via SNI and reloaded when changed
+		tlsConfig, err = smtpdCerts.tlsConfig()
 		if err != nil {
 			log.Fatalln("unable to load SSL keys for smtpd.", "dsn:", s.dsn.tcpAddr, "ssl", s.dsn.ssl, "err:", err)
 		}
-		// TODO: http://fastah.blackbuck.mobi/blog/securing-https-in-go/
-		tlsConfig = &tls.Config{
-			Certificates:       []tls.Certificate{cert},
-			InsecureSkipVerify: true,
-		}
-		listener, err = tls.Listen(s.dsn.tcpAddr.Network(), s.dsn.tcpAddr.String(), tlsConfig)
-		if err != nil {
-			log.Fatalln("unable to create TLS listener.", err)
-		}
-	} else {
-		listener, err = net.Listen(s.dsn.tcpAddr.Network(), s.dsn.tcpAddr.String())
-		if err != nil {
-			log.Fatalln("unable to create listener")
-		}
 	}
+	// TLS handshake is done after PROXY header (if any) has been read
+	listener, err = net.Listen(s.dsn.tcpAddr.Network(), s.dsn.tcpAddr.String())
 	if err != nil {
-		log.Fatalln(err)
+		log.Fatalln("unable to create listener", err)
 	} else {
 		defer listener.Close()
 		for {
@@ -53,12 +41,26 @@ func (s *Smtpd) ListenAndServe() {
 				log.Println("Client error: ", error)
 			} else {
 				go func(conn net.Conn) {
+					// PROXY protocol
+					if s.dsn.proxy {
+						pconn, err := newProxyConn(conn)
+						if err != nil {
+							Logger.Info("smtpd - " + conn.RemoteAddr().String() + " - PROXY protocol: " + err.Error())
+							conn.Close()
+							return
+						}
+						conn = pconn
+					}
+					if s.dsn.ssl {
+						conn = tls.Server(conn, tlsConfig)
+					}
 					ChSmtpSessionsCount <- 1
 					defer func() { ChSmtpSessionsCount <- -1 }()
 					sss, err := NewSMTPServerSession(conn, s.dsn.ssl)
 					if err != nil {
 						log.Println("unable to get new SmtpServerSession.", err)
 					} else {
+						sss.dsn = s.dsn
 						sss.handle()
 					}
 				}(conn)
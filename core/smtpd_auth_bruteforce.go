@@ -0,0 +1,228 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AuthBanScopeIp ban of a client IP
+	AuthBanScopeIp = "ip"
+	// AuthBanScopeUser lockout of a login
+	AuthBanScopeUser = "user"
+)
+
+// AuthBan represents a temporary ban of an IP or a lockout of a login
+// after too many failed SMTP AUTH
+type AuthBan struct {
+	Id        int64
+	Scope     string `sql:"not null"`
+	Target    string `sql:"not null"`
+	Failures  int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// AuthBanGetAll returns all active bans
+func AuthBanGetAll() (bans []AuthBan, err error) {
+	bans = []AuthBan{}
+	err = DB.Where("expires_at > ?", time.Now()).Order("expires_at").Find(&bans).Error
+	return
+}
+
+// AuthBanDel lifts a ban
+func AuthBanDel(id int64) error {
+	ban := AuthBan{}
+	if err := DB.Where("id = ?", id).Find(&ban).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errors.New("ban " + strconv.FormatInt(id, 10) + " doesn't exists")
+		}
+		return err
+	}
+	if err := DB.Delete(&ban).Error; err != nil {
+		return err
+	}
+	// give a new chance
+	authFailures.reset(ban.Scope + ":" + ban.Target)
+	return nil
+}
+
+// authBanIsActive returns true if target is banned
+func authBanIsActive(scope, target string) (bool, error) {
+	err := DB.Where("scope = ? and target = ? and expires_at > ?", scope, strings.ToLower(target), time.Now()).Find(&AuthBan{}).Error
+	if err == nil {
+		return true, nil
+	}
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+// authBanAdd bans target for duration (replaces existing ban)
+func authBanAdd(scope, target string, failures int, duration time.Duration) error {
+	now := time.Now()
+	target = strings.ToLower(target)
+	tx := DB.Begin()
+	if err := tx.Where("scope = ? and target = ?", scope, target).Delete(&AuthBan{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(&AuthBan{
+		Scope:     scope,
+		Target:    target,
+		Failures:  failures,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// authBanPurge removes expired bans
+func authBanPurge() error {
+	return DB.Where("expires_at <= ?", time.Now()).Delete(&AuthBan{}).Error
+}
+
+// authFailureTracker counts recent failures per key (scope:target)
+type authFailureTracker struct {
+	sync.Mutex
+	failures map[string][]time.Time
+	lastGc   time.Time
+}
+
+var authFailures = &authFailureTracker{failures: map[string][]time.Time{}}
+
+// add records a failure for key and returns the number of failures in window
+func (t *authFailureTracker) add(key string, window time.Duration) int {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	t.failures[key] = append(t.prune(t.failures[key], now, window), now)
+	// gc
+	if now.Sub(t.lastGc) > window {
+		for k, v := range t.failures {
+			if v = t.prune(v, now, window); len(v) == 0 {
+				delete(t.failures, k)
+			} else {
+				t.failures[k] = v
+			}
+		}
+		t.lastGc = now
+		go func() {
+			if err := authBanPurge(); err != nil {
+				Logger.Error("smtpd - unable to purge expired auth bans - " + err.Error())
+			}
+		}()
+	}
+	return len(t.failures[key])
+}
+
+// prune removes failures older than window
+func (t *authFailureTracker) prune(failures []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) > window {
+		i++
+	}
+	return failures[i:]
+}
+
+// reset forgets failures for key
+func (t *authFailureTracker) reset(key string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.failures, key)
+}
+
+// authEvent emits a structured log event (for fail2ban like tools)
+func authEvent(event string, fields logrus.Fields) {
+	fields["event"] = event
+	Logger.WithFields(fields).Warn("smtpd auth event " + event)
+}
+
+// authIsBanned checks if remote IP is banned
+func (s *SMTPServerSession) authIsBanned() bool {
+	if !Cfg.GetSmtpdAuthBruteforceEnabled() {
+		return false
+	}
+	banned, err := authBanIsActive(AuthBanScopeIp, ipFromAddr(s.Conn.RemoteAddr()).String())
+	if err != nil {
+		s.LogError("unable to check auth ban - " + err.Error())
+		return false
+	}
+	return banned
+}
+
+// authIsLocked checks if login is locked out
+func (s *SMTPServerSession) authIsLocked(login string) (bool, error) {
+	if !Cfg.GetSmtpdAuthBruteforceEnabled() {
+		return false, nil
+	}
+	return authBanIsActive(AuthBanScopeUser, login)
+}
+
+// authFailure records a failed auth, bans IP and locks login if needed
+// and returns the delay (in seconds) to apply before replying
+func (s *SMTPServerSession) authFailure(mechanism, login string) (delay int) {
+	if !Cfg.GetSmtpdAuthBruteforceEnabled() {
+		return 0
+	}
+	ip := ipFromAddr(s.Conn.RemoteAddr()).String()
+	window := Cfg.GetSmtpdAuthBruteforceWindow()
+	ipFailures := authFailures.add(AuthBanScopeIp+":"+ip, window)
+	fields := logrus.Fields{
+		"ip":        ip,
+		"login":     login,
+		"mechanism": mechanism,
+		"session":   s.uuid,
+		"failures":  ipFailures,
+	}
+	authEvent("auth_failure", fields)
+
+	// ban IP
+	if max := Cfg.GetSmtpdAuthBruteforceIpFailures(); max != 0 && ipFailures >= max {
+		if err := authBanAdd(AuthBanScopeIp, ip, ipFailures, Cfg.GetSmtpdAuthBruteforceIpBan()); err != nil {
+			s.LogError("unable to ban IP " + ip + " - " + err.Error())
+		} else {
+			authFailures.reset(AuthBanScopeIp + ":" + ip)
+			authEvent("auth_ban", logrus.Fields{"ip": ip, "failures": ipFailures, "duration": Cfg.GetSmtpdAuthBruteforceIpBan().String()})
+		}
+	}
+
+	// lock user
+	if login != "" {
+		login = strings.ToLower(login)
+		loginFailures := authFailures.add(AuthBanScopeUser+":"+login, window)
+		if max := Cfg.GetSmtpdAuthBruteforceUserFailures(); max != 0 && loginFailures >= max {
+			if err := authBanAdd(AuthBanScopeUser, login, loginFailures, Cfg.GetSmtpdAuthBruteforceUserLock()); err != nil {
+				s.LogError("unable to lock user " + login + " - " + err.Error())
+			} else {
+				authFailures.reset(AuthBanScopeUser + ":" + login)
+				authEvent("auth_lockout", logrus.Fields{"login": login, "failures": loginFailures, "duration": Cfg.GetSmtpdAuthBruteforceUserLock().String()})
+			}
+		}
+	}
+
+	// progressive delay
+	delay = ipFailures
+	if delay > Cfg.GetSmtpdAuthBruteforceMaxDelay() {
+		delay = Cfg.GetSmtpdAuthBruteforceMaxDelay()
+	}
+	return
+}
+
+// authSuccess resets failures of login
+func (s *SMTPServerSession) authSuccess(login string) {
+	if !Cfg.GetSmtpdAuthBruteforceEnabled() {
+		return
+	}
+	authFailures.reset(AuthBanScopeUser + ":" + strings.ToLower(login))
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+// setTestDB replaces DB with an in memory sqlite database holding tables
+// for models, returns a func restoring it
+func setTestDB(t *testing.T, models ...interface{}) func() {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection: each one would open its own database
+	db.DB().SetMaxOpenConns(1)
+	if err = db.AutoMigrate(models...).Error; err != nil {
+		t.Fatal(err)
+	}
+	saved := DB
+	DB = db
+	return func() {
+		DB = saved
+		db.Close()
+	}
+}
+
+// setTestAuthFailures replaces the failure tracker, returns a func restoring it
+func setTestAuthFailures() func() {
+	saved := authFailures
+	// no gc (which purges DB in background) during tests
+	authFailures = &authFailureTracker{failures: map[string][]time.Time{}, lastGc: time.Now()}
+	return func() { authFailures = saved }
+}
+
+func Test_authFailureTracker(t *testing.T) {
+	tracker := &authFailureTracker{failures: map[string][]time.Time{}, lastGc: time.Now()}
+	window := time.Minute
+	assert.Equal(t, 1, tracker.add("ip:192.0.2.1", window))
+	assert.Equal(t, 2, tracker.add("ip:192.0.2.1", window))
+	assert.Equal(t, 1, tracker.add("ip:192.0.2.2", window))
+
+	// failures older than window are forgotten
+	tracker.failures["ip:192.0.2.1"][0] = time.Now().Add(-2 * window)
+	assert.Equal(t, 2, tracker.add("ip:192.0.2.1", window))
+
+	tracker.reset("ip:192.0.2.1")
+	assert.Equal(t, 1, tracker.add("ip:192.0.2.1", window))
+	assert.Equal(t, 2, tracker.add("ip:192.0.2.2", window))
+
+	now := time.Now()
+	failures := []time.Time{now.Add(-3 * window), now.Add(-2 * window), now}
+	assert.Equal(t, []time.Time{now}, tracker.prune(failures, now, window))
+	assert.Empty(t, tracker.prune(failures[:2], now, window))
+}
+
+func Test_authFailureDelay(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestAuthFailures()()
+	Cfg = &Config{}
+	Cfg.cfg.SmtpdAuthBruteforceWindow = 60
+	Cfg.cfg.SmtpdAuthBruteforceMaxDelay = 3
+
+	// disabled
+	s, _ := newTestSMTPSession(SmtpdRoleSubmission, true)
+	assert.Equal(t, 0, s.authFailure("PLAIN", "john@example.com"))
+
+	// progressive delay, per IP
+	Cfg.cfg.SmtpdAuthBruteforceEnabled = true
+	for _, expected := range []int{1, 2, 3, 3} {
+		assert.Equal(t, expected, s.authFailure("PLAIN", "john@example.com"))
+	}
+	s2, _ := newTestSMTPSession(SmtpdRoleSubmission, true)
+	assert.Equal(t, 3, s2.authFailure("LOGIN", ""))
+
+	// success resets the login, not the IP
+	s.authSuccess("John@example.com")
+	assert.Empty(t, authFailures.failures[AuthBanScopeUser+":john@example.com"])
+	assert.Len(t, authFailures.failures[AuthBanScopeIp+":192.0.2.1"], 5)
+}
+
+func Test_authBan(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	defer setTestAuthFailures()()
+	defer setTestDB(t, &AuthBan{})()
+	Cfg = &Config{}
+	Cfg.cfg.SmtpdAuthBruteforceEnabled = true
+	Cfg.cfg.SmtpdAuthBruteforceWindow = 60
+	Cfg.cfg.SmtpdAuthBruteforceIpFailures = 3
+	Cfg.cfg.SmtpdAuthBruteforceIpBan = 3600
+	Cfg.cfg.SmtpdAuthBruteforceUserFailures = 2
+	Cfg.cfg.SmtpdAuthBruteforceUserLock = 1800
+
+	s, _ := newTestSMTPSession(SmtpdRoleSubmission, true)
+	assert.False(t, s.authIsBanned())
+
+	// user is locked after 2 failures, IP banned after 3
+	s.authFailure("PLAIN", "John@example.com")
+	locked, err := s.authIsLocked("john@example.com")
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	s.authFailure("PLAIN", "john@example.com")
+	locked, err = s.authIsLocked("JOHN@example.com")
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.False(t, s.authIsBanned())
+	s.authFailure("PLAIN", "jane@example.com")
+	assert.True(t, s.authIsBanned())
+	// counters restart after a ban
+	assert.Empty(t, authFailures.failures[AuthBanScopeIp+":192.0.2.1"])
+
+	bans, err := AuthBanGetAll()
+	assert.NoError(t, err)
+	if assert.Len(t, bans, 2) {
+		assert.Equal(t, AuthBanScopeUser, bans[0].Scope)
+		assert.Equal(t, "john@example.com", bans[0].Target)
+		assert.Equal(t, 2, bans[0].Failures)
+		assert.Equal(t, AuthBanScopeIp, bans[1].Scope)
+		assert.Equal(t, "192.0.2.1", bans[1].Target)
+		assert.Equal(t, 3, bans[1].Failures)
+	}
+
+	// lift
+	assert.NoError(t, AuthBanDel(bans[1].Id))
+	assert.False(t, s.authIsBanned())
+	assert.Error(t, AuthBanDel(bans[1].Id))
+
+	// expiry
+	assert.NoError(t, DB.Model(&AuthBan{}).Where("id = ?", bans[0].Id).Update("expires_at", time.Now().Add(-time.Second)).Error)
+	locked, err = s.authIsLocked("john@example.com")
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	bans, err = AuthBanGetAll()
+	assert.NoError(t, err)
+	assert.Empty(t, bans)
+	assert.NoError(t, authBanPurge())
+	count := 0
+	assert.NoError(t, DB.Model(&AuthBan{}).Count(&count).Error)
+	assert.Equal(t, 0, count)
+
+	// a new ban replaces the previous one
+	assert.NoError(t, authBanAdd(AuthBanScopeIp, "192.0.2.2", 5, time.Hour))
+	assert.NoError(t, authBanAdd(AuthBanScopeIp, "192.0.2.2", 7, time.Hour))
+	bans, err = AuthBanGetAll()
+	assert.NoError(t, err)
+	if assert.Len(t, bans, 1) {
+		assert.Equal(t, 7, bans[0].Failures)
+	}
+}
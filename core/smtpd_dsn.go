@@ -7,10 +7,23 @@ import (
 	"strings"
 )
 
-// DSN IP port and secured (none, tls, ssl)
+// smtpd listener roles
+const (
+	// SmtpdRoleMx inbound mail from the internet: no AUTH, no relay
+	SmtpdRoleMx = "mx"
+	// SmtpdRoleSubmission message submission (port 587): AUTH required, only after STARTTLS
+	SmtpdRoleSubmission = "submission"
+	// SmtpdRoleSubmissions message submission over implicit TLS (port 465, RFC 8314)
+	SmtpdRoleSubmissions = "submissions"
+)
+
+// DSN IP port, secured (none, tls, ssl), role and PROXY protocol
+// an empty role keeps the legacy behavior (no policy enforced)
 type dsn struct {
 	tcpAddr net.TCPAddr
 	ssl     bool
+	role    string
+	proxy   bool
 }
 
 // String return string representation of a dsn
@@ -19,9 +32,20 @@ func (d *dsn) String() string {
 	if d.ssl {
 		s = " SSL"
 	}
+	if d.role != "" {
+		s += " " + d.role
+	}
+	if d.proxy {
+		s += " PROXY"
+	}
 	return d.tcpAddr.String() + s
 }
 
+// isSubmission returns true if dsn role is submission or submissions
+func (d *dsn) isSubmission() bool {
+	return d.role == SmtpdRoleSubmission || d.role == SmtpdRoleSubmissions
+}
+
 //getDsnsFromString Get dsn string from config and returns slice of dsn struct
 func GetDsnsFromString(dsnsStr string) (dsns []dsn, err error) {
 	if len(dsnsStr) == 0 {
@@ -32,12 +56,25 @@ func GetDsnsFromString(dsnsStr string) (dsns []dsn, err error) {
 
 	// parse
 	for _, dsnStr := range strings.Split(dsnsStr, ";") {
-		if strings.Count(dsnStr, ":") != 2 {
+		// IPv6 addresses must be enclosed in brackets: [::1]:25:false
+		host, parts := "", dsnStr
+		if strings.HasPrefix(dsnStr, "[") {
+			end := strings.Index(dsnStr, "]")
+			if end == -1 || !strings.HasPrefix(dsnStr[end+1:], ":") {
+				return dsns, errors.New("bad smtpd.dsn " + dsnStr + " found in config" + dsnsStr)
+			}
+			host = dsnStr[1:end]
+			parts = dsnStr[end+1:]
+		}
+		if strings.Count(parts, ":") < 2 || strings.Count(parts, ":") > 4 {
 			return dsns, errors.New("bad smtpd.dsn " + dsnStr + " found in config" + dsnsStr)
 		}
-		t := strings.Split(dsnStr, ":")
+		t := strings.Split(parts, ":")
+		if host == "" {
+			host = t[0]
+		}
 		// ip & port valid ?
-		tcpAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(t[0], t[1]))
+		tcpAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, t[1]))
 		if err != nil {
 			return dsns, errors.New("bad IP:Port found in dsn" + dsnStr + "from config dsn" + dsnsStr)
 		}
@@ -45,7 +82,29 @@ func GetDsnsFromString(dsnsStr string) (dsns []dsn, err error) {
 		if err != nil {
 			return dsns, ErrBadDsn(err)
 		}
-		dsns = append(dsns, dsn{*tcpAddr, ssl})
+		// role
+		role := ""
+		if len(t) > 3 {
+			role = t[3]
+			switch role {
+			case "", SmtpdRoleMx, SmtpdRoleSubmission:
+			case SmtpdRoleSubmissions:
+				if !ssl {
+					return dsns, ErrBadDsn(errors.New("role submissions needs SSL (implicit TLS) in dsn " + dsnStr))
+				}
+			default:
+				return dsns, ErrBadDsn(errors.New("unknown role " + role + " in dsn " + dsnStr))
+			}
+		}
+		// PROXY protocol
+		proxy := false
+		if len(t) == 5 {
+			if t[4] != "proxy" {
+				return dsns, ErrBadDsn(errors.New("unknown option " + t[4] + " in dsn " + dsnStr))
+			}
+			proxy = true
+		}
+		dsns = append(dsns, dsn{*tcpAddr, ssl, role, proxy})
 	}
 	return
 }
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetDsnsFromString(t *testing.T) {
+	dsns, err := GetDsnsFromString("127.0.0.1:25:false;[::1]:2525:false:mx;[2001:DB8::1]:465:true:submissions:proxy")
+	assert.NoError(t, err)
+	if assert.Len(t, dsns, 3) {
+		assert.Equal(t, "127.0.0.1:25", dsns[0].String())
+		assert.Equal(t, "[::1]:2525 mx", dsns[1].String())
+		assert.Equal(t, "[2001:db8::1]:465 SSL submissions PROXY", dsns[2].String())
+		assert.True(t, dsns[2].isSubmission())
+	}
+
+	for _, bad := range []string{
+		"",
+		"::1:25:false",
+		"[::1:25:false",
+		"[::1]25:false",
+		"[::1]:25",
+		"[::1]:smtp25:false",
+		"[::1]:25:false:relay",
+		"[::1]:587:false:submissions",
+		"[::1]:25:false:mx:nope",
+	} {
+		_, err := GetDsnsFromString(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HAProxy PROXY protocol (v1 and v2)
+// http://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+
+// proxyV2Signature is the binary header signature of PROXY protocol v2
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// errProxyHeader is returned when PROXY header is invalid
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyConn is a net.Conn which returns addresses sent by the proxy
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// Read reads from buffered reader (it may hold data following the header)
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns client address
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// LocalAddr returns address the client connected to
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// addrInNetworks checks if addr belongs to one of networks (IPs or CIDRs)
+func addrInNetworks(addr net.Addr, networks []string) bool {
+	ip := ipFromAddr(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		ipNet, err := parseIpOrCidr(network)
+		if err != nil {
+			Logger.Error("smtpd - bad trusted network " + network + " - " + err.Error())
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newProxyConn reads PROXY header from conn and returns a conn with
+// client addresses
+func newProxyConn(conn net.Conn) (*proxyConn, error) {
+	if !addrInNetworks(conn.RemoteAddr(), Cfg.GetSmtpdProxyProtocolTrusted()) {
+		return nil, errors.New("PROXY protocol source " + conn.RemoteAddr().String() + " is not trusted")
+	}
+	pc := &proxyConn{
+		Conn:       conn,
+		reader:     bufio.NewReader(conn),
+		remoteAddr: conn.RemoteAddr(),
+		localAddr:  conn.LocalAddr(),
+	}
+	conn.SetReadDeadline(time.Now().Add(Cfg.GetSmtpdProxyProtocolTimeout()))
+	defer conn.SetReadDeadline(time.Time{})
+
+	signature, err := pc.reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		// v1 header may be shorter than v2 signature
+		if err != io.EOF || len(signature) == 0 {
+			return nil, err
+		}
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		err = pc.readV2Header()
+	} else {
+		err = pc.readV1Header()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// readV1Header parses human readable header
+// PROXY TCP4|TCP6|UNKNOWN SRC_IP DST_IP SRC_PORT DST_PORT\r\n
+func (c *proxyConn) readV1Header() error {
+	line := []byte{}
+	// max header size is 107 bytes
+	for len(line) < 107 {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return errProxyHeader
+	}
+	// proxy doesn't know client (health checks...)
+	if fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return errProxyHeader
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil || (srcIP.To4() != nil) != (fields[1] == "TCP4") {
+		return errProxyHeader
+	}
+	srcPort, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return errProxyHeader
+	}
+	dstPort, err := strconv.ParseUint(fields[5], 10, 16)
+	if err != nil {
+		return errProxyHeader
+	}
+	c.remoteAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.localAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+// readV2Header parses binary header
+func (c *proxyConn) readV2Header() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	// version 2
+	if header[12]>>4 != 2 {
+		return errProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	switch command {
+	// LOCAL: connection established by the proxy itself
+	case 0x00:
+		return nil
+	// PROXY
+	case 0x01:
+	default:
+		return errProxyHeader
+	}
+
+	// TLVs (if any) are ignored
+	switch family {
+	// TCP over IPv4
+	case 0x11:
+		if len(payload) < 12 {
+			return errProxyHeader
+		}
+		c.remoteAddr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		c.localAddr = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	// TCP over IPv6
+	case 0x21:
+		if len(payload) < 36 {
+			return errProxyHeader
+		}
+		c.remoteAddr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		c.localAddr = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	// UNSPEC or unsupported (UDP, unix sockets): keep proxy addresses
+	default:
+	}
+	return nil
+}
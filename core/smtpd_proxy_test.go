@@ -0,0 +1,105 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var proxyTestAddr = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242}
+
+// proxyTestConn returns a proxyConn reading header
+func proxyTestConn(header []byte) *proxyConn {
+	return &proxyConn{
+		reader:     bufio.NewReader(bytes.NewReader(header)),
+		remoteAddr: proxyTestAddr,
+		localAddr:  proxyTestAddr,
+	}
+}
+
+func Test_readV1Header(t *testing.T) {
+	tests := []struct {
+		header     string
+		remoteAddr string
+		localAddr  string
+		err        bool
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\nEHLO", "192.0.2.1:56324", "198.51.100.1:25", false},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 56324 587\r\n", "[2001:db8::1]:56324", "[2001:db8::2]:587", false},
+		{"PROXY UNKNOWN\r\n", "10.0.0.1:4242", "10.0.0.1:4242", false},
+		{"PROXY UNKNOWN 2001:db8::1 2001:db8::2 56324 587\r\n", "10.0.0.1:4242", "10.0.0.1:4242", false},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\n", "", "", true},
+		{"PROXY TCP4 2001:db8::1 198.51.100.1 56324 25\r\n", "", "", true},
+		{"PROXY TCP6 192.0.2.1 198.51.100.1 56324 25\r\n", "", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 65536 25\r\n", "", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "", "", true},
+		{"PROXY UDP4 192.0.2.1 198.51.100.1 56324 25\r\n", "", "", true},
+		{"EHLO example.com\r\n", "", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 25", "", "", true},
+		{"PROXY " + string(bytes.Repeat([]byte("A"), 120)) + "\r\n", "", "", true},
+	}
+	for _, test := range tests {
+		c := proxyTestConn([]byte(test.header))
+		err := c.readV1Header()
+		if test.err {
+			assert.Error(t, err, test.header)
+			continue
+		}
+		if assert.NoError(t, err, test.header) {
+			assert.Equal(t, test.remoteAddr, c.RemoteAddr().String(), test.header)
+			assert.Equal(t, test.localAddr, c.LocalAddr().String(), test.header)
+		}
+	}
+
+	// data following the header is kept
+	c := proxyTestConn([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 25\r\nEHLO example.com\r\n"))
+	assert.NoError(t, c.readV1Header())
+	data, _ := ioutil.ReadAll(c)
+	assert.Equal(t, "EHLO example.com\r\n", string(data))
+}
+
+// proxyTestV2Header returns a PROXY v2 header
+func proxyTestV2Header(versionCommand, family byte, payload []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, versionCommand, family, byte(len(payload)>>8), byte(len(payload)))
+	return append(header, payload...)
+}
+
+func Test_readV2Header(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0, 25}
+	ipv6 := append(append(append([]byte{}, net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x02, 0x4b)
+	tests := []struct {
+		name       string
+		header     []byte
+		remoteAddr string
+		localAddr  string
+		err        bool
+	}{
+		{"tcp4", proxyTestV2Header(0x21, 0x11, ipv4), "192.0.2.1:56324", "198.51.100.1:25", false},
+		{"tcp4 with TLV", proxyTestV2Header(0x21, 0x11, append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)), "192.0.2.1:56324", "198.51.100.1:25", false},
+		{"tcp6", proxyTestV2Header(0x21, 0x21, ipv6), "[2001:db8::1]:56324", "[2001:db8::2]:587", false},
+		{"local", proxyTestV2Header(0x20, 0x00, nil), "10.0.0.1:4242", "10.0.0.1:4242", false},
+		{"unix", proxyTestV2Header(0x21, 0x31, make([]byte, 216)), "10.0.0.1:4242", "10.0.0.1:4242", false},
+		{"version 1", proxyTestV2Header(0x11, 0x11, ipv4), "", "", true},
+		{"bad command", proxyTestV2Header(0x22, 0x11, ipv4), "", "", true},
+		{"short tcp4", proxyTestV2Header(0x21, 0x11, ipv4[:8]), "", "", true},
+		{"short tcp6", proxyTestV2Header(0x21, 0x21, ipv6[:32]), "", "", true},
+		{"truncated", proxyTestV2Header(0x21, 0x11, ipv4)[:20], "", "", true},
+	}
+	for _, test := range tests {
+		c := proxyTestConn(test.header)
+		err := c.readV2Header()
+		if test.err {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.remoteAddr, c.RemoteAddr().String(), test.name)
+			assert.Equal(t, test.localAddr, c.LocalAddr().String(), test.name)
+		}
+	}
+}
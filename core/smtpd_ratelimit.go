@@ -0,0 +1,430 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RateLimitScopeIp is the scope of limits applied to client IP/network
+	RateLimitScopeIp = "ip"
+	// RateLimitScopeUser is the scope of limits applied to authenticated users
+	RateLimitScopeUser = "user"
+)
+
+// RateLimit represents a rate limit override stored in DB
+// Target is a CIDR (or a single IP) for scope ip, a login for scope user
+// A null field means "use the default value from config", 0 means unlimited
+type RateLimit struct {
+	Id            int64
+	Scope         string `sql:"not null"`
+	Target        string `sql:"not null"`
+	MaxSessions   sql.NullInt64
+	ConnPerMinute sql.NullInt64
+	MsgPerHour    sql.NullInt64
+	RcptPerHour   sql.NullInt64
+}
+
+// RateLimitValues are the effective limits for a key (0 means unlimited)
+type RateLimitValues struct {
+	MaxSessions   int
+	ConnPerMinute int
+	MsgPerHour    int
+	RcptPerHour   int
+}
+
+// RateLimitAdd adds (or replaces) an override for target
+// limits lower than 0 are ignored (-> default from config)
+func RateLimitAdd(scope, target string, maxSessions, connPerMinute, msgPerHour, rcptPerHour int) error {
+	scope = strings.ToLower(strings.TrimSpace(scope))
+	target = strings.ToLower(strings.TrimSpace(target))
+	switch scope {
+	case RateLimitScopeIp:
+		if _, err := parseIpOrCidr(target); err != nil {
+			return err
+		}
+	case RateLimitScopeUser:
+		if target == "" {
+			return errors.New("user login must not be empty")
+		}
+	default:
+		return errors.New("invalid scope " + scope + ", must be ip or user")
+	}
+	rl := RateLimit{
+		Scope:  scope,
+		Target: target,
+	}
+	for _, l := range []struct {
+		value int
+		field *sql.NullInt64
+	}{
+		{maxSessions, &rl.MaxSessions},
+		{connPerMinute, &rl.ConnPerMinute},
+		{msgPerHour, &rl.MsgPerHour},
+		{rcptPerHour, &rl.RcptPerHour},
+	} {
+		if l.value >= 0 {
+			*l.field = sql.NullInt64{Int64: int64(l.value), Valid: true}
+		}
+	}
+	tx := DB.Begin()
+	if err := tx.Where("scope = ? and target = ?", scope, target).Delete(&RateLimit{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(&rl).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	smtpdRateLimiter.flushOverrides()
+	return nil
+}
+
+// RateLimitDel removes an override
+func RateLimitDel(id int64) error {
+	if err := DB.Delete(&RateLimit{Id: id}).Error; err != nil {
+		return err
+	}
+	smtpdRateLimiter.flushOverrides()
+	return nil
+}
+
+// RateLimitGetAll returns all overrides
+func RateLimitGetAll() (limits []RateLimit, err error) {
+	limits = []RateLimit{}
+	err = DB.Order("scope, target").Find(&limits).Error
+	return
+}
+
+// RateLimitCounter is a snapshot of the current counters for a key
+type RateLimitCounter struct {
+	Scope          string
+	Key            string
+	Sessions       int
+	ConnAvailable  float64
+	MsgAvailable   float64
+	RcptAvailable  float64
+	Limits         RateLimitValues
+	LastActivityAt time.Time
+}
+
+// RateLimitGetCounters returns the current in memory counters
+func RateLimitGetCounters() []RateLimitCounter {
+	return smtpdRateLimiter.counters()
+}
+
+// parseIpOrCidr returns target as an IP network
+func parseIpOrCidr(target string) (*net.IPNet, error) {
+	if strings.Contains(target, "/") {
+		_, ipNet, err := net.ParseCIDR(target)
+		if err != nil {
+			return nil, errors.New("invalid CIDR " + target)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return nil, errors.New("invalid IP " + target)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ipFromAddr returns the IP of a net.Addr
+func ipFromAddr(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// tokenBucket is a classic token bucket
+// capacity tokens are refilled over period
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	period   time.Duration
+	last     time.Time
+}
+
+func newTokenBucket(capacity int, period time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		period:   period,
+		last:     time.Now(),
+	}
+}
+
+// refill adds tokens earned since last refill
+func (b *tokenBucket) refill(now time.Time) {
+	if b.capacity == 0 {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.capacity / b.period.Seconds()
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// take removes n tokens, returns false if there is not enough tokens
+// a bucket with a capacity of 0 is unlimited
+func (b *tokenBucket) take(n int) bool {
+	if b.capacity == 0 {
+		return true
+	}
+	b.refill(time.Now())
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// setCapacity updates bucket capacity keeping available tokens
+func (b *tokenBucket) setCapacity(capacity int) {
+	if float64(capacity) == b.capacity {
+		return
+	}
+	b.refill(time.Now())
+	b.capacity = float64(capacity)
+	if b.tokens > b.capacity || b.capacity == 0 {
+		b.tokens = b.capacity
+	}
+}
+
+// rateLimitEntry keeps counters for one key
+type rateLimitEntry struct {
+	limits   RateLimitValues
+	sessions int
+	conn     *tokenBucket
+	msg      *tokenBucket
+	rcpt     *tokenBucket
+	lastSeen time.Time
+}
+
+func newRateLimitEntry(limits RateLimitValues) *rateLimitEntry {
+	return &rateLimitEntry{
+		limits:   limits,
+		conn:     newTokenBucket(limits.ConnPerMinute, time.Minute),
+		msg:      newTokenBucket(limits.MsgPerHour, time.Hour),
+		rcpt:     newTokenBucket(limits.RcptPerHour, time.Hour),
+		lastSeen: time.Now(),
+	}
+}
+
+// setLimits updates limits (overrides may have changed)
+func (e *rateLimitEntry) setLimits(limits RateLimitValues) {
+	e.limits = limits
+	e.conn.setCapacity(limits.ConnPerMinute)
+	e.msg.setCapacity(limits.MsgPerHour)
+	e.rcpt.setCapacity(limits.RcptPerHour)
+}
+
+// rateLimiter handles counters for smtpd sessions
+type rateLimiter struct {
+	sync.Mutex
+	entries map[string]*rateLimitEntry
+	// overrides cache
+	overrides         []RateLimit
+	overridesLoadedAt time.Time
+}
+
+var smtpdRateLimiter = newRateLimiter()
+
+func newRateLimiter() *rateLimiter {
+	rl := &rateLimiter{
+		entries: make(map[string]*rateLimitEntry),
+	}
+	go rl.gc()
+	return rl
+}
+
+// gc removes idle entries
+func (r *rateLimiter) gc() {
+	for {
+		time.Sleep(10 * time.Minute)
+		r.Lock()
+		for k, e := range r.entries {
+			if e.sessions == 0 && time.Since(e.lastSeen) > 2*time.Hour {
+				delete(r.entries, k)
+			}
+		}
+		r.Unlock()
+	}
+}
+
+// flushOverrides forces reload of overrides from DB
+func (r *rateLimiter) flushOverrides() {
+	r.Lock()
+	r.overridesLoadedAt = time.Time{}
+	r.Unlock()
+}
+
+// getOverrides returns overrides from DB (cached for one minute)
+// must be called with lock held
+func (r *rateLimiter) getOverrides() []RateLimit {
+	if time.Since(r.overridesLoadedAt) < time.Minute {
+		return r.overrides
+	}
+	overrides, err := RateLimitGetAll()
+	if err != nil {
+		Logger.Error("ratelimit - unable to load overrides from DB - " + err.Error())
+		return r.overrides
+	}
+	r.overrides = overrides
+	r.overridesLoadedAt = time.Now()
+	return r.overrides
+}
+
+// limitsFor returns effective limits and the key of the counter for target
+// for IPs the key is the most specific matching override network (counters
+// are shared by all the IPs of that network) or the IP itself
+// must be called with lock held
+func (r *rateLimiter) limitsFor(scope, target string) (string, RateLimitValues) {
+	limits := Cfg.GetSmtpdRateLimitDefaults(scope)
+	key := scope + ":" + target
+	var match *RateLimit
+	bestPrefix := -1
+	ip := net.ParseIP(target)
+	overrides := r.getOverrides()
+	for i, o := range overrides {
+		if o.Scope != scope {
+			continue
+		}
+		if scope == RateLimitScopeUser {
+			if o.Target == strings.ToLower(target) {
+				match = &overrides[i]
+				break
+			}
+			continue
+		}
+		ipNet, err := parseIpOrCidr(o.Target)
+		if err != nil || ip == nil || !ipNet.Contains(ip) {
+			continue
+		}
+		if prefix, _ := ipNet.Mask.Size(); prefix > bestPrefix {
+			bestPrefix = prefix
+			match = &overrides[i]
+		}
+	}
+	if match == nil {
+		return key, limits
+	}
+	if scope == RateLimitScopeIp {
+		key = scope + ":" + match.Target
+	}
+	if match.MaxSessions.Valid {
+		limits.MaxSessions = int(match.MaxSessions.Int64)
+	}
+	if match.ConnPerMinute.Valid {
+		limits.ConnPerMinute = int(match.ConnPerMinute.Int64)
+	}
+	if match.MsgPerHour.Valid {
+		limits.MsgPerHour = int(match.MsgPerHour.Int64)
+	}
+	if match.RcptPerHour.Valid {
+		limits.RcptPerHour = int(match.RcptPerHour.Int64)
+	}
+	return key, limits
+}
+
+// entry returns (and creates if needed) the entry for target
+// must be called with lock held
+func (r *rateLimiter) entry(scope, target string) (string, *rateLimitEntry) {
+	key, limits := r.limitsFor(scope, target)
+	e, ok := r.entries[key]
+	if !ok {
+		e = newRateLimitEntry(limits)
+		r.entries[key] = e
+	} else {
+		e.setLimits(limits)
+	}
+	e.lastSeen = time.Now()
+	return key, e
+}
+
+// openSession registers a new session for target
+// it returns a non empty key on success (to be used to close the session)
+// and the reason of the refusal otherwise
+func (r *rateLimiter) openSession(scope, target string) (key, reason string) {
+	r.Lock()
+	defer r.Unlock()
+	key, e := r.entry(scope, target)
+	if e.limits.MaxSessions != 0 && e.sessions >= e.limits.MaxSessions {
+		return "", "too many concurrent sessions"
+	}
+	if !e.conn.take(1) {
+		return "", "too many connections per minute"
+	}
+	e.sessions++
+	return key, ""
+}
+
+// closeSession unregisters a session
+func (r *rateLimiter) closeSession(key string) {
+	r.Lock()
+	defer r.Unlock()
+	if e, ok := r.entries[key]; ok && e.sessions > 0 {
+		e.sessions--
+		e.lastSeen = time.Now()
+	}
+}
+
+// takeMsg consumes one message token for target
+func (r *rateLimiter) takeMsg(scope, target string) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, e := r.entry(scope, target)
+	return e.msg.take(1)
+}
+
+// takeRcpt consumes one recipient token for target
+func (r *rateLimiter) takeRcpt(scope, target string) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, e := r.entry(scope, target)
+	return e.rcpt.take(1)
+}
+
+// counters returns a snapshot of current counters
+func (r *rateLimiter) counters() []RateLimitCounter {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	counters := []RateLimitCounter{}
+	for k, e := range r.entries {
+		e.conn.refill(now)
+		e.msg.refill(now)
+		e.rcpt.refill(now)
+		t := strings.SplitN(k, ":", 2)
+		counters = append(counters, RateLimitCounter{
+			Scope:          t[0],
+			Key:            t[1],
+			Sessions:       e.sessions,
+			ConnAvailable:  e.conn.tokens,
+			MsgAvailable:   e.msg.tokens,
+			RcptAvailable:  e.rcpt.tokens,
+			Limits:         e.limits,
+			LastActivityAt: e.lastSeen,
+		})
+	}
+	return counters
+}
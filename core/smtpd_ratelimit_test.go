@@ -0,0 +1,44 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_tokenBucket(t *testing.T) {
+	// unlimited
+	b := newTokenBucket(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		assert.True(t, b.take(1))
+	}
+
+	b = newTokenBucket(10, time.Minute)
+	assert.True(t, b.take(4))
+	assert.True(t, b.take(6))
+	assert.False(t, b.take(1))
+
+	// 30 seconds later half of the capacity is refilled
+	b.last = b.last.Add(-30 * time.Second)
+	assert.True(t, b.take(5))
+	assert.False(t, b.take(1))
+
+	// refill never exceeds capacity
+	b.last = b.last.Add(-time.Hour)
+	assert.False(t, b.take(11))
+	assert.True(t, b.take(10))
+
+	// capacity changes keep available tokens
+	b = newTokenBucket(10, time.Minute)
+	assert.True(t, b.take(8))
+	b.setCapacity(20)
+	assert.False(t, b.take(3))
+	assert.True(t, b.take(2))
+	b = newTokenBucket(10, time.Minute)
+	b.setCapacity(5)
+	assert.False(t, b.take(6))
+	assert.True(t, b.take(5))
+	b.setCapacity(0)
+	assert.True(t, b.take(1000))
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recipient verification for relay rcpthosts (IsLocal false)
+// Before accepting RCPT TO the backend is asked if the recipient exists:
+//  smtp: RCPT probe (MAIL FROM:<sender> RCPT TO:<rcpt> QUIT) to the endpoint
+//        of the rcpthost or to its routes (same as deliveries)
+//  lmtp: RCPT probe to a LMTP server
+//  ldap: search with TMAIL_SMTPD_RCPT_VERIFY_LDAP_FILTER in the directory
+//        used for SMTP AUTH
+// Valid and unknown recipients are cached, backend failures (timeout, 4xx)
+// are not: the rcpthost decides if the recipient is accepted (fail-open) or
+// temporary rejected (fail-closed).
+
+const (
+	// RcptVerifySmtp is verification by SMTP callout
+	RcptVerifySmtp = "smtp"
+	// RcptVerifyLmtp is verification by LMTP callout
+	RcptVerifyLmtp = "lmtp"
+	// RcptVerifyLdap is verification by LDAP lookup
+	RcptVerifyLdap = "ldap"
+)
+
+// rcptVerifyCacheMax is the number of cached entries above which expired
+// ones are purged
+const rcptVerifyCacheMax = 10000
+
+// rcptVerifyEntry is a cached verification result
+type rcptVerifyEntry struct {
+	valid    bool
+	msg      string
+	expireAt time.Time
+}
+
+// cached results (rcpt -> entry)
+var rcptVerifyCache = struct {
+	sync.Mutex
+	entries map[string]rcptVerifyEntry
+}{entries: map[string]rcptVerifyEntry{}}
+
+// rcptVerifyCacheGet returns cached result for rcpt
+func rcptVerifyCacheGet(rcpt string) (entry rcptVerifyEntry, ok bool) {
+	rcptVerifyCache.Lock()
+	defer rcptVerifyCache.Unlock()
+	entry, ok = rcptVerifyCache.entries[rcpt]
+	if ok && time.Now().After(entry.expireAt) {
+		delete(rcptVerifyCache.entries, rcpt)
+		return entry, false
+	}
+	return
+}
+
+// rcptVerifyCacheSet caches result for rcpt
+func rcptVerifyCacheSet(rcpt string, valid bool, msg string) {
+	ttl := Cfg.GetSmtpdRcptVerifyNegativeTtl()
+	if valid {
+		ttl = Cfg.GetSmtpdRcptVerifyCacheTtl()
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	rcptVerifyCache.Lock()
+	defer rcptVerifyCache.Unlock()
+	if len(rcptVerifyCache.entries) >= rcptVerifyCacheMax {
+		for key, entry := range rcptVerifyCache.entries {
+			if now.After(entry.expireAt) {
+				delete(rcptVerifyCache.entries, key)
+			}
+		}
+	}
+	rcptVerifyCache.entries[rcpt] = rcptVerifyEntry{valid, msg, now.Add(ttl)}
+}
+
+// rcptVerify checks if rcpt exists on the backend of rcpthost
+// msg is the backend reply for unknown recipients, err is a backend failure
+func rcptVerify(rcpthost *RcptHost, rcpt string) (valid bool, msg string, err error) {
+	key := strings.ToLower(rcpt)
+	if entry, ok := rcptVerifyCacheGet(key); ok {
+		return entry.valid, entry.msg, nil
+	}
+	switch rcpthost.RcptVerify {
+	case RcptVerifySmtp:
+		valid, msg, err = rcptVerifySmtp(rcpthost, rcpt)
+	case RcptVerifyLmtp:
+		var network, address string
+		if network, address, err = parseLmtpEndpoint(rcpthost.RcptVerifyEndpoint); err == nil {
+			valid, msg, err = rcptVerifyProbe(network, address, true, rcpt)
+		}
+	case RcptVerifyLdap:
+		valid, err = rcptVerifyLdap(rcpt)
+	default:
+		return false, "", errors.New("unknown verification method " + rcpthost.RcptVerify)
+	}
+	if err != nil {
+		return false, "", err
+	}
+	rcptVerifyCacheSet(key, valid, msg)
+	return valid, msg, nil
+}
+
+// rcptVerifyRelay asks the backend of relay host rcpthost if LastRcptTo
+// exists, returns false if the recipient has been rejected
+func (s *SMTPServerSession) rcptVerifyRelay(rcpthost *RcptHost) bool {
+	valid, msg, err := rcptVerify(rcpthost, s.LastRcptTo)
+	if err != nil {
+		if !rcpthost.RcptVerifyFailOpen {
+			s.LogError("RCPT - unable to verify " + s.LastRcptTo + ". " + err.Error())
+			s.Out("451 4.4.3 unable to verify recipient, try again later")
+			s.SMTPResponseCode = 451
+			return false
+		}
+		s.Log("RCPT - unable to verify " + s.LastRcptTo + ", accepted (fail-open). " + err.Error())
+		return true
+	}
+	if !valid {
+		s.Log("RCPT - rejected by backend: " + s.LastRcptTo + " - " + strings.Replace(msg, "\n", " ", -1))
+		s.Out("550 5.1.1 Sorry, no mailbox here by that name")
+		s.SMTPResponseCode = 550
+		s.BadRcptToCount++
+		if Cfg.GetSmtpdMaxBadRcptTo() != 0 && s.BadRcptToCount > Cfg.GetSmtpdMaxBadRcptTo() {
+			s.Log("RCPT - too many bad rcpt to, connection droped")
+			s.ExitAsap()
+		}
+		return false
+	}
+	return true
+}
+
+// rcptVerifySmtp probes endpoint of rcpthost or its routes (the first
+// reachable one)
+func rcptVerifySmtp(rcpthost *RcptHost, rcpt string) (valid bool, msg string, err error) {
+	addresses := []string{}
+	if rcpthost.RcptVerifyEndpoint != "" {
+		address := rcpthost.RcptVerifyEndpoint
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "25")
+		}
+		addresses = append(addresses, address)
+	} else {
+		routes, err := getRoutes("", rcpthost.Hostname, "")
+		if err != nil {
+			return false, "", err
+		}
+		for _, route := range routes {
+			host := route.RemoteHost
+			if ip := parseAddressLiteral(host); ip != nil {
+				host = ip.String()
+			}
+			addresses = append(addresses, net.JoinHostPort(host, strconv.FormatInt(route.RemotePort.Int64, 10)))
+		}
+	}
+	if len(addresses) == 0 {
+		return false, "", errors.New("no route to host " + rcpthost.Hostname)
+	}
+	for _, address := range addresses {
+		valid, msg, err = rcptVerifyProbe("tcp", address, false, rcpt)
+		// unreachable: next one
+		if _, ok := err.(*net.OpError); ok {
+			continue
+		}
+		return
+	}
+	return
+}
+
+// rcptVerifyProbe connects to address and checks if RCPT TO:<rcpt> is
+// accepted (LHLO is used instead of EHLO if lmtp is true)
+func rcptVerifyProbe(network, address string, lmtp bool, rcpt string) (valid bool, msg string, err error) {
+	timeout := Cfg.GetSmtpdRcptVerifyTimeout()
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return false, "", err
+	}
+	// timeout for the whole probe
+	conn.SetDeadline(time.Now().Add(timeout))
+	text := textproto.NewConn(conn)
+	defer text.Close()
+	cmd := func(expectedCode int, format string, args ...interface{}) (int, string, error) {
+		if _, err := text.Cmd(format, args...); err != nil {
+			return 0, "", err
+		}
+		return text.ReadResponse(expectedCode)
+	}
+
+	if _, _, err = text.ReadResponse(220); err != nil {
+		return false, "", fmt.Errorf("%s bad greeting - %s", address, err)
+	}
+	hello := "EHLO"
+	if lmtp {
+		hello = "LHLO"
+	}
+	if _, _, err = cmd(250, "%s %s", hello, Cfg.GetMe()); err != nil {
+		return false, "", fmt.Errorf("%s %s failed - %s", address, hello, err)
+	}
+	if _, _, err = cmd(250, "MAIL FROM:<%s>", Cfg.GetSmtpdRcptVerifySender()); err != nil {
+		return false, "", fmt.Errorf("%s MAIL FROM failed - %s", address, err)
+	}
+	code, msg, err := cmd(-1, "RCPT TO:<%s>", rcpt)
+	if err != nil && code == 0 {
+		return false, "", fmt.Errorf("%s RCPT TO failed - %s", address, err)
+	}
+	cmd(221, "QUIT")
+	switch code / 100 {
+	case 2:
+		return true, "", nil
+	case 5:
+		return false, msg, nil
+	}
+	return false, "", fmt.Errorf("%s RCPT TO reply %d %s", address, code, msg)
+}
+
+// rcptVerifyLdap searches rcpt in the directory
+func rcptVerifyLdap(rcpt string) (bool, error) {
+	if Cfg.GetSmtpdAuthLdapUrl() == "" || Cfg.GetSmtpdAuthLdapBaseDn() == "" {
+		return false, errors.New("LDAP url or base DN is not defined")
+	}
+	conn, err := ldapDial(Cfg.GetSmtpdAuthLdapUrl(), Cfg.GetSmtpdAuthLdapStartTLS(), Cfg.GetSmtpdRcptVerifyTimeout())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if Cfg.GetSmtpdAuthLdapBindDn() != "" {
+		if err = conn.Bind(Cfg.GetSmtpdAuthLdapBindDn(), Cfg.GetSmtpdAuthLdapBindPasswd()); err != nil {
+			return false, err
+		}
+	}
+	filter := strings.Replace(Cfg.GetSmtpdRcptVerifyLdapFilter(), "%s", ldapEscapeFilter(rcpt), -1)
+	entries, err := conn.Search(Cfg.GetSmtpdAuthLdapBaseDn(), filter, []string{"1.1"})
+	if err != nil {
+		// more than one entry: it exists
+		if lerr, ok := err.(*ldapError); ok && lerr.code == 4 {
+			return true, nil
+		}
+		return false, err
+	}
+	return len(entries) != 0, nil
+}
@@ -0,0 +1,270 @@
+package core
+
+import (
+	"bufio"
+	"database/sql"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRcptVerifyServer is a SMTP/LMTP server answering RCPT TO by local part:
+// ok 250, unknown 550, busy 450, slow no reply
+type testRcptVerifyServer struct {
+	sync.Mutex
+	listener net.Listener
+	hellos   []string
+	rcpts    int
+}
+
+func newTestRcptVerifyServer(t *testing.T) *testRcptVerifyServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testRcptVerifyServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *testRcptVerifyServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("220 backend.example.com\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.ToLower(line)
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO", "LHLO":
+			server.Lock()
+			server.hellos = append(server.hellos, verb)
+			server.Unlock()
+			conn.Write([]byte("250-backend.example.com\r\n250 PIPELINING\r\n"))
+		case "MAIL":
+			conn.Write([]byte("250 2.1.0 Ok\r\n"))
+		case "RCPT":
+			server.Lock()
+			server.rcpts++
+			server.Unlock()
+			switch {
+			case strings.Contains(line, "<ok@"):
+				conn.Write([]byte("250 2.1.5 Ok\r\n"))
+			case strings.Contains(line, "<unknown@"):
+				conn.Write([]byte("550 5.1.1 User unknown\r\n"))
+			case strings.Contains(line, "<busy@"):
+				conn.Write([]byte("450 4.2.1 Mailbox busy\r\n"))
+			default:
+				// slow: no reply
+				time.Sleep(2 * time.Second)
+				return
+			}
+		case "QUIT":
+			conn.Write([]byte("221 2.0.0 Bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("502 5.5.2 Error\r\n"))
+		}
+	}
+}
+
+func (server *testRcptVerifyServer) rcptCount() int {
+	server.Lock()
+	defer server.Unlock()
+	return server.rcpts
+}
+
+// setTestRcptVerify sets config used by verifications and empties the
+// cache, returns a func restoring them
+func setTestRcptVerify() func() {
+	cfg := Cfg
+	Cfg = &Config{}
+	Cfg.cfg.Me = "mail.example.com"
+	Cfg.cfg.SmtpdRcptVerifyTimeout = 1
+	Cfg.cfg.SmtpdRcptVerifyCacheTtl = 3600
+	Cfg.cfg.SmtpdRcptVerifyNegativeTtl = 600
+	rcptVerifyCache.Lock()
+	entries := rcptVerifyCache.entries
+	rcptVerifyCache.entries = map[string]rcptVerifyEntry{}
+	rcptVerifyCache.Unlock()
+	return func() {
+		Cfg = cfg
+		rcptVerifyCache.Lock()
+		rcptVerifyCache.entries = entries
+		rcptVerifyCache.Unlock()
+	}
+}
+
+func Test_rcptVerifyProbe(t *testing.T) {
+	defer setTestRcptVerify()()
+	server := newTestRcptVerifyServer(t)
+	defer server.listener.Close()
+	address := server.listener.Addr().String()
+
+	valid, msg, err := rcptVerifyProbe("tcp", address, false, "ok@example.com")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Empty(t, msg)
+
+	valid, msg, err = rcptVerifyProbe("tcp", address, true, "unknown@example.com")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+	assert.Equal(t, "5.1.1 User unknown", msg)
+
+	// temporary failure and timeout are backend failures
+	_, _, err = rcptVerifyProbe("tcp", address, false, "busy@example.com")
+	assert.Error(t, err)
+	start := time.Now()
+	_, _, err = rcptVerifyProbe("tcp", address, false, "slow@example.com")
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	server.Lock()
+	assert.Equal(t, []string{"EHLO", "LHLO", "EHLO", "EHLO"}, server.hellos)
+	server.Unlock()
+}
+
+func Test_rcptVerifyCache(t *testing.T) {
+	defer setTestRcptVerify()()
+	server := newTestRcptVerifyServer(t)
+	defer server.listener.Close()
+	rcpthost := &RcptHost{Hostname: "example.com", RcptVerify: RcptVerifyLmtp, RcptVerifyEndpoint: "tcp:" + server.listener.Addr().String()}
+
+	// positive and negative results are cached
+	for i := 0; i < 2; i++ {
+		valid, _, err := rcptVerify(rcpthost, "ok@example.com")
+		assert.NoError(t, err)
+		assert.True(t, valid)
+		valid, msg, err := rcptVerify(rcpthost, "Unknown@example.com")
+		assert.NoError(t, err)
+		assert.False(t, valid)
+		assert.Equal(t, "5.1.1 User unknown", msg)
+	}
+	assert.Equal(t, 2, server.rcptCount())
+
+	// backend failures are not
+	for i := 0; i < 2; i++ {
+		_, _, err := rcptVerify(rcpthost, "busy@example.com")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 4, server.rcptCount())
+
+	// expired entries are verified again
+	rcptVerifyCache.Lock()
+	entry := rcptVerifyCache.entries["ok@example.com"]
+	entry.expireAt = time.Now().Add(-time.Second)
+	rcptVerifyCache.entries["ok@example.com"] = entry
+	rcptVerifyCache.Unlock()
+	valid, _, err := rcptVerify(rcpthost, "ok@example.com")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, 5, server.rcptCount())
+
+	// TTL 0: no cache
+	Cfg.cfg.SmtpdRcptVerifyCacheTtl = 0
+	Cfg.cfg.SmtpdRcptVerifyNegativeTtl = 0
+	rcptVerifyCache.Lock()
+	rcptVerifyCache.entries = map[string]rcptVerifyEntry{}
+	rcptVerifyCache.Unlock()
+	for i := 0; i < 2; i++ {
+		rcptVerify(rcpthost, "ok@example.com")
+		rcptVerify(rcpthost, "unknown@example.com")
+	}
+	assert.Equal(t, 9, server.rcptCount())
+	rcptVerifyCache.Lock()
+	assert.Empty(t, rcptVerifyCache.entries)
+	rcptVerifyCache.Unlock()
+}
+
+func Test_rcptVerifyRelay(t *testing.T) {
+	defer setTestRcptVerify()()
+	defer stubSMTPLogger()()
+	server := newTestRcptVerifyServer(t)
+	defer server.listener.Close()
+	Cfg.cfg.SmtpdMaxBadRcptTo = 1
+
+	tests := []struct {
+		rcpt     string
+		failOpen bool
+		ok       bool
+		reply    string
+	}{
+		{"ok@example.com", false, true, ""},
+		{"unknown@example.com", true, false, "550 5.1.1"},
+		{"busy@example.com", false, false, "451 4.4.3"},
+		{"busy@example.com", true, true, ""},
+		{"slow@example.com", false, false, "451 4.4.3"},
+		{"slow@example.com", true, true, ""},
+	}
+	for _, test := range tests {
+		rcpthost := &RcptHost{Hostname: "example.com", RcptVerify: RcptVerifySmtp, RcptVerifyEndpoint: server.listener.Addr().String(), RcptVerifyFailOpen: test.failOpen}
+		s, conn := newTestSMTPSession(SmtpdRoleMx, false)
+		s.LastRcptTo = test.rcpt
+		assert.Equal(t, test.ok, s.rcptVerifyRelay(rcpthost), test)
+		if test.ok {
+			assert.Empty(t, conn.out.String(), test)
+		} else {
+			assert.Contains(t, conn.out.String(), test.reply, test)
+		}
+	}
+
+	// too many unknown recipients
+	rcpthost := &RcptHost{Hostname: "example.com", RcptVerify: RcptVerifySmtp, RcptVerifyEndpoint: server.listener.Addr().String()}
+	s, _ := newTestSMTPSession(SmtpdRoleMx, false)
+	s.LastRcptTo = "unknown@example.com"
+	assert.False(t, s.rcptVerifyRelay(rcpthost))
+	assert.Empty(t, s.exitasap)
+	assert.False(t, s.rcptVerifyRelay(rcpthost))
+	assert.Len(t, s.exitasap, 1)
+}
+
+func Test_rcptVerifySmtpRoutes(t *testing.T) {
+	defer setTestRcptVerify()()
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &Route{})()
+	server := newTestRcptVerifyServer(t)
+	defer server.listener.Close()
+
+	// a closed port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	serverPort := server.listener.Addr().(*net.TCPAddr).Port
+
+	rcpthost := &RcptHost{Hostname: "example.com", RcptVerify: RcptVerifySmtp}
+	assert.NoError(t, DB.Create(&Route{Host: "example.com", RemoteHost: "127.0.0.1", RemotePort: sql.NullInt64{Int64: int64(closedPort), Valid: true}, Priority: sql.NullInt64{Int64: 1, Valid: true}}).Error)
+
+	// unreachable route only
+	_, _, err = rcptVerifySmtp(rcpthost, "ok@example.com")
+	assert.IsType(t, &net.OpError{}, err)
+
+	// next route is used
+	assert.NoError(t, DB.Create(&Route{Host: "example.com", RemoteHost: "[127.0.0.1]", RemotePort: sql.NullInt64{Int64: int64(serverPort), Valid: true}, Priority: sql.NullInt64{Int64: 2, Valid: true}}).Error)
+	valid, _, err := rcptVerifySmtp(rcpthost, "ok@example.com")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	valid, msg, err := rcptVerifySmtp(rcpthost, "unknown@example.com")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+	assert.Equal(t, "5.1.1 User unknown", msg)
+	assert.Equal(t, 2, server.rcptCount())
+}
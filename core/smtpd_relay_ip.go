@@ -4,38 +4,150 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
 
-// relayOkIp represents an IP that can use SMTP for relaying
+// relayOkIp represents an IP or a network (CIDR) that can use SMTP for relaying
+// and the policy applied to messages relayed from this network
 type RelayIpOk struct {
-	Id int64
-	Ip string `sql:"unique"`
+	Id          int64
+	Ip          string `sql:"unique"`
+	Description string
+	// ExpiresAt nil: never expires
+	ExpiresAt *time.Time
+	// MaxSize max message size in bytes (0: smtpd default)
+	MaxSize int64
+	// SenderDomains allowed domains for MAIL FROM, comma separated (empty: any)
+	SenderDomains string
+	// SkipScan disables content scanning (clamav)
+	SkipScan bool
+}
+
+// isExpired returns true if entry has expired
+func (r *RelayIpOk) isExpired() bool {
+	return r.ExpiresAt != nil && r.ExpiresAt.Before(time.Now())
+}
+
+// contains returns true if ip is this IP or belongs to this network
+func (r *RelayIpOk) contains(ip net.IP) bool {
+	ipNet, err := parseIpOrCidr(r.Ip)
+	return err == nil && ipNet.Contains(ip)
+}
+
+// prefixLen returns network prefix length (used to find the most specific entry)
+func (r *RelayIpOk) prefixLen() int {
+	ipNet, err := parseIpOrCidr(r.Ip)
+	if err != nil {
+		return -1
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones
+}
+
+// SenderAllowed checks if mailFrom can be relayed from this network
+// null sender (bounces) is always allowed
+func (r *RelayIpOk) SenderAllowed(mailFrom string) bool {
+	if r.SenderDomains == "" || mailFrom == "" {
+		return true
+	}
+	domain := strings.ToLower(mailFrom[strings.LastIndex(mailFrom, "@")+1:])
+	for _, d := range strings.Split(r.SenderDomains, ",") {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// RelayIpGetByAddr returns the most specific active entry matching addr
+// or nil if addr can't relay
+func RelayIpGetByAddr(addr net.Addr) (*RelayIpOk, error) {
+	ip := ipFromAddr(addr)
+	if ip == nil {
+		return nil, errors.New("unable to parse IP from " + addr.String())
+	}
+	ips, err := RelayIpGetAll()
+	if err != nil {
+		return nil, err
+	}
+	var match *RelayIpOk
+	for i, rip := range ips {
+		if rip.isExpired() || !rip.contains(ip) {
+			continue
+		}
+		if match == nil || rip.prefixLen() > match.prefixLen() {
+			match = &ips[i]
+		}
+	}
+	return match, nil
 }
 
 // remoteIpCanUseSmtp checks if an IP can relay
-func IpCanRelay(ip net.Addr) (bool, error) {
-	err := DB.Where("ip = ?", ip.String()[:strings.Index(ip.String(), ":")]).Find(&RelayIpOk{}).Error
-	if err == nil {
-		return true, nil
+func IpCanRelay(addr net.Addr) (bool, error) {
+	rip, err := RelayIpGetByAddr(addr)
+	return rip != nil, err
+}
+
+// relayIpNormalize validates ip (IP or CIDR, v4 or v6) and returns its
+// canonical form
+func relayIpNormalize(ip string) (string, error) {
+	ip = strings.TrimSpace(ip)
+	ipNet, err := parseIpOrCidr(ip)
+	if err != nil {
+		return "", err
 	}
-	if err != gorm.ErrRecordNotFound {
-		return false, err
+	// single IP
+	if !strings.Contains(ip, "/") {
+		return ipNet.IP.String(), nil
 	}
-	return false, nil
+	return ipNet.String(), nil
 }
 
-// relayipAdd authorize IP to relay through tmail
-func RelayIpAdd(ip string) error {
+// relayipAdd authorize IP or network (CIDR) to relay through tmail
+// an existing entry for the same IP/network is replaced
+func RelayIpAdd(ip, description string, expiresAt *time.Time, maxSize int64, senderDomains []string, skipScan bool) error {
 	// input validation
-	if net.ParseIP(ip) == nil {
-		return errors.New("Invalid IP: " + ip)
+	ip, err := relayIpNormalize(ip)
+	if err != nil {
+		return err
+	}
+	if maxSize < 0 {
+		return errors.New("max size must be positive")
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		return errors.New("expiration date is in the past")
+	}
+	domains := []string{}
+	for _, d := range senderDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" {
+			continue
+		}
+		if strings.ContainsAny(d, "@, ") {
+			return errors.New("invalid sender domain " + d)
+		}
+		domains = append(domains, d)
 	}
 	rip := RelayIpOk{
-		Ip: ip,
+		Ip:            ip,
+		Description:   strings.TrimSpace(description),
+		ExpiresAt:     expiresAt,
+		MaxSize:       maxSize,
+		SenderDomains: strings.Join(domains, ","),
+		SkipScan:      skipScan,
 	}
-	return DB.Save(&rip).Error
+	tx := DB.Begin()
+	if err := tx.Where("ip = ?", ip).Delete(&RelayIpOk{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(&rip).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
 // RelayIpList return all IPs authorized to relay through tmail
@@ -45,11 +157,24 @@ func RelayIpGetAll() (ips []RelayIpOk, err error) {
 	return
 }
 
-// RelayIpDel remove ip from authorized IP
+// RelayIpDel remove ip (or network) from authorized IP
 func RelayIpDel(ip string) error {
 	// input validation
-	if net.ParseIP(ip) == nil {
-		return errors.New("Invalid IP: " + ip)
+	ip, err := relayIpNormalize(ip)
+	if err != nil {
+		return err
 	}
 	return DB.Where("ip = ?", ip).Delete(&RelayIpOk{}).Error
 }
+
+// RelayIpDelById remove entry id from authorized IP
+func RelayIpDelById(id int64) error {
+	err := DB.Where("id = ?", id).Find(&RelayIpOk{}).Error
+	if err == gorm.ErrRecordNotFound {
+		return errors.New("relay IP entry doesn't exists")
+	}
+	if err != nil {
+		return err
+	}
+	return DB.Where("id = ?", id).Delete(&RelayIpOk{}).Error
+}
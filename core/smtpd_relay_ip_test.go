@@ -0,0 +1,75 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseIpOrCidr(t *testing.T) {
+	tests := []struct {
+		target string
+		ipNet  string
+		err    bool
+	}{
+		{"127.0.0.1", "127.0.0.1/32", false},
+		{"192.168.1.12/16", "192.168.0.0/16", false},
+		{"::ffff:10.0.0.1", "10.0.0.1/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::/32", "2001:db8::/32", false},
+		{"", "", true},
+		{"localhost", "", true},
+		{"10.0.0.0/33", "", true},
+		{"10.0.0.256", "", true},
+	}
+	for _, test := range tests {
+		ipNet, err := parseIpOrCidr(test.target)
+		if test.err {
+			assert.Error(t, err, test.target)
+			continue
+		}
+		if assert.NoError(t, err, test.target) {
+			assert.Equal(t, test.ipNet, ipNet.String(), test.target)
+		}
+	}
+}
+
+func Test_RelayIpOkSenderAllowed(t *testing.T) {
+	tests := []struct {
+		senderDomains string
+		mailFrom      string
+		allowed       bool
+	}{
+		{"", "john@example.com", true},
+		{"", "", true},
+		{"example.com", "", true},
+		{"example.com", "john@example.com", true},
+		{"example.com", "john@EXAMPLE.com", true},
+		{"example.com,example.net", "john@example.net", true},
+		{"example.com", "john@sub.example.com", false},
+		{"example.com", "john@example.org", false},
+		{"example.com", "\"a@example.com\"@example.org", false},
+	}
+	for _, test := range tests {
+		r := RelayIpOk{SenderDomains: test.senderDomains}
+		assert.Equal(t, test.allowed, r.SenderAllowed(test.mailFrom), test.senderDomains+" "+test.mailFrom)
+	}
+}
+
+func Test_RelayIpOkContains(t *testing.T) {
+	r := RelayIpOk{Ip: "192.168.0.0/16"}
+	assert.True(t, r.contains(net.ParseIP("192.168.10.1")))
+	assert.False(t, r.contains(net.ParseIP("192.169.0.1")))
+	assert.False(t, r.contains(net.ParseIP("2001:db8::1")))
+	assert.Equal(t, 16, r.prefixLen())
+	assert.Equal(t, 32, (&RelayIpOk{Ip: "192.168.0.1"}).prefixLen())
+	assert.Equal(t, -1, (&RelayIpOk{Ip: "bad"}).prefixLen())
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	assert.False(t, r.isExpired())
+	assert.True(t, (&RelayIpOk{ExpiresAt: &past}).isExpired())
+	assert.False(t, (&RelayIpOk{ExpiresAt: &future}).isExpired())
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var (
+	// errSaslAuthFailed bad credentials
+	errSaslAuthFailed = ErrAuthFailed
+	// errSaslMalformed bad client response
+	errSaslMalformed = errors.New("malformed auth input")
+)
+
+// saslMechanism is a server side SASL mechanism
+// a new instance is used for each AUTH command
+type saslMechanism interface {
+	// next processes client response (nil if there is no initial response)
+	// and returns next challenge. done is true when exchange is over and
+	// user is authenticated
+	next(response []byte) (challenge []byte, done bool, err error)
+	// authUser returns authenticated user
+	authUser() *User
+	// authLogin returns login sent by client (empty if unknown yet)
+	authLogin() string
+}
+
+// saslMechanismDef defines a SASL mechanism
+type saslMechanismDef struct {
+	// plaintext mechanisms (password or token in clear) need TLS
+	plaintext bool
+	// available returns false if mechanism can't be used (missing config)
+	available func() bool
+	// init returns a new instance of the mechanism
+	init func(s *SMTPServerSession) saslMechanism
+}
+
+// saslMechanisms supported mechanisms
+var saslMechanisms = map[string]saslMechanismDef{
+	"PLAIN": {
+		plaintext: true,
+		init:      func(s *SMTPServerSession) saslMechanism { return &saslPlain{} },
+	},
+	"LOGIN": {
+		plaintext: true,
+		init:      func(s *SMTPServerSession) saslMechanism { return &saslLogin{} },
+	},
+	"CRAM-MD5": {
+		init: func(s *SMTPServerSession) saslMechanism { return &saslCramMd5{} },
+	},
+	"SCRAM-SHA-256": {
+		init: func(s *SMTPServerSession) saslMechanism { return &saslScramSha256{} },
+	},
+	"XOAUTH2": {
+		plaintext: true,
+		available: func() bool { return Cfg.GetSmtpdAuthOauthJwksFile() != "" },
+		init:      func(s *SMTPServerSession) saslMechanism { return &saslOauth{xoauth2: true} },
+	},
+	"OAUTHBEARER": {
+		plaintext: true,
+		available: func() bool { return Cfg.GetSmtpdAuthOauthJwksFile() != "" },
+		init:      func(s *SMTPServerSession) saslMechanism { return &saslOauth{} },
+	},
+}
+
+// saslAllowedMechanisms returns mechanisms allowed for the current
+// session, in config order
+func (s *SMTPServerSession) saslAllowedMechanisms() (mechanisms []string) {
+	for _, name := range Cfg.GetSmtpdAuthMechanisms() {
+		def, ok := saslMechanisms[name]
+		if !ok {
+			continue
+		}
+		if def.plaintext && !s.tls && !Cfg.GetSmtpdAuthPlaintextWithoutTLS() {
+			continue
+		}
+		if def.available != nil && !def.available() {
+			continue
+		}
+		mechanisms = append(mechanisms, name)
+	}
+	return
+}
+
+// PLAIN (RFC 4616)
+type saslPlain struct {
+	login string
+	user  *User
+}
+
+func (m *saslPlain) next(response []byte) (challenge []byte, done bool, err error) {
+	if response == nil {
+		return []byte{}, false, nil
+	}
+	// authzid\0authcid\0passwd
+	t := bytes.Split(response, []byte{0})
+	if len(t) != 3 {
+		return nil, false, errSaslMalformed
+	}
+	// we do not support authorization identity other than authcid
+	if len(t[0]) != 0 && !strings.EqualFold(string(t[0]), string(t[1])) {
+		return nil, false, errSaslAuthFailed
+	}
+	m.login = string(t[1])
+	m.user, err = Authenticate(m.login, string(t[2]))
+	return nil, err == nil, err
+}
+
+func (m *saslPlain) authUser() *User {
+	return m.user
+}
+
+func (m *saslPlain) authLogin() string {
+	return m.login
+}
+
+// LOGIN (draft-murchison-sasl-login)
+type saslLogin struct {
+	step  int
+	login string
+	user  *User
+}
+
+func (m *saslLogin) next(response []byte) (challenge []byte, done bool, err error) {
+	switch m.step {
+	case 0:
+		m.step++
+		// initial response is the username
+		if response != nil {
+			m.login = string(response)
+			m.step++
+			return []byte("Password:"), false, nil
+		}
+		return []byte("Username:"), false, nil
+	case 1:
+		m.login = string(response)
+		m.step++
+		return []byte("Password:"), false, nil
+	default:
+		m.user, err = Authenticate(m.login, string(response))
+		return nil, err == nil, err
+	}
+}
+
+func (m *saslLogin) authUser() *User {
+	return m.user
+}
+
+func (m *saslLogin) authLogin() string {
+	return m.login
+}
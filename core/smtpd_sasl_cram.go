@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// CRAM-MD5 (RFC 2195)
+// We do not store clear password, but HMAC-MD5 inner and outer
+// contexts (same trick as dovecot CRAM-MD5 scheme)
+type saslCramMd5 struct {
+	challenge []byte
+	login     string
+	user      *User
+}
+
+func (m *saslCramMd5) next(response []byte) (challenge []byte, done bool, err error) {
+	if m.challenge == nil {
+		if response != nil {
+			return nil, false, errSaslMalformed
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+		if err != nil {
+			return nil, false, err
+		}
+		m.challenge = []byte(fmt.Sprintf("<%d.%d@%s>", n, time.Now().Unix(), Cfg.GetMe()))
+		return m.challenge, false, nil
+	}
+
+	// user digest
+	t := bytes.Split(response, []byte{32})
+	if len(t) != 2 {
+		return nil, false, errSaslMalformed
+	}
+	digest, err := hex.DecodeString(string(t[1]))
+	if err != nil {
+		return nil, false, errSaslMalformed
+	}
+	m.login = string(t[0])
+	user, err := UserGetByLogin(m.login)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, false, errSaslAuthFailed
+		}
+		return nil, false, err
+	}
+	// no key (user created before CRAM-MD5 support)
+	if user.CramMd5Key == "" {
+		return nil, false, errSaslAuthFailed
+	}
+	expected, err := cramMd5Digest(user.CramMd5Key, m.challenge)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hmac.Equal(digest, expected) {
+		return nil, false, errSaslAuthFailed
+	}
+	m.user = user
+	return nil, true, nil
+}
+
+func (m *saslCramMd5) authUser() *User {
+	return m.user
+}
+
+func (m *saslCramMd5) authLogin() string {
+	return m.login
+}
+
+// cramMd5Key returns HMAC-MD5 inner and outer contexts for passwd
+func cramMd5Key(passwd string) (string, error) {
+	key := []byte(passwd)
+	if len(key) > 64 {
+		h := md5.Sum(key)
+		key = h[:]
+	}
+	ipad := make([]byte, 64)
+	opad := make([]byte, 64)
+	copy(ipad, key)
+	copy(opad, key)
+	for i := range ipad {
+		ipad[i] ^= 0x36
+		opad[i] ^= 0x5c
+	}
+	contexts := []string{}
+	for _, pad := range [][]byte{ipad, opad} {
+		h := md5.New()
+		h.Write(pad)
+		state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		contexts = append(contexts, base64.StdEncoding.EncodeToString(state))
+	}
+	return strings.Join(contexts, "$"), nil
+}
+
+// cramMd5Digest returns HMAC-MD5 of challenge from precomputed contexts
+func cramMd5Digest(key string, challenge []byte) ([]byte, error) {
+	contexts := strings.Split(key, "$")
+	if len(contexts) != 2 {
+		return nil, errors.New("bad CRAM-MD5 key format")
+	}
+	sum := challenge
+	for _, context := range contexts {
+		state, err := base64.StdEncoding.DecodeString(context)
+		if err != nil {
+			return nil, err
+		}
+		h := md5.New()
+		if err = h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, err
+		}
+		h.Write(sum)
+		sum = h.Sum(nil)
+	}
+	return sum, nil
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OAUTHBEARER (RFC 7628) and XOAUTH2 (Google)
+// Bearer tokens must be JWT signed by a key from the configured JWKS file
+type saslOauth struct {
+	xoauth2 bool
+	failed  bool
+	login   string
+	user    *User
+}
+
+func (m *saslOauth) next(response []byte) (challenge []byte, done bool, err error) {
+	// client ack of error challenge
+	if m.failed {
+		return nil, false, errSaslAuthFailed
+	}
+	if response == nil {
+		return []byte{}, false, nil
+	}
+
+	var login, token string
+	if m.xoauth2 {
+		login, token, err = oauthParseXoauth2(response)
+	} else {
+		login, token, err = oauthParseOauthBearer(response)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	m.login = login
+
+	m.user, err = oauthGetUser(login, token)
+	if err != nil {
+		if err != errSaslAuthFailed {
+			return nil, false, err
+		}
+		// RFC 7628 3.2.2: error is sent as a challenge, client must reply
+		// with a dummy response before we fail
+		m.failed = true
+		if m.xoauth2 {
+			return []byte(`{"status":"401","schemes":"bearer"}`), false, nil
+		}
+		return []byte(`{"status":"invalid_token","schemes":"bearer"}`), false, nil
+	}
+	return nil, true, nil
+}
+
+func (m *saslOauth) authUser() *User {
+	return m.user
+}
+
+func (m *saslOauth) authLogin() string {
+	return m.login
+}
+
+// oauthParseXoauth2 parses "user=" user "^Aauth=Bearer " token "^A^A"
+func oauthParseXoauth2(response []byte) (login, token string, err error) {
+	for _, kv := range bytes.Split(response, []byte{1}) {
+		t := strings.SplitN(string(kv), "=", 2)
+		if len(t) != 2 {
+			continue
+		}
+		switch t[0] {
+		case "user":
+			login = t[1]
+		case "auth":
+			token = t[1]
+		}
+	}
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		return "", "", errSaslMalformed
+	}
+	return login, strings.TrimSpace(token[7:]), nil
+}
+
+// oauthParseOauthBearer parses gs2-header "^A" "auth=Bearer " token "^A^A"
+func oauthParseOauthBearer(response []byte) (login, token string, err error) {
+	t := bytes.SplitN(response, []byte{1}, 2)
+	if len(t) != 2 {
+		return "", "", errSaslMalformed
+	}
+	// gs2 header: "n,a=user,"
+	gs2 := strings.Split(string(t[0]), ",")
+	if len(gs2) < 2 || (gs2[0] != "n" && gs2[0] != "y") {
+		return "", "", errSaslMalformed
+	}
+	if strings.HasPrefix(gs2[1], "a=") {
+		login = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(gs2[1][2:])
+	}
+	for _, kv := range bytes.Split(t[1], []byte{1}) {
+		p := strings.SplitN(string(kv), "=", 2)
+		if len(p) == 2 && p[0] == "auth" {
+			token = p[1]
+		}
+	}
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		return "", "", errSaslMalformed
+	}
+	return login, strings.TrimSpace(token[7:]), nil
+}
+
+// oauthGetUser validates token and returns user
+// if login is not empty it must match the token user claim
+func oauthGetUser(login, token string) (*User, error) {
+	claims, err := JWTValidate(token, Cfg.GetSmtpdAuthOauthJwksFile(), Cfg.GetSmtpdAuthOauthIssuer(), Cfg.GetSmtpdAuthOauthAudience())
+	if err != nil {
+		Logger.Info("smtpd - oauth token rejected: " + err.Error())
+		return nil, errSaslAuthFailed
+	}
+	claimLogin := claims.GetString(Cfg.GetSmtpdAuthOauthUserClaim())
+	if claimLogin == "" || (login != "" && !strings.EqualFold(login, claimLogin)) {
+		return nil, errSaslAuthFailed
+	}
+	user, err := UserGetByLogin(claimLogin)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errSaslAuthFailed
+		}
+		return nil, err
+	}
+	return user, nil
+}
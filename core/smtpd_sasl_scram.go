@@ -0,0 +1,199 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramIterations PBKDF2 iterations used for new SCRAM-SHA-256 keys
+const scramIterations = 4096
+
+// SCRAM-SHA-256 (RFC 5802, RFC 7677) without channel binding
+type saslScramSha256 struct {
+	step            int
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	login           string
+	user            *User
+	storedKey       []byte
+	serverKey       []byte
+}
+
+func (m *saslScramSha256) next(response []byte) (challenge []byte, done bool, err error) {
+	switch m.step {
+	// client-first-message
+	case 0:
+		if response == nil {
+			return []byte{}, false, nil
+		}
+		m.step++
+		return m.clientFirst(string(response))
+	// client-final-message
+	case 1:
+		m.step++
+		return m.clientFinal(string(response))
+	// client ack of server-final-message
+	default:
+		if len(response) != 0 {
+			return nil, false, errSaslMalformed
+		}
+		return nil, true, nil
+	}
+}
+
+func (m *saslScramSha256) authUser() *User {
+	return m.user
+}
+
+func (m *saslScramSha256) authLogin() string {
+	return m.login
+}
+
+// clientFirst handles "n,,n=user,r=nonce"
+func (m *saslScramSha256) clientFirst(msg string) (challenge []byte, done bool, err error) {
+	t := strings.SplitN(msg, ",", 3)
+	if len(t) != 3 {
+		return nil, false, errSaslMalformed
+	}
+	// gs2 header: channel binding is not supported, authzid is not supported
+	if (t[0] != "n" && t[0] != "y") || t[1] != "" {
+		return nil, false, errSaslMalformed
+	}
+	m.clientFirstBare = t[2]
+	attrs := scramParseAttributes(m.clientFirstBare)
+	login, ok := attrs["n"]
+	if !ok || attrs["r"] == "" {
+		return nil, false, errSaslMalformed
+	}
+	login = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(login)
+	m.login = login
+
+	user, err := UserGetByLogin(login)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, false, errSaslAuthFailed
+		}
+		return nil, false, err
+	}
+	// no keys (user created before SCRAM support)
+	if user.ScramSha256 == "" {
+		return nil, false, errSaslAuthFailed
+	}
+	iterations, salt, storedKey, serverKey, err := scramParseKeys(user.ScramSha256)
+	if err != nil {
+		return nil, false, err
+	}
+	m.user = user
+	m.storedKey = storedKey
+	m.serverKey = serverKey
+
+	serverNonce := make([]byte, 18)
+	if _, err = rand.Read(serverNonce); err != nil {
+		return nil, false, err
+	}
+	m.nonce = attrs["r"] + base64.StdEncoding.EncodeToString(serverNonce)
+	m.serverFirst = "r=" + m.nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=" + strconv.Itoa(iterations)
+	return []byte(m.serverFirst), false, nil
+}
+
+// clientFinal handles "c=biws,r=nonce,p=proof"
+func (m *saslScramSha256) clientFinal(msg string) (challenge []byte, done bool, err error) {
+	i := strings.LastIndex(msg, ",p=")
+	if i == -1 {
+		return nil, false, errSaslMalformed
+	}
+	withoutProof := msg[:i]
+	attrs := scramParseAttributes(msg)
+	// channel binding: base64("n,,") or base64("y,,")
+	if attrs["c"] != "biws" && attrs["c"] != "eSws" {
+		return nil, false, errSaslMalformed
+	}
+	if attrs["r"] != m.nonce {
+		return nil, false, errSaslAuthFailed
+	}
+	proof, err := base64.StdEncoding.DecodeString(attrs["p"])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, false, errSaslMalformed
+	}
+	authMessage := []byte(m.clientFirstBare + "," + m.serverFirst + "," + withoutProof)
+	clientSignature := scramHmac(m.storedKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if !hmac.Equal(storedKey[:], m.storedKey) {
+		m.user = nil
+		return nil, false, errSaslAuthFailed
+	}
+	serverSignature := scramHmac(m.serverKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), false, nil
+}
+
+// scramParseAttributes parses "a=value,b=value"
+func scramParseAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		attrs[attr[:1]] = attr[2:]
+	}
+	return attrs
+}
+
+// scramHmac returns HMAC-SHA-256
+func scramHmac(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// scramSha256Keys returns SCRAM-SHA-256 keys for passwd, in RFC 5803 format:
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func scramSha256Keys(passwd string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	saltedPassword := pbkdf2.Key([]byte(passwd), salt, scramIterations, sha256.Size, sha256.New)
+	storedKey := sha256.Sum256(scramHmac(saltedPassword, []byte("Client Key")))
+	serverKey := scramHmac(saltedPassword, []byte("Server Key"))
+	return "SCRAM-SHA-256$" + strconv.Itoa(scramIterations) + ":" + base64.StdEncoding.EncodeToString(salt) +
+		"$" + base64.StdEncoding.EncodeToString(storedKey[:]) + ":" + base64.StdEncoding.EncodeToString(serverKey), nil
+}
+
+// scramParseKeys parses keys stored by scramSha256Keys
+func scramParseKeys(keys string) (iterations int, salt, storedKey, serverKey []byte, err error) {
+	t := strings.Split(keys, "$")
+	if len(t) != 3 || t[0] != "SCRAM-SHA-256" {
+		err = errors.New("bad SCRAM-SHA-256 keys format")
+		return
+	}
+	p1 := strings.Split(t[1], ":")
+	p2 := strings.Split(t[2], ":")
+	if len(p1) != 2 || len(p2) != 2 {
+		err = errors.New("bad SCRAM-SHA-256 keys format")
+		return
+	}
+	if iterations, err = strconv.Atoi(p1[0]); err != nil {
+		return
+	}
+	if salt, err = base64.StdEncoding.DecodeString(p1[1]); err != nil {
+		return
+	}
+	if storedKey, err = base64.StdEncoding.DecodeString(p2[0]); err != nil {
+		return
+	}
+	serverKey, err = base64.StdEncoding.DecodeString(p2[1])
+	return
+}
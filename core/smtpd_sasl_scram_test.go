@@ -0,0 +1,84 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func Test_scramSha256Keys(t *testing.T) {
+	keys, err := scramSha256Keys("pencil")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(keys, "SCRAM-SHA-256$4096:"))
+	iterations, salt, storedKey, serverKey, err := scramParseKeys(keys)
+	assert.NoError(t, err)
+	assert.Equal(t, scramIterations, iterations)
+	assert.Len(t, salt, 16)
+	saltedPassword := pbkdf2.Key([]byte("pencil"), salt, iterations, sha256.Size, sha256.New)
+	expected := sha256.Sum256(scramHmac(saltedPassword, []byte("Client Key")))
+	assert.Equal(t, expected[:], storedKey)
+	assert.Equal(t, scramHmac(saltedPassword, []byte("Server Key")), serverKey)
+
+	// salt is random
+	other, err := scramSha256Keys("pencil")
+	assert.NoError(t, err)
+	assert.NotEqual(t, keys, other)
+
+	for _, bad := range []string{"", "SCRAM-SHA-1$4096:c2FsdA==$a2V5:a2V5", "SCRAM-SHA-256$4096:c2FsdA==", "SCRAM-SHA-256$x:c2FsdA==$a2V5:a2V5", "SCRAM-SHA-256$4096:!$a2V5:a2V5", "SCRAM-SHA-256$4096$a2V5:a2V5"} {
+		_, _, _, _, err = scramParseKeys(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// RFC 7677 section 3 exchange
+func Test_saslScramSha256ClientFinal(t *testing.T) {
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	saltedPassword := pbkdf2.Key([]byte("pencil"), salt, 4096, sha256.Size, sha256.New)
+	storedKey := sha256.Sum256(scramHmac(saltedPassword, []byte("Client Key")))
+	nonce := "rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	newMech := func() *saslScramSha256 {
+		return &saslScramSha256{
+			step:            1,
+			clientFirstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO",
+			serverFirst:     "r=" + nonce + ",s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			nonce:           nonce,
+			user:            &User{Login: "user"},
+			storedKey:       storedKey[:],
+			serverKey:       scramHmac(saltedPassword, []byte("Server Key")),
+		}
+	}
+
+	m := newMech()
+	challenge, done, err := m.next([]byte("c=biws,r=" + nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", string(challenge))
+	_, done, err = m.next([]byte{})
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.NotNil(t, m.authUser())
+
+	tests := []struct {
+		msg string
+		err error
+	}{
+		{"c=biws,r=" + nonce + ",p=AHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", errSaslAuthFailed},
+		{"c=biws,r=other,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", errSaslAuthFailed},
+		{"c=cD10bHMtdW5pcXVlLCw=,r=" + nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", errSaslMalformed},
+		{"c=biws,r=" + nonce, errSaslMalformed},
+		{"c=biws,r=" + nonce + ",p=dHzb", errSaslMalformed},
+	}
+	for _, test := range tests {
+		m = newMech()
+		_, _, err = m.next([]byte(test.msg))
+		assert.Equal(t, test.err, err, test.msg)
+	}
+	// a bad proof doesn't authenticate
+	m = newMech()
+	m.next([]byte(tests[0].msg))
+	assert.Nil(t, m.authUser())
+}
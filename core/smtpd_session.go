@@ -2,13 +2,11 @@ package core
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"net"
 	"net/mail"
-	"path"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -38,6 +36,8 @@ type SMTPServerSession struct {
 	tls              bool
 	tlsVersion       string
 	RelayGranted     bool
+	network          *RelayIpOk // relay network of the client IP, see getNetwork
+	networkLoaded    bool
 	user             *User
 	seenHelo         bool
 	seenMail         bool
@@ -55,6 +55,15 @@ type SMTPServerSession struct {
 	startAt          time.Time
 	exiting          bool
 	CurrentRawMail   []byte
+	rateLimitIpKey   string
+	rateLimitUserKey string
+	dsn              dsn
+	// peerAddr is the address of the peer (a frontend when using XCLIENT)
+	peerAddr     net.Addr
+	xclientName  string
+	xclientHelo  string
+	xclientProto string
+	xforward     map[string]string
 }
 
 // NewSMTPServerSession returns a new SMTP session
@@ -73,6 +82,7 @@ func NewSMTPServerSession(conn net.Conn, isTLS bool) (sss *SMTPServerSession, er
 	}
 
 	sss.remoteAddr = conn.RemoteAddr().String()
+	sss.peerAddr = conn.RemoteAddr()
 	//sss.logger = Log
 
 	sss.RelayGranted = false
@@ -154,6 +164,28 @@ func (s *SMTPServerSession) Reset() {
 	s.resetTimeout()
 }
 
+// getNetwork returns the relay network entry of the client IP (nil if
+// none), its policy (max size, scan) applies to all messages of the
+// session, whatever the way relay is granted
+func (s *SMTPServerSession) getNetwork() (*RelayIpOk, error) {
+	if s.networkLoaded {
+		return s.network, nil
+	}
+	network, err := RelayIpGetByAddr(s.Conn.RemoteAddr())
+	if err != nil {
+		return nil, err
+	}
+	s.network = network
+	s.networkLoaded = true
+	return network, nil
+}
+
+// isOutbound returns true if the current message is sent by an
+// authenticated user or from a relay network
+func (s *SMTPServerSession) isOutbound() bool {
+	return s.user != nil || (s.network != nil && s.network.SenderAllowed(s.Envelope.MailFrom))
+}
+
 // Out : to client
 func (s *SMTPServerSession) Out(msg string) {
 	s.Conn.Write([]byte(msg + "\r\n"))
@@ -220,17 +252,34 @@ func (s *SMTPServerSession) smtpGreeting() {
 	}
 	s.Log(fmt.Sprintf("starting new transaction %d/%d", SmtpSessionsCount, Cfg.GetSmtpdConcurrencyIncoming()))
 
+	// banned after too many auth failures (mx listeners do not offer AUTH)
+	if s.dsn.role != SmtpdRoleMx && s.authIsBanned() {
+		s.Log("GREETING - IP banned after too many auth failures")
+		s.Out("421 4.7.0 too many authentication failures from your IP, try again later " + s.uuid)
+		s.SMTPResponseCode = 421
+		s.ExitAsap()
+		return
+	}
+
+	// rate limit per IP
+	if Cfg.GetSmtpdRateLimitEnabled() {
+		var reason string
+		s.rateLimitIpKey, reason = smtpdRateLimiter.openSession(RateLimitScopeIp, ipFromAddr(s.Conn.RemoteAddr()).String())
+		if s.rateLimitIpKey == "" {
+			s.Log("GREETING - rate limit reached: " + reason)
+			s.Out("421 4.7.0 " + reason + " from your IP, try again later " + s.uuid)
+			s.SMTPResponseCode = 421
+			s.ExitAsap()
+			return
+		}
+	}
+
 	// Plugins
 	if execSMTPdPlugins("connect", s) {
 		return
 	}
 
-	o := "220 " + Cfg.GetMe() + " ESMTP"
-	if !Cfg.GetHideServerSignature() {
-		o += " - tmail " + Version
-	}
-	o += " - " + s.uuid
-	s.Out(o)
+	s.Out(s.greetingLine())
 	if s.tls {
 		s.Log("secured via " + tlsGetVersion(s.connTLS.ConnectionState().Version) + " " + tlsGetCipherSuite(s.connTLS.ConnectionState().CipherSuite))
 	}
@@ -256,7 +305,7 @@ func (s *SMTPServerSession) heloBase(msg []string) (cont bool) {
 	if len(msg) > 1 {
 		if Cfg.getRFCHeloNeedsFqnOrAddress() {
 			// if it's not an address check for fqn
-			if net.ParseIP(msg[1]) == nil {
+			if parseAddressLiteral(msg[1]) == nil {
 				ok, err := isFQN(msg[1])
 				if err != nil {
 					s.Log("fail to do lookup on helo host. " + err.Error())
@@ -299,13 +348,29 @@ func (s *SMTPServerSession) smtpEhlo(msg []string) {
 		// Extensions
 		// Size
 		s.Out(fmt.Sprintf("250-SIZE %d", Cfg.GetSmtpdMaxDataBytes()))
-		s.Out("250-X-PEPPER")
 		// STARTTLS
 		if !s.tls {
 			s.Out("250-STARTTLS")
 		}
+		// XCLIENT/XFORWARD for trusted frontends
+		if s.xclientIsTrusted() {
+			s.Out("250-XCLIENT " + strings.Join(xclientAttributes, " "))
+		}
+		if s.xforwardIsTrusted() {
+			s.Out("250-XFORWARD " + strings.Join(xforwardAttributes, " "))
+		}
 		// Auth
-		s.Out("250 AUTH PLAIN")
+		// not on mx, only over TLS on submission
+		mechanisms := []string{}
+		if s.dsn.role != SmtpdRoleMx && (s.tls || !s.dsn.isSubmission()) {
+			mechanisms = s.saslAllowedMechanisms()
+		}
+		if len(mechanisms) == 0 {
+			s.Out("250 X-PEPPER")
+		} else {
+			s.Out("250-X-PEPPER")
+			s.Out("250 AUTH " + strings.Join(mechanisms, " "))
+		}
 	}
 }
 
@@ -324,6 +389,15 @@ func (s *SMTPServerSession) smtpMailFrom(msg []string) {
 		s.SMTPResponseCode = 503
 		return
 	}
+
+	// submission: AUTH required
+	if s.dsn.isSubmission() && s.user == nil {
+		s.Log("MAIL - authentication required on submission listener")
+		s.pause(2)
+		s.Out("530 5.7.0 Authentication required")
+		s.SMTPResponseCode = 530
+		return
+	}
 	msgLen := len(msg)
 	// mail from ?
 	if msgLen == 1 || !strings.HasPrefix(strings.ToLower(msg[1]), "from:") || msgLen > 4 {
@@ -452,6 +526,24 @@ func (s *SMTPServerSession) smtpMailFrom(msg []string) {
 			return
 		}
 	}
+	// address rewriting
+	if !s.rewriteEnvelope(&s.Envelope.MailFrom, RewriteKindSender) {
+		return
+	}
+
+	// submission: sender (rewritten) must match authenticated user
+	if !s.submissionCheckMailFrom() {
+		return
+	}
+
+	// rate limit
+	if !s.rateLimitTake("msg") {
+		s.Log("MAIL - message rate limit reached")
+		s.Out("451 4.7.1 message rate limit exceeded, try again later")
+		s.SMTPResponseCode = 451
+		return
+	}
+
 	// Plugin - hook "mailpost"
 	execSMTPdPlugins("mailpost", s)
 	s.seenMail = true
@@ -542,6 +634,19 @@ func (s *SMTPServerSession) smtpRcptTo(msg []string) {
 	// make domain part insensitive
 	s.LastRcptTo = localDom[0] + "@" + strings.ToLower(localDom[1])
 
+	// address rewriting
+	if !s.rewriteEnvelope(&s.LastRcptTo, RewriteKindRecipient) {
+		return
+	}
+	localDom = strings.Split(s.LastRcptTo, "@")
+	if len(localDom) != 2 {
+		s.Log("RCPT - bad rewritten address: " + s.LastRcptTo)
+		s.Out("451 4.3.0 oops, problem with address rewriting")
+		s.SMTPResponseCode = 451
+		return
+	}
+	s.LastRcptTo = localDom[0] + "@" + strings.ToLower(localDom[1])
+
 	// Relay granted for this recipient ?
 	s.RelayGranted = false
 
@@ -584,23 +689,34 @@ func (s *SMTPServerSession) smtpRcptTo(msg []string) {
 					}
 					return
 				}
+			} else if rcpthost.RcptVerify != "" && !s.rcptVerifyRelay(&rcpthost) {
+				// relay host: rejected by the backend
+				return
 			}
 		}
 	}
 	// User authentified & access granted ?
-	if !s.RelayGranted && s.user != nil {
+	// no relay on mx listeners
+	if !s.RelayGranted && s.user != nil && s.dsn.role != SmtpdRoleMx {
 		s.RelayGranted = s.user.AuthRelay
 	}
 
 	// Remote IP authorised ?
-	if !s.RelayGranted {
-		s.RelayGranted, err = IpCanRelay(s.Conn.RemoteAddr())
+	if !s.RelayGranted && s.dsn.role != SmtpdRoleMx {
+		network, err := s.getNetwork()
 		if err != nil {
 			s.LogError("RCPT - relay access failed while checking if IP is allowed to relay. " + err.Error())
 			s.Out("455 4.3.0 oops, problem with relay access")
 			s.SMTPResponseCode = 455
 			return
 		}
+		if network != nil {
+			if network.SenderAllowed(s.Envelope.MailFrom) {
+				s.RelayGranted = true
+			} else {
+				s.Log("RCPT - sender " + s.Envelope.MailFrom + " not allowed to relay from " + network.Ip)
+			}
+		}
 	}
 
 	// Relay denied
@@ -614,6 +730,12 @@ func (s *SMTPServerSession) smtpRcptTo(msg []string) {
 
 	// Check if there is already this recipient
 	if !IsStringInSlice(s.LastRcptTo, s.Envelope.RcptTo) {
+		if !s.rateLimitTake("rcpt") {
+			s.Log("RCPT - recipient rate limit reached")
+			s.Out("451 4.7.1 recipient rate limit exceeded, try again later")
+			s.SMTPResponseCode = 451
+			return
+		}
 		s.Envelope.RcptTo = append(s.Envelope.RcptTo, s.LastRcptTo)
 		s.Log("RCPT - + " + s.LastRcptTo)
 	}
@@ -924,9 +1046,26 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 		}
 	}
 
+	// relay network policy
+	network, err := s.getNetwork()
+	if err != nil {
+		s.LogError("MAIL - unable to get relay network of client IP - " + err.Error())
+		s.Out("451 4.3.0 oops, problem with relay network")
+		s.SMTPResponseCode = 451
+		s.Reset()
+		return
+	}
+	if network != nil && network.MaxSize != 0 && int64(s.dataBytes) > network.MaxSize {
+		s.Log(fmt.Sprintf("MAIL - Message size (%d) exceeds max size (%d) of relay network %s.", s.dataBytes, network.MaxSize, network.Ip))
+		s.Out("552 5.3.4 sorry, that message size exceeds the limit for your network")
+		s.SMTPResponseCode = 552
+		s.Reset()
+		return
+	}
+
 	// scan
 	// clamav
-	if Cfg.GetSmtpdClamavEnabled() {
+	if Cfg.GetSmtpdClamavEnabled() && (network == nil || !network.SkipScan) {
 		found, virusName, err := NewClamav().ScanStream(bytes.NewReader(s.CurrentRawMail))
 		Logger.Debug("clamav scan result", found, virusName, err)
 		if err != nil {
@@ -937,6 +1076,24 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 			s.Reset()
 			return
 		}
+		if found && Cfg.GetSmtpdClamavQuarantine() {
+			authUser := ""
+			if s.user != nil {
+				authUser = s.user.Login
+			}
+			q, err := quarantineAdd(&s.CurrentRawMail, s.Envelope, authUser, s.isOutbound(), QuarantineReasonVirus, "clamav: "+virusName)
+			if err != nil {
+				s.LogError("MAIL - unable to quarantine message infected by " + virusName + ": " + err.Error())
+				s.Out("451 4.3.0 oops, problem with quarantine")
+				s.SMTPResponseCode = 451
+			} else {
+				s.Log(fmt.Sprintf("MAIL - infected by %s, quarantined as %d", virusName, q.Id))
+				s.Out("250 2.0.0 Ok: quarantined")
+				s.SMTPResponseCode = 250
+			}
+			s.Reset()
+			return
+		}
 		if found {
 			s.Out("554 5.7.1 message infected by " + virusName)
 			s.SMTPResponseCode = 554
@@ -960,13 +1117,8 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 	s.Log("message-id:", string(HeaderMessageID))
 
 	// Add recieved header
-	remoteIP := strings.Split(s.Conn.RemoteAddr().String(), ":")[0]
-	remoteHost := "no reverse"
-	remoteHosts, err := net.LookupAddr(remoteIP)
-	if err == nil {
-		remoteHost = remoteHosts[0]
-	}
-	localIP := strings.Split(s.Conn.LocalAddr().String(), ":")[0]
+	helo, remoteHost, remoteIP, proto := s.receivedFrom()
+	localIP := ipFromAddr(s.Conn.LocalAddr()).String()
 	localHost := "no reverse"
 	localHosts, err := net.LookupAddr(localIP)
 	if err == nil {
@@ -975,11 +1127,11 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 	recieved := "Received: from "
 
 	// helo
-	if len(s.helo) != 0 {
-		recieved += fmt.Sprintf("%s ", s.helo)
+	if len(helo) != 0 {
+		recieved += fmt.Sprintf("%s ", helo)
 	}
 
-	recieved += fmt.Sprintf("(%s [%s])", remoteHost, remoteIP)
+	recieved += fmt.Sprintf("(%s %s)", remoteHost, addressLiteral(remoteIP))
 
 	// Authentified
 	if s.user != nil {
@@ -987,11 +1139,13 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 	}
 
 	// local
-	recieved += fmt.Sprintf(" by %s (%s)", localIP, localHost)
+	recieved += fmt.Sprintf(" by %s (%s)", addressLiteral(localIP), localHost)
 
 	// Proto
 	if s.tls {
 		recieved += " with ESMTPS " + tlsGetVersion(s.connTLS.ConnectionState().Version) + " " + tlsGetCipherSuite(s.connTLS.ConnectionState().CipherSuite) + "; "
+	} else if proto != "" {
+		recieved += " with " + proto + "; "
 	} else {
 		recieved += " whith SMTP; "
 	}
@@ -1007,8 +1161,19 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 	s.CurrentRawMail = append(h, s.CurrentRawMail...)
 	recieved = ""
 
+	// submission: From header must match authenticated user
+	if !s.submissionCheckFromHeader() {
+		s.Reset()
+		return
+	}
+
 	s.CurrentRawMail = append([]byte("X-Env-From: "+s.Envelope.MailFrom+"\r\n"), s.CurrentRawMail...)
 
+	// content rules
+	if !s.contentPolicy() {
+		return
+	}
+
 	// Plugins
 	if execSMTPdPlugins("data", s) {
 		return
@@ -1022,7 +1187,8 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 
 	// Plugins
 	execSMTPdPlugins("beforequeue", s)
-	id, err := QueueAddMessage(&s.CurrentRawMail, s.Envelope, authUser)
+
+	id, err := queueAddMessage(&s.CurrentRawMail, s.Envelope, authUser, authUser, s.isOutbound())
 	if err != nil {
 		s.LogError("MAIL - unable to put message in queue -", err.Error())
 		s.Out("451 temporary queue error")
@@ -1031,6 +1197,7 @@ func (s *SMTPServerSession) smtpData(msg []string) {
 		return
 	}
 	s.Log("message queued as", id)
+	s.xforward = nil
 	s.Out(fmt.Sprintf("250 2.0.0 Ok: queued %s", id))
 	s.SMTPResponseCode = 250
 	s.Reset()
@@ -1054,7 +1221,7 @@ func (s *SMTPServerSession) smtpStartTLS() {
 		s.SMTPResponseCode = 454
 		return
 	}
-	cert, err := tls.LoadX509KeyPair(path.Join(GetBasePath(), "ssl/server.crt"), path.Join(GetBasePath(), "ssl/server.key"))
+	tlsConfig, err := smtpdCerts.tlsConfig()
 	if err != nil {
 		msg := "TLS failed unable to load server keys: " + err.Error()
 		s.LogError(msg)
@@ -1063,18 +1230,12 @@ func (s *SMTPServerSession) smtpStartTLS() {
 		return
 	}
 
-	tlsConfig := tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		InsecureSkipVerify: true,
-	}
-	tlsConfig.Rand = rand.Reader
-
 	s.Out("220 Ready to start TLS nego")
 	s.SMTPResponseCode = 220
 
 	//var tlsConn *tls.Conn
 	//tlsConn = tls.Server(client.socket, TLSconfig)
-	s.connTLS = tls.Server(s.Conn, &tlsConfig)
+	s.connTLS = tls.Server(s.Conn, tlsConfig)
 	// run a handshake
 	// errors.New("tls: unsupported SSLv2 handshake received")
 	err = s.connTLS.Handshake()
@@ -1097,46 +1258,36 @@ func (s *SMTPServerSession) smtpStartTLS() {
 }
 
 // SMTP AUTH
-// Return boolean closeCon
-// Pour le moment in va juste implémenter PLAIN
+// AUTH mechanism [initial-response] (RFC 4954)
 func (s *SMTPServerSession) smtpAuth(rawMsg string) {
 	defer s.recoverOnPanic()
-	// TODO si pas TLS
-	//var authType, user, passwd string
-	//TODO si pas plain
-
-	//
-	splitted := strings.Split(rawMsg, " ")
-	var encoded string
-	if len(splitted) == 3 {
-		encoded = splitted[2]
-	} else if len(splitted) == 2 {
-		// refactor: readline function
-		var line []byte
-		ch := make([]byte, 1)
-		// return a
-		s.Out("334 ")
-		s.SMTPResponseCode = 334
-		// get encoded by reading next line
-		for {
-			s.resetTimeout()
-			_, err := s.Conn.Read(ch)
-			if err != nil {
-				s.Out("501 malformed auth input (#5.5.4)")
-				s.SMTPResponseCode = 501
-				s.Log("error reading auth err:" + err.Error())
-				s.ExitAsap()
-				return
-			}
-			if ch[0] == 10 {
-				s.timer.Stop()
-				s.LogDebug("< " + string(line))
-				break
-			}
-			line = append(line, ch[0])
-		}
+	// no AUTH on mx
+	if s.dsn.role == SmtpdRoleMx {
+		s.Log("auth - AUTH not available on mx listener")
+		s.Out("503 5.5.1 AUTH not available on this port")
+		s.SMTPResponseCode = 503
+		return
+	}
+	// submission: AUTH only over TLS
+	if s.dsn.isSubmission() && !s.tls {
+		s.Log("auth - AUTH attempt without TLS on submission listener")
+		s.Out("538 5.7.11 Encryption required for requested authentication mechanism")
+		s.SMTPResponseCode = 538
+		return
+	}
+	if s.user != nil {
+		s.Out("503 5.5.1 already authenticated")
+		s.SMTPResponseCode = 503
+		return
+	}
+	if s.seenMail {
+		s.Out("503 5.5.1 AUTH not permitted during a mail transaction")
+		s.SMTPResponseCode = 503
+		return
+	}
 
-	} else {
+	splitted := strings.Fields(rawMsg)
+	if len(splitted) != 2 && len(splitted) != 3 {
 		s.Out("501 malformed auth input (#5.5.4)")
 		s.SMTPResponseCode = 501
 		s.Log("malformed auth input: " + rawMsg)
@@ -1144,60 +1295,168 @@ func (s *SMTPServerSession) smtpAuth(rawMsg string) {
 		return
 	}
 
-	// decode  "authorize-id\0userid\0passwd\0"
-	authData, err := base64.StdEncoding.DecodeString(encoded)
-	if err != nil {
-		s.Out("501 malformed auth input (#5.5.4)")
-		s.SMTPResponseCode = 501
-		s.Log("malformed auth input: " + rawMsg + " err:" + err.Error())
-		s.ExitAsap()
+	// mechanism allowed ?
+	mechanismName := strings.ToUpper(splitted[1])
+	if !IsStringInSlice(mechanismName, s.saslAllowedMechanisms()) {
+		if _, ok := saslMechanisms[mechanismName]; ok && !s.tls {
+			s.Log("auth - mechanism " + mechanismName + " needs TLS")
+			s.Out("538 5.7.11 Encryption required for requested authentication mechanism")
+			s.SMTPResponseCode = 538
+			return
+		}
+		s.Log("auth - unsupported mechanism " + mechanismName)
+		s.Out("504 5.5.4 unrecognized authentication type")
+		s.SMTPResponseCode = 504
 		return
 	}
+	mechanism := saslMechanisms[mechanismName].init(s)
 
-	// split
-	t := make([][]byte, 3)
-	i := 0
-	for _, b := range authData {
-		if b == 0 {
-			i++
-			continue
+	// initial response ("=" means empty)
+	var response []byte
+	var err error
+	if len(splitted) == 3 {
+		response = []byte{}
+		if splitted[2] != "=" {
+			if response, err = base64.StdEncoding.DecodeString(splitted[2]); err != nil {
+				s.Out("501 malformed auth input (#5.5.4)")
+				s.SMTPResponseCode = 501
+				s.Log("malformed auth input: " + rawMsg + " err:" + err.Error())
+				s.ExitAsap()
+				return
+			}
 		}
-		t[i] = append(t[i], b)
 	}
-	//authId := string(t[0])
-	authLogin := string(t[1])
-	authPasswd := string(t[2])
 
-	s.user, err = UserGet(authLogin, authPasswd)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			s.Out("535 authentication failed - No such user (#5.7.1)")
-			s.SMTPResponseCode = 535
-			s.Log("auth failed: " + rawMsg + " err:" + err.Error())
+	// challenge/response
+	for {
+		challenge, done, err := mechanism.next(response)
+		// locked out users are rejected as if credentials were bad
+		if err == nil && done {
+			var locked bool
+			if locked, err = s.authIsLocked(mechanism.authUser().Login); err == nil && locked {
+				s.Log("auth - user " + mechanism.authUser().Login + " is locked out")
+				err = errSaslAuthFailed
+			}
+		}
+		if err != nil {
+			switch err {
+			case errSaslAuthFailed:
+				s.pause(s.authFailure(mechanismName, mechanism.authLogin()))
+				s.Out("535 authentication failed (#5.7.8)")
+				s.SMTPResponseCode = 535
+				s.Log("auth " + mechanismName + " failed for login " + mechanism.authLogin())
+			case errSaslMalformed:
+				s.Out("501 malformed auth input (#5.5.4)")
+				s.SMTPResponseCode = 501
+				s.Log("auth " + mechanismName + " malformed input")
+			default:
+				s.Out("454 oops, problem with auth (#4.3.0)")
+				s.SMTPResponseCode = 454
+				s.LogError("auth " + mechanismName + " err:" + err.Error())
+			}
 			s.ExitAsap()
 			return
 		}
-		if err.Error() == "crypto/bcrypt: hashedPassword is not the hash of the given password" {
-			s.Out("535 authentication failed (#5.7.1)")
-			s.SMTPResponseCode = 535
-			s.Log("auth failed: " + rawMsg + " err:" + err.Error())
+		if done {
+			break
+		}
+		s.Out("334 " + base64.StdEncoding.EncodeToString(challenge))
+		s.SMTPResponseCode = 334
+		line, err := s.readLine()
+		if err != nil {
+			s.Out("501 malformed auth input (#5.5.4)")
+			s.SMTPResponseCode = 501
+			s.Log("error reading auth err:" + err.Error())
+			s.ExitAsap()
+			return
+		}
+		// canceled by client
+		if line == "*" {
+			s.Out("501 5.0.0 authentication canceled")
+			s.SMTPResponseCode = 501
+			s.Log("auth " + mechanismName + " canceled by client")
+			return
+		}
+		if response, err = base64.StdEncoding.DecodeString(line); err != nil {
+			s.Out("501 malformed auth input (#5.5.4)")
+			s.SMTPResponseCode = 501
+			s.Log("malformed auth input: " + line + " err:" + err.Error())
+			s.ExitAsap()
+			return
+		}
+	}
+	s.user = mechanism.authUser()
+	s.authSuccess(s.user.Login)
+
+	// rate limit per user
+	if Cfg.GetSmtpdRateLimitEnabled() {
+		var reason string
+		s.rateLimitUserKey, reason = smtpdRateLimiter.openSession(RateLimitScopeUser, s.user.Login)
+		if s.rateLimitUserKey == "" {
+			s.Log("auth - rate limit reached for user " + s.user.Login + ": " + reason)
+			s.Out("421 4.7.0 " + reason + " for this user, try again later")
+			s.SMTPResponseCode = 421
+			s.user = nil
 			s.ExitAsap()
 			return
 		}
-		s.Out("454 oops, problem with auth (#4.3.0)")
-		s.SMTPResponseCode = 454
-		s.Log("ERROR auth " + rawMsg + " err:" + err.Error())
-		s.ExitAsap()
-		return
 	}
 	s.Log("auth succeed for user " + s.user.Login)
 	s.Out("235 ok, go ahead (#2.0.0)")
 	s.SMTPResponseCode = 235
 }
 
+// readLine reads a line from client (used during AUTH exchange)
+func (s *SMTPServerSession) readLine() (string, error) {
+	var line []byte
+	ch := make([]byte, 1)
+	for {
+		s.resetTimeout()
+		if _, err := s.Conn.Read(ch); err != nil {
+			return "", err
+		}
+		if ch[0] == LF {
+			s.timer.Stop()
+			break
+		}
+		line = append(line, ch[0])
+	}
+	s.LogDebug("< " + string(line))
+	return strings.TrimSpace(string(line)), nil
+}
+
+// rateLimitTake consumes a msg or rcpt token for remote IP and
+// authenticated user, returns false if one of the limits is reached
+func (s *SMTPServerSession) rateLimitTake(kind string) bool {
+	if !Cfg.GetSmtpdRateLimitEnabled() {
+		return true
+	}
+	take := smtpdRateLimiter.takeMsg
+	if kind == "rcpt" {
+		take = smtpdRateLimiter.takeRcpt
+	}
+	if !take(RateLimitScopeIp, ipFromAddr(s.Conn.RemoteAddr()).String()) {
+		return false
+	}
+	if s.user != nil && !take(RateLimitScopeUser, s.user.Login) {
+		return false
+	}
+	return true
+}
+
+// greetingLine returns 220 greeting
+func (s *SMTPServerSession) greetingLine() string {
+	o := "220 " + Cfg.GetMe() + " ESMTP"
+	if !Cfg.GetHideServerSignature() {
+		o += " - tmail " + Version
+	}
+	return o + " - " + s.uuid
+}
+
 // RSET SMTP ahandler
 func (s *SMTPServerSession) rset() {
 	s.Reset()
+	s.xforward = nil
 	s.Out("250 2.0.0 ok")
 	s.SMTPResponseCode = 250
 }
@@ -1278,6 +1537,10 @@ func (s *SMTPServerSession) handle() {
 						s.smtpStartTLS()
 					case "auth":
 						s.smtpAuth(strMsg)
+					case "xclient":
+						s.smtpXclient(splittedMsg)
+					case "xforward":
+						s.smtpXforward(splittedMsg)
 					case "rset":
 						s.rset()
 					case "noop":
@@ -1300,6 +1563,12 @@ func (s *SMTPServerSession) handle() {
 	}()
 	<-s.exitasap
 	s.Conn.Close()
+	if s.rateLimitIpKey != "" {
+		smtpdRateLimiter.closeSession(s.rateLimitIpKey)
+	}
+	if s.rateLimitUserKey != "" {
+		smtpdRateLimiter.closeSession(s.rateLimitUserKey)
+	}
 	s.Log("EOT")
 	s.exiting = false
 	return
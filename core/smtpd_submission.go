@@ -0,0 +1,91 @@
+package core
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/toorop/tmail/message"
+)
+
+// sender policies for submission listeners
+const (
+	// SubmissionSenderPolicyNone no check
+	SubmissionSenderPolicyNone = "none"
+	// SubmissionSenderPolicyEnforce MAIL FROM and From header must match authenticated user
+	SubmissionSenderPolicyEnforce = "enforce"
+	// SubmissionSenderPolicyRewrite MAIL FROM and From header are rewritten to authenticated user
+	SubmissionSenderPolicyRewrite = "rewrite"
+)
+
+// submissionSenderAddress returns the address authenticated user is allowed
+// to use as sender, or an empty string if there is no constraint
+// (login is not an email address)
+func (s *SMTPServerSession) submissionSenderAddress() string {
+	if s.user == nil || !s.dsn.isSubmission() || Cfg.GetSmtpdSubmissionSenderPolicy() == SubmissionSenderPolicyNone {
+		return ""
+	}
+	if _, err := mail.ParseAddress(s.user.Login); err != nil {
+		return ""
+	}
+	return strings.ToLower(s.user.Login)
+}
+
+// submissionCheckMailFrom checks (or rewrites) MAIL FROM against
+// authenticated user. Returns false if the sender is rejected
+func (s *SMTPServerSession) submissionCheckMailFrom() bool {
+	sender := s.submissionSenderAddress()
+	if sender == "" || strings.ToLower(s.Envelope.MailFrom) == sender {
+		return true
+	}
+	if Cfg.GetSmtpdSubmissionSenderPolicy() == SubmissionSenderPolicyRewrite {
+		s.Log("MAIL - sender " + s.Envelope.MailFrom + " rewritten to " + sender)
+		s.Envelope.MailFrom = sender
+		return true
+	}
+	s.Log("MAIL - sender " + s.Envelope.MailFrom + " not owned by user " + s.user.Login)
+	s.Out("553 5.7.1 sender address rejected: not owned by user " + s.user.Login)
+	s.SMTPResponseCode = 553
+	s.pause(2)
+	return false
+}
+
+// submissionCheckFromHeader checks (or rewrites) From header of current
+// mail against authenticated user. Returns false if the message is rejected
+func (s *SMTPServerSession) submissionCheckFromHeader() bool {
+	sender := s.submissionSenderAddress()
+	if sender == "" {
+		return true
+	}
+	from := message.RawGetHeader(&s.CurrentRawMail, "from")
+	// RFC 6409 8.1: MSA may add a missing From header
+	if from == "" {
+		message.RawSetHeader(&s.CurrentRawMail, "from", "<"+sender+">")
+		return true
+	}
+	addresses, err := mail.ParseAddressList(from)
+	if err == nil {
+		owned := true
+		for _, address := range addresses {
+			if strings.ToLower(address.Address) != sender {
+				owned = false
+				break
+			}
+		}
+		if owned {
+			return true
+		}
+	}
+	if Cfg.GetSmtpdSubmissionSenderPolicy() == SubmissionSenderPolicyRewrite {
+		name := ""
+		if err == nil && len(addresses) != 0 {
+			name = addresses[0].Name
+		}
+		s.Log("DATA - From header " + from + " rewritten to " + sender)
+		message.RawSetHeader(&s.CurrentRawMail, "from", (&mail.Address{Name: name, Address: sender}).String())
+		return true
+	}
+	s.Log("DATA - From header " + from + " not owned by user " + s.user.Login)
+	s.Out("554 5.7.1 From header rejected: not owned by user " + s.user.Login)
+	s.SMTPResponseCode = 554
+	return false
+}
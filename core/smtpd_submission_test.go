@@ -0,0 +1,159 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/toorop/tmail/message"
+)
+
+// testSMTPConn is a net.Conn recording what the server writes
+type testSMTPConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *testSMTPConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+func (c *testSMTPConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2525}
+}
+
+// newTestSMTPSession returns a session on a listener with the given role
+func newTestSMTPSession(role string, isTLS bool) (*SMTPServerSession, *testSMTPConn) {
+	conn := &testSMTPConn{}
+	s := &SMTPServerSession{
+		uuid:     "test",
+		Conn:     conn,
+		tls:      isTLS,
+		timer:    time.NewTimer(time.Hour),
+		timeout:  time.Hour,
+		peerAddr: conn.RemoteAddr(),
+		dsn:      dsn{role: role},
+		exitasap: make(chan int, 1),
+	}
+	return s, conn
+}
+
+// stubSMTPLogger replaces Logger with a silent one, returns a func restoring it
+func stubSMTPLogger() func() {
+	logger := Logger
+	Logger = logrus.New()
+	Logger.Out = io.Discard
+	return func() { Logger = logger }
+}
+
+func Test_submissionCheckMailFrom(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	Cfg = &Config{}
+
+	tests := []struct {
+		policy   string
+		role     string
+		login    string
+		mailFrom string
+		ok       bool
+		expected string
+	}{
+		{SubmissionSenderPolicyNone, SmtpdRoleSubmission, "john@example.com", "jane@example.com", true, "jane@example.com"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmission, "john@example.com", "John@Example.com", true, "John@Example.com"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmission, "john@example.com", "jane@example.com", false, "jane@example.com"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleMx, "john@example.com", "jane@example.com", true, "jane@example.com"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmissions, "john", "jane@example.com", true, "jane@example.com"},
+		{SubmissionSenderPolicyRewrite, SmtpdRoleSubmissions, "john@example.com", "jane@example.com", true, "john@example.com"},
+	}
+	for _, test := range tests {
+		Cfg.cfg.SmtpdSubmissionSenderPolicy = test.policy
+		s, conn := newTestSMTPSession(test.role, true)
+		s.user = &User{Login: test.login}
+		s.Envelope.MailFrom = test.mailFrom
+		assert.Equal(t, test.ok, s.submissionCheckMailFrom(), test)
+		assert.Equal(t, test.expected, s.Envelope.MailFrom, test)
+		if test.ok {
+			assert.Empty(t, conn.out.String(), test)
+		} else {
+			assert.Contains(t, conn.out.String(), "553 5.7.1", test)
+		}
+	}
+}
+
+func Test_submissionCheckFromHeader(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	Cfg = &Config{}
+
+	tests := []struct {
+		policy   string
+		role     string
+		from     string
+		ok       bool
+		expected string
+	}{
+		{SubmissionSenderPolicyNone, SmtpdRoleSubmission, "jane@example.com", true, "jane@example.com"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmission, "John <JOHN@example.com>", true, "John <JOHN@example.com>"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmission, "", true, "<john@example.com>"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmission, "john@example.com, jane@example.com", false, "john@example.com, jane@example.com"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleSubmission, "not an address", false, "not an address"},
+		{SubmissionSenderPolicyEnforce, SmtpdRoleMx, "jane@example.com", true, "jane@example.com"},
+		{SubmissionSenderPolicyRewrite, SmtpdRoleSubmission, "Jane <jane@example.com>", true, `"Jane" <john@example.com>`},
+		{SubmissionSenderPolicyRewrite, SmtpdRoleSubmission, "not an address", true, "<john@example.com>"},
+	}
+	for _, test := range tests {
+		Cfg.cfg.SmtpdSubmissionSenderPolicy = test.policy
+		s, conn := newTestSMTPSession(test.role, true)
+		s.user = &User{Login: "john@example.com"}
+		s.CurrentRawMail = []byte("Subject: test\r\n\r\nbody\r\n")
+		if test.from != "" {
+			s.CurrentRawMail = []byte("From: " + test.from + "\r\nSubject: test\r\n\r\nbody\r\n")
+		}
+		assert.Equal(t, test.ok, s.submissionCheckFromHeader(), test)
+		assert.Equal(t, test.expected, message.RawGetHeader(&s.CurrentRawMail, "from"), test)
+		if test.ok {
+			assert.Empty(t, conn.out.String(), test)
+		} else {
+			assert.Contains(t, conn.out.String(), "554 5.7.1", test)
+		}
+	}
+}
+
+func Test_smtpEhloAuthAdvertisement(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	Cfg = &Config{}
+	Cfg.cfg.Me = "mail.example.com"
+	Cfg.cfg.SmtpdAuthMechanisms = "PLAIN CRAM-MD5"
+
+	tests := []struct {
+		role string
+		tls  bool
+		auth string
+	}{
+		{SmtpdRoleMx, false, ""},
+		{SmtpdRoleMx, true, ""},
+		{SmtpdRoleSubmission, false, ""},
+		{SmtpdRoleSubmission, true, "250 AUTH PLAIN CRAM-MD5\r\n"},
+		{SmtpdRoleSubmissions, true, "250 AUTH PLAIN CRAM-MD5\r\n"},
+		{"", false, "250 AUTH CRAM-MD5\r\n"},
+		{"", true, "250 AUTH PLAIN CRAM-MD5\r\n"},
+	}
+	for _, test := range tests {
+		s, conn := newTestSMTPSession(test.role, test.tls)
+		s.smtpEhlo([]string{"EHLO", "client.example.com"})
+		out := conn.out.String()
+		assert.Contains(t, out, "250-mail.example.com\r\n", test)
+		if test.auth == "" {
+			assert.NotContains(t, out, "AUTH", test)
+			assert.Contains(t, out, "250 X-PEPPER\r\n", test)
+		} else {
+			assert.Contains(t, out, "250-X-PEPPER\r\n"+test.auth, test)
+		}
+	}
+}
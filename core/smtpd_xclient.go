@@ -0,0 +1,324 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// XCLIENT and XFORWARD (Postfix compatible)
+// http://www.postfix.org/XCLIENT_README.html
+// http://www.postfix.org/XFORWARD_README.html
+//
+// XCLIENT replaces client attributes for the whole session (relay decisions,
+// auth, Received header), XFORWARD only for logging and Received header of
+// the next transaction.
+
+// supported attributes
+var (
+	xclientAttributes  = []string{"NAME", "ADDR", "PORT", "PROTO", "HELO", "LOGIN"}
+	xforwardAttributes = []string{"NAME", "ADDR", "PORT", "PROTO", "HELO", "IDENT", "SOURCE"}
+)
+
+// xclientConn is a net.Conn which returns the client address sent via XCLIENT
+type xclientConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+// RemoteAddr returns client address
+func (c *xclientConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// xclientIsTrusted returns true if peer can use XCLIENT
+func (s *SMTPServerSession) xclientIsTrusted() bool {
+	return addrInNetworks(s.peerAddr, Cfg.GetSmtpdXclientTrusted())
+}
+
+// xforwardIsTrusted returns true if peer can use XFORWARD
+func (s *SMTPServerSession) xforwardIsTrusted() bool {
+	return addrInNetworks(s.peerAddr, Cfg.GetSmtpdXforwardTrusted())
+}
+
+// xtextDecode decodes xtext (RFC 3461 4)
+func xtextDecode(s string) (string, error) {
+	out := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '+' {
+			out = append(out, s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", errors.New("bad xtext " + s)
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errors.New("bad xtext " + s)
+		}
+		out = append(out, byte(b))
+		i += 2
+	}
+	return string(out), nil
+}
+
+// xParseAttributes parses NAME=value... and returns attributes
+// unavailable values ([UNAVAILABLE], [TEMPUNAVAIL]) are returned empty
+func xParseAttributes(args []string, allowed []string) (map[string]string, error) {
+	attributes := map[string]string{}
+	for _, arg := range args {
+		t := strings.SplitN(arg, "=", 2)
+		if len(t) != 2 {
+			return nil, errors.New("bad attribute " + arg)
+		}
+		name := strings.ToUpper(t[0])
+		if !IsStringInSlice(name, allowed) {
+			return nil, errors.New("unsupported attribute " + name)
+		}
+		value, err := xtextDecode(t[1])
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(value, "[UNAVAILABLE]") || strings.EqualFold(value, "[TEMPUNAVAIL]") {
+			value = ""
+		}
+		attributes[name] = value
+	}
+	return attributes, nil
+}
+
+// xParseAddr parses ADDR attribute (IPv6 addresses may be prefixed by IPV6:)
+func xParseAddr(value string) net.IP {
+	if strings.HasPrefix(strings.ToUpper(value), "IPV6:") {
+		value = value[5:]
+	}
+	return net.ParseIP(value)
+}
+
+// smtpXclient XCLIENT command
+func (s *SMTPServerSession) smtpXclient(msg []string) {
+	defer s.recoverOnPanic()
+	if !s.xclientIsTrusted() {
+		s.Log("XCLIENT - denied for untrusted client")
+		s.pause(2)
+		s.Out("550 5.7.0 insufficient authorization")
+		s.SMTPResponseCode = 550
+		return
+	}
+	if s.seenMail {
+		s.Out("503 5.5.1 mail transaction in progress")
+		s.SMTPResponseCode = 503
+		return
+	}
+	if len(msg) < 2 {
+		s.Out("501 5.5.4 syntax: XCLIENT attribute=value...")
+		s.SMTPResponseCode = 501
+		return
+	}
+	attributes, err := xParseAttributes(msg[1:], xclientAttributes)
+	if err != nil {
+		s.Log("XCLIENT - " + err.Error())
+		s.Out("501 5.5.4 " + err.Error())
+		s.SMTPResponseCode = 501
+		return
+	}
+
+	// client address
+	remoteAddr := &net.TCPAddr{IP: ipFromAddr(s.Conn.RemoteAddr())}
+	if tcpAddr, ok := s.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		remoteAddr.Port = tcpAddr.Port
+	}
+	if value, ok := attributes["ADDR"]; ok && value != "" {
+		if remoteAddr.IP = xParseAddr(value); remoteAddr.IP == nil {
+			s.Out("501 5.5.4 bad ADDR attribute " + value)
+			s.SMTPResponseCode = 501
+			return
+		}
+	}
+	if value, ok := attributes["PORT"]; ok && value != "" {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			s.Out("501 5.5.4 bad PORT attribute " + value)
+			s.SMTPResponseCode = 501
+			return
+		}
+		remoteAddr.Port = int(port)
+	}
+
+	// authenticated user
+	var user *User
+	if value, ok := attributes["LOGIN"]; ok && value != "" {
+		if user, err = authExternalUser(value); err != nil {
+			s.LogError("XCLIENT - unable to get user " + value + " - " + err.Error())
+			s.Out("454 4.3.0 oops, problem with XCLIENT LOGIN")
+			s.SMTPResponseCode = 454
+			return
+		}
+	} else if !ok {
+		user = s.user
+	}
+
+	previousIp := ipFromAddr(s.Conn.RemoteAddr())
+	previousUser := s.user
+	s.Log(fmt.Sprintf("XCLIENT - %s -> %s", s.Conn.RemoteAddr().String(), remoteAddr.String()))
+	if xc, ok := s.Conn.(*xclientConn); ok {
+		xc.remoteAddr = remoteAddr
+	} else {
+		s.Conn = &xclientConn{Conn: s.Conn, remoteAddr: remoteAddr}
+	}
+	// new address without name: reverse lookup
+	if _, ok := attributes["ADDR"]; ok {
+		s.xclientName = ""
+	}
+	for name, value := range attributes {
+		switch name {
+		case "NAME":
+			s.xclientName = value
+		case "HELO":
+			s.xclientHelo = value
+		case "PROTO":
+			s.xclientProto = strings.ToUpper(value)
+		}
+	}
+	s.user = user
+	// relay network of the new address
+	s.network = nil
+	s.networkLoaded = false
+
+	// new session
+	s.Reset()
+	s.xforward = nil
+	s.seenHelo = false
+	s.helo = ""
+	if s.dsn.role != SmtpdRoleMx && s.authIsBanned() {
+		s.Log("XCLIENT - IP banned after too many auth failures")
+		s.Out("421 4.7.0 too many authentication failures from your IP, try again later " + s.uuid)
+		s.SMTPResponseCode = 421
+		s.ExitAsap()
+		return
+	}
+
+	// rate limits apply to the client and user behind the frontend
+	if Cfg.GetSmtpdRateLimitEnabled() {
+		if !remoteAddr.IP.Equal(previousIp) {
+			if s.rateLimitIpKey != "" {
+				smtpdRateLimiter.closeSession(s.rateLimitIpKey)
+			}
+			var reason string
+			s.rateLimitIpKey, reason = smtpdRateLimiter.openSession(RateLimitScopeIp, remoteAddr.IP.String())
+			if s.rateLimitIpKey == "" {
+				s.Log("XCLIENT - rate limit reached: " + reason)
+				s.Out("421 4.7.0 " + reason + " from your IP, try again later " + s.uuid)
+				s.SMTPResponseCode = 421
+				s.ExitAsap()
+				return
+			}
+		}
+		if userLogin(user) != userLogin(previousUser) {
+			if s.rateLimitUserKey != "" {
+				smtpdRateLimiter.closeSession(s.rateLimitUserKey)
+				s.rateLimitUserKey = ""
+			}
+			if user != nil {
+				var reason string
+				s.rateLimitUserKey, reason = smtpdRateLimiter.openSession(RateLimitScopeUser, user.Login)
+				if s.rateLimitUserKey == "" {
+					s.Log("XCLIENT - rate limit reached for user " + user.Login + ": " + reason)
+					s.Out("421 4.7.0 " + reason + " for this user, try again later " + s.uuid)
+					s.SMTPResponseCode = 421
+					s.user = nil
+					s.ExitAsap()
+					return
+				}
+			}
+		}
+	}
+	s.Out(s.greetingLine())
+	s.SMTPResponseCode = 220
+}
+
+// userLogin returns login of user (lowercased), empty string if user is nil
+func userLogin(user *User) string {
+	if user == nil {
+		return ""
+	}
+	return strings.ToLower(user.Login)
+}
+
+// smtpXforward XFORWARD command
+func (s *SMTPServerSession) smtpXforward(msg []string) {
+	defer s.recoverOnPanic()
+	if !s.xforwardIsTrusted() {
+		s.Log("XFORWARD - denied for untrusted client")
+		s.pause(2)
+		s.Out("550 5.7.0 insufficient authorization")
+		s.SMTPResponseCode = 550
+		return
+	}
+	if s.seenMail {
+		s.Out("503 5.5.1 mail transaction in progress")
+		s.SMTPResponseCode = 503
+		return
+	}
+	if len(msg) < 2 {
+		s.Out("501 5.5.4 syntax: XFORWARD attribute=value...")
+		s.SMTPResponseCode = 501
+		return
+	}
+	attributes, err := xParseAttributes(msg[1:], xforwardAttributes)
+	if err != nil {
+		s.Log("XFORWARD - " + err.Error())
+		s.Out("501 5.5.4 " + err.Error())
+		s.SMTPResponseCode = 501
+		return
+	}
+	if value := attributes["ADDR"]; value != "" && xParseAddr(value) == nil {
+		s.Out("501 5.5.4 bad ADDR attribute " + value)
+		s.SMTPResponseCode = 501
+		return
+	}
+	// attributes are cumulative until the end of the transaction
+	if s.xforward == nil {
+		s.xforward = map[string]string{}
+	}
+	for name, value := range attributes {
+		s.xforward[name] = value
+	}
+	s.Log(fmt.Sprintf("XFORWARD - %v", attributes))
+	s.Out("250 2.0.0 Ok")
+	s.SMTPResponseCode = 250
+}
+
+// receivedFrom returns helo, reverse hostname, IP and protocol of the client
+// for the Received header
+func (s *SMTPServerSession) receivedFrom() (helo, host, ip, proto string) {
+	helo, host, proto = s.helo, s.xclientName, s.xclientProto
+	if s.xclientHelo != "" {
+		helo = s.xclientHelo
+	}
+	ip = ipFromAddr(s.Conn.RemoteAddr()).String()
+	if s.xforward != nil {
+		if value := s.xforward["ADDR"]; value != "" {
+			ip = xParseAddr(value).String()
+			host = ""
+		}
+		if value := s.xforward["NAME"]; value != "" {
+			host = value
+		}
+		if value := s.xforward["HELO"]; value != "" {
+			helo = value
+		}
+		if value := s.xforward["PROTO"]; value != "" {
+			proto = strings.ToUpper(value)
+		}
+	}
+	if host == "" {
+		host = "no reverse"
+		if hosts, err := net.LookupAddr(ip); err == nil {
+			host = hosts[0]
+		}
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_xtextDecode(t *testing.T) {
+	tests := []struct {
+		xtext   string
+		decoded string
+		err     bool
+	}{
+		{"", "", false},
+		{"mail.example.com", "mail.example.com", false},
+		{"john+2Bdoe@example.com", "john+doe@example.com", false},
+		{"a+3Db+20c", "a=b c", false},
+		{"+5bUNAVAILABLE+5d", "[UNAVAILABLE]", false},
+		{"+", "", true},
+		{"abc+2", "", true},
+		{"abc+zz", "", true},
+		{"abc+-1", "", true},
+	}
+	for _, test := range tests {
+		decoded, err := xtextDecode(test.xtext)
+		if test.err {
+			assert.Error(t, err, test.xtext)
+			continue
+		}
+		assert.NoError(t, err, test.xtext)
+		assert.Equal(t, test.decoded, decoded, test.xtext)
+	}
+}
+
+func Test_xParseAttributes(t *testing.T) {
+	attributes, err := xParseAttributes([]string{"addr=192.0.2.1", "NAME=[UNAVAILABLE]", "LOGIN=john+40example.com"}, xclientAttributes)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ADDR": "192.0.2.1", "NAME": "", "LOGIN": "john@example.com"}, attributes)
+
+	for _, args := range [][]string{{"ADDR"}, {"SOURCE=LOCAL"}, {"NAME=+zz"}} {
+		_, err = xParseAttributes(args, xclientAttributes)
+		assert.Error(t, err, args)
+	}
+
+	assert.Equal(t, "2001:db8::1", xParseAddr("IPv6:2001:db8::1").String())
+	assert.Equal(t, "192.0.2.1", xParseAddr("192.0.2.1").String())
+	assert.Nil(t, xParseAddr("unknown"))
+}
+
+func Test_smtpXclientRateLimit(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer func(limiter *rateLimiter) { smtpdRateLimiter = limiter }(smtpdRateLimiter)
+	defer stubSMTPLogger()()
+	defer setTestDB(t, &RateLimit{}, &User{})()
+	Cfg = &Config{}
+	Cfg.cfg.SmtpdXclientTrusted = "192.0.2.1"
+	Cfg.cfg.SmtpdRateLimitEnabled = true
+	Cfg.cfg.SmtpdRateLimitIpMaxSessions = 1
+	Cfg.cfg.SmtpdRateLimitUserMaxSessions = 1
+	smtpdRateLimiter = newRateLimiter()
+
+	// frontend session, IP key opened at greeting
+	newSession := func() (*SMTPServerSession, *testSMTPConn) {
+		s, conn := newTestSMTPSession(SmtpdRoleSubmission, true)
+		s.rateLimitIpKey, _ = smtpdRateLimiter.openSession(RateLimitScopeIp, "192.0.2.1")
+		return s, conn
+	}
+
+	// keys are moved to the client and the user
+	s, conn := newSession()
+	s.smtpXclient([]string{"XCLIENT", "ADDR=198.51.100.1", "LOGIN=john+40example.com"})
+	assert.Contains(t, conn.out.String(), "220 ")
+	assert.Equal(t, "ip:198.51.100.1", s.rateLimitIpKey)
+	assert.Equal(t, "user:john@example.com", s.rateLimitUserKey)
+
+	// the same client is refused
+	s2, conn2 := newSession()
+	s2.smtpXclient([]string{"XCLIENT", "ADDR=198.51.100.1"})
+	assert.Contains(t, conn2.out.String(), "421 4.7.0 too many concurrent sessions from your IP")
+	assert.Empty(t, s2.rateLimitIpKey)
+	assert.Equal(t, uint32(421), s2.SMTPResponseCode)
+
+	// the same user is refused
+	s3, conn3 := newSession()
+	s3.smtpXclient([]string{"XCLIENT", "ADDR=198.51.100.2", "LOGIN=JOHN+40example.com"})
+	assert.Contains(t, conn3.out.String(), "421 4.7.0 too many concurrent sessions for this user")
+	assert.Nil(t, s3.user)
+	assert.Empty(t, s3.rateLimitUserKey)
+
+	// frontend sessions were released
+	key, _ := smtpdRateLimiter.openSession(RateLimitScopeIp, "192.0.2.1")
+	assert.NotEmpty(t, key)
+
+	// user session is released when LOGIN is removed
+	conn.out.Reset()
+	s.smtpXclient([]string{"XCLIENT", "LOGIN=[UNAVAILABLE]"})
+	assert.Contains(t, conn.out.String(), "220 ")
+	assert.Nil(t, s.user)
+	assert.Empty(t, s.rateLimitUserKey)
+	assert.Equal(t, "ip:198.51.100.1", s.rateLimitIpKey)
+	key, _ = smtpdRateLimiter.openSession(RateLimitScopeUser, "john@example.com")
+	assert.NotEmpty(t, key)
+}
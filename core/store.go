@@ -17,9 +17,9 @@ type Storer interface {
 func NewStore(driver, source string) (Storer, error) {
 	switch driver {
 	case "disk":
-		return NewDiskStore()
+		return NewDiskStore(source)
 	case "openstack":
-		return newOpenstackStore()
+		return newOpenstackStore(source)
 	default:
 		return nil, errors.New("no such driver " + driver + " for store")
 	}
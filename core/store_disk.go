@@ -14,9 +14,9 @@ type diskStore struct {
 	basePath string
 }
 
-// NewDiskStore returns a store with local disk as backend
-func NewDiskStore() (*diskStore, error) {
-	basePath := path.Clean(Cfg.GetStoreSource())
+// NewDiskStore returns a store with local disk (directory source) as backend
+func NewDiskStore(source string) (*diskStore, error) {
+	basePath := path.Clean(source)
 	// check if path exists & is writable
 	fi, err := os.Stat(basePath)
 	if err != nil {
@@ -15,8 +15,8 @@ type openstackStore struct {
 }
 
 // newOpenstackStore check object storage and return a new openstackStore
-func newOpenstackStore() (*openstackStore, error) {
-	osPath := objectstorageV1.NewOsPathFromPath(Cfg.GetStoreSource())
+func newOpenstackStore(source string) (*openstackStore, error) {
+	osPath := objectstorageV1.NewOsPathFromPath(source)
 	if !osPath.IsContainer() {
 		return nil, errors.New("path " + Cfg.GetStoreDriver() + " is not a path to a valid openstack container")
 	}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smtpd TLS certificates
+// default certificate is ssl/server.crt (and ssl/server.key), extra
+// certificates (CERT.crt and CERT.key) are read from the SNI directory and
+// selected using names (CN and SANs) they are valid for. If ACME is enabled
+// certificates obtained from the CA are used when no file matches.
+// Files are reloaded when they change.
+// REST server uses the same mechanism with ssl/web_server.crt (and
+// ssl/web_server.key) as default certificate.
+
+// TLSCertificate represents a certificate used by smtpd
+type TLSCertificate struct {
+	File      string
+	Names     []string
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	DaysLeft  int
+	Default   bool
+}
+
+// tlsCertStore holds certificates of a server
+type tlsCertStore struct {
+	sync.Mutex
+	service     string                      // for logs
+	files       func() ([][2]string, error) // certificate/key pairs, default first
+	lastCheck   time.Time
+	modTimes    map[string]time.Time
+	defaultCert *tls.Certificate
+	byName      map[string]*tls.Certificate
+	infos       []TLSCertificate
+}
+
+var smtpdCerts = &tlsCertStore{service: "smtpd", files: tlsCertFiles}
+
+var restCerts = &tlsCertStore{service: "httpd", files: restTLSCertFiles}
+
+// tlsCertFiles returns certificate/key pairs to load, default first
+func tlsCertFiles() (pairs [][2]string, err error) {
+	pairs = [][2]string{{path.Join(GetBasePath(), "ssl/server.crt"), path.Join(GetBasePath(), "ssl/server.key")}}
+	crts, err := filepath.Glob(path.Join(Cfg.GetSmtpdTLSSniDir(), "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(crts)
+	for _, crt := range crts {
+		pairs = append(pairs, [2]string{crt, strings.TrimSuffix(crt, ".crt") + ".key"})
+	}
+	return pairs, nil
+}
+
+// restTLSCertFiles returns REST server certificate/key pair
+func restTLSCertFiles() ([][2]string, error) {
+	return [][2]string{{path.Join(GetBasePath(), "ssl/web_server.crt"), path.Join(GetBasePath(), "ssl/web_server.key")}}, nil
+}
+
+// refresh (re)loads certificates if files have changed
+func (c *tlsCertStore) refresh() error {
+	c.Lock()
+	defer c.Unlock()
+	if !c.lastCheck.IsZero() && time.Since(c.lastCheck) < Cfg.GetSmtpdTLSReloadInterval() {
+		return nil
+	}
+	c.lastCheck = time.Now()
+	pairs, err := c.files()
+	if err != nil {
+		return err
+	}
+
+	// something changed ?
+	modTimes := map[string]time.Time{}
+	for _, pair := range pairs {
+		for _, file := range pair {
+			if fi, err := os.Stat(file); err == nil {
+				modTimes[file] = fi.ModTime()
+			}
+		}
+	}
+	if c.defaultCert != nil && len(modTimes) == len(c.modTimes) {
+		changed := false
+		for file, modTime := range modTimes {
+			if !modTime.Equal(c.modTimes[file]) {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return nil
+		}
+	}
+
+	// load
+	var defaultCert *tls.Certificate
+	byName := map[string]*tls.Certificate{}
+	infos := []TLSCertificate{}
+	for i, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err == nil && len(cert.Certificate) != 0 {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		}
+		if err != nil {
+			// default certificate is mandatory
+			if i == 0 {
+				return errors.New("unable to load " + c.service + " certificate " + pair[0] + " - " + err.Error())
+			}
+			Logger.Error(c.service + " - unable to load certificate " + pair[0] + " - " + err.Error())
+			continue
+		}
+		names := tlsCertNames(cert.Leaf)
+		if i == 0 {
+			defaultCert = &cert
+		} else {
+			for _, name := range names {
+				if _, ok := byName[name]; !ok {
+					byName[name] = &cert
+				}
+			}
+		}
+		infos = append(infos, TLSCertificate{
+			File:      pair[0],
+			Names:     names,
+			Issuer:    cert.Leaf.Issuer.CommonName,
+			NotBefore: cert.Leaf.NotBefore,
+			NotAfter:  cert.Leaf.NotAfter,
+			Default:   i == 0,
+		})
+	}
+	if c.defaultCert != nil {
+		Logger.Info(c.service + " - TLS certificates reloaded")
+	}
+	c.modTimes = modTimes
+	c.defaultCert = defaultCert
+	c.byName = byName
+	c.infos = infos
+	return nil
+}
+
+// tlsCertNames returns lowercased names certificate is valid for
+func tlsCertNames(cert *x509.Certificate) []string {
+	names := []string{}
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		name = strings.ToLower(name)
+		if name != "" && !IsStringInSlice(name, names) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// getCertificate returns certificate matching SNI (default if none)
+func (c *tlsCertStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := c.refresh(); err != nil {
+		Logger.Error(c.service + " - " + err.Error())
+	}
+	c.Lock()
+	defer c.Unlock()
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name != "" {
+		if cert, ok := c.byName[name]; ok {
+			return cert, nil
+		}
+		// wildcard
+		if i := strings.Index(name, "."); i != -1 {
+			if cert, ok := c.byName["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+	// ACME certificate (Me if no SNI)
+	if cert := AcmeGetCertificate(name); cert != nil {
+		return cert, nil
+	}
+	if c.defaultCert == nil {
+		return nil, errors.New("no certificate available")
+	}
+	return c.defaultCert, nil
+}
+
+// tlsConfig returns TLS config for smtpd
+func (c *tlsCertStore) tlsConfig() (*tls.Config, error) {
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate:     c.getCertificate,
+		InsecureSkipVerify: true,
+		Rand:               rand.Reader,
+	}, nil
+}
+
+// RestTLSLoad loads REST server certificate
+func RestTLSLoad() error {
+	return restCerts.refresh()
+}
+
+// RestTLSGetCertificate returns REST server certificate (ACME one if
+// any, ssl/web_server.crt otherwise)
+func RestTLSGetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return restCerts.getCertificate(hello)
+}
+
+// TLSGetCertificates returns smtpd certificates with their expiration
+func TLSGetCertificates() ([]TLSCertificate, error) {
+	if err := smtpdCerts.refresh(); err != nil {
+		return nil, err
+	}
+	smtpdCerts.Lock()
+	defer smtpdCerts.Unlock()
+	certs := make([]TLSCertificate, len(smtpdCerts.infos))
+	copy(certs, smtpdCerts.infos)
+	if Cfg.GetAcmeEnabled() {
+		acmeCerts, err := AcmeCertificateGetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, acmeCert := range acmeCerts {
+			certs = append(certs, TLSCertificate{
+				File:     "acme:" + acmeCert.Domain,
+				Names:    []string{acmeCert.Domain},
+				NotAfter: acmeCert.NotAfter,
+			})
+		}
+	}
+	for i := range certs {
+		certs[i].DaysLeft = int(time.Until(certs[i].NotAfter).Hours() / 24)
+	}
+	return certs, nil
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert writes a self signed certificate for name (and its key)
+func writeTestCert(t *testing.T, crt, key, name string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_tlsCertStoreReload(t *testing.T) {
+	defer func(cfg *Config) { Cfg = cfg }(Cfg)
+	defer stubSMTPLogger()()
+	Cfg = &Config{}
+	Cfg.cfg.SmtpdTLSReloadInterval = 60
+
+	dir := t.TempDir()
+	crt, key := filepath.Join(dir, "web_server.crt"), filepath.Join(dir, "web_server.key")
+	store := &tlsCertStore{service: "httpd", files: func() ([][2]string, error) {
+		return [][2]string{{crt, key}}, nil
+	}}
+
+	// no certificate
+	assert.Error(t, store.refresh())
+	_, err := store.getCertificate(&tls.ClientHelloInfo{})
+	assert.Error(t, err)
+
+	writeTestCert(t, crt, key, "first.example.com")
+	store.lastCheck = time.Time{}
+	cert, err := store.getCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "first.example.com", cert.Leaf.Subject.CommonName)
+
+	// changes are seen after reload interval
+	writeTestCert(t, crt, key, "second.example.com")
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(crt, later, later))
+	cert, err = store.getCertificate(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "first.example.com", cert.Leaf.Subject.CommonName)
+	store.lastCheck = store.lastCheck.Add(-time.Hour)
+	cert, err = store.getCertificate(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "second.example.com", cert.Leaf.Subject.CommonName)
+}
@@ -22,6 +22,9 @@ type User struct {
 	IsCatchall   bool   `sql:"default:false"`
 	MailboxQuota string `sql:"null"`
 	Home         string `sql:"null"` // used by dovecot to store mailbox
+	CramMd5Key   string `sql:"null"` // HMAC-MD5 precomputed contexts for CRAM-MD5
+	ScramSha256  string `sql:"null"` // SCRAM-SHA-256 salted keys (RFC 5803 format)
+	LmtpEndpoint string `sql:"null"` // LMTP server for local deliveries (overrides rcpthost one)
 }
 
 // UserAdd add an user
@@ -56,8 +59,8 @@ func UserAdd(login, passwd, mbQuota string, haveMailbox, authRelay, isCatchall b
 
 	// if we have to create mailbox, login must be a valid email address
 	if haveMailbox {
-		// check if dovecot is available
-		if !Cfg.GetDovecotSupportEnabled() {
+		// check if dovecot is available (native maildir delivery doesn't need it)
+		if Cfg.GetDeliverdLocalAgent() == "dovecot" && !Cfg.GetDovecotSupportEnabled() {
 			return errors.New("you must enable (and install) Dovecot support")
 		}
 
@@ -97,7 +100,7 @@ func UserAdd(login, passwd, mbQuota string, haveMailbox, authRelay, isCatchall b
 			return errors.New("rcpthost " + t[1] + " is an domain alias. You can't add user for this kind of domain")
 		}
 		// home = base/d/domain/u/user
-		user.Home = Cfg.GetUsersHomeBase() + "/" + string(t[1][0]) + "/" + t[1] + "/" + string(t[0][0]) + "/" + t[0]
+		user.Home = userHome(login)
 
 		// catchall
 		if isCatchall {
@@ -123,6 +126,11 @@ func UserAdd(login, passwd, mbQuota string, haveMailbox, authRelay, isCatchall b
 	}
 	user.Passwd = string(hashed)
 
+	// SASL secrets (CRAM-MD5, SCRAM-SHA-256)
+	if err = user.setSaslSecrets(passwd); err != nil {
+		return err
+	}
+
 	// sha512 for dovecot compatibility
 	// {SHA512-CRYPT}$6$iW6KmxlZL56A1raN$4DjgXTUzFZlGQgq61YnBMF2AYWKdY5ZanOUWTDBhuvBYVzkdNjqrmpYnLlQ3M0kU1joUH0Bb2aJcPhUF0xlSq/
 	salt, err := NewUUID()
@@ -191,6 +199,12 @@ func UserDel(login string) error {
 		return errors.New("User " + login + " doesn't exists")
 	}
 	// TODO on doit verifier si l'host doit etre supprimé de rcpthost
+	if err = DB.Where("login = ?", login).Delete(&SieveScript{}).Error; err != nil {
+		return err
+	}
+	if err = DB.Where("login = ?", login).Delete(&Vacation{}).Error; err != nil {
+		return err
+	}
 	return DB.Where("login = ?", login).Delete(&User{}).Error
 }
 
@@ -215,6 +229,22 @@ func UserChangePassword(login, password string) error {
 	return user.ChangePasswd(password)
 }
 
+// UserSetLmtpEndpoint sets LMTP endpoint used for local deliveries to user
+// ("" to use rcpthost or default one)
+func UserSetLmtpEndpoint(login, endpoint string) error {
+	if endpoint != "" {
+		if _, _, err := parseLmtpEndpoint(endpoint); err != nil {
+			return err
+		}
+	}
+	user, err := UserGetByLogin(login)
+	if err != nil {
+		return err
+	}
+	user.LmtpEndpoint = endpoint
+	return DB.Save(user).Error
+}
+
 // ChangePasswd is used to change user password
 func (u *User) ChangePasswd(passwd string) error {
 	if len(passwd) < 6 {
@@ -225,6 +255,9 @@ func (u *User) ChangePasswd(passwd string) error {
 		return err
 	}
 	u.Passwd = string(hashed)
+	if err = u.setSaslSecrets(passwd); err != nil {
+		return err
+	}
 	if u.HaveMailbox {
 		salt, err := NewUUID()
 		if err != nil {
@@ -238,3 +271,12 @@ func (u *User) ChangePasswd(passwd string) error {
 	}
 	return DB.Save(u).Error
 }
+
+// setSaslSecrets sets keys used by challenge/response SASL mechanisms
+func (u *User) setSaslSecrets(passwd string) (err error) {
+	if u.CramMd5Key, err = cramMd5Key(passwd); err != nil {
+		return
+	}
+	u.ScramSha256, err = scramSha256Keys(passwd)
+	return
+}
@@ -50,13 +50,31 @@ func StripQuotes(s string) string {
 	return s
 }
 
-// IsIPV4 return true if ip is ipV4
-// todo: refactor
+// IsIPV4 return true if ip is ipV4 (IPv4-mapped IPv6 addresses included)
 func IsIPV4(ip string) bool {
-	if len(ip) > 15 {
-		return false
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() != nil
+}
+
+// addressLiteral returns ip as a RFC 5321 address literal
+// ([192.0.2.1] or [IPv6:2001:db8::1])
+func addressLiteral(ip string) string {
+	if IsIPV4(ip) {
+		return "[" + ip + "]"
+	}
+	return "[IPv6:" + ip + "]"
+}
+
+// parseAddressLiteral parses an IP or a RFC 5321 address literal
+// returns nil if s is not an address
+func parseAddressLiteral(s string) net.IP {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+		if strings.HasPrefix(strings.ToLower(s), "ipv6:") {
+			s = s[5:]
+		}
 	}
-	return true
+	return net.ParseIP(s)
 }
 
 // Unix2dos replace all line ending from \n to \r\n
@@ -81,6 +99,11 @@ func Unix2dos(ch *[]byte) (err error) {
 	return nil
 }
 
+// Dos2unix replace all line ending from \r\n to \n
+func Dos2unix(ch *[]byte) {
+	*ch = bytes.Replace(*ch, []byte{13, 10}, []byte{10}, -1)
+}
+
 // isFQN checks if domain is FQN (MX or A record)
 func isFQN(host string) (bool, error) {
 	_, err := net.LookupMX(host)
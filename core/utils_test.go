@@ -0,0 +1,30 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseAddressLiteral(t *testing.T) {
+	tests := []struct {
+		literal string
+		ip      net.IP
+	}{
+		{"192.0.2.1", net.ParseIP("192.0.2.1")},
+		{"[192.0.2.1]", net.ParseIP("192.0.2.1")},
+		{"[IPv6:2001:db8::1]", net.ParseIP("2001:db8::1")},
+		{"[ipv6:::1]", net.ParseIP("::1")},
+		{"[IPv6:192.0.2.1]", net.ParseIP("192.0.2.1")},
+		{"2001:db8::1", net.ParseIP("2001:db8::1")},
+		{"[2001:db8::1]", net.ParseIP("2001:db8::1")},
+		{"[IPv6:2001:db8::1", nil},
+		{"[IPv6:mail.example.com]", nil},
+		{"mail.example.com", nil},
+		{"[]", nil},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.ip, parseAddressLiteral(test.literal), test.literal)
+	}
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Vacation represents out of office settings of an user
+// Responses are sent from deliverLocal (see autoReply)
+type Vacation struct {
+	Id      int64
+	Login   string `sql:"unique"`
+	Enabled bool
+	Subject string
+	Body    string `sql:"type:text;"`
+	// StartAt, EndAt nil: no limit
+	StartAt *time.Time
+	EndAt   *time.Time
+	// ExcludedSenders addresses or domains which never get a response, comma separated
+	ExcludedSenders string
+	// Days min days between two responses to a same sender
+	Days int
+}
+
+// isActive returns true if responses have to be sent at t
+func (v *Vacation) isActive(t time.Time) bool {
+	if !v.Enabled {
+		return false
+	}
+	if v.StartAt != nil && t.Before(*v.StartAt) {
+		return false
+	}
+	if v.EndAt != nil && t.After(*v.EndAt) {
+		return false
+	}
+	return true
+}
+
+// isExcluded returns true if sender is in excluded senders
+func (v *Vacation) isExcluded(sender string) bool {
+	sender = strings.ToLower(sender)
+	for _, excluded := range strings.Split(v.ExcludedSenders, ",") {
+		excluded = strings.ToLower(strings.TrimSpace(excluded))
+		if excluded == "" {
+			continue
+		}
+		if strings.Contains(excluded, "@") {
+			if sender == excluded {
+				return true
+			}
+		} else if strings.HasSuffix(sender, "@"+excluded) {
+			return true
+		}
+	}
+	return false
+}
+
+// VacationSet adds or replaces vacation settings of user login
+// days 0: 7 days
+func VacationSet(login string, enabled bool, subject, body string, startAt, endAt *time.Time, excludedSenders string, days int) error {
+	login = strings.ToLower(login)
+	exists, err := UserExists(login)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("no such user " + login)
+	}
+	v := Vacation{
+		Enabled:         enabled,
+		Subject:         subject,
+		Body:            body,
+		StartAt:         startAt,
+		EndAt:           endAt,
+		ExcludedSenders: excludedSenders,
+		Days:            days,
+	}
+	if v.Days == 0 {
+		v.Days = 7
+	}
+	if v.Days < 1 || v.Days > 365 {
+		return errors.New("days must be between 1 and 365")
+	}
+	if v.StartAt != nil && v.EndAt != nil && v.EndAt.Before(*v.StartAt) {
+		return errors.New("end date is before start date")
+	}
+	if v.Enabled && strings.TrimSpace(v.Body) == "" {
+		return errors.New("body is empty")
+	}
+	old := Vacation{}
+	err = DB.Where("login = ?", login).Find(&old).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	v.Id, v.Login = old.Id, login
+	return DB.Save(&v).Error
+}
+
+// VacationGet returns vacation settings of user login
+func VacationGet(login string) (v *Vacation, err error) {
+	v = &Vacation{}
+	err = DB.Where("login = ?", strings.ToLower(login)).Find(v).Error
+	return
+}
+
+// VacationDel deletes vacation settings of user login
+func VacationDel(login string) error {
+	v, err := VacationGet(login)
+	if err != nil {
+		return err
+	}
+	return DB.Delete(v).Error
+}
+
+// vacationReply sends out of office response of user (errors are only logged)
+func (d *Delivery) vacationReply(user *User) {
+	v, err := VacationGet(user.Login)
+	if err == gorm.ErrRecordNotFound {
+		return
+	}
+	if err != nil {
+		Logger.Error(fmt.Sprintf("delivery-local %s: unable to get vacation settings of %s. %s", d.ID, user.Login, err))
+		return
+	}
+	if !v.isActive(time.Now()) || v.isExcluded(d.QMsg.MailFrom) {
+		return
+	}
+	reply := autoReply{
+		login:     user.Login,
+		subject:   v.Subject,
+		body:      v.Body,
+		addresses: []string{d.QMsg.RcptTo}, // user+detail, alias
+		handle:    "vacation",
+		days:      v.Days,
+	}
+	sent, err := reply.send(d.QMsg.MailFrom, *d.RawData)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("delivery-local %s: unable to send vacation reply of %s. %s", d.ID, user.Login, err))
+		return
+	}
+	if sent {
+		Logger.Info(fmt.Sprintf("delivery-local %s: vacation reply of %s sent to %s", d.ID, user.Login, d.QMsg.MailFrom))
+	}
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_vacationIsActive(t *testing.T) {
+	now := time.Now()
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		enabled bool
+		startAt *time.Time
+		endAt   *time.Time
+		active  bool
+	}{
+		{false, nil, nil, false},
+		{true, nil, nil, true},
+		{true, &before, &after, true},
+		{true, &after, nil, false},
+		{true, nil, &before, false},
+		{false, &before, &after, false},
+	}
+	for _, test := range tests {
+		v := Vacation{Enabled: test.enabled, StartAt: test.startAt, EndAt: test.endAt}
+		assert.Equal(t, test.active, v.isActive(now), test)
+	}
+}
+
+func Test_vacationIsExcluded(t *testing.T) {
+	v := Vacation{ExcludedSenders: "boss@example.com, Example.ORG ,,"}
+	assert.True(t, v.isExcluded("Boss@example.com"))
+	assert.True(t, v.isExcluded("jane@example.org"))
+	assert.False(t, v.isExcluded("jane@sub.example.org"))
+	assert.False(t, v.isExcluded("jane@example.com"))
+	assert.False(t, v.isExcluded("jane@notexample.org"))
+	assert.False(t, (&Vacation{}).isExcluded("jane@example.org"))
+}
+
+func Test_VacationSet(t *testing.T) {
+	defer setTestDB(t, &User{}, &Vacation{})()
+	assert.NoError(t, DB.Create(&User{Login: "john@example.com", Passwd: "x"}).Error)
+
+	assert.Error(t, VacationSet("jane@example.com", true, "", "away", nil, nil, "", 0))
+	assert.Error(t, VacationSet("john@example.com", true, "", " ", nil, nil, "", 0))
+	assert.Error(t, VacationSet("john@example.com", true, "", "away", nil, nil, "", 366))
+	now := time.Now()
+	yesterday := now.Add(-24 * time.Hour)
+	assert.Error(t, VacationSet("john@example.com", true, "", "away", &now, &yesterday, "", 0))
+
+	assert.NoError(t, VacationSet("John@example.com", true, "Away", "away", nil, nil, "", 0))
+	v, err := VacationGet("john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, v.Days)
+	assert.Equal(t, "Away", v.Subject)
+
+	// replaced
+	assert.NoError(t, VacationSet("john@example.com", false, "", "", nil, nil, "example.org", 3))
+	v2, err := VacationGet("john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, v.Id, v2.Id)
+	assert.False(t, v2.Enabled)
+	assert.Equal(t, 3, v2.Days)
+
+	assert.NoError(t, VacationDel("john@example.com"))
+	_, err = VacationGet("john@example.com")
+	assert.Error(t, err)
+}
@@ -9,11 +9,13 @@ require (
 	github.com/lib/pq v1.1.1
 	github.com/nsqio/go-nsq v1.1.0
 	github.com/sirupsen/logrus v1.9.0
+	github.com/stretchr/testify v1.8.1
 	github.com/toorop/go-dkim v0.0.0-20201103131630-e1cd1a0a5208
 	github.com/toorop/go-sqlite3 v0.0.0-20150624184432-023bc7af3f7a
 	github.com/toorop/gopenstack v0.0.0-20180222105328-a83d16339d49
 	github.com/tredoe/osutil v1.0.6
 	golang.org/x/crypto v0.0.0-20191205180655-e7c4368fe9dd
+	golang.org/x/text v0.16.0
 )
 
 require (
@@ -32,7 +34,6 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.0.1 // indirect
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.1 // indirect
 	github.com/urfave/cli v1.22.10 // indirect
 	golang.org/x/sys v0.2.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
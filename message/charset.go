@@ -0,0 +1,121 @@
+package message
+
+import (
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// Charsets
+// UTF-8 and US-ASCII texts are used as is, other charsets are converted
+// with golang.org/x/text: texts are decoded as MUAs and browsers do (WHATWG
+// labels, eg: iso-8859-1 is decoded as windows-1252, its superset) and
+// encoded with the exact charset of their label (IANA names).
+
+// normalizeCharset returns the lowercased canonical name of UTF-8 and
+// US-ASCII, other charsets lowercased
+func normalizeCharset(charset string) string {
+	switch charset = strings.ToLower(strings.TrimSpace(charset)); charset {
+	case "utf8":
+		return "utf-8"
+	case "ascii":
+		return "us-ascii"
+	}
+	return charset
+}
+
+// charsetDecoding returns the encoding used to decode texts in charset (nil
+// if charset is not supported)
+func charsetDecoding(charset string) encoding.Encoding {
+	enc, err := htmlindex.Get(charset)
+	// the replacement encoding is for charsets which must not be decoded
+	if err != nil || enc == encoding.Replacement {
+		return nil
+	}
+	return enc
+}
+
+// charsetEncoding returns the encoding used to encode texts in charset (nil
+// if charset is not supported)
+func charsetEncoding(charset string) encoding.Encoding {
+	if enc, err := ianaindex.MIME.Encoding(charset); err == nil && enc != nil {
+		return enc
+	}
+	return charsetDecoding(charset)
+}
+
+// IsCharsetSupported returns true if texts in charset can be converted
+func IsCharsetSupported(charset string) bool {
+	switch charset = normalizeCharset(charset); charset {
+	case "", "utf-8", "us-ascii":
+		return true
+	}
+	return charsetDecoding(charset) != nil
+}
+
+// DecodeCharset converts data from charset to UTF-8
+func DecodeCharset(charset string, data []byte) (string, error) {
+	switch charset = normalizeCharset(charset); charset {
+	case "", "utf-8", "us-ascii":
+		return string(data), nil
+	}
+	enc := charsetDecoding(charset)
+	if enc == nil {
+		return "", ErrUnsupportedCharset
+	}
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// EncodeCharset converts UTF-8 text to charset, ok is false if charset
+// can't represent text
+func EncodeCharset(charset, text string) (data []byte, ok bool) {
+	switch charset = normalizeCharset(charset); charset {
+	case "utf-8":
+		return []byte(text), utf8.ValidString(text)
+	case "", "us-ascii":
+		return []byte(text), isASCII([]byte(text))
+	}
+	enc := charsetEncoding(charset)
+	if enc == nil {
+		return nil, false
+	}
+	data, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// CharsetReader returns a reader converting input from charset to UTF-8
+// (for mime.WordDecoder)
+func CharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch charset = normalizeCharset(charset); charset {
+	case "", "utf-8", "us-ascii":
+		return input, nil
+	}
+	enc := charsetDecoding(charset)
+	if enc == nil {
+		return nil, ErrUnsupportedCharset
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// DecodeHeader decodes RFC 2047 encoded words of header value value, value
+// is returned as is if it can't be decoded
+func DecodeHeader(value string) string {
+	decoder := &mime.WordDecoder{CharsetReader: CharsetReader}
+	decoded, err := decoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,71 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Charsets(t *testing.T) {
+	text, err := DecodeCharset("ISO-8859-15", []byte{'c', 'o', 0xFB, 't', ' ', 0xA4})
+	assert.NoError(t, err)
+	assert.Equal(t, "coût €", text)
+	text, err = DecodeCharset("windows-1252", []byte{0x80, 0x82, 0x93, 0x9F, 0xE9})
+	assert.NoError(t, err)
+	assert.Equal(t, "€‚“Ÿé", text)
+	// iso-8859-1 is decoded as windows-1252
+	text, err = DecodeCharset("iso-8859-1", []byte{0x80, 0xE9})
+	assert.NoError(t, err)
+	assert.Equal(t, "€é", text)
+	_, err = DecodeCharset("x-unknown", []byte{0xC1})
+	assert.Equal(t, ErrUnsupportedCharset, err)
+	_, err = DecodeCharset("iso-2022-kr", []byte{0xC1})
+	assert.Equal(t, ErrUnsupportedCharset, err)
+
+	tests := []struct {
+		charset string
+		data    []byte
+		text    string
+	}{
+		{"iso-8859-2", []byte{0xB3, 0xF3, 0xBF, 0xEA, 'k'}, "łóżęk"},
+		{"koi8-r", []byte{0xF0, 0xD2, 0xC9, 0xD7, 0xC5, 0xD4}, "Привет"},
+		{"shift_jis", []byte{0x82, 0xB1, 0x82, 0xF1, 0x82, 0xC9, 0x82, 0xBF, 0x82, 0xCD}, "こんにちは"},
+		{"GB2312", []byte{0xC4, 0xE3, 0xBA, 0xC3}, "你好"},
+		{"big5", []byte{0xA7, 0x41, 0xA6, 0x6E}, "你好"},
+	}
+	for _, test := range tests {
+		text, err := DecodeCharset(test.charset, test.data)
+		assert.NoError(t, err, test.charset)
+		assert.Equal(t, test.text, text, test.charset)
+		data, ok := EncodeCharset(test.charset, test.text)
+		assert.True(t, ok, test.charset)
+		assert.Equal(t, test.data, data, test.charset)
+		assert.True(t, IsCharsetSupported(test.charset), test.charset)
+	}
+
+	data, ok := EncodeCharset("latin1", "été")
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0xE9, 't', 0xE9}, data)
+	// iso-8859-1 is encoded as is
+	_, ok = EncodeCharset("iso-8859-1", "€")
+	assert.False(t, ok)
+	data, ok = EncodeCharset("cp1252", "€")
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0x80}, data)
+	_, ok = EncodeCharset("us-ascii", "é")
+	assert.False(t, ok)
+	_, ok = EncodeCharset("koi8-r", "é")
+	assert.False(t, ok)
+	assert.True(t, IsCharsetSupported("CP1252"))
+	assert.True(t, IsCharsetSupported("utf8"))
+	assert.False(t, IsCharsetSupported("x-unknown"))
+}
+
+func Test_DecodeHeader(t *testing.T) {
+	assert.Equal(t, "Jô <jo@tmail.io>", DecodeHeader("=?utf-8?q?J=C3=B4?= <jo@tmail.io>"))
+	assert.Equal(t, "coût €", DecodeHeader("=?iso-8859-15?q?co=FBt_=A4?="))
+	assert.Equal(t, "а", DecodeHeader("=?koi8-r?q?=C1?="))
+	assert.Equal(t, "こんにちは", DecodeHeader("=?ISO-2022-JP?B?GyRCJDMkcyRLJEEkTxsoQg==?="))
+	assert.Equal(t, "=?x-unknown?q?=C1?=", DecodeHeader("=?x-unknown?q?=C1?="))
+	assert.Equal(t, "plain", DecodeHeader("plain"))
+}
@@ -0,0 +1,691 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// MIME parts
+// ParseMIME splits a raw message in a tree of parts keeping the raw bytes
+// of each one. A part which is not modified is serialized with its
+// original bytes, only modified parts (and the delimiters of their
+// parents) are rebuilt: signatures (DKIM...) of untouched parts survive.
+// WalkMIME streams the parts of a message without keeping them in memory.
+
+// maxMIMEDepth is the max nesting level of multiparts
+const maxMIMEDepth = 50
+
+var (
+	// ErrMIMETooDeep when multiparts are nested too deeply
+	ErrMIMETooDeep = errors.New("MIME parts are nested too deeply")
+	// ErrNotMultipart when a multipart operation is done on a leaf part
+	ErrNotMultipart = errors.New("part is not a multipart")
+	// ErrUnsupportedCharset when a text part uses a charset we can't convert
+	ErrUnsupportedCharset = errors.New("unsupported charset")
+)
+
+// Part is a MIME part, the message itself is the root part
+type Part struct {
+	Header textproto.MIMEHeader
+	// lowercased media type (text/plain if not defined)
+	ContentType string
+	Params      map[string]string
+	// sub parts of a multipart
+	Parts []*Part
+
+	// raw header fields (with their line endings)
+	header []byte
+	// empty line between header and body
+	separator []byte
+	// raw body of a leaf part
+	body []byte
+	// multipart: preamble, delimiter lines (with line ending before them),
+	// close delimiter and epilogue
+	preamble   []byte
+	delimiters [][]byte
+	closing    []byte
+	epilogue   []byte
+	// original bytes, nil if the part has been modified
+	raw []byte
+	// header can't be parsed
+	malformed bool
+}
+
+// ParseMIME parses raw message
+func ParseMIME(raw []byte) (*Part, error) {
+	return parsePart(raw, 0)
+}
+
+// parsePart parses the raw part raw at depth depth
+func parsePart(raw []byte, depth int) (*Part, error) {
+	if depth > maxMIMEDepth {
+		return nil, ErrMIMETooDeep
+	}
+	p := &Part{raw: raw}
+	// header ends with the first empty line
+	offset := 0
+	for offset < len(raw) {
+		end := bytes.IndexByte(raw[offset:], '\n')
+		if end == -1 {
+			end = len(raw)
+		} else {
+			end += offset + 1
+		}
+		if line := raw[offset:end]; len(bytes.TrimRight(line, "\r\n")) == 0 && len(line) != 0 && line[len(line)-1] == '\n' {
+			p.header = raw[:offset]
+			p.separator = raw[offset:end]
+			p.body = raw[end:]
+			break
+		}
+		offset = end
+	}
+	if p.separator == nil {
+		p.header = raw
+		p.body = []byte{}
+	}
+	if err := p.parseHeader(); err != nil {
+		if depth == 0 {
+			return nil, err
+		}
+		// sub part without header
+		p.header, p.separator, p.body = []byte{}, nil, raw
+		p.parseHeader()
+		p.malformed = true
+		return p, nil
+	}
+	if !p.IsMultipart() {
+		return p, nil
+	}
+	if err := p.parseMultipart(depth); err != nil {
+		return nil, err
+	}
+	p.body = nil
+	return p, nil
+}
+
+// parseHeader parses raw header fields of p
+func (p *Part) parseHeader() error {
+	p.Header = textproto.MIMEHeader{}
+	if len(bytes.TrimSpace(p.header)) != 0 {
+		header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(append([]byte{}, p.header...), 13, 10, 13, 10)))).ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		p.Header = header
+	}
+	p.parseContentType()
+	return nil
+}
+
+// parseContentType sets media type and parameters of p from its header
+func (p *Part) parseContentType() {
+	p.ContentType, p.Params = "text/plain", map[string]string{}
+	if contentType := p.Header.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if mediaType != "" {
+			p.ContentType = mediaType
+		}
+		if err == nil {
+			p.Params = params
+		}
+	}
+}
+
+// parseMultipart splits body of p in sub parts
+func (p *Part) parseMultipart(depth int) error {
+	dash := []byte("--" + p.Params["boundary"])
+	body := p.body
+	start, lastEnd := -1, 0
+	for offset := 0; offset < len(body); {
+		end := bytes.IndexByte(body[offset:], '\n')
+		if end == -1 {
+			end = len(body)
+		} else {
+			end += offset + 1
+		}
+		line := bytes.TrimRight(body[offset:end], "\r\n")
+		if bytes.HasPrefix(line, dash) {
+			rest := line[len(dash):]
+			closing := bytes.HasPrefix(rest, []byte("--"))
+			if closing {
+				rest = rest[2:]
+			}
+			if len(bytes.Trim(rest, " \t")) == 0 {
+				// the line ending before a delimiter belongs to it
+				delimStart := offset
+				if offset > lastEnd && body[offset-1] == '\n' {
+					delimStart--
+					if delimStart > lastEnd && body[delimStart-1] == '\r' {
+						delimStart--
+					}
+				}
+				if start == -1 {
+					p.preamble = body[:delimStart]
+				} else {
+					child, err := parsePart(body[lastEnd:delimStart], depth+1)
+					if err != nil {
+						return err
+					}
+					p.Parts = append(p.Parts, child)
+				}
+				if closing {
+					p.closing = body[delimStart:end]
+					p.epilogue = body[end:]
+					return nil
+				}
+				p.delimiters = append(p.delimiters, body[delimStart:end])
+				start, lastEnd = delimStart, end
+			}
+		}
+		offset = end
+	}
+	// no close delimiter
+	if start == -1 {
+		p.preamble = body
+		return nil
+	}
+	child, err := parsePart(body[lastEnd:], depth+1)
+	if err != nil {
+		return err
+	}
+	p.Parts = append(p.Parts, child)
+	return nil
+}
+
+// IsMultipart returns true if p is a multipart
+func (p *Part) IsMultipart() bool {
+	return strings.HasPrefix(p.ContentType, "multipart/") && p.Params["boundary"] != ""
+}
+
+// Malformed returns true if the header of sub part p can't be parsed (p
+// has been parsed as a part without header)
+func (p *Part) Malformed() bool {
+	return p.malformed
+}
+
+// IsAttachment returns true if p is an attachment
+func (p *Part) IsAttachment() bool {
+	disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	return disposition == "attachment"
+}
+
+// Charset returns the lowercased charset of p
+func (p *Part) Charset() string {
+	return strings.ToLower(p.Params["charset"])
+}
+
+// Filename returns the decoded filename of p (from Content-Disposition or
+// Content-Type name parameter)
+func (p *Part) Filename() string {
+	_, params, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	filename := params["filename"]
+	if filename == "" {
+		filename = p.Params["name"]
+	}
+	// RFC 2231 parameters are decoded by ParseMediaType, not RFC 2047 ones
+	return DecodeHeader(filename)
+}
+
+// Walk calls fn for p and all its sub parts (depth first), if fn returns
+// false sub parts of the part are not walked
+func (p *Part) Walk(fn func(part *Part) bool) {
+	if !fn(p) {
+		return
+	}
+	for _, child := range p.Parts {
+		child.Walk(fn)
+	}
+}
+
+// modified returns true if p or one of its sub parts has been modified
+func (p *Part) modified() bool {
+	if p.raw == nil {
+		return true
+	}
+	for _, child := range p.Parts {
+		if child.modified() {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes returns raw part, untouched parts keep their original bytes
+func (p *Part) Bytes() []byte {
+	if !p.modified() {
+		return p.raw
+	}
+	out := &bytes.Buffer{}
+	out.Write(p.header)
+	if p.separator != nil {
+		out.Write(p.separator)
+	} else {
+		out.WriteString(p.lineEnding())
+	}
+	if !p.IsMultipart() {
+		out.Write(p.body)
+		return out.Bytes()
+	}
+	out.Write(p.preamble)
+	for i, child := range p.Parts {
+		out.Write(p.delimiters[i])
+		out.Write(child.Bytes())
+	}
+	out.Write(p.closing)
+	out.Write(p.epilogue)
+	return out.Bytes()
+}
+
+// lineEnding returns line ending used by p (CRLF by default)
+func (p *Part) lineEnding() string {
+	if i := bytes.IndexByte(p.header, '\n'); i > 0 && p.header[i-1] != '\r' {
+		return "\n"
+	}
+	return "\r\n"
+}
+
+// GetHeader returns the first value of header key
+func (p *Part) GetHeader(key string) string {
+	return p.Header.Get(key)
+}
+
+// GetDecodedHeader returns the first value of header key with its RFC 2047
+// encoded words decoded
+func (p *Part) GetDecodedHeader(key string) string {
+	return DecodeHeader(p.Header.Get(key))
+}
+
+// SetHeader replaces all the occurrences of header key by a single header
+// with value value, the header is added at the end if not present.
+func (p *Part) SetHeader(key, value string) {
+	field := []byte(textproto.CanonicalMIMEHeaderKey(key) + ": " + value)
+	FoldHeader(&field)
+	field = append(bytes.Replace(field, []byte{13, 10}, []byte(p.lineEnding()), -1), p.lineEnding()...)
+	fields, replaced := [][]byte{}, false
+	for _, f := range splitHeaderFields(p.header) {
+		if headerFieldIs(f, key) {
+			if !replaced {
+				fields = append(fields, field)
+				replaced = true
+			}
+			continue
+		}
+		fields = append(fields, f)
+	}
+	if !replaced {
+		fields = append(fields, field)
+	}
+	p.setRawHeader(bytes.Join(fields, nil))
+}
+
+// DelHeader removes all the occurrences of header key
+func (p *Part) DelHeader(key string) {
+	fields := [][]byte{}
+	for _, f := range splitHeaderFields(p.header) {
+		if !headerFieldIs(f, key) {
+			fields = append(fields, f)
+		}
+	}
+	p.setRawHeader(bytes.Join(fields, nil))
+}
+
+// setRawHeader replaces raw header fields of p
+func (p *Part) setRawHeader(header []byte) {
+	p.header = header
+	p.raw = nil
+	// fields come from a valid header
+	p.parseHeader()
+}
+
+// splitHeaderFields splits raw header fields (with their continuation
+// lines and line endings)
+func splitHeaderFields(header []byte) [][]byte {
+	fields := [][]byte{}
+	start := 0
+	for offset := 0; offset < len(header); {
+		end := bytes.IndexByte(header[offset:], '\n')
+		if end == -1 {
+			end = len(header)
+		} else {
+			end += offset + 1
+		}
+		if offset != 0 && header[offset] != ' ' && header[offset] != '\t' {
+			fields = append(fields, header[start:offset])
+			start = offset
+		}
+		offset = end
+	}
+	if start < len(header) {
+		fields = append(fields, header[start:])
+	}
+	return fields
+}
+
+// headerFieldIs returns true if field is a key header
+func headerFieldIs(field []byte, key string) bool {
+	i := bytes.IndexByte(field, ':')
+	return i != -1 && strings.EqualFold(string(bytes.TrimSpace(field[:i])), key)
+}
+
+// Decode returns body of leaf part p decoded from its transfer encoding
+func (p *Part) Decode() ([]byte, error) {
+	if p.IsMultipart() {
+		return nil, errors.New("multipart has no body to decode")
+	}
+	switch strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return decodeBase64(p.body)
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(p.body)))
+	}
+	return p.body, nil
+}
+
+// decodeBase64 decodes base64 data ignoring spaces, line endings and
+// missing padding
+func decodeBase64(data []byte) ([]byte, error) {
+	clean := make([]byte, 0, len(data))
+	for _, c := range data {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			clean = append(clean, c)
+		}
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(string(clean), "="))
+}
+
+// SetBody replaces body of leaf part p by data encoded with its transfer
+// encoding (7bit parts with 8bit data are switched to quoted-printable)
+func (p *Part) SetBody(data []byte) error {
+	if p.IsMultipart() {
+		return ErrNotMultipart
+	}
+	encoding := strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding")))
+	if (encoding == "" || encoding == "7bit") && !isASCII(data) {
+		encoding = "quoted-printable"
+		p.SetHeader("Content-Transfer-Encoding", encoding)
+	}
+	switch encoding {
+	case "base64":
+		p.body = encodeBase64(data, p.lineEnding())
+	case "quoted-printable":
+		out := &bytes.Buffer{}
+		w := quotedprintable.NewWriter(out)
+		w.Write(data)
+		w.Close()
+		p.body = out.Bytes()
+		if p.lineEnding() == "\n" {
+			p.body = bytes.Replace(p.body, []byte{13, 10}, []byte{10}, -1)
+		}
+	default:
+		p.body = data
+		if strings.HasPrefix(p.ContentType, "text/") {
+			p.body = bytes.Replace(bytes.Replace(data, []byte{13, 10}, []byte{10}, -1), []byte{10}, []byte(p.lineEnding()), -1)
+		}
+	}
+	p.raw = nil
+	return nil
+}
+
+// encodeBase64 encodes data in base64 lines of 76 chars
+func encodeBase64(data []byte, lineEnding string) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	out := &bytes.Buffer{}
+	for len(encoded) > 76 {
+		out.WriteString(encoded[:76] + lineEnding)
+		encoded = encoded[76:]
+	}
+	if len(encoded) != 0 {
+		out.WriteString(encoded + lineEnding)
+	}
+	return out.Bytes()
+}
+
+// Text returns decoded text of leaf part p as UTF-8
+func (p *Part) Text() (string, error) {
+	data, err := p.Decode()
+	if err != nil {
+		return "", err
+	}
+	return DecodeCharset(p.Charset(), data)
+}
+
+// SetText replaces text of leaf part p, text is converted to the charset
+// of p or p is switched to UTF-8 if its charset can't represent text
+func (p *Part) SetText(text string) error {
+	data, ok := EncodeCharset(p.Charset(), text)
+	if !ok {
+		if !utf8.ValidString(text) {
+			return errors.New("text is not valid UTF-8")
+		}
+		params := map[string]string{}
+		for k, v := range p.Params {
+			params[k] = v
+		}
+		params["charset"] = "utf-8"
+		p.SetHeader("Content-Type", mime.FormatMediaType(p.ContentType, params))
+		data = []byte(text)
+	}
+	return p.SetBody(data)
+}
+
+// isASCII returns true if data contains only 7 bits chars
+func isASCII(data []byte) bool {
+	for _, c := range data {
+		if c > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// NewTextPart returns a new UTF-8 quoted-printable part of media type
+// mediaType (text/plain, text/html...)
+func NewTextPart(mediaType, text string) *Part {
+	p := &Part{}
+	p.setRawHeader([]byte("Content-Type: " + mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}) + "\r\nContent-Transfer-Encoding: quoted-printable\r\n"))
+	p.separator = []byte{13, 10}
+	p.SetBody([]byte(text))
+	return p
+}
+
+// NewAttachmentPart returns a new base64 attachment named filename
+func NewAttachmentPart(mediaType, filename string, data []byte) *Part {
+	p := &Part{}
+	p.setRawHeader([]byte("Content-Type: " + mime.FormatMediaType(mediaType, map[string]string{"name": filename}) +
+		"\r\nContent-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": filename}) +
+		"\r\nContent-Transfer-Encoding: base64\r\n"))
+	p.separator = []byte{13, 10}
+	p.SetBody(data)
+	return p
+}
+
+// NewMultipart returns a new multipart of media type mediaType
+// (multipart/mixed, multipart/alternative...) with parts
+func NewMultipart(mediaType, boundary string, parts ...*Part) *Part {
+	p := &Part{}
+	p.setRawHeader([]byte("Content-Type: " + mime.FormatMediaType(mediaType, map[string]string{"boundary": boundary}) + "\r\n"))
+	p.separator = []byte{13, 10}
+	p.setParts(parts)
+	return p
+}
+
+// Wrap moves the content of p (its Content-* headers and body) in a new sub
+// part of p and turns p in a multipart/mixed with this sub part followed
+// by parts.
+// Wrapping a signed or encrypted message keeps it valid.
+func (p *Part) Wrap(boundary string, parts ...*Part) error {
+	lineEnding := p.lineEnding()
+	body := p.body
+	if p.IsMultipart() {
+		// keep the original body bytes
+		buf := &bytes.Buffer{}
+		buf.Write(p.preamble)
+		for i, child := range p.Parts {
+			buf.Write(p.delimiters[i])
+			buf.Write(child.Bytes())
+		}
+		buf.Write(p.closing)
+		buf.Write(p.epilogue)
+		body = buf.Bytes()
+	}
+	// Content-* fields go to the inner part
+	fields, innerFields := [][]byte{}, [][]byte{}
+	for _, f := range splitHeaderFields(p.header) {
+		if len(f) > 8 && strings.EqualFold(string(f[:8]), "content-") {
+			innerFields = append(innerFields, f)
+		} else if !headerFieldIs(f, "MIME-Version") {
+			fields = append(fields, f)
+		}
+	}
+	innerRaw := append(bytes.Join(innerFields, nil), lineEnding...)
+	inner, err := parsePart(append(innerRaw, body...), 1)
+	if err != nil {
+		return err
+	}
+	fields = append(fields, []byte("MIME-Version: 1.0"+lineEnding))
+	fields = append(fields, []byte("Content-Type: multipart/mixed; boundary=\""+boundary+"\""+lineEnding))
+	p.setRawHeader(bytes.Join(fields, nil))
+	p.separator = []byte(lineEnding)
+	p.setParts(append([]*Part{inner}, parts...))
+	return nil
+}
+
+// setParts replaces body of multipart p by parts
+func (p *Part) setParts(parts []*Part) {
+	p.body = nil
+	p.preamble = []byte{}
+	p.Parts = []*Part{}
+	p.delimiters = [][]byte{}
+	p.closing = nil
+	p.epilogue = []byte{}
+	p.raw = nil
+	for _, part := range parts {
+		p.AddPart(part)
+	}
+}
+
+// delimiter returns a delimiter line of multipart p
+func (p *Part) delimiter(first bool) []byte {
+	if first {
+		return []byte("--" + p.Params["boundary"] + p.lineEnding())
+	}
+	return []byte(p.lineEnding() + "--" + p.Params["boundary"] + p.lineEnding())
+}
+
+// InsertPart inserts part at index i of sub parts of multipart p
+func (p *Part) InsertPart(i int, part *Part) error {
+	if !p.IsMultipart() {
+		return ErrNotMultipart
+	}
+	if i < 0 || i > len(p.Parts) {
+		return errors.New("part index out of range")
+	}
+	p.Parts = append(p.Parts[:i], append([]*Part{part}, p.Parts[i:]...)...)
+	delimiter := p.delimiter(len(p.delimiters) == 0 && len(p.preamble) == 0)
+	p.delimiters = append(p.delimiters[:i], append([][]byte{delimiter}, p.delimiters[i:]...)...)
+	// the first delimiter stays first
+	if i == 0 && len(p.delimiters) > 1 {
+		p.delimiters[0], p.delimiters[1] = p.delimiters[1], p.delimiters[0]
+	}
+	if p.closing == nil {
+		p.closing = []byte(p.lineEnding() + "--" + p.Params["boundary"] + "--" + p.lineEnding())
+	}
+	p.raw = nil
+	return nil
+}
+
+// AddPart adds part at the end of sub parts of multipart p
+func (p *Part) AddPart(part *Part) error {
+	return p.InsertPart(len(p.Parts), part)
+}
+
+// RemovePart removes sub part i of multipart p
+func (p *Part) RemovePart(i int) error {
+	if !p.IsMultipart() {
+		return ErrNotMultipart
+	}
+	if i < 0 || i >= len(p.Parts) {
+		return errors.New("part index out of range")
+	}
+	p.Parts = append(p.Parts[:i], p.Parts[i+1:]...)
+	// the first delimiter stays first
+	if i == 0 && len(p.delimiters) > 1 {
+		i = 1
+	}
+	p.delimiters = append(p.delimiters[:i], p.delimiters[i+1:]...)
+	p.raw = nil
+	return nil
+}
+
+// ReplacePart replaces sub part i of multipart p by part
+func (p *Part) ReplacePart(i int, part *Part) error {
+	if !p.IsMultipart() {
+		return ErrNotMultipart
+	}
+	if i < 0 || i >= len(p.Parts) {
+		return errors.New("part index out of range")
+	}
+	p.Parts[i] = part
+	p.raw = nil
+	return nil
+}
+
+// WalkMIME reads a message from r and calls fn for each part (depth first,
+// multiparts before their sub parts) with its body decoded from its
+// transfer encoding (nil for multiparts). Parts are streamed: only their
+// header is set and they can't be serialized.
+// If fn returns an error the walk stops and returns it.
+func WalkMIME(r io.Reader, fn func(p *Part, body io.Reader) error) error {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && (err != io.EOF || len(header) == 0) {
+		return err
+	}
+	p := &Part{Header: header}
+	p.parseContentType()
+	return walkMIME(p, reader.R, fn, 0)
+}
+
+// walkMIME walks part p with raw body body at depth depth
+func walkMIME(p *Part, body io.Reader, fn func(p *Part, body io.Reader) error, depth int) error {
+	if depth > maxMIMEDepth {
+		return ErrMIMETooDeep
+	}
+	if !p.IsMultipart() {
+		switch strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))) {
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, body)
+		case "quoted-printable":
+			body = quotedprintable.NewReader(body)
+		}
+		return fn(p, body)
+	}
+	if err := fn(p, nil); err != nil {
+		return err
+	}
+	reader := multipart.NewReader(body, p.Params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		child := &Part{Header: part.Header}
+		child.parseContentType()
+		if err = walkMIME(child, part, fn, depth+1); err != nil {
+			return err
+		}
+	}
+}
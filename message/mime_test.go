@@ -0,0 +1,176 @@
+package message
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const mimeMail1 = "From: toorop@tmail.io\r\nTo: foo@bar.com\r\nSubject: test\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+	"This is a multi-part message in MIME format.\r\n" +
+	"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+	"--inner\r\nContent-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nh=E9llo\r\n" +
+	"--inner\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\nPGh0bWw+PGJvZHk+aGVsbG88L2JvZHk+PC9odG1sPg==\r\n" +
+	"--inner--\r\n" +
+	"\r\n--outer\r\nContent-Type: application/pdf; name=\"doc.pdf\"\r\nContent-Disposition: attachment; filename=\"doc.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjQK\r\n" +
+	"--outer--  \r\nepilogue\r\n"
+
+func Test_ParseMIME(t *testing.T) {
+	root, err := ParseMIME([]byte(mimeMail1))
+	assert.NoError(t, err)
+	assert.True(t, root.IsMultipart())
+	assert.Equal(t, "multipart/mixed", root.ContentType)
+	assert.Len(t, root.Parts, 2)
+	assert.Len(t, root.Parts[0].Parts, 2)
+	assert.True(t, root.Parts[1].IsAttachment())
+
+	text, err := root.Parts[0].Parts[0].Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "héllo", text)
+	html, err := root.Parts[0].Parts[1].Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "<html><body>hello</body></html>", html)
+
+	// untouched message
+	assert.Equal(t, mimeMail1, string(root.Bytes()))
+	assert.False(t, root.Parts[1].Malformed())
+
+	// sub part with a malformed header
+	raw := "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nBad Header Line\r\n\r\nhello\r\n--b\r\nContent-Type: text/html\r\n\r\n<p>hi</p>\r\n--b--\r\n"
+	root, err = ParseMIME([]byte(raw))
+	assert.NoError(t, err)
+	if assert.Len(t, root.Parts, 2) {
+		assert.True(t, root.Parts[0].Malformed())
+		assert.Equal(t, "text/plain", root.Parts[0].ContentType)
+		assert.False(t, root.Parts[1].Malformed())
+		assert.Equal(t, "text/html", root.Parts[1].ContentType)
+	}
+	assert.Equal(t, raw, string(root.Bytes()))
+}
+
+func Test_PartSetText(t *testing.T) {
+	root, err := ParseMIME([]byte(mimeMail1))
+	assert.NoError(t, err)
+	assert.NoError(t, root.Parts[0].Parts[0].SetText("héllo world"))
+	out := string(root.Bytes())
+	assert.Contains(t, out, "Content-Transfer-Encoding: quoted-printable\r\n\r\nh=E9llo world\r\n--inner\r\n")
+	// other parts are untouched
+	assert.Contains(t, out, "\r\n--outer\r\nContent-Type: application/pdf; name=\"doc.pdf\"\r\nContent-Disposition: attachment; filename=\"doc.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjQK\r\n--outer--  \r\nepilogue\r\n")
+	assert.True(t, strings.HasPrefix(out, "From: toorop@tmail.io\r\nTo: foo@bar.com\r\n"))
+
+	// charset can't represent text
+	assert.NoError(t, root.Parts[0].Parts[0].SetText("hello €"))
+	assert.Equal(t, "utf-8", root.Parts[0].Parts[0].Charset())
+	text, err := root.Parts[0].Parts[0].Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello €", text)
+
+	// 7bit part switched to quoted-printable
+	root, err = ParseMIME([]byte(rawMail1))
+	assert.NoError(t, err)
+	assert.NoError(t, root.SetText("body é\r\n"))
+	assert.Equal(t, "quoted-printable", root.GetHeader("Content-Transfer-Encoding"))
+	assert.Equal(t, "utf-8", root.Charset())
+}
+
+func Test_PartWrap(t *testing.T) {
+	signed := "From: toorop@tmail.io\r\nMIME-Version: 1.0\r\nContent-Type: multipart/signed; protocol=\"application/pgp-signature\"; boundary=\"sig\"\r\n\r\n" +
+		"--sig\r\nContent-Type: text/plain\r\n\r\nsigned text\r\n--sig\r\nContent-Type: application/pgp-signature\r\n\r\nSIGNATURE\r\n--sig--\r\n"
+	root, err := ParseMIME([]byte(signed))
+	assert.NoError(t, err)
+	assert.NoError(t, root.Wrap("wrap", NewTextPart("text/plain", "disclaimer")))
+	out := string(root.Bytes())
+	assert.Equal(t, "From: toorop@tmail.io\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"wrap\"\r\n\r\n"+
+		"--wrap\r\nContent-Type: multipart/signed; protocol=\"application/pgp-signature\"; boundary=\"sig\"\r\n\r\n"+
+		"--sig\r\nContent-Type: text/plain\r\n\r\nsigned text\r\n--sig\r\nContent-Type: application/pgp-signature\r\n\r\nSIGNATURE\r\n--sig--\r\n"+
+		"\r\n--wrap\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ndisclaimer"+
+		"\r\n--wrap--\r\n", out)
+
+	root, err = ParseMIME([]byte(out))
+	assert.NoError(t, err)
+	assert.Len(t, root.Parts, 2)
+	assert.Equal(t, "multipart/signed", root.Parts[0].ContentType)
+	assert.Len(t, root.Parts[0].Parts, 2)
+}
+
+func Test_PartMutations(t *testing.T) {
+	root, err := ParseMIME([]byte(mimeMail1))
+	assert.NoError(t, err)
+	alternative := root.Parts[0]
+
+	// remove the attachment
+	assert.NoError(t, root.RemovePart(1))
+	assert.Equal(t, "From: toorop@tmail.io\r\nTo: foo@bar.com\r\nSubject: test\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n"+
+		"This is a multi-part message in MIME format.\r\n"+
+		"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n"+
+		"--inner\r\nContent-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nh=E9llo\r\n"+
+		"--inner\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\nPGh0bWw+PGJvZHk+aGVsbG88L2JvZHk+PC9odG1sPg==\r\n"+
+		"--inner--\r\n"+
+		"\r\n--outer--  \r\nepilogue\r\n", string(root.Bytes()))
+
+	// add an attachment and insert a part before the alternative
+	assert.NoError(t, root.AddPart(NewAttachmentPart("text/csv", "données.csv", []byte("a;b\n"))))
+	assert.NoError(t, root.InsertPart(0, NewTextPart("text/plain", "first")))
+	assert.Equal(t, ErrNotMultipart, alternative.Parts[0].AddPart(NewTextPart("text/plain", "x")))
+	assert.Error(t, root.RemovePart(3))
+
+	root, err = ParseMIME(root.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, root.Parts, 3)
+	text, _ := root.Parts[0].Text()
+	assert.Equal(t, "first", text)
+	assert.Equal(t, "multipart/alternative", root.Parts[1].ContentType)
+	assert.Equal(t, "données.csv", root.Parts[2].Filename())
+	assert.True(t, root.Parts[2].IsAttachment())
+	data, _ := root.Parts[2].Decode()
+	assert.Equal(t, "a;b\n", string(data))
+	assert.Equal(t, "epilogue\r\n", string(root.epilogue))
+
+	// replace the alternative by its text part
+	assert.NoError(t, root.ReplacePart(1, root.Parts[1].Parts[0]))
+	root, err = ParseMIME(root.Bytes())
+	assert.NoError(t, err)
+	text, _ = root.Parts[1].Text()
+	assert.Equal(t, "héllo", text)
+
+	// new multipart
+	multi := NewMultipart("multipart/alternative", "b1", NewTextPart("text/plain", "text"), NewTextPart("text/html", "<p>html</p>"))
+	assert.Equal(t, "Content-Type: multipart/alternative; boundary=b1\r\n\r\n--b1\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ntext"+
+		"\r\n--b1\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>html</p>\r\n--b1--\r\n", string(multi.Bytes()))
+}
+
+func Test_WalkMIME(t *testing.T) {
+	types, texts := []string{}, []string{}
+	err := WalkMIME(strings.NewReader(mimeMail1), func(p *Part, body io.Reader) error {
+		types = append(types, p.ContentType)
+		if body != nil && !p.IsAttachment() {
+			data, err := ioutil.ReadAll(body)
+			if err != nil {
+				return err
+			}
+			text, err := DecodeCharset(p.Charset(), data)
+			if err != nil {
+				return err
+			}
+			texts = append(texts, text)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html", "application/pdf"}, types)
+	assert.Equal(t, []string{"héllo", "<html><body>hello</body></html>"}, texts)
+
+	// walk stops on error
+	stop := errors.New("stop")
+	count := 0
+	err = WalkMIME(strings.NewReader(mimeMail1), func(p *Part, body io.Reader) error {
+		count++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, count)
+}
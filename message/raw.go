@@ -40,3 +40,96 @@ func RawGetMessageId(raw *[]byte) []byte {
 	}
 	return []byte{}
 }
+
+// RawGetHeader returns the (unfolded) value of the first header header
+// or an empty string if not found
+func RawGetHeader(raw *[]byte, header string) string {
+	bHeader := []byte(strings.ToLower(header) + ":")
+	found := false
+	value := []byte{}
+	for _, line := range bytes.Split(RawGetHeaders(raw), []byte{13, 10}) {
+		if found {
+			// folded
+			if len(line) != 0 && (line[0] == 32 || line[0] == 9) {
+				value = append(value, line...)
+				continue
+			}
+			break
+		}
+		if bytes.HasPrefix(bytes.ToLower(line), bHeader) {
+			found = true
+			value = append(value, line[len(bHeader):]...)
+		}
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// RawSetHeader replaces all the occurrences of header header by
+// a single header with value value. If header is not present it
+// will be added on top of headers.
+func RawSetHeader(raw *[]byte, header, value string) {
+	bHeader := []byte(strings.ToLower(header) + ":")
+	newHeader := []byte(textproto.CanonicalMIMEHeaderKey(header) + ": " + value)
+	FoldHeader(&newHeader)
+
+	parts := bytes.SplitN(*raw, []byte{13, 10, 13, 10}, 2)
+	lines := [][]byte{}
+	inHeader := false
+	replaced := false
+	for _, line := range bytes.Split(parts[0], []byte{13, 10}) {
+		if inHeader && len(line) != 0 && (line[0] == 32 || line[0] == 9) {
+			continue
+		}
+		inHeader = false
+		if bytes.HasPrefix(bytes.ToLower(line), bHeader) {
+			inHeader = true
+			if !replaced {
+				lines = append(lines, newHeader)
+				replaced = true
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !replaced {
+		lines = append([][]byte{newHeader}, lines...)
+	}
+	out := bytes.Join(lines, []byte{13, 10})
+	if len(parts) == 2 {
+		out = append(out, []byte{13, 10, 13, 10}...)
+		out = append(out, parts[1]...)
+	}
+	*raw = out
+}
+
+// RawDelHeader removes all the occurrences of header header
+// If header ends with * all headers with this prefix are removed
+// (eg List-*)
+func RawDelHeader(raw *[]byte, header string) {
+	header = strings.ToLower(header)
+	match := func(line []byte) bool {
+		if strings.HasSuffix(header, "*") {
+			return bytes.HasPrefix(bytes.ToLower(line), []byte(header[:len(header)-1])) && bytes.Contains(line, []byte{58})
+		}
+		return bytes.HasPrefix(bytes.ToLower(line), []byte(header+":"))
+	}
+
+	parts := bytes.SplitN(*raw, []byte{13, 10, 13, 10}, 2)
+	lines := [][]byte{}
+	inHeader := false
+	for _, line := range bytes.Split(parts[0], []byte{13, 10}) {
+		if inHeader && len(line) != 0 && (line[0] == 32 || line[0] == 9) {
+			continue
+		}
+		inHeader = match(line)
+		if !inHeader {
+			lines = append(lines, line)
+		}
+	}
+	out := bytes.Join(lines, []byte{13, 10})
+	if len(parts) == 2 {
+		out = append(out, []byte{13, 10, 13, 10}...)
+		out = append(out, parts[1]...)
+	}
+	*raw = out
+}
@@ -0,0 +1,36 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rawMail1 = "Subject: test\r\nFrom: \"Toorop\"\r\n <toorop@tmail.io>\r\nTo: foo@bar.com\r\n\r\nbody\r\nFrom: not a header\r\n"
+
+func Test_RawGetHeader(t *testing.T) {
+	raw := []byte(rawMail1)
+	assert.Equal(t, "\"Toorop\" <toorop@tmail.io>", RawGetHeader(&raw, "from"))
+	assert.Equal(t, "foo@bar.com", RawGetHeader(&raw, "To"))
+	assert.Equal(t, "", RawGetHeader(&raw, "Cc"))
+}
+
+func Test_RawSetHeader(t *testing.T) {
+	raw := []byte(rawMail1)
+	RawSetHeader(&raw, "from", "john@tmail.io")
+	assert.Equal(t, "Subject: test\r\nFrom: john@tmail.io\r\nTo: foo@bar.com\r\n\r\nbody\r\nFrom: not a header\r\n", string(raw))
+
+	RawSetHeader(&raw, "Cc", "jane@tmail.io")
+	assert.Equal(t, "jane@tmail.io", RawGetHeader(&raw, "cc"))
+	assert.True(t, RawHaveHeader(&raw, "Cc"))
+}
+
+func Test_RawDelHeader(t *testing.T) {
+	raw := []byte(rawMail1)
+	RawDelHeader(&raw, "from")
+	assert.Equal(t, "Subject: test\r\nTo: foo@bar.com\r\n\r\nbody\r\nFrom: not a header\r\n", string(raw))
+
+	raw = []byte("List-Id: <a.b>\r\nSubject: test\r\nlist-post: <mailto:a@b>\r\n\tfolded\r\nListing: no\r\n\r\nList-Id: body\r\n")
+	RawDelHeader(&raw, "List-*")
+	assert.Equal(t, "Subject: test\r\nListing: no\r\n\r\nList-Id: body\r\n", string(raw))
+}
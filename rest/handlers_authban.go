@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// authBanGetAll returns active bans and lockouts
+func authBanGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	bans, err := api.AuthBanGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get auth bans", err.Error())
+		return
+	}
+	js, err := json.Marshal(bans)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// authBanDel lifts a ban or a lockout
+func authBanDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get ban id", err.Error())
+		return
+	}
+	if err = api.AuthBanDel(id); err != nil {
+		httpWriteErrorJson(w, 500, "unable to lift ban "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "auth ban "+idStr+" lifted")
+}
+
+// addAuthBanHandlers add auth bans handlers to router
+func addAuthBanHandlers(router *httprouter.Router) {
+	// get active bans
+	router.GET("/authbans", wrapHandler(authBanGetAll))
+	// lift a ban
+	router.DELETE("/authbans/:id", wrapHandler(authBanDel))
+}
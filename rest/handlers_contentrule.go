@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// contentRuleGetAll returns the latest version of all content rules
+func contentRuleGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	rules, err := api.ContentRuleGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get content rules", err.Error())
+		return
+	}
+	js, err := json.Marshal(rules)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// contentRuleGetHistory returns all versions of a content rule
+func contentRuleGetHistory(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	name := httpcontext.Get(r, "params").(httprouter.Params).ByName("name")
+	rules, err := api.ContentRuleGetHistory(name)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get content rule "+name, err.Error())
+		return
+	}
+	if len(rules) == 0 {
+		httpWriteErrorJson(w, 404, "no such content rule "+name, "")
+		return
+	}
+	js, err := json.Marshal(rules)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// contentRuleAdd adds a content rule or a new version of an existing one
+func contentRuleAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Name       string   `json:"name"`
+		Conditions []string `json:"conditions"`
+		Actions    []string `json:"actions"`
+		Priority   int      `json:"priority"`
+		Comment    string   `json:"comment"`
+		Disabled   bool     `json:"disabled"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	if _, err := api.ContentRuleGet(p.Name); err == nil {
+		if err = api.ContentRuleUpdate(p.Name, p.Conditions, p.Actions, p.Priority, p.Comment, p.Disabled); err != nil {
+			httpWriteErrorJson(w, 422, "unable to update content rule", err.Error())
+			return
+		}
+		logInfo(r, "content rule updated "+p.Name)
+		w.WriteHeader(201)
+		return
+	}
+	if err := api.ContentRuleAdd(p.Name, p.Conditions, p.Actions, p.Priority, p.Comment); err != nil {
+		httpWriteErrorJson(w, 422, "unable to add content rule", err.Error())
+		return
+	}
+	logInfo(r, "content rule added "+p.Name)
+	w.WriteHeader(201)
+}
+
+// contentRuleDel deletes a content rule
+func contentRuleDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	name := httpcontext.Get(r, "params").(httprouter.Params).ByName("name")
+	if err := api.ContentRuleDel(name); err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete content rule "+name, err.Error())
+		return
+	}
+	logInfo(r, "content rule deleted "+name)
+	w.WriteHeader(204)
+}
+
+// addContentRuleHandlers add content rules handlers to router
+func addContentRuleHandlers(router *httprouter.Router) {
+	// get all rules
+	router.GET("/contentrules", wrapHandler(contentRuleGetAll))
+	// get versions of a rule
+	router.GET("/contentrules/:name", wrapHandler(contentRuleGetHistory))
+	// add or update a rule
+	router.POST("/contentrules", wrapHandler(contentRuleAdd))
+	// delete a rule
+	router.DELETE("/contentrules/:name", wrapHandler(contentRuleDel))
+}
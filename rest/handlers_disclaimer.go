@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// disclaimerGetAll returns all disclaimers
+func disclaimerGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	disclaimers, err := api.DisclaimerGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get disclaimers", err.Error())
+		return
+	}
+	js, err := json.Marshal(disclaimers)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// disclaimerSet sets the disclaimer of a domain or of an user
+func disclaimerSet(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Domain string `json:"domain"`
+		User   string `json:"user"`
+		Text   string `json:"text"`
+		Html   string `json:"html"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	if err := api.DisclaimerSet(p.Domain, p.User, p.Text, p.Html); err != nil {
+		httpWriteErrorJson(w, 422, "unable to set disclaimer", err.Error())
+		return
+	}
+	logInfo(r, "disclaimer set for "+p.Domain+p.User)
+	w.WriteHeader(201)
+}
+
+// disclaimerDel deletes a disclaimer
+func disclaimerDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get disclaimer id", err.Error())
+		return
+	}
+	if err = api.DisclaimerDel(id); err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete disclaimer "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "disclaimer deleted "+idStr)
+	w.WriteHeader(204)
+}
+
+// addDisclaimerHandlers add disclaimer handlers to router
+func addDisclaimerHandlers(router *httprouter.Router) {
+	// get all disclaimers
+	router.GET("/disclaimers", wrapHandler(disclaimerGetAll))
+	// set a disclaimer
+	router.POST("/disclaimers", wrapHandler(disclaimerSet))
+	// delete a disclaimer
+	router.DELETE("/disclaimers/:id", wrapHandler(disclaimerDel))
+}
@@ -0,0 +1,89 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// journalRuleGetAll returns all journaling rules
+func journalRuleGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	rules, err := api.JournalRuleGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get journaling rules", err.Error())
+		return
+	}
+	js, err := json.Marshal(rules)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// journalRuleAdd adds a journaling rule
+func journalRuleAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Direction   string `json:"direction"`
+		Format      string `json:"format"`
+		Destination string `json:"destination"`
+		Domain      string `json:"domain"`
+		User        string `json:"user"`
+		Retention   int    `json:"retention"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	if err := api.JournalRuleAdd(p.Direction, p.Format, p.Destination, p.Domain, p.User, p.Retention); err != nil {
+		httpWriteErrorJson(w, 422, "unable to add journaling rule", err.Error())
+		return
+	}
+	logInfo(r, "journaling rule added "+p.Direction+" "+p.Format+" -> "+p.Destination)
+	w.WriteHeader(201)
+}
+
+// journalRuleDel deletes a journaling rule
+func journalRuleDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get journaling rule id", err.Error())
+		return
+	}
+	if err = api.JournalRuleDel(id); err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete journaling rule "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "journaling rule deleted "+idStr)
+	w.WriteHeader(204)
+}
+
+// addJournalHandlers add journaling handlers to router
+func addJournalHandlers(router *httprouter.Router) {
+	// get all rules
+	router.GET("/journalrules", wrapHandler(journalRuleGetAll))
+	// add a rule
+	router.POST("/journalrules", wrapHandler(journalRuleAdd))
+	// delete a rule
+	router.DELETE("/journalrules/:id", wrapHandler(journalRuleDel))
+}
@@ -0,0 +1,281 @@
+package rest
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// mailingListGetAll returns all mailing lists
+func mailingListGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	lists, err := api.MailingListGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get mailing lists", err.Error())
+		return
+	}
+	js, err := json.Marshal(lists)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// mailingListGetOne returns a mailing list
+func mailingListGetOne(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	address := httpcontext.Get(r, "params").(httprouter.Params).ByName("list")
+	list, err := api.MailingListGet(address)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such mailing list "+address, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get mailing list "+address, err.Error())
+		return
+	}
+	js, err := json.Marshal(list)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// mailingListAdd creates (POST) or updates (PUT) a mailing list
+func mailingListAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Description string `json:"description"`
+		Policy      string `json:"policy"`
+		Moderators  string `json:"moderators"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	address := httpcontext.Get(r, "params").(httprouter.Params).ByName("list")
+	if r.Method == "PUT" {
+		if err := api.MailingListUpdate(address, p.Description, p.Policy, p.Moderators); err != nil {
+			httpWriteErrorJson(w, 422, "unable to update mailing list", err.Error())
+			return
+		}
+		logInfo(r, "mailing list updated "+address)
+		w.WriteHeader(204)
+		return
+	}
+	if err := api.MailingListAdd(address, p.Description, p.Policy, p.Moderators); err != nil {
+		httpWriteErrorJson(w, 422, "unable to create mailing list", err.Error())
+		return
+	}
+	logInfo(r, "mailing list added "+address)
+	w.Header().Set("Location", httpGetScheme()+"://"+r.Host+"/mailinglists/"+address)
+	w.WriteHeader(201)
+}
+
+// mailingListDel deletes a mailing list
+func mailingListDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	address := httpcontext.Get(r, "params").(httprouter.Params).ByName("list")
+	err := api.MailingListDel(address)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such mailing list "+address, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete mailing list "+address, err.Error())
+		return
+	}
+	logInfo(r, "mailing list deleted "+address)
+	w.WriteHeader(204)
+}
+
+// mailingListMemberGetAll returns members of a mailing list
+func mailingListMemberGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	address := httpcontext.Get(r, "params").(httprouter.Params).ByName("list")
+	members, err := api.MailingListMemberGetAll(address)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such mailing list "+address, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get members of "+address, err.Error())
+		return
+	}
+	js, err := json.Marshal(members)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// mailingListMemberAdd adds a member to a mailing list
+func mailingListMemberAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	if err := api.MailingListMemberAdd(params.ByName("list"), params.ByName("member")); err != nil {
+		httpWriteErrorJson(w, 422, "unable to add member", err.Error())
+		return
+	}
+	logInfo(r, params.ByName("member")+" added to mailing list "+params.ByName("list"))
+	w.WriteHeader(201)
+}
+
+// mailingListMemberDel removes a member from a mailing list
+func mailingListMemberDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	err := api.MailingListMemberDel(params.ByName("list"), params.ByName("member"))
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such member "+params.ByName("member"), "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to remove member "+params.ByName("member"), err.Error())
+		return
+	}
+	logInfo(r, params.ByName("member")+" removed from mailing list "+params.ByName("list"))
+	w.WriteHeader(204)
+}
+
+// mailingListPendingGetAll returns pending actions (subscriptions and held
+// posts) of a mailing list
+func mailingListPendingGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	address := httpcontext.Get(r, "params").(httprouter.Params).ByName("list")
+	pending, err := api.MailingListPendingGetAll(address)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such mailing list "+address, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get pending actions of "+address, err.Error())
+		return
+	}
+	js, err := json.Marshal(pending)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// mailingListModerate approves (POST) or rejects (DELETE) a held post
+func mailingListModerate(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	token := httpcontext.Get(r, "params").(httprouter.Params).ByName("token")
+	approve := r.Method == "POST"
+	err := api.MailingListModerate(token, approve)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such held message "+token, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to moderate message "+token, err.Error())
+		return
+	}
+	if approve {
+		logInfo(r, "held message "+token+" approved")
+	} else {
+		logInfo(r, "held message "+token+" rejected")
+	}
+	w.WriteHeader(204)
+}
+
+// mailingListUnsubscribeForm is displayed on GET, mail clients POST directly
+// (RFC 8058: GET must not unsubscribe)
+var mailingListUnsubscribeForm = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/unsubscribe/{{.}}">
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<input type="submit" value="Unsubscribe">
+</form>
+</body></html>
+`))
+
+// mailingListUnsubscribe handles one-click unsubscriptions (no auth: token
+// identifies the member)
+func mailingListUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := httpcontext.Get(r, "params").(httprouter.Params).ByName("token")
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		mailingListUnsubscribeForm.Execute(w, token)
+		return
+	}
+	err := api.MailingListUnsubscribe(token)
+	if err == gorm.ErrRecordNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to unsubscribe", err.Error())
+		return
+	}
+	logInfo(r, "one-click unsubscribe "+token)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("You have been unsubscribed.\n"))
+}
+
+// addMailingListHandlers add mailing lists handlers to router
+func addMailingListHandlers(router *httprouter.Router) {
+	// get all lists
+	router.GET("/mailinglists", wrapHandler(mailingListGetAll))
+
+	// add a list
+	router.POST("/mailinglists/:list", wrapHandler(mailingListAdd))
+
+	// get a list
+	router.GET("/mailinglists/:list", wrapHandler(mailingListGetOne))
+
+	// update a list
+	router.PUT("/mailinglists/:list", wrapHandler(mailingListAdd))
+
+	// delete a list
+	router.DELETE("/mailinglists/:list", wrapHandler(mailingListDel))
+
+	// members
+	router.GET("/mailinglists/:list/members", wrapHandler(mailingListMemberGetAll))
+	router.POST("/mailinglists/:list/members/:member", wrapHandler(mailingListMemberAdd))
+	router.DELETE("/mailinglists/:list/members/:member", wrapHandler(mailingListMemberDel))
+
+	// pending actions, held posts are approved (POST) or rejected (DELETE)
+	router.GET("/mailinglists/:list/pending", wrapHandler(mailingListPendingGetAll))
+	router.POST("/mailinglists/:list/pending/:token", wrapHandler(mailingListModerate))
+	router.DELETE("/mailinglists/:list/pending/:token", wrapHandler(mailingListModerate))
+
+	// one-click unsubscribe (RFC 8058)
+	router.GET("/unsubscribe/:token", wrapHandler(mailingListUnsubscribe))
+	router.POST("/unsubscribe/:token", wrapHandler(mailingListUnsubscribe))
+}
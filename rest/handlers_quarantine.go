@@ -0,0 +1,174 @@
+package rest
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// quarantineGetAll returns quarantined messages
+func quarantineGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	messages, err := api.QuarantineGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get quarantined messages", err.Error())
+		return
+	}
+	js, err := json.Marshal(messages)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// quarantineGetOne returns a quarantined message with its headers
+func quarantineGetOne(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get message id", err.Error())
+		return
+	}
+	m, err := api.QuarantineGet(id)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such quarantined message "+idStr, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get quarantined message "+idStr, err.Error())
+		return
+	}
+	headers, err := api.QuarantineGetHeaders(id)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get headers of quarantined message "+idStr, err.Error())
+		return
+	}
+	js, err := json.Marshal(struct {
+		Message interface{} `json:"message"`
+		Headers string      `json:"headers"`
+	}{m, headers})
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// quarantineRelease requeues a quarantined message (for recipient rcpt if
+// the rcpt query parameter is set)
+func quarantineRelease(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get message id", err.Error())
+		return
+	}
+	err = api.QuarantineRelease(id, r.URL.Query().Get("rcpt"))
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such quarantined message "+idStr, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to release quarantined message "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "quarantined message "+idStr+" released")
+	w.WriteHeader(204)
+}
+
+// quarantineDel deletes a quarantined message
+func quarantineDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get message id", err.Error())
+		return
+	}
+	err = api.QuarantineDel(id)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such quarantined message "+idStr, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete quarantined message "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "quarantined message "+idStr+" deleted")
+	w.WriteHeader(204)
+}
+
+// quarantineReleaseForm is displayed on GET (link checkers must not
+// release messages)
+var quarantineReleaseForm = template.Must(template.New("release").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/quarantine-release?{{.}}">
+<input type="submit" value="Release message">
+</form>
+</body></html>
+`))
+
+// quarantineReleaseSigned handles release links of digests (no auth: the
+// link is signed)
+func quarantineReleaseSigned(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		quarantineReleaseForm.Execute(w, template.URL(r.URL.RawQuery))
+		return
+	}
+	query := r.URL.Query()
+	id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 403, "unable to release message", "bad expiry "+query.Get("exp"))
+		return
+	}
+	err = api.QuarantineReleaseSigned(id, query.Get("rcpt"), expires, query.Get("sig"))
+	if err == gorm.ErrRecordNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 403, "unable to release message", err.Error())
+		return
+	}
+	logInfo(r, "quarantined message "+query.Get("id")+" released for "+query.Get("rcpt"))
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("The message has been released.\n"))
+}
+
+// addQuarantineHandlers add quarantine handlers to router
+func addQuarantineHandlers(router *httprouter.Router) {
+	// get all quarantined messages
+	router.GET("/quarantine", wrapHandler(quarantineGetAll))
+	// get a quarantined message and its headers
+	router.GET("/quarantine/:id", wrapHandler(quarantineGetOne))
+	// release a quarantined message
+	router.POST("/quarantine/:id/release", wrapHandler(quarantineRelease))
+	// delete a quarantined message
+	router.DELETE("/quarantine/:id", wrapHandler(quarantineDel))
+	// release links of digests
+	router.GET("/quarantine-release", wrapHandler(quarantineReleaseSigned))
+	router.POST("/quarantine-release", wrapHandler(quarantineReleaseSigned))
+}
@@ -0,0 +1,109 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// rateLimitGetOverrides returns all rate limit overrides
+func rateLimitGetOverrides(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	limits, err := api.RateLimitGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get rate limits", err.Error())
+		return
+	}
+	js, err := json.Marshal(limits)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// rateLimitAdd adds or replaces a rate limit override
+// null or missing limits are not overridden
+func rateLimitAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Scope         string `json:"scope"`
+		Target        string `json:"target"`
+		MaxSessions   *int   `json:"maxSessions"`
+		ConnPerMinute *int   `json:"connPerMinute"`
+		MsgPerHour    *int   `json:"msgPerHour"`
+		RcptPerHour   *int   `json:"rcptPerHour"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	values := []int{-1, -1, -1, -1}
+	for i, v := range []*int{p.MaxSessions, p.ConnPerMinute, p.MsgPerHour, p.RcptPerHour} {
+		if v != nil {
+			values[i] = *v
+		}
+	}
+	if err := api.RateLimitAdd(p.Scope, p.Target, values[0], values[1], values[2], values[3]); err != nil {
+		httpWriteErrorJson(w, 422, "unable to add rate limit", err.Error())
+		return
+	}
+	logInfo(r, "rate limit added for "+p.Scope+" "+p.Target)
+	w.WriteHeader(201)
+}
+
+// rateLimitDel deletes a rate limit override
+func rateLimitDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get rate limit id", err.Error())
+		return
+	}
+	if err = api.RateLimitDel(id); err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete rate limit "+idStr, err.Error())
+		return
+	}
+}
+
+// rateLimitGetCounters returns current counters
+func rateLimitGetCounters(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	js, err := json.Marshal(api.RateLimitGetCounters())
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// addRateLimitHandlers add rate limit handlers to router
+func addRateLimitHandlers(router *httprouter.Router) {
+	// get all overrides
+	router.GET("/ratelimits", wrapHandler(rateLimitGetOverrides))
+	// add an override
+	router.POST("/ratelimits", wrapHandler(rateLimitAdd))
+	// delete an override
+	router.DELETE("/ratelimits/:id", wrapHandler(rateLimitDel))
+	// current counters
+	router.GET("/ratelimits/counters", wrapHandler(rateLimitGetCounters))
+}
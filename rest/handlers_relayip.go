@@ -0,0 +1,89 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// relayIpGetAll returns all IPs and networks authorized to relay
+func relayIpGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	ips, err := api.RelayIpGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get relay IPs", err.Error())
+		return
+	}
+	js, err := json.Marshal(ips)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// relayIpAdd adds (or replaces) an IP or network authorized to relay
+func relayIpAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Ip            string     `json:"ip"`
+		Description   string     `json:"description"`
+		ExpiresAt     *time.Time `json:"expiresAt"`
+		MaxSize       int64      `json:"maxSize"`
+		SenderDomains []string   `json:"senderDomains"`
+		SkipScan      bool       `json:"skipScan"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	if err := api.RelayIpAdd(p.Ip, p.Description, p.ExpiresAt, p.MaxSize, p.SenderDomains, p.SkipScan); err != nil {
+		httpWriteErrorJson(w, 422, "unable to add relay IP", err.Error())
+		return
+	}
+	logInfo(r, "relay IP "+p.Ip+" added")
+	w.WriteHeader(201)
+}
+
+// relayIpDel deletes an authorized IP or network
+func relayIpDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get relay IP id", err.Error())
+		return
+	}
+	if err = api.RelayIpDelById(id); err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete relay IP "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "relay IP "+idStr+" deleted")
+}
+
+// addRelayIpHandlers add relay IP handlers to router
+func addRelayIpHandlers(router *httprouter.Router) {
+	// get all authorized IPs
+	router.GET("/relayips", wrapHandler(relayIpGetAll))
+	// add an IP or network
+	router.POST("/relayips", wrapHandler(relayIpAdd))
+	// delete an entry
+	router.DELETE("/relayips/:id", wrapHandler(relayIpDel))
+}
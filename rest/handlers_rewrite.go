@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// rewriteRuleGetAll returns all address rewriting rules
+func rewriteRuleGetAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	rules, err := api.RewriteRuleGetAll()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get rewriting rules", err.Error())
+		return
+	}
+	js, err := json.Marshal(rules)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// rewriteRuleAdd adds an address rewriting rule
+func rewriteRuleAdd(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Scope       string `json:"scope"`
+		Kind        string `json:"kind"`
+		Match       string `json:"match"`
+		Pattern     string `json:"pattern"`
+		Replacement string `json:"replacement"`
+		AuthUser    string `json:"authUser"`
+		Priority    int    `json:"priority"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	if err := api.RewriteRuleAdd(p.Scope, p.Kind, p.Match, p.Pattern, p.Replacement, p.AuthUser, p.Priority); err != nil {
+		httpWriteErrorJson(w, 422, "unable to add rewriting rule", err.Error())
+		return
+	}
+	logInfo(r, "rewriting rule added "+p.Pattern+" -> "+p.Replacement)
+	w.WriteHeader(201)
+}
+
+// rewriteRuleDel deletes an address rewriting rule
+func rewriteRuleDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	idStr := httpcontext.Get(r, "params").(httprouter.Params).ByName("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get rewriting rule id", err.Error())
+		return
+	}
+	if err = api.RewriteRuleDel(id); err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete rewriting rule "+idStr, err.Error())
+		return
+	}
+	logInfo(r, "rewriting rule deleted "+idStr)
+	w.WriteHeader(204)
+}
+
+// addRewriteHandlers add address rewriting handlers to router
+func addRewriteHandlers(router *httprouter.Router) {
+	// get all rules
+	router.GET("/rewriterules", wrapHandler(rewriteRuleGetAll))
+	// add a rule
+	router.POST("/rewriterules", wrapHandler(rewriteRuleAdd))
+	// delete a rule
+	router.DELETE("/rewriterules/:id", wrapHandler(rewriteRuleDel))
+}
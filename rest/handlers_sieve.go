@@ -0,0 +1,153 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// sieveList returns Sieve scripts of an user
+func sieveList(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	scripts, err := api.SieveScriptList(httpcontext.Get(r, "params").(httprouter.Params).ByName("user"))
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get sieve scripts", err.Error())
+		return
+	}
+	js, err := json.Marshal(scripts)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// sieveGetOne returns a Sieve script
+func sieveGetOne(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	script, err := api.SieveScriptGet(params.ByName("user"), params.ByName("name"))
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such sieve script "+params.ByName("name"), "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get sieve script "+params.ByName("name"), err.Error())
+		return
+	}
+	js, err := json.Marshal(script)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// sievePut adds or replaces a Sieve script
+func sievePut(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Script string `json:"script"`
+		Active bool   `json:"active"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	if err := api.SieveScriptPut(params.ByName("user"), params.ByName("name"), p.Script, p.Active); err != nil {
+		httpWriteErrorJson(w, 422, "unable to save sieve script", err.Error())
+		return
+	}
+	logInfo(r, "sieve script "+params.ByName("name")+" saved for user "+params.ByName("user"))
+	w.WriteHeader(204)
+}
+
+// sieveDel deletes a Sieve script
+func sieveDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	err := api.SieveScriptDel(params.ByName("user"), params.ByName("name"))
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such sieve script "+params.ByName("name"), "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete sieve script "+params.ByName("name"), err.Error())
+		return
+	}
+	logInfo(r, "sieve script "+params.ByName("name")+" deleted for user "+params.ByName("user"))
+	w.WriteHeader(204)
+}
+
+// sieveActivate activates a Sieve script
+func sieveActivate(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	err := api.SieveScriptActivate(params.ByName("user"), params.ByName("name"))
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no such sieve script "+params.ByName("name"), "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to activate sieve script "+params.ByName("name"), err.Error())
+		return
+	}
+	logInfo(r, "sieve script "+params.ByName("name")+" activated for user "+params.ByName("user"))
+	w.WriteHeader(204)
+}
+
+// sieveDeactivate deactivates Sieve scripts of an user
+func sieveDeactivate(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	params := httpcontext.Get(r, "params").(httprouter.Params)
+	if err := api.SieveScriptActivate(params.ByName("user"), ""); err != nil {
+		httpWriteErrorJson(w, 500, "unable to deactivate sieve scripts", err.Error())
+		return
+	}
+	logInfo(r, "sieve scripts deactivated for user "+params.ByName("user"))
+	w.WriteHeader(204)
+}
+
+// addSieveHandlers add Sieve handlers to router
+func addSieveHandlers(router *httprouter.Router) {
+	// list scripts of an user
+	router.GET("/users/:user/sieve", wrapHandler(sieveList))
+
+	// deactivate scripts of an user
+	router.DELETE("/users/:user/sieve", wrapHandler(sieveDeactivate))
+
+	// get a script
+	router.GET("/users/:user/sieve/:name", wrapHandler(sieveGetOne))
+
+	// add or replace a script
+	router.PUT("/users/:user/sieve/:name", wrapHandler(sievePut))
+
+	// delete a script
+	router.DELETE("/users/:user/sieve/:name", wrapHandler(sieveDel))
+
+	// activate a script
+	router.POST("/users/:user/sieve/:name/activate", wrapHandler(sieveActivate))
+}
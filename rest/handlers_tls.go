@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// tlsGetCertificates returns smtpd certificates (names, expiration...)
+func tlsGetCertificates(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	certs, err := api.TLSGetCertificates()
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get certificates", err.Error())
+		return
+	}
+	js, err := json.Marshal(certs)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// acmeRenew obtains or renews ACME certificates (all of them if force=true)
+func acmeRenew(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+	if err := api.AcmeRenew(force); err != nil {
+		httpWriteErrorJson(w, 500, "unable to renew certificates", err.Error())
+		return
+	}
+	logInfo(r, "ACME certificates renewed")
+	w.WriteHeader(204)
+}
+
+// acmeHTTP01Challenge serves ACME HTTP-01 challenges (no auth: requested by the CA)
+func acmeHTTP01Challenge(w http.ResponseWriter, r *http.Request) {
+	token := httpcontext.Get(r, "params").(httprouter.Params).ByName("token")
+	response, ok := api.AcmeHTTP01Response(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+// tlsGetCertificate returns certificate for REST server: ACME one if any,
+// else ssl/web_server.crt (reloaded when it changes)
+func tlsGetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return api.RestTLSGetCertificate(hello)
+}
+
+// addTLSHandlers add TLS handlers to router
+func addTLSHandlers(router *httprouter.Router) {
+	// smtpd certificates
+	router.GET("/tls/certificates", wrapHandler(tlsGetCertificates))
+	// ACME renew
+	router.POST("/tls/acme/renew", wrapHandler(acmeRenew))
+	// ACME HTTP-01 challenges
+	router.GET("/.well-known/acme-challenge/:token", wrapHandler(acmeHTTP01Challenge))
+}
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/julienschmidt/httprouter"
+	"github.com/nbio/httpcontext"
+	"github.com/toorop/tmail/api"
+)
+
+// vacationGet returns vacation settings of an user
+func vacationGet(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	user := httpcontext.Get(r, "params").(httprouter.Params).ByName("user")
+	vacation, err := api.VacationGet(user)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no vacation settings for user "+user, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to get vacation settings of user "+user, err.Error())
+		return
+	}
+	js, err := json.Marshal(vacation)
+	if err != nil {
+		httpWriteErrorJson(w, 500, "JSON encondig failed", err.Error())
+		return
+	}
+	httpWriteJson(w, js)
+}
+
+// vacationSet adds or replaces vacation settings of an user
+func vacationSet(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	p := struct {
+		Enabled         bool       `json:"enabled"`
+		Subject         string     `json:"subject"`
+		Body            string     `json:"body"`
+		StartAt         *time.Time `json:"startAt"`
+		EndAt           *time.Time `json:"endAt"`
+		ExcludedSenders string     `json:"excludedSenders"`
+		Days            int        `json:"days"`
+	}{}
+
+	// nil body
+	if r.Body == nil {
+		httpWriteErrorJson(w, 422, "empty body", "")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		httpWriteErrorJson(w, 500, "unable to get JSON body", err.Error())
+		return
+	}
+	user := httpcontext.Get(r, "params").(httprouter.Params).ByName("user")
+	if err := api.VacationSet(user, p.Enabled, p.Subject, p.Body, p.StartAt, p.EndAt, p.ExcludedSenders, p.Days); err != nil {
+		httpWriteErrorJson(w, 422, "unable to set vacation", err.Error())
+		return
+	}
+	logInfo(r, "vacation settings saved for user "+user)
+	w.WriteHeader(204)
+}
+
+// vacationDel deletes vacation settings of an user
+func vacationDel(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	user := httpcontext.Get(r, "params").(httprouter.Params).ByName("user")
+	err := api.VacationDel(user)
+	if err == gorm.ErrRecordNotFound {
+		httpWriteErrorJson(w, 404, "no vacation settings for user "+user, "")
+		return
+	}
+	if err != nil {
+		httpWriteErrorJson(w, 500, "unable to delete vacation settings of user "+user, err.Error())
+		return
+	}
+	logInfo(r, "vacation settings deleted for user "+user)
+	w.WriteHeader(204)
+}
+
+// addVacationHandlers add vacation handlers to router
+func addVacationHandlers(router *httprouter.Router) {
+	// get vacation settings
+	router.GET("/users/:user/vacation", wrapHandler(vacationGet))
+
+	// add or replace vacation settings
+	router.PUT("/users/:user/vacation", wrapHandler(vacationSet))
+
+	// delete vacation settings
+	router.DELETE("/users/:user/vacation", wrapHandler(vacationDel))
+}
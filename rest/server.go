@@ -1,11 +1,11 @@
 package rest
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 
 	"github.com/codegangsta/negroni"
@@ -29,8 +29,32 @@ func LaunchServer() {
 
 	// Users handlers
 	addUsersHandlers(router)
+	// Sieve scripts
+	addSieveHandlers(router)
+	// Vacation
+	addVacationHandlers(router)
+	// Mailing lists
+	addMailingListHandlers(router)
+	// Address rewriting
+	addRewriteHandlers(router)
+	// Content rules
+	addContentRuleHandlers(router)
+	// Quarantine
+	addQuarantineHandlers(router)
+	// Journaling
+	addJournalHandlers(router)
+	// Disclaimers
+	addDisclaimerHandlers(router)
 	// Queue
 	addQueueHandlers(router)
+	// Rate limits
+	addRateLimitHandlers(router)
+	// Auth bans
+	addAuthBanHandlers(router)
+	// Relay IPs
+	addRelayIpHandlers(router)
+	// TLS certificates
+	addTLSHandlers(router)
 
 	// Microservice data handler
 	router.Handler("GET", "/msdata/:id", http.StripPrefix("/msdata/", http.FileServer(http.Dir(core.Cfg.GetTempDir()))))
@@ -43,7 +67,16 @@ func LaunchServer() {
 	// TLS
 	if core.Cfg.GetRestServerIsTls() {
 		core.Logger.Info("httpd " + addr + " TLS launched")
-		log.Fatalln(http.ListenAndServeTLS(addr, path.Join(getBasePath(), "ssl/web_server.crt"), path.Join(getBasePath(), "ssl/web_server.key"), n))
+		// ACME certificates if any, ssl/web_server.crt as fallback
+		if err := core.RestTLSLoad(); err != nil && !core.Cfg.GetAcmeEnabled() {
+			log.Fatalln(err)
+		}
+		server := &http.Server{
+			Addr:      addr,
+			Handler:   n,
+			TLSConfig: &tls.Config{GetCertificate: tlsGetCertificate},
+		}
+		log.Fatalln(server.ListenAndServeTLS("", ""))
 	} else {
 		core.Logger.Info("httpd " + addr + " launched")
 		log.Fatalln(http.ListenAndServe(addr, n))
@@ -0,0 +1,130 @@
+package sieve
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+)
+
+// maxMimeDepth is the max nesting level of multipart parsed by body test
+const maxMimeDepth = 10
+
+// bodyPart is a leaf MIME part
+type bodyPart struct {
+	contentType string
+	content     []byte
+}
+
+// splitBody returns body of raw message (after the first empty line)
+func splitBody(raw []byte) []byte {
+	crlf := bytes.Index(raw, []byte("\r\n\r\n"))
+	lf := bytes.Index(raw, []byte("\n\n"))
+	switch {
+	case crlf != -1 && (lf == -1 || crlf < lf):
+		return raw[crlf+4:]
+	case lf != -1:
+		return raw[lf+2:]
+	}
+	return []byte{}
+}
+
+// bodyValues returns values tested by body test
+// :raw: undecoded body, :content: decoded parts of listed types
+// :text (default): decoded text parts
+func (in *interpreter) bodyValues(args parsedArgs) []string {
+	if args.has("raw") {
+		return []string{string(in.body)}
+	}
+	types := []string{"text"}
+	if a, ok := args.tags["content"]; ok {
+		types = in.strings(a)
+	}
+	header := textproto.MIMEHeader(in.header)
+	parts := mimeParts(header, in.body, 0)
+	values := []string{}
+	for _, part := range parts {
+		for _, t := range types {
+			if contentTypeMatch(part.contentType, t) {
+				values = append(values, string(part.content))
+				break
+			}
+		}
+	}
+	return values
+}
+
+// contentTypeMatch checks if contentType matches t ("": all, "text": text/*,
+// "text/html": exact)
+func contentTypeMatch(contentType, t string) bool {
+	t = strings.ToLower(t)
+	if t == "" || t == contentType {
+		return true
+	}
+	return !strings.Contains(t, "/") && strings.HasPrefix(contentType, t+"/")
+}
+
+// mimeParts returns decoded leaf parts of an entity
+func mimeParts(header textproto.MIMEHeader, body []byte, depth int) []bodyPart {
+	contentType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		contentType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(contentType, "multipart/") && params["boundary"] != "" && depth < maxMimeDepth {
+		parts := []bodyPart{}
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				break
+			}
+			content, err := ioutil.ReadAll(part)
+			if err != nil {
+				break
+			}
+			parts = append(parts, mimeParts(part.Header, content, depth+1)...)
+		}
+		return parts
+	}
+	return []bodyPart{{contentType: contentType, content: decodeContent(header, body, params["charset"])}}
+}
+
+// decodeContent decodes transfer encoding and converts charset to UTF-8
+// (only UTF-8, US-ASCII and ISO-8859-1 are handled)
+func decodeContent(header textproto.MIMEHeader, body []byte, charset string) []byte {
+	var reader io.Reader = bytes.NewReader(body)
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		reader = base64.NewDecoder(base64.StdEncoding, newBase64Cleaner(body))
+	case "quoted-printable":
+		reader = quotedprintable.NewReader(reader)
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil && len(content) == 0 {
+		content = body
+	}
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		content = []byte(string(runes))
+	}
+	return content
+}
+
+// newBase64Cleaner returns a reader of body without white spaces
+func newBase64Cleaner(body []byte) io.Reader {
+	return bytes.NewReader(bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, body))
+}
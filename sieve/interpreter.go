@@ -0,0 +1,536 @@
+package sieve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxVariableLength is the max length of a variable value
+const maxVariableLength = 4096
+
+// variableRegexp matches ${name} and ${0}
+var variableRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*|[0-9]+)\}`)
+
+// interpreter holds execution state
+type interpreter struct {
+	script       *Script
+	msg          Message
+	header       mail.Header
+	body         []byte
+	variables    map[string]string
+	matchVars    []string
+	flags        []string
+	implicitKeep bool
+	result       *Result
+	regexps      map[string]*regexp.Regexp
+}
+
+func newInterpreter(s *Script, msg Message) *interpreter {
+	in := &interpreter{
+		script:       s,
+		msg:          msg,
+		header:       mail.Header{},
+		variables:    map[string]string{},
+		flags:        []string{},
+		implicitKeep: true,
+		result:       &Result{},
+		regexps:      map[string]*regexp.Regexp{},
+	}
+	if m, err := mail.ReadMessage(bytes.NewReader(msg.Raw)); err == nil {
+		in.header = m.Header
+	}
+	in.body = splitBody(msg.Raw)
+	return in
+}
+
+// run executes commands
+func (in *interpreter) run(commands []*command) error {
+	// true if a branch of current if/elsif/else chain has been executed
+	branchTaken := false
+	for _, cmd := range commands {
+		switch cmd.name {
+		case "if", "elsif":
+			if cmd.name == "elsif" && branchTaken {
+				continue
+			}
+			ok, err := in.test(cmd.tests[0])
+			if err != nil {
+				return err
+			}
+			branchTaken = ok
+			if ok {
+				if err = in.run(cmd.block); err != nil {
+					return err
+				}
+			}
+		case "else":
+			if !branchTaken {
+				if err := in.run(cmd.block); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := in.action(cmd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expand expands variables in s (if variables extension is required)
+func (in *interpreter) expand(s string) string {
+	if !in.script.extensions["variables"] {
+		return s
+	}
+	return variableRegexp.ReplaceAllStringFunc(s, func(v string) string {
+		name := strings.ToLower(v[2 : len(v)-1])
+		if name[0] >= '0' && name[0] <= '9' {
+			i, err := strconv.Atoi(name)
+			if err != nil || i >= len(in.matchVars) {
+				return ""
+			}
+			return in.matchVars[i]
+		}
+		return in.variables[name]
+	})
+}
+
+// strings returns expanded strings of arg
+func (in *interpreter) strings(arg argument) []string {
+	out := make([]string, len(arg.strings))
+	for i, s := range arg.strings {
+		out[i] = in.expand(s)
+	}
+	return out
+}
+
+// string returns first expanded string of arg
+func (in *interpreter) string(arg argument) string {
+	if len(arg.strings) == 0 {
+		return ""
+	}
+	return in.expand(arg.strings[0])
+}
+
+// action executes an action command
+func (in *interpreter) action(cmd *command) error {
+	args, err := parseArgs(cmd.name, cmd.args, commandSpecs[cmd.name], in.script.extensions)
+	if err != nil {
+		return err
+	}
+	switch cmd.name {
+	case "require":
+		// checked by Parse
+	case "stop":
+		return errStop
+	case "keep":
+		in.implicitKeep = false
+		in.store("", args)
+	case "discard":
+		in.implicitKeep = false
+	case "fileinto":
+		if !args.has("copy") {
+			in.implicitKeep = false
+		}
+		folder := in.string(args.positional[0])
+		if folder == "" {
+			return fmt.Errorf("line %d: fileinto: empty folder", cmd.line)
+		}
+		in.store(folder, args)
+	case "redirect":
+		if !args.has("copy") {
+			in.implicitKeep = false
+		}
+		to := in.string(args.positional[0])
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("line %d: redirect: bad address %s", cmd.line, to)
+		}
+		for _, r := range in.result.Redirects {
+			if strings.EqualFold(r, addr.Address) {
+				return nil
+			}
+		}
+		in.result.Redirects = append(in.result.Redirects, addr.Address)
+	case "reject":
+		in.implicitKeep = false
+		in.result.Rejected = true
+		in.result.Reject = in.string(args.positional[0])
+	case "vacation":
+		if in.result.Vacation != nil {
+			return fmt.Errorf("line %d: vacation can only be used once", cmd.line)
+		}
+		v := &Vacation{Days: 7, Reason: in.string(args.positional[0]), Mime: args.has("mime")}
+		if a, ok := args.tags["days"]; ok {
+			v.Days = int(a.number)
+		}
+		if a, ok := args.tags["subject"]; ok {
+			v.Subject = in.string(a)
+		}
+		if a, ok := args.tags["from"]; ok {
+			v.From = in.string(a)
+		}
+		if a, ok := args.tags["addresses"]; ok {
+			v.Addresses = in.strings(a)
+		}
+		if a, ok := args.tags["handle"]; ok {
+			v.Handle = in.string(a)
+		}
+		in.result.Vacation = v
+	case "setflag", "addflag", "removeflag":
+		variable := ""
+		if args.positional[0].typ == tokenString {
+			variable = strings.ToLower(in.string(args.positional[0]))
+		}
+		flags := parseFlags(in.strings(args.positional[1]))
+		current := in.flags
+		if variable != "" {
+			current = parseFlags([]string{in.variables[variable]})
+		}
+		switch cmd.name {
+		case "setflag":
+			current = flags
+		case "addflag":
+			current = addFlags(current, flags)
+		case "removeflag":
+			current = removeFlags(current, flags)
+		}
+		if variable != "" {
+			in.variables[variable] = strings.Join(current, " ")
+		} else {
+			in.flags = current
+		}
+	case "set":
+		name := strings.ToLower(args.positional[0].strings[0])
+		if !variableRegexp.MatchString("${"+name+"}") || (name[0] >= '0' && name[0] <= '9') {
+			return fmt.Errorf("line %d: set: bad variable name %s", cmd.line, name)
+		}
+		value := in.string(args.positional[1])
+		switch {
+		case args.has("lower"):
+			value = strings.ToLower(value)
+		case args.has("upper"):
+			value = strings.ToUpper(value)
+		}
+		if value != "" {
+			r, size := utf8.DecodeRuneInString(value)
+			switch {
+			case args.has("lowerfirst"):
+				value = strings.ToLower(string(r)) + value[size:]
+			case args.has("upperfirst"):
+				value = strings.ToUpper(string(r)) + value[size:]
+			}
+		}
+		if args.has("quotewildcard") {
+			value = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `\`, `\\`).Replace(value)
+		}
+		if args.has("length") {
+			value = strconv.Itoa(utf8.RuneCountInString(value))
+		}
+		if len(value) > maxVariableLength {
+			value = value[:maxVariableLength]
+		}
+		in.variables[name] = value
+	default:
+		return fmt.Errorf("line %d: unknown command %s", cmd.line, cmd.name)
+	}
+	return nil
+}
+
+// store adds a store to result (flags from :flags or current ones)
+func (in *interpreter) store(folder string, args parsedArgs) {
+	flags := in.flags
+	if a, ok := args.tags["flags"]; ok {
+		flags = parseFlags(in.strings(a))
+	}
+	for i, s := range in.result.Stores {
+		if s.Folder == folder {
+			in.result.Stores[i].Flags = flags
+			return
+		}
+	}
+	in.result.Stores = append(in.result.Stores, Store{Folder: folder, Flags: flags})
+}
+
+// finish adds implicit keep and checks actions compatibility
+func (in *interpreter) finish() (*Result, error) {
+	if in.implicitKeep {
+		in.store("", parsedArgs{})
+	}
+	if in.result.Rejected && (len(in.result.Stores) != 0 || len(in.result.Redirects) != 0 || in.result.Vacation != nil) {
+		return nil, errors.New("reject can't be used with keep, fileinto, redirect or vacation")
+	}
+	return in.result, nil
+}
+
+// parseFlags splits space separated flags and removes duplicates
+func parseFlags(list []string) []string {
+	return addFlags([]string{}, list)
+}
+
+// addFlags adds flags to current (flags are case insensitive)
+func addFlags(current, flags []string) []string {
+	out := append([]string{}, current...)
+	for _, f := range flags {
+		for _, flag := range strings.Fields(f) {
+			found := false
+			for _, c := range out {
+				if strings.EqualFold(c, flag) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				out = append(out, flag)
+			}
+		}
+	}
+	return out
+}
+
+// removeFlags removes flags from current
+func removeFlags(current, flags []string) []string {
+	remove := parseFlags(flags)
+	out := []string{}
+	for _, c := range current {
+		found := false
+		for _, r := range remove {
+			if strings.EqualFold(c, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// test evaluates a test
+func (in *interpreter) test(t *test) (bool, error) {
+	args, err := parseArgs(t.name, t.args, testSpecs[t.name], in.script.extensions)
+	if err != nil {
+		return false, err
+	}
+	switch t.name {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "not":
+		ok, err := in.test(t.tests[0])
+		return !ok, err
+	case "allof", "anyof":
+		for _, child := range t.tests {
+			ok, err := in.test(child)
+			if err != nil {
+				return false, err
+			}
+			if ok != (t.name == "allof") {
+				return ok, nil
+			}
+		}
+		return t.name == "allof", nil
+	case "exists":
+		for _, name := range in.strings(args.positional[0]) {
+			if len(in.header[textproto.CanonicalMIMEHeaderKey(name)]) == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "size":
+		size := int64(len(in.msg.Raw))
+		if a, ok := args.tags["over"]; ok {
+			return size > a.number, nil
+		}
+		return size < args.tags["under"].number, nil
+	case "header":
+		values := []string{}
+		for _, name := range in.strings(args.positional[0]) {
+			values = append(values, in.headerValues(name)...)
+		}
+		return in.match(args, values, in.strings(args.positional[1])), nil
+	case "address":
+		values := []string{}
+		for _, name := range in.strings(args.positional[0]) {
+			for _, value := range in.headerValues(name) {
+				for _, addr := range parseAddresses(value) {
+					if part, ok := in.addressPart(addr, args); ok {
+						values = append(values, part)
+					}
+				}
+			}
+		}
+		return in.match(args, values, in.strings(args.positional[1])), nil
+	case "envelope":
+		values := []string{}
+		for _, part := range in.strings(args.positional[0]) {
+			address := ""
+			switch strings.ToLower(part) {
+			case "from":
+				address = in.msg.MailFrom
+			case "to":
+				address = in.msg.RcptTo
+			default:
+				continue
+			}
+			if value, ok := in.addressPart(address, args); ok {
+				values = append(values, value)
+			}
+		}
+		return in.match(args, values, in.strings(args.positional[1])), nil
+	case "body":
+		return in.match(args, in.bodyValues(args), in.strings(args.positional[0])), nil
+	case "string":
+		return in.match(args, in.strings(args.positional[0]), in.strings(args.positional[1])), nil
+	case "hasflag":
+		flags := in.flags
+		if args.positional[0].typ == tokenString {
+			flags = []string{}
+			for _, variable := range in.strings(args.positional[0]) {
+				flags = addFlags(flags, []string{in.variables[strings.ToLower(variable)]})
+			}
+		}
+		return in.match(args, flags, in.strings(args.positional[1])), nil
+	}
+	return false, fmt.Errorf("line %d: unknown test %s", t.line, t.name)
+}
+
+// headerValues returns decoded (RFC 2047) values of header name
+func (in *interpreter) headerValues(name string) []string {
+	decoder := new(mime.WordDecoder)
+	values := []string{}
+	for _, value := range in.header[textproto.CanonicalMIMEHeaderKey(name)] {
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		values = append(values, strings.TrimSpace(value))
+	}
+	return values
+}
+
+// parseAddresses returns addresses of an address header value (the whole
+// value if it can't be parsed)
+func parseAddresses(value string) []string {
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return []string{value}
+	}
+	addresses := make([]string, len(list))
+	for i, addr := range list {
+		addresses[i] = addr.Address
+	}
+	return addresses
+}
+
+// addressPart returns address part selected by args (:all by default)
+// ok is false if :detail is requested and address has no detail (RFC 5233)
+func (in *interpreter) addressPart(address string, args parsedArgs) (part string, ok bool) {
+	i := strings.LastIndex(address, "@")
+	localpart := address
+	if i != -1 {
+		localpart = address[:i]
+	}
+	switch {
+	case args.has("localpart"):
+		return localpart, true
+	case args.has("domain"):
+		if i == -1 {
+			return "", true
+		}
+		return address[i+1:], true
+	case args.has("user"), args.has("detail"):
+		j := -1
+		if in.msg.Delimiter != "" {
+			j = strings.IndexAny(localpart, in.msg.Delimiter)
+		}
+		if args.has("user") {
+			if j == -1 {
+				return localpart, true
+			}
+			return localpart[:j], true
+		}
+		if j == -1 {
+			return "", false
+		}
+		return localpart[j+1:], true
+	}
+	return address, true
+}
+
+// match compares values to keys using comparator and match type of args
+// match variables are set on a successful :matches
+func (in *interpreter) match(args parsedArgs, values, keys []string) bool {
+	caseInsensitive := true
+	if c, ok := args.tags["comparator"]; ok && c.strings[0] == "i;octet" {
+		caseInsensitive = false
+	}
+	for _, value := range values {
+		for _, key := range keys {
+			switch {
+			case args.has("contains"):
+				if caseInsensitive {
+					if strings.Contains(strings.ToLower(value), strings.ToLower(key)) {
+						return true
+					}
+				} else if strings.Contains(value, key) {
+					return true
+				}
+			case args.has("matches"):
+				if captures := in.globMatch(key, value, caseInsensitive); captures != nil {
+					in.matchVars = captures
+					return true
+				}
+			default:
+				if (caseInsensitive && strings.EqualFold(value, key)) || value == key {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// globMatch matches value against pattern (* and ?, \ to escape) and
+// returns captures (whole value first) or nil if value doesn't match
+func (in *interpreter) globMatch(pattern, value string, caseInsensitive bool) []string {
+	flags := "(?s)"
+	if caseInsensitive {
+		flags = "(?is)"
+	}
+	key := flags + pattern
+	re, ok := in.regexps[key]
+	if !ok {
+		expr := flags + "^"
+		for i := 0; i < len(pattern); i++ {
+			switch pattern[i] {
+			case '*':
+				expr += "(.*?)"
+			case '?':
+				expr += "(.)"
+			case '\\':
+				if i+1 < len(pattern) {
+					i++
+				}
+				expr += regexp.QuoteMeta(pattern[i : i+1])
+			default:
+				_, size := utf8.DecodeRuneInString(pattern[i:])
+				expr += regexp.QuoteMeta(pattern[i : i+size])
+				i += size - 1
+			}
+		}
+		re = regexp.MustCompile(expr + "$")
+		in.regexps[key] = re
+	}
+	return re.FindStringSubmatch(value)
+}
@@ -0,0 +1,278 @@
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenType is the type of a lexical token (RFC 5228 8.1)
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenSemicolon
+	tokenComma
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBracket
+	tokenRightBracket
+	tokenLeftBrace
+	tokenRightBrace
+)
+
+// token is a lexical token
+type token struct {
+	typ    tokenType
+	text   string
+	number int64
+	line   int
+}
+
+// String returns token as it appears in script (for error messages)
+func (t token) String() string {
+	switch t.typ {
+	case tokenEOF:
+		return "end of script"
+	case tokenString:
+		return strconv.Quote(t.text)
+	case tokenTag:
+		return ":" + t.text
+	case tokenNumber:
+		return strconv.FormatInt(t.number, 10)
+	}
+	return t.text
+}
+
+// lexer splits a script into tokens
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+// lex returns tokens of src
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1}
+	tokens := []token{}
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.typ == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+// errorf returns an error located at current line
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// skip skips white spaces and comments
+func (l *lexer) skip() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end == -1 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// next returns next token
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	t := token{line: l.line}
+	if l.pos >= len(l.src) {
+		t.typ = tokenEOF
+		return t, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == ';':
+		t.typ, t.text = tokenSemicolon, ";"
+	case c == ',':
+		t.typ, t.text = tokenComma, ","
+	case c == '(':
+		t.typ, t.text = tokenLeftParen, "("
+	case c == ')':
+		t.typ, t.text = tokenRightParen, ")"
+	case c == '[':
+		t.typ, t.text = tokenLeftBracket, "["
+	case c == ']':
+		t.typ, t.text = tokenRightBracket, "]"
+	case c == '{':
+		t.typ, t.text = tokenLeftBrace, "{"
+	case c == '}':
+		t.typ, t.text = tokenRightBrace, "}"
+	case c == '"':
+		return l.quotedString()
+	case c == ':':
+		l.pos++
+		id := l.identifier()
+		if id == "" {
+			return t, l.errorf("bad tag")
+		}
+		t.typ, t.text = tokenTag, strings.ToLower(id)
+		return t, nil
+	case c >= '0' && c <= '9':
+		return l.numberToken()
+	case isIdentifierChar(c, true):
+		id := l.identifier()
+		// multi-line string
+		if strings.EqualFold(id, "text") && l.pos < len(l.src) && l.src[l.pos] == ':' {
+			l.pos++
+			return l.multiLineString()
+		}
+		t.typ, t.text = tokenIdentifier, strings.ToLower(id)
+		return t, nil
+	default:
+		return t, l.errorf("unexpected character %q", c)
+	}
+	l.pos++
+	return t, nil
+}
+
+// isIdentifierChar returns true if c can be used in an identifier
+func isIdentifierChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// identifier reads an identifier
+func (l *lexer) identifier() string {
+	start := l.pos
+	for l.pos < len(l.src) && isIdentifierChar(l.src[l.pos], l.pos == start) {
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// numberToken reads a number with an optional K, M or G quantifier
+func (l *lexer) numberToken() (token, error) {
+	t := token{typ: tokenNumber, line: l.line}
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+		l.pos++
+	}
+	n, err := strconv.ParseInt(l.src[start:l.pos], 10, 64)
+	if err != nil {
+		return t, l.errorf("bad number %s", l.src[start:l.pos])
+	}
+	if l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case 'k', 'K':
+			n <<= 10
+			l.pos++
+		case 'm', 'M':
+			n <<= 20
+			l.pos++
+		case 'g', 'G':
+			n <<= 30
+			l.pos++
+		}
+	}
+	t.number, t.text = n, l.src[start:l.pos]
+	return t, nil
+}
+
+// quotedString reads a "quoted string"
+func (l *lexer) quotedString() (token, error) {
+	t := token{typ: tokenString, line: l.line}
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return t, l.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		l.pos++
+		switch c {
+		case '"':
+			t.text = b.String()
+			return t, nil
+		case '\\':
+			if l.pos >= len(l.src) {
+				return t, l.errorf("unterminated string")
+			}
+			c = l.src[l.pos]
+			l.pos++
+		case '\n':
+			l.line++
+		}
+		b.WriteByte(c)
+	}
+}
+
+// multiLineString reads a text: multi-line string (dot-stuffed, ends with
+// a line containing a single dot)
+func (l *lexer) multiLineString() (token, error) {
+	t := token{typ: tokenString, line: l.line}
+	// rest of the line can only hold white spaces or a comment
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '#' {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) || l.src[l.pos] != '\n' {
+		return t, l.errorf("text: must be followed by a new line")
+	}
+	l.pos++
+	l.line++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return t, l.errorf("unterminated multi-line string")
+		}
+		end := strings.IndexByte(l.src[l.pos:], '\n')
+		var line string
+		if end == -1 {
+			line = l.src[l.pos:]
+			l.pos = len(l.src)
+		} else {
+			line = l.src[l.pos : l.pos+end+1]
+			l.pos += end + 1
+			l.line++
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "." {
+			t.text = b.String()
+			return t, nil
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		b.WriteString(line)
+	}
+}
@@ -0,0 +1,198 @@
+package sieve
+
+import (
+	"fmt"
+)
+
+// argument is a command or test argument: a tag, a number or a string list
+type argument struct {
+	typ     tokenType // tokenTag, tokenNumber or tokenString (string list)
+	tag     string
+	number  int64
+	strings []string
+	line    int
+}
+
+// test is a test (if/elsif conditions, allof/anyof/not children)
+type test struct {
+	name  string
+	args  []argument
+	tests []*test
+	line  int
+}
+
+// command is a control or action command
+type command struct {
+	name  string
+	args  []argument
+	tests []*test
+	block []*command
+	line  int
+}
+
+// parser builds commands from tokens
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse returns commands of src
+func parse(src string) ([]*command, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	commands, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return commands, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected(t token) error {
+	return fmt.Errorf("line %d: unexpected %s", t.line, t)
+}
+
+// commands = *command
+func (p *parser) commands() ([]*command, error) {
+	commands := []*command{}
+	for p.peek().typ == tokenIdentifier {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// command = identifier arguments (";" / block)
+func (p *parser) command() (*command, error) {
+	t := p.next()
+	cmd := &command{name: t.text, line: t.line}
+	var err error
+	if cmd.args, cmd.tests, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	switch t = p.next(); t.typ {
+	case tokenSemicolon:
+	case tokenLeftBrace:
+		if cmd.block, err = p.commands(); err != nil {
+			return nil, err
+		}
+		if t = p.next(); t.typ != tokenRightBrace {
+			return nil, p.unexpected(t)
+		}
+	default:
+		return nil, p.unexpected(t)
+	}
+	return cmd, nil
+}
+
+// arguments = *argument [test / test-list]
+func (p *parser) arguments() (args []argument, tests []*test, err error) {
+	for {
+		t := p.peek()
+		switch t.typ {
+		case tokenTag:
+			p.next()
+			args = append(args, argument{typ: tokenTag, tag: t.text, line: t.line})
+			continue
+		case tokenNumber:
+			p.next()
+			args = append(args, argument{typ: tokenNumber, number: t.number, line: t.line})
+			continue
+		case tokenString, tokenLeftBracket:
+			list, err := p.stringList()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, argument{typ: tokenString, strings: list, line: t.line})
+			continue
+		case tokenIdentifier:
+			tst, err := p.test()
+			if err != nil {
+				return nil, nil, err
+			}
+			tests = []*test{tst}
+		case tokenLeftParen:
+			if tests, err = p.testList(); err != nil {
+				return nil, nil, err
+			}
+		}
+		return args, tests, nil
+	}
+}
+
+// string-list = "[" string *("," string) "]" / string
+func (p *parser) stringList() ([]string, error) {
+	t := p.next()
+	if t.typ == tokenString {
+		return []string{t.text}, nil
+	}
+	list := []string{}
+	for {
+		t = p.next()
+		if t.typ != tokenString {
+			return nil, p.unexpected(t)
+		}
+		list = append(list, t.text)
+		switch t = p.next(); t.typ {
+		case tokenComma:
+		case tokenRightBracket:
+			return list, nil
+		default:
+			return nil, p.unexpected(t)
+		}
+	}
+}
+
+// test = identifier arguments
+func (p *parser) test() (*test, error) {
+	t := p.next()
+	if t.typ != tokenIdentifier {
+		return nil, p.unexpected(t)
+	}
+	tst := &test{name: t.text, line: t.line}
+	var err error
+	if tst.args, tst.tests, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	return tst, nil
+}
+
+// test-list = "(" test *("," test) ")"
+func (p *parser) testList() ([]*test, error) {
+	p.next()
+	tests := []*test{}
+	for {
+		tst, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, tst)
+		switch t := p.next(); t.typ {
+		case tokenComma:
+		case tokenRightParen:
+			return tests, nil
+		default:
+			return nil, p.unexpected(t)
+		}
+	}
+}
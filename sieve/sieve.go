@@ -0,0 +1,425 @@
+// Package sieve implements a Sieve mail filtering language interpreter
+// (RFC 5228) with the following extensions: fileinto, reject (RFC 5429),
+// envelope, imap4flags (RFC 5232), body (RFC 5173), variables (RFC 5229),
+// vacation (RFC 5230), copy (RFC 3894) and subaddress (RFC 5233).
+//
+// Scripts are parsed and validated once, then executed against messages.
+// Execution doesn't perform any action: it returns what has to be done
+// (stores, redirects, reject, vacation) and lets the caller do it.
+package sieve
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Extensions holds supported extensions (capabilities)
+var Extensions = []string{"fileinto", "reject", "envelope", "imap4flags", "body", "variables", "vacation", "copy", "subaddress", "comparator-i;octet", "comparator-i;ascii-casemap"}
+
+// supported comparators
+var comparators = []string{"i;ascii-casemap", "i;octet"}
+
+// argKind is the kind of an argument
+type argKind int
+
+const (
+	argNone argKind = iota // tag without value
+	argString
+	argStringList
+	argNumber
+)
+
+// tagSpec describes a tagged argument
+type tagSpec struct {
+	kind argKind
+	ext  string
+}
+
+// spec describes arguments of a command or a test
+type spec struct {
+	ext        string
+	tags       map[string]tagSpec
+	exclusive  [][]string
+	positional []argKind
+	optional   int // number of leading positional arguments which can be omitted
+	tests      int // 0: none, 1: one test, -1: test list
+	block      bool
+}
+
+// tags shared by tests
+var (
+	comparatorTag = tagSpec{kind: argString}
+	matchTypeTags = map[string]tagSpec{"is": {}, "contains": {}, "matches": {}}
+	addressTags   = map[string]tagSpec{"all": {}, "localpart": {}, "domain": {}, "user": {ext: "subaddress"}, "detail": {ext: "subaddress"}}
+	matchTypes    = []string{"is", "contains", "matches"}
+	addressParts  = []string{"all", "localpart", "domain", "user", "detail"}
+)
+
+// mergeTags returns tags of all maps
+func mergeTags(maps ...map[string]tagSpec) map[string]tagSpec {
+	tags := map[string]tagSpec{}
+	for _, m := range maps {
+		for name, t := range m {
+			tags[name] = t
+		}
+	}
+	return tags
+}
+
+// commands specs
+var commandSpecs = map[string]spec{
+	"require": {positional: []argKind{argStringList}},
+	"if":      {tests: 1, block: true},
+	"elsif":   {tests: 1, block: true},
+	"else":    {block: true},
+	"stop":    {},
+	"keep": {
+		tags: map[string]tagSpec{"flags": {kind: argStringList, ext: "imap4flags"}},
+	},
+	"discard": {},
+	"fileinto": {
+		ext:        "fileinto",
+		tags:       map[string]tagSpec{"flags": {kind: argStringList, ext: "imap4flags"}, "copy": {ext: "copy"}},
+		positional: []argKind{argString},
+	},
+	"redirect": {
+		tags:       map[string]tagSpec{"copy": {ext: "copy"}},
+		positional: []argKind{argString},
+	},
+	"reject": {ext: "reject", positional: []argKind{argString}},
+	"vacation": {
+		ext: "vacation",
+		tags: map[string]tagSpec{
+			"days":      {kind: argNumber},
+			"subject":   {kind: argString},
+			"from":      {kind: argString},
+			"addresses": {kind: argStringList},
+			"mime":      {},
+			"handle":    {kind: argString},
+		},
+		positional: []argKind{argString},
+	},
+	"setflag":    {ext: "imap4flags", positional: []argKind{argString, argStringList}, optional: 1},
+	"addflag":    {ext: "imap4flags", positional: []argKind{argString, argStringList}, optional: 1},
+	"removeflag": {ext: "imap4flags", positional: []argKind{argString, argStringList}, optional: 1},
+	"set": {
+		ext:        "variables",
+		tags:       map[string]tagSpec{"lower": {}, "upper": {}, "lowerfirst": {}, "upperfirst": {}, "quotewildcard": {}, "length": {}},
+		exclusive:  [][]string{{"lower", "upper"}, {"lowerfirst", "upperfirst"}},
+		positional: []argKind{argString, argString},
+	},
+}
+
+// tests specs
+var testSpecs = map[string]spec{
+	"address": {
+		tags:       mergeTags(map[string]tagSpec{"comparator": comparatorTag}, matchTypeTags, addressTags),
+		exclusive:  [][]string{matchTypes, addressParts},
+		positional: []argKind{argStringList, argStringList},
+	},
+	"envelope": {
+		ext:        "envelope",
+		tags:       mergeTags(map[string]tagSpec{"comparator": comparatorTag}, matchTypeTags, addressTags),
+		exclusive:  [][]string{matchTypes, addressParts},
+		positional: []argKind{argStringList, argStringList},
+	},
+	"header": {
+		tags:       mergeTags(map[string]tagSpec{"comparator": comparatorTag}, matchTypeTags),
+		exclusive:  [][]string{matchTypes},
+		positional: []argKind{argStringList, argStringList},
+	},
+	"exists": {positional: []argKind{argStringList}},
+	"size": {
+		tags:      map[string]tagSpec{"over": {kind: argNumber}, "under": {kind: argNumber}},
+		exclusive: [][]string{{"over", "under"}},
+	},
+	"allof": {tests: -1},
+	"anyof": {tests: -1},
+	"not":   {tests: 1},
+	"true":  {},
+	"false": {},
+	"body": {
+		ext:        "body",
+		tags:       mergeTags(map[string]tagSpec{"comparator": comparatorTag, "raw": {}, "text": {}, "content": {kind: argStringList}}, matchTypeTags),
+		exclusive:  [][]string{matchTypes, {"raw", "text", "content"}},
+		positional: []argKind{argStringList},
+	},
+	"string": {
+		ext:        "variables",
+		tags:       mergeTags(map[string]tagSpec{"comparator": comparatorTag}, matchTypeTags),
+		exclusive:  [][]string{matchTypes},
+		positional: []argKind{argStringList, argStringList},
+	},
+	"hasflag": {
+		ext:        "imap4flags",
+		tags:       mergeTags(map[string]tagSpec{"comparator": comparatorTag}, matchTypeTags),
+		exclusive:  [][]string{matchTypes},
+		positional: []argKind{argStringList, argStringList},
+		optional:   1,
+	},
+}
+
+// parsedArgs holds arguments sorted by spec
+type parsedArgs struct {
+	tags       map[string]argument
+	positional []argument
+}
+
+// has returns true if tag is set
+func (a parsedArgs) has(tag string) bool {
+	_, ok := a.tags[tag]
+	return ok
+}
+
+// parseArgs checks args against spec and returns them sorted
+// omitted optional positional arguments are returned as empty arguments
+func parseArgs(name string, args []argument, s spec, extensions map[string]bool) (parsedArgs, error) {
+	parsed := parsedArgs{tags: map[string]argument{}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg.typ != tokenTag {
+			parsed.positional = append(parsed.positional, arg)
+			continue
+		}
+		if len(parsed.positional) != 0 {
+			return parsed, fmt.Errorf("line %d: %s: tag :%s must precede positional arguments", arg.line, name, arg.tag)
+		}
+		ts, ok := s.tags[arg.tag]
+		if !ok {
+			return parsed, fmt.Errorf("line %d: %s: unknown tag :%s", arg.line, name, arg.tag)
+		}
+		if ts.ext != "" && !extensions[ts.ext] {
+			return parsed, fmt.Errorf("line %d: %s: tag :%s requires extension %s", arg.line, name, arg.tag, ts.ext)
+		}
+		if _, ok := parsed.tags[arg.tag]; ok {
+			return parsed, fmt.Errorf("line %d: %s: duplicate tag :%s", arg.line, name, arg.tag)
+		}
+		if ts.kind != argNone {
+			if i+1 >= len(args) || !argMatchKind(args[i+1], ts.kind) {
+				return parsed, fmt.Errorf("line %d: %s: bad value for tag :%s", arg.line, name, arg.tag)
+			}
+			i++
+			arg = args[i]
+			arg.tag = args[i-1].tag
+		}
+		parsed.tags[arg.tag] = arg
+	}
+	for _, group := range s.exclusive {
+		found := ""
+		for _, tag := range group {
+			if !parsed.has(tag) {
+				continue
+			}
+			if found != "" {
+				return parsed, fmt.Errorf("%s: tags :%s and :%s can't be used together", name, found, tag)
+			}
+			found = tag
+		}
+	}
+	if c, ok := parsed.tags["comparator"]; ok && !inSlice(c.strings[0], comparators) {
+		return parsed, fmt.Errorf("line %d: %s: unsupported comparator %s", c.line, name, c.strings[0])
+	}
+
+	// positional
+	n := len(parsed.positional)
+	if n > len(s.positional) || n < len(s.positional)-s.optional {
+		return parsed, fmt.Errorf("%s: bad number of arguments", name)
+	}
+	if n < len(s.positional) {
+		parsed.positional = append(make([]argument, len(s.positional)-n), parsed.positional...)
+	}
+	for i, arg := range parsed.positional {
+		if i < len(s.positional)-n {
+			continue
+		}
+		if !argMatchKind(arg, s.positional[i]) {
+			return parsed, fmt.Errorf("line %d: %s: bad argument %d", arg.line, name, i+1)
+		}
+	}
+	return parsed, nil
+}
+
+// argMatchKind returns true if arg is of kind
+func argMatchKind(arg argument, kind argKind) bool {
+	switch kind {
+	case argString:
+		return arg.typ == tokenString && len(arg.strings) == 1
+	case argStringList:
+		return arg.typ == tokenString
+	case argNumber:
+		return arg.typ == tokenNumber
+	}
+	return false
+}
+
+// inSlice returns true if s is in list
+func inSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Script is a parsed and validated Sieve script
+type Script struct {
+	commands   []*command
+	extensions map[string]bool
+}
+
+// Parse parses and validates a script
+func Parse(src string) (*Script, error) {
+	commands, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{commands: commands, extensions: map[string]bool{}}
+
+	// require must be first
+	i := 0
+	for ; i < len(commands) && commands[i].name == "require"; i++ {
+		args, err := parseArgs("require", commands[i].args, commandSpecs["require"], s.extensions)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range args.positional[0].strings {
+			if !inSlice(ext, Extensions) {
+				return nil, fmt.Errorf("line %d: unsupported extension %s", commands[i].line, ext)
+			}
+			s.extensions[ext] = true
+		}
+	}
+	if err = s.validateCommands(commands[i:]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validateCommands validates commands and their tests
+func (s *Script) validateCommands(commands []*command) error {
+	for i, cmd := range commands {
+		cs, ok := commandSpecs[cmd.name]
+		if !ok {
+			return fmt.Errorf("line %d: unknown command %s", cmd.line, cmd.name)
+		}
+		if cmd.name == "require" {
+			return fmt.Errorf("line %d: require must be at the beginning of the script", cmd.line)
+		}
+		if (cmd.name == "elsif" || cmd.name == "else") && (i == 0 || (commands[i-1].name != "if" && commands[i-1].name != "elsif")) {
+			return fmt.Errorf("line %d: %s without if", cmd.line, cmd.name)
+		}
+		if cs.ext != "" && !s.extensions[cs.ext] {
+			return fmt.Errorf("line %d: %s requires extension %s", cmd.line, cmd.name, cs.ext)
+		}
+		if _, err := parseArgs(cmd.name, cmd.args, cs, s.extensions); err != nil {
+			return err
+		}
+		if err := checkTestsCount(cmd.name, cmd.line, len(cmd.tests), cs.tests); err != nil {
+			return err
+		}
+		if cs.block != (cmd.block != nil) {
+			if cs.block {
+				return fmt.Errorf("line %d: %s requires a block", cmd.line, cmd.name)
+			}
+			return fmt.Errorf("line %d: %s can't have a block", cmd.line, cmd.name)
+		}
+		if err := s.validateTests(cmd.tests); err != nil {
+			return err
+		}
+		if err := s.validateCommands(cmd.block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTests validates tests
+func (s *Script) validateTests(tests []*test) error {
+	for _, t := range tests {
+		ts, ok := testSpecs[t.name]
+		if !ok {
+			return fmt.Errorf("line %d: unknown test %s", t.line, t.name)
+		}
+		if ts.ext != "" && !s.extensions[ts.ext] {
+			return fmt.Errorf("line %d: %s requires extension %s", t.line, t.name, ts.ext)
+		}
+		args, err := parseArgs(t.name, t.args, ts, s.extensions)
+		if err != nil {
+			return err
+		}
+		if t.name == "size" && !args.has("over") && !args.has("under") {
+			return fmt.Errorf("line %d: size requires :over or :under", t.line)
+		}
+		if err = checkTestsCount(t.name, t.line, len(t.tests), ts.tests); err != nil {
+			return err
+		}
+		if err = s.validateTests(t.tests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTestsCount checks number of tests
+func checkTestsCount(name string, line, count, expected int) error {
+	switch {
+	case expected == -1 && count == 0:
+		return fmt.Errorf("line %d: %s requires a test list", line, name)
+	case expected == 0 && count != 0:
+		return fmt.Errorf("line %d: %s doesn't take tests", line, name)
+	case expected == 1 && count != 1:
+		return fmt.Errorf("line %d: %s requires one test", line, name)
+	}
+	return nil
+}
+
+// Message is the message to filter
+type Message struct {
+	MailFrom string // envelope sender ("" for bounces)
+	RcptTo   string // envelope recipient
+	// recipient delimiter characters used by :user and :detail
+	// (user+detail@domain), "" if subaddresses are not used
+	Delimiter string
+	Raw       []byte
+}
+
+// Store is a copy of the message to store
+type Store struct {
+	Folder string // "" for INBOX (keep)
+	Flags  []string
+}
+
+// Vacation is an auto-reply to send (RFC 5230)
+type Vacation struct {
+	Days      int
+	Subject   string
+	From      string
+	Addresses []string
+	Mime      bool
+	Handle    string
+	Reason    string
+}
+
+// Result holds actions to perform
+// if there is no store, redirect or reject the message is discarded
+type Result struct {
+	Stores    []Store
+	Redirects []string
+	Rejected  bool
+	Reject    string
+	Vacation  *Vacation
+}
+
+// errStop is used to stop execution
+var errStop = errors.New("stop")
+
+// Execute runs script against msg and returns actions to perform
+// On error the caller should keep the message (RFC 5228 2.10.6)
+func (s *Script) Execute(msg Message) (*Result, error) {
+	in := newInterpreter(s, msg)
+	if err := in.run(s.commands); err != nil && err != errStop {
+		return nil, err
+	}
+	return in.finish()
+}
@@ -0,0 +1,182 @@
+package sieve
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMail = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.org, \"Carol\" <carol@example.org>\r\n" +
+	"Subject: =?UTF-8?Q?Caf=C3=A9?= [list] meeting\r\n" +
+	"List-Id: <dev.example.com>\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Hello, the meeting is at n=C2=B02\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PGI+c2VjcmV0PC9iPg==\r\n" +
+	"--b1--\r\n"
+
+func execute(t *testing.T, script string) *Result {
+	s, err := Parse(script)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	result, err := s.Execute(Message{MailFrom: "alice@example.com", RcptTo: "bob@example.org", Raw: []byte(testMail)})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return result
+}
+
+func Test_ParseErrors(t *testing.T) {
+	scripts := []string{
+		`fileinto "Spam";`,                         // fileinto not required
+		`require "fileinto"; keep`,                 // missing ;
+		`require "foo";`,                           // unsupported extension
+		`keep; require "fileinto";`,                // require after command
+		`if true { keep; } else keep;`,             // else without block
+		`elsif true { keep; }`,                     // elsif without if
+		`if header :is :contains "a" "b" { }`,      // two match types
+		`if size 100 { }`,                          // missing :over/:under
+		`if header :comparator "i;foo" "a" "b" {}`, // unknown comparator
+		`redirect :copy "a@b.c";`,                  // copy not required
+		`if foo { }`,                               // unknown test
+		`"string";`,                                // not a command
+		`keep; /* unterminated`,                    // comment
+	}
+	for _, script := range scripts {
+		_, err := Parse(script)
+		assert.Error(t, err, script)
+	}
+}
+
+func Test_ImplicitKeep(t *testing.T) {
+	result := execute(t, `# nothing`)
+	assert.Equal(t, []Store{{Folder: "", Flags: []string{}}}, result.Stores)
+	result = execute(t, `discard;`)
+	assert.Empty(t, result.Stores)
+	assert.False(t, result.Rejected)
+}
+
+func Test_FileintoAndFlags(t *testing.T) {
+	result := execute(t, `require ["fileinto", "imap4flags", "copy"];
+if header :contains "subject" "café" {
+	addflag "\\Seen";
+	fileinto :copy "Meetings";
+	fileinto :copy :flags "\\Flagged" "Archives/2024";
+}`)
+	assert.Equal(t, []Store{
+		{Folder: "Meetings", Flags: []string{`\Seen`}},
+		{Folder: "Archives/2024", Flags: []string{`\Flagged`}},
+		{Folder: "", Flags: []string{`\Seen`}},
+	}, result.Stores)
+}
+
+func Test_ElsifAndStop(t *testing.T) {
+	result := execute(t, `require "fileinto";
+if address :domain "from" "example.net" {
+	fileinto "A";
+} elsif address :localpart :is "to" "carol" {
+	fileinto "B";
+	stop;
+} else {
+	fileinto "C";
+}
+fileinto "D";`)
+	assert.Equal(t, []Store{{Folder: "B", Flags: []string{}}}, result.Stores)
+}
+
+func Test_RedirectAndReject(t *testing.T) {
+	result := execute(t, `redirect "dave@example.net"; redirect "Dave@example.net";`)
+	assert.Equal(t, []string{"dave@example.net"}, result.Redirects)
+	assert.Empty(t, result.Stores)
+
+	result = execute(t, `require ["reject", "envelope"]; if envelope :domain "from" "example.com" { reject "go away"; }`)
+	assert.True(t, result.Rejected)
+	assert.Equal(t, "go away", result.Reject)
+
+	s, err := Parse(`require "reject"; reject "no"; keep;`)
+	assert.NoError(t, err)
+	_, err = s.Execute(Message{Raw: []byte(testMail)})
+	assert.Error(t, err)
+}
+
+func Test_Variables(t *testing.T) {
+	result := execute(t, `require ["variables", "fileinto"];
+if header :matches "List-Id" "<*.example.com>" {
+	set :upperfirst "list" "${1}";
+	fileinto "Lists/${list}";
+}
+set "n" "${unknown}x";
+if string :is "${n}" "x" { fileinto "ok"; }`)
+	assert.Equal(t, []Store{{Folder: "Lists/Dev", Flags: []string{}}, {Folder: "ok", Flags: []string{}}}, result.Stores)
+}
+
+func Test_Body(t *testing.T) {
+	result := execute(t, `require ["body", "fileinto"];
+if body :contains "n°2" { fileinto "text"; }
+if body :content "text/html" :contains "secret" { fileinto "html"; }
+if body :content "text/plain" :contains "secret" { fileinto "never"; }
+if body :raw :contains "PGI+" { fileinto "raw"; }`)
+	assert.Equal(t, []Store{{Folder: "text", Flags: []string{}}, {Folder: "html", Flags: []string{}}, {Folder: "raw", Flags: []string{}}}, result.Stores)
+}
+
+func Test_Vacation(t *testing.T) {
+	result := execute(t, `require "vacation";
+if not exists "x-spam" {
+	vacation :days 3 :subject "Away" :addresses ["bob@example.org"] "I'm away";
+}`)
+	if assert.NotNil(t, result.Vacation) {
+		assert.Equal(t, 3, result.Vacation.Days)
+		assert.Equal(t, "Away", result.Vacation.Subject)
+		assert.Equal(t, "I'm away", result.Vacation.Reason)
+		assert.Equal(t, []string{"bob@example.org"}, result.Vacation.Addresses)
+	}
+	assert.Len(t, result.Stores, 1)
+}
+
+func Test_SizeAndMultiLine(t *testing.T) {
+	result := execute(t, `require ["fileinto", "vacation"];
+if allof(size :over 100, size :under 1M, true) { fileinto "sized"; }
+vacation text:
+Hello
+..dot
+.
+;`)
+	assert.Equal(t, "sized", result.Stores[0].Folder)
+	assert.Equal(t, "Hello\n.dot\n", result.Vacation.Reason)
+}
+
+func Test_Subaddress(t *testing.T) {
+	s, err := Parse(`require ["envelope", "subaddress", "fileinto", "variables"];
+if envelope :detail :matches "to" "*" { fileinto "${1}"; }
+if envelope :user "to" "bob" { fileinto "user"; }
+if address :detail "to" "" { fileinto "never"; }`)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	result, err := s.Execute(Message{RcptTo: "bob+lists-dev@example.org", Delimiter: "+", Raw: []byte(testMail)})
+	assert.NoError(t, err)
+	assert.Equal(t, []Store{{Folder: "lists-dev", Flags: []string{}}, {Folder: "user", Flags: []string{}}}, result.Stores)
+
+	// several delimiters: first one found is used
+	result, err = s.Execute(Message{RcptTo: "bob-lists+dev@example.org", Delimiter: "+-", Raw: []byte(testMail)})
+	assert.NoError(t, err)
+	assert.Equal(t, "lists+dev", result.Stores[0].Folder)
+
+	// no delimiter: implicit keep
+	result, err = s.Execute(Message{RcptTo: "bob+lists@example.org", Raw: []byte(testMail)})
+	assert.NoError(t, err)
+	assert.Equal(t, []Store{{Folder: "", Flags: []string{}}}, result.Stores)
+
+	_, err = Parse(`require "envelope"; if envelope :detail "to" "x" { keep; }`)
+	assert.Error(t, err)
+}
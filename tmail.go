@@ -75,6 +75,11 @@ func init() {
 	// init rand seed
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	// LMTP agent can't file messages in folders without delimiter
+	if core.Cfg.GetDeliverdLocalAgent() == "lmtp" && core.Cfg.GetDeliverdLmtpDelimiter() == "" && (core.Cfg.GetDeliverdSieveEnabled() || core.Cfg.GetDeliverdRcptDetailFolder()) {
+		log.Fatalln("LMTP delivery can't file messages in folders, set TMAIL_DELIVERD_LMTP_DELIMITER or disable TMAIL_DELIVERD_SIEVE_ENABLED and TMAIL_DELIVERD_RCPT_DETAIL_FOLDER")
+	}
+
 	// Dovecot support
 	if core.Cfg.GetDovecotSupportEnabled() {
 		_, err := exec.LookPath(core.Cfg.GetDovecotLda())
@@ -187,6 +192,10 @@ func main() {
 			// deliverd
 			if core.Cfg.GetLaunchDeliverd() {
 				go core.LaunchDeliverd()
+				// quarantine expiration and digests
+				go core.LaunchQuarantine()
+				// journal archive retention
+				go core.LaunchJournal()
 			}
 
 			// HTTP REST server
@@ -194,6 +203,14 @@ func main() {
 				go rest.LaunchServer()
 			}
 
+			// ACME client (after HTTP-01 challenge servers)
+			if core.Cfg.GetAcmeEnabled() {
+				if core.Cfg.GetAcmeChallenge() == core.AcmeChallengeHTTP01 && core.Cfg.GetAcmeHttp01Listen() != "" {
+					go core.LaunchAcmeHTTP01Server()
+				}
+				go core.LaunchAcme()
+			}
+
 			<-sigChan
 			core.Logger.Info("Exiting...")
 